@@ -11,16 +11,27 @@ import (
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "使用法: %s <ソースファイル> [出力ファイル]\n", os.Args[0])
+	args, target, executable, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ エラー: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "使用法: %s [--target=<name>] [-o <実行可能ファイル>] <ソースファイル> [出力ファイル]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  pug言語ソースファイルをx86_64アセンブリにコンパイルします\n")
+		fmt.Fprintf(os.Stderr, "  -o を指定すると、アセンブルとリンクまで行い実行可能ファイルを作成します\n")
+		fmt.Fprintf(os.Stderr, "  --target に指定できる値: %s（既定値: %s）\n",
+			strings.Join(phase2.TargetNames(), ", "), phase2.DefaultTarget().Name)
 		fmt.Fprintf(os.Stderr, "\n例:\n")
 		fmt.Fprintf(os.Stderr, "  %s program.dog\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s program.dog program.s\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s program.dog -o program\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --target=darwin program.dog\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	inputFile := os.Args[1]
+	inputFile := args[0]
 
 	// 基本的なパス検証（セキュリティ対策）
 	if strings.Contains(inputFile, "..") {
@@ -28,11 +39,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 出力ファイル名を決定
+	// 出力ファイル名を決定（-o のみの場合はアセンブリファイルを残さない）
 	var outputFile string
-	if len(os.Args) >= 3 {
-		outputFile = os.Args[2]
-	} else {
+	if len(args) >= 2 {
+		outputFile = args[1]
+	} else if executable == "" {
 		// 拡張子を .s に変更
 		ext := filepath.Ext(inputFile)
 		outputFile = strings.TrimSuffix(inputFile, ext) + ".s"
@@ -47,7 +58,13 @@ func main() {
 	}
 
 	fmt.Printf("📝 ソースファイル: %s\n", inputFile)
-	fmt.Printf("🎯 出力ファイル: %s\n", outputFile)
+	if outputFile != "" {
+		fmt.Printf("🎯 出力ファイル: %s\n", outputFile)
+	}
+	if executable != "" {
+		fmt.Printf("🎯 実行可能ファイル: %s\n", executable)
+	}
+	fmt.Printf("🖥️ ターゲット: %s\n", target.Description)
 
 	// 字句解析
 	fmt.Println("🔤 字句解析中...")
@@ -58,17 +75,15 @@ func main() {
 	parser := phase1.NewParser(lexer)
 	program := parser.ParseProgram()
 
-	if len(parser.Errors()) > 0 {
+	if diagnostics := parser.Diagnostics(); phase1.HasErrors(diagnostics) {
 		fmt.Fprintf(os.Stderr, "❌ 構文解析エラー:\n")
-		for _, err := range parser.Errors() {
-			fmt.Fprintf(os.Stderr, "  %s\n", err)
-		}
+		fmt.Fprint(os.Stderr, phase1.RenderDiagnostics(diagnostics, inputFile, string(source)))
 		os.Exit(1)
 	}
 
 	// コード生成
 	fmt.Println("⚙️ アセンブリコード生成中...")
-	codeGen := phase2.NewCodeGenerator()
+	codeGen := phase2.NewCodeGeneratorForTarget(target)
 	asmCode, err := codeGen.Generate(program)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "❌ コード生成エラー: %v\n", err)
@@ -76,18 +91,81 @@ func main() {
 	}
 
 	// アセンブリコードをファイルに出力
-	fmt.Println("💾 アセンブリファイル出力中...")
-	err = os.WriteFile(outputFile, []byte(asmCode), 0600) // #nosec G703 -- outputFile is derived from validated inputFile
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "❌ ファイル出力エラー: %v\n", err)
-		os.Exit(1)
+	if outputFile != "" {
+		fmt.Println("💾 アセンブリファイル出力中...")
+		err = os.WriteFile(outputFile, []byte(asmCode), 0600) // #nosec G703 -- outputFile is derived from validated inputFile
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ ファイル出力エラー: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// アセンブルとリンク
+	if executable != "" {
+		fmt.Println("🔗 アセンブル・リンク中...")
+		if err := codeGen.AssembleAndLink(asmCode, executable); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 実行可能ファイル作成エラー: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	fmt.Printf("✅ コンパイル完了！\n")
 	fmt.Printf("📊 生成されたアセンブリ: %d行\n", strings.Count(asmCode, "\n"))
+	if executable != "" {
+		fmt.Printf("\n実行: %s\n", runPath(executable))
+		return
+	}
+
+	executable = strings.TrimSuffix(outputFile, ".s")
+	assemble, link := target.BuildCommands(outputFile, executable+".o", executable)
 	fmt.Printf("\n次のステップ:\n")
-	fmt.Printf("  アセンブル: as -arch x86_64 -o %s.o %s\n", strings.TrimSuffix(outputFile, ".s"), outputFile)
-	fmt.Printf("  リンク:     ld -o %s -lSystem -syslibroot /Library/Developer/CommandLineTools/SDKs/MacOSX.sdk -e _main -arch x86_64 %s.o\n",
-		strings.TrimSuffix(outputFile, ".s"), strings.TrimSuffix(outputFile, ".s"))
-	fmt.Printf("  実行:       ./%s\n", strings.TrimSuffix(outputFile, ".s"))
+	fmt.Printf("  アセンブル: %s\n", strings.Join(assemble, " "))
+	fmt.Printf("  リンク:     %s\n", strings.Join(link, " "))
+	fmt.Printf("  実行:       %s\n", runPath(executable))
+}
+
+// runPath は実行可能ファイルをシェルから起動するときのパスを返す（相対パスには ./ を付ける）
+func runPath(executable string) string {
+	if filepath.IsAbs(executable) || strings.HasPrefix(executable, "./") {
+		return executable
+	}
+	return "./" + executable
+}
+
+// parseArgs は位置引数と、出力先のターゲット、-o で指定された実行可能ファイルのパスを返す
+// --target と -o は "--target=linux" と "--target linux" のどちらの形式でも指定できる
+// それ以外のオプション（-O2 など）は現在は読み飛ばす
+func parseArgs(args []string) ([]string, *phase2.Target, string, error) {
+	target := phase2.DefaultTarget()
+	var positional []string
+	var executable string
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		switch name {
+		case "--target", "-o":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, nil, "", fmt.Errorf("%s には値が必要です", name)
+				}
+				i++
+				value = args[i]
+			}
+			if name == "-o" {
+				executable = value
+				continue
+			}
+			t, err := phase2.LookupTarget(value)
+			if err != nil {
+				return nil, nil, "", err
+			}
+			target = t
+		default:
+			if !strings.HasPrefix(args[i], "-") {
+				positional = append(positional, args[i])
+			}
+		}
+	}
+
+	return positional, target, executable, nil
 }
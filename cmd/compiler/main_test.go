@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nyasuto/pug/phase2"
+)
+
+// TestParseArgs はコマンドライン引数の解析をテストする
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		positional string
+		target     *phase2.Target
+		executable string
+		err        string
+	}{
+		{"ソースと出力ファイル", []string{"hello.dog", "hello.s"}, "hello.dog hello.s", phase2.DefaultTarget(), "", ""},
+		{"ターゲット", []string{"--target", "darwin", "hello.dog"}, "hello.dog", phase2.TargetDarwin, "", ""},
+		{"実行可能ファイル", []string{"hello.dog", "-o", "hello"}, "hello.dog", phase2.DefaultTarget(), "hello", ""},
+		{"最適化オプションは読み飛ばす", []string{"-O2", "hello.dog", "-o=bin/hello"}, "hello.dog", phase2.DefaultTarget(), "bin/hello", ""},
+		{"値のない出力先", []string{"hello.dog", "-o"}, "", nil, "", "-o には値が必要です"},
+		{"未知のターゲット", []string{"--target=windows", "hello.dog"}, "", nil, "", "unknown target: windows (available: darwin, linux, macos)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positional, target, executable, err := parseArgs(tt.args)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := strings.Join(positional, " "); got != tt.positional || target != tt.target || executable != tt.executable {
+				t.Errorf("expected (%s, %s, %q), got (%s, %s, %q)",
+					tt.positional, tt.target.Name, tt.executable, got, target.Name, executable)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/nyasuto/pug/phase1"
+	"github.com/nyasuto/pug/phase2"
 )
 
 func main() {
@@ -25,7 +26,7 @@ func main() {
 	if arg == "--repl" || arg == "-r" {
 		fmt.Println("🔄 REPL モードを開始します...")
 		fmt.Println("終了するには Ctrl+C を押してください")
-		phase1.Start(os.Stdin, os.Stdout)
+		phase1.StartWithTypes(os.Stdin, os.Stdout, newTypeReporter())
 		return
 	}
 
@@ -41,6 +42,33 @@ func main() {
 	fmt.Println("✅ プログラムが正常に実行されました")
 }
 
+// typeReporter はREPLの入力を型推論し、let束縛された関数の型を報告する
+// 入力をまたいで同じ型検査器を使い、以前に定義した名前の型を引き継ぐ
+type typeReporter struct {
+	checker *phase2.TypeChecker
+}
+
+// newTypeReporter は新しいtypeReporterを作成する
+func newTypeReporter() *typeReporter {
+	return &typeReporter{checker: phase2.NewTypeChecker()}
+}
+
+// ReportTypes は入力を型検査し、定義された関数の推論された型を返す
+// インタープリターは動的に型付けされるため、型エラーのある入力では何も報告しない
+func (r *typeReporter) ReportTypes(program *phase1.Program) []string {
+	before := len(r.checker.GetErrors())
+	r.checker.CheckProgram(program)
+	if len(r.checker.GetErrors()) > before {
+		return nil
+	}
+
+	var lines []string
+	for _, signature := range r.checker.FunctionSignatures(program) {
+		lines = append(lines, signature.String())
+	}
+	return lines
+}
+
 // validateFilePath はファイルパスのセキュリティ検証を行う
 func validateFilePath(filename string) error {
 	// 空文字列チェック
@@ -102,11 +130,9 @@ func executeFile(filename string) error {
 	program := p.ParseProgram()
 
 	// パースエラーの確認
-	if errors := p.Errors(); len(errors) != 0 {
+	if diagnostics := p.Diagnostics(); len(diagnostics) != 0 {
 		fmt.Println("🚨 構文解析エラー:")
-		for _, msg := range errors {
-			fmt.Printf("  %s\n", msg)
-		}
+		fmt.Print(phase1.RenderDiagnostics(diagnostics, filename, string(input)))
 		return fmt.Errorf("構文解析に失敗しました")
 	}
 
@@ -117,7 +143,8 @@ func executeFile(filename string) error {
 	evaluated := phase1.Eval(program, env)
 
 	// 実行エラーの確認
-	if evaluated != nil && evaluated.Type() == phase1.ERROR_OBJ {
+	if err, ok := evaluated.(*phase1.Error); ok {
+		fmt.Fprintln(os.Stderr, err.Traceback(string(input)))
 		return fmt.Errorf("実行エラー: %s", evaluated.Inspect())
 	}
 
@@ -4,6 +4,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/nyasuto/pug/phase1"
 )
 
 func TestExecuteFile_Success(t *testing.T) {
@@ -174,6 +176,27 @@ func TestMainWithFile(t *testing.T) {
 	// ここでは基本的な設定のテストのみ
 }
 
+func TestTypeReporter(t *testing.T) {
+	reporter := newTypeReporter()
+	inputs := []struct {
+		input    string
+		expected []string
+	}{
+		{"let inc = fn(x) { x + 1 };", []string{"inc: fn(int) -> int"}},
+		{"let x = inc(1);", nil},
+		{"let apply = fn(f, v) { f(v) };", []string{"apply: fn(fn(?T) -> ?U, ?T) -> ?U"}},
+		{"let bad = fn() { inc(\"a\") };", nil},
+	}
+
+	for _, tt := range inputs {
+		program := phase1.NewParser(phase1.New(tt.input)).ParseProgram()
+		lines := reporter.ReportTypes(program)
+		if strings.Join(lines, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("ReportTypes(%q) = %v, want %v", tt.input, lines, tt.expected)
+		}
+	}
+}
+
 func TestValidateFilePath(t *testing.T) {
 	tests := []struct {
 		name     string
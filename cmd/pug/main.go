@@ -3,26 +3,54 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/nyasuto/pug/phase1"
 	"github.com/nyasuto/pug/phase2"
 )
 
 func main() {
+	var opts *options
+	if len(os.Args) >= 2 && os.Args[1] != "check" {
+		parsed, err := parseOptions(os.Args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		opts = parsed
+
+		// DOT形式の出力はGraphvizにそのまま渡せるよう、バナーを表示せずに処理する
+		if opts.emitCFG != "" {
+			os.Exit(runEmitCFG(opts.filename, opts.emitCFG))
+		}
+	}
+
 	fmt.Println("🐶 pug コンパイラ - Phase 2 コンパイラ")
 	fmt.Println("段階的に学ぶコンパイラ実装プロジェクト")
 
 	if len(os.Args) < 2 {
 		fmt.Println("📝 使用方法: pug <filename.dog> [-o output]")
+		fmt.Println("           pug check <filename.dog>")
 		fmt.Println("🔧 オプション:")
 		fmt.Println("  --emit-asm    アセンブリコードを表示")
 		fmt.Println("  --emit-ast    AST構造を表示")
+		fmt.Println("  --emit-cfg=dot 制御フローグラフをGraphviz形式で出力")
+		fmt.Printf("  --target=<name> 出力先のプラットフォーム（%s、既定値: %s）\n",
+			strings.Join(phase2.TargetNames(), ", "), phase2.DefaultTarget().Name)
 		fmt.Println("  -O0,-O1,-O2   最適化レベル")
 		os.Exit(1)
 	}
 
-	filename := os.Args[1]
-	fmt.Printf("📄 ファイル '%s' をコンパイル中...\n", filename)
+	if os.Args[1] == "check" {
+		if len(os.Args) < 3 {
+			fmt.Println("📝 使用方法: pug check <filename.dog>")
+			os.Exit(1)
+		}
+		os.Exit(runCheck(os.Args[2]))
+	}
+
+	filename := opts.filename
+	fmt.Printf("📄 ファイル '%s' を %s 向けにコンパイル中...\n", filename, opts.target.Name)
 
 	// ファイルを読み込み
 	// #nosec G304 G703 - コンパイラツールとしてファイル読み込みは必要な機能
@@ -40,24 +68,159 @@ func main() {
 	program := parser.ParseProgram()
 
 	// パースエラーをチェック
-	errors := parser.Errors()
-	if len(errors) > 0 {
+	if diagnostics := parser.Diagnostics(); phase1.HasErrors(diagnostics) {
 		fmt.Println("❌ パースエラー:")
-		for _, err := range errors {
-			fmt.Printf("  %s\n", err)
-		}
+		fmt.Print(phase1.RenderDiagnostics(diagnostics, filename, string(input)))
 		os.Exit(1)
 	}
 
 	// コード生成
-	codegen := phase2.NewCodeGenerator()
+	codegen := phase2.NewCodeGeneratorForTarget(opts.target)
 	asmCode, err := codegen.Generate(program)
 	if err != nil {
 		fmt.Printf("❌ コード生成エラー: %v\n", err)
 		os.Exit(1)
 	}
 
-	// アセンブリコードを表示
+	// -o が指定されていれば実行可能ファイルを作成し、なければアセンブリコードを表示
+	if opts.output != "" {
+		if err := codegen.AssembleAndLink(asmCode, opts.output); err != nil {
+			fmt.Printf("❌ 実行可能ファイルの作成に失敗しました: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ 実行可能ファイルを作成しました: %s\n", opts.output)
+		return
+	}
+
 	fmt.Println("✅ アセンブリコード生成成功:")
 	fmt.Println(asmCode)
 }
+
+// options はコンパイル時のコマンドライン引数から解析したオプション
+type options struct {
+	filename string
+	target   *phase2.Target
+	emitCFG  string // --emit-cfg で指定された出力形式（指定がなければ空）
+	output   string // -o で指定された実行可能ファイルのパス（指定がなければ空）
+}
+
+// parseOptions はソースファイル名とオプションを解析する
+// --target・--emit-cfg・-o は "--target=linux" と "--target linux" のどちらの形式でも指定できる
+// それ以外のオプションは現在は読み飛ばす
+func parseOptions(args []string) (*options, error) {
+	opts := &options{target: phase2.DefaultTarget()}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--target", "--emit-cfg", "-o":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("%s には値が必要です", name)
+				}
+				i++
+				value = args[i]
+			}
+			switch name {
+			case "--emit-cfg":
+				opts.emitCFG = value
+				continue
+			case "-o":
+				opts.output = value
+				continue
+			}
+			target, err := phase2.LookupTarget(value)
+			if err != nil {
+				return nil, err
+			}
+			opts.target = target
+		default:
+			if !strings.HasPrefix(arg, "-") && opts.filename == "" {
+				opts.filename = arg
+			}
+		}
+	}
+
+	if opts.filename == "" {
+		return nil, fmt.Errorf("ソースファイルが指定されていません")
+	}
+	return opts, nil
+}
+
+// runEmitCFG はトップレベルと各関数の制御フローグラフを指定された形式で出力し、終了コードを返す
+func runEmitCFG(filename, format string) int {
+	if format != "dot" {
+		fmt.Fprintf(os.Stderr, "❌ 未対応の制御フローグラフの出力形式です: %s（dot のみ対応）\n", format)
+		return 1
+	}
+
+	// #nosec G304 G703 - コンパイラツールとしてファイル読み込みは必要な機能
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ ファイル読み込みエラー: %v\n", err)
+		return 1
+	}
+
+	parser := phase1.NewParser(phase1.New(string(input)))
+	program := parser.ParseProgram()
+	if diagnostics := parser.Diagnostics(); phase1.HasErrors(diagnostics) {
+		fmt.Fprint(os.Stderr, phase1.RenderDiagnostics(diagnostics, filename, string(input)))
+		return 1
+	}
+
+	fmt.Print(phase2.DotGraphs(phase2.BuildCFGs(program)))
+	return 0
+}
+
+// runCheck はコード生成を行わずに構文・制御フロー・型の検査だけを行い、終了コードを返す
+func runCheck(filename string) int {
+	fmt.Printf("🔍 ファイル '%s' を検査中...\n", filename)
+
+	// #nosec G304 G703 - コンパイラツールとしてファイル読み込みは必要な機能
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Printf("❌ ファイル読み込みエラー: %v\n", err)
+		return 1
+	}
+
+	diagnostics, signatures := checkSource(string(input))
+	if len(diagnostics) > 0 {
+		fmt.Fprint(os.Stderr, phase1.RenderDiagnostics(diagnostics, filename, string(input)))
+	}
+	if phase1.HasErrors(diagnostics) {
+		fmt.Printf("❌ %d 件の問題が見つかりました\n", len(diagnostics))
+		return 1
+	}
+
+	if len(signatures) > 0 {
+		fmt.Println("📐 推論された関数の型:")
+		for _, signature := range signatures {
+			fmt.Printf("  %s\n", signature)
+		}
+	}
+	fmt.Println("✅ 問題は見つかりませんでした")
+	return 0
+}
+
+// checkSource はソースを解析し、各フェーズの診断を位置順に、トップレベルの関数の推論された型を定義順に返す
+// 構文エラーがある場合はそれ以降の検査を行わない
+func checkSource(source string) ([]*phase1.Diagnostic, []phase2.Signature) {
+	parser := phase1.NewParser(phase1.New(source))
+	program := parser.ParseProgram()
+	if diagnostics := parser.Diagnostics(); phase1.HasErrors(diagnostics) {
+		return diagnostics, nil
+	}
+
+	analyzer := phase2.NewControlFlowAnalyzer()
+	analyzer.ValidateProgram(program)
+
+	checker := phase2.NewTypeChecker()
+	checker.CheckProgram(program)
+
+	diagnostics := append([]*phase1.Diagnostic{}, parser.Diagnostics()...)
+	diagnostics = append(diagnostics, analyzer.Diagnostics()...)
+	diagnostics = append(diagnostics, checker.Diagnostics()...)
+	phase1.SortDiagnostics(diagnostics)
+	return diagnostics, checker.FunctionSignatures(program)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nyasuto/pug/phase2"
+)
+
+// TestParseOptions はコンパイル時のコマンドライン引数の解析をテストする
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		filename string
+		target   *phase2.Target
+		emitCFG  string
+		output   string
+		err      string
+	}{
+		{"ファイル名のみ", []string{"hello.dog"}, "hello.dog", phase2.DefaultTarget(), "", "", ""},
+		{"=形式のターゲット", []string{"hello.dog", "--target=darwin"}, "hello.dog", phase2.TargetDarwin, "", "", ""},
+		{"別の引数のターゲット", []string{"--target", "linux", "hello.dog"}, "hello.dog", phase2.TargetLinux, "", "", ""},
+		{"制御フローグラフ", []string{"hello.dog", "--emit-cfg=dot"}, "hello.dog", phase2.DefaultTarget(), "dot", "", ""},
+		{"実行可能ファイルの出力先", []string{"hello.dog", "-o", "hello"}, "hello.dog", phase2.DefaultTarget(), "", "hello", ""},
+		{"=形式の出力先", []string{"-o=bin/hello", "hello.dog"}, "hello.dog", phase2.DefaultTarget(), "", "bin/hello", ""},
+		{"未知のオプションは読み飛ばす", []string{"hello.dog", "--emit-asm", "-O1"}, "hello.dog", phase2.DefaultTarget(), "", "", ""},
+		{"未知のターゲット", []string{"hello.dog", "--target=windows"}, "", nil, "", "", "unknown target: windows (available: darwin, linux, macos)"},
+		{"値のないターゲット", []string{"hello.dog", "--target"}, "", nil, "", "", "--target には値が必要です"},
+		{"値のない出力先", []string{"hello.dog", "-o"}, "", nil, "", "", "-o には値が必要です"},
+		{"ファイル名なし", []string{"--target=linux"}, "", nil, "", "", "ソースファイルが指定されていません"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseOptions(tt.args)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opts.filename != tt.filename || opts.target != tt.target || opts.emitCFG != tt.emitCFG || opts.output != tt.output {
+				t.Errorf("expected (%s, %s, %q, %q), got (%s, %s, %q, %q)",
+					tt.filename, tt.target.Name, tt.emitCFG, tt.output, opts.filename, opts.target.Name, opts.emitCFG, opts.output)
+			}
+		})
+	}
+}
+
+// TestCheckSource は各フェーズの診断をまとめて、同じ問題を重ねて報告しないことをテストする
+func TestCheckSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected []string // 期待する診断のコードと位置（コード@行:列）
+	}{
+		{"問題のないプログラム", "let x = 1;\nputs(x);", nil},
+		{"定義前の参照", "puts(x);\nlet x = 1;", []string{"E0304@1:6"}},
+		{"定義前の代入", "x = 1;\nlet x = 2;", []string{"E0304@1:1"}},
+		{"ブロックで定義した変数の参照", "if (true) { let y = 1; }\nputs(y);", []string{"W0305@2:6"}},
+		{"ブロックでの再定義", "let x = 1;\nif (true) { let x = 2; }\nputs(x);", []string{"W0308@2:17"}},
+		{"未定義の識別子", "puts(q);", []string{"E0201@1:6"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics, _ := checkSource(tt.source)
+			var got []string
+			for _, d := range diagnostics {
+				got = append(got, d.Code+"@"+d.Span.String())
+			}
+			if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("expected diagnostics %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -47,6 +47,7 @@ func (p *Program) String() string {
 type LetStatement struct {
 	Token Token // LETトークン
 	Name  *Identifier
+	Type  TypeAnnotation // 型注釈（省略時はnil）
 	Value Expression
 }
 
@@ -56,6 +57,9 @@ func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -185,11 +189,105 @@ func (oe *InfixExpression) String() string {
 	return out.String()
 }
 
+// ArrayLiteral は配列リテラルを表すノード
+type ArrayLiteral struct {
+	Token    Token // LBRACKETトークン
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode()      {}
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) String() string {
+	var out bytes.Buffer
+	elements := []string{}
+	for _, el := range al.Elements {
+		elements = append(elements, el.String())
+	}
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+	return out.String()
+}
+
+// HashLiteralPair はハッシュリテラル中のキーと値の組
+type HashLiteralPair struct {
+	Key   Expression
+	Value Expression
+}
+
+// HashLiteral はハッシュリテラルを表すノード
+// 出力や評価の順序を安定させるため、組はソース上の順序で保持する
+type HashLiteral struct {
+	Token Token // LBRACEトークン
+	Pairs []*HashLiteralPair
+}
+
+func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+": "+pair.Value.String())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// IndexExpression は添字アクセス式（配列・ハッシュ）を表すノード
+type IndexExpression struct {
+	Token Token // LBRACKETトークン
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ie.Left.String())
+	out.WriteString("[")
+	out.WriteString(ie.Index.String())
+	out.WriteString("])")
+	return out.String()
+}
+
+// AssignExpression は代入式（=、+=、-=など）を表すノード
+type AssignExpression struct {
+	Token    Token      // 代入演算子トークン
+	Target   Expression // 代入先（識別子または添字アクセス式）
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	return out.String()
+}
+
+// BinaryOperator は複合代入演算子に対応する二項演算子を返す（単純代入の場合は空文字列）
+func (ae *AssignExpression) BinaryOperator() string {
+	if ae.Operator == "=" {
+		return ""
+	}
+	return strings.TrimSuffix(ae.Operator, "=")
+}
+
 // IfExpression はif式を表すノード
+// else if が続く場合はElseIfに後続のif式を保持し、Alternativeは使用しない
 type IfExpression struct {
 	Token       Token // IFトークン
 	Condition   Expression
 	Consequence *BlockStatement
+	ElseIf      *IfExpression
 	Alternative *BlockStatement
 }
 
@@ -197,17 +295,40 @@ func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
-	out.WriteString("if")
-	out.WriteString(ie.Condition.String())
-	out.WriteString(" ")
-	out.WriteString(ie.Consequence.String())
-	if ie.Alternative != nil {
-		out.WriteString("else ")
-		out.WriteString(ie.Alternative.String())
+	switch ie.Condition.(type) {
+	case *InfixExpression, *PrefixExpression, *IndexExpression:
+		// これらの式の文字列は括弧で囲まれている
+		out.WriteString("if " + ie.Condition.String() + " ")
+	default:
+		out.WriteString("if (" + ie.Condition.String() + ") ")
+	}
+	out.WriteString(bracedBlock(ie.Consequence))
+	if ie.ElseIf != nil {
+		out.WriteString(" else ")
+		out.WriteString(ie.ElseIf.String())
+	} else if ie.Alternative != nil {
+		out.WriteString(" else ")
+		out.WriteString(bracedBlock(ie.Alternative))
 	}
 	return out.String()
 }
 
+// bracedBlock はブロックを { } で囲み、再び構文解析できる形の文字列にする
+// 式文は末尾にセミコロンを持たないため、後に文が続く場合はセミコロンで区切る
+func bracedBlock(bs *BlockStatement) string {
+	if len(bs.Statements) == 0 {
+		return "{ }"
+	}
+	parts := make([]string, len(bs.Statements))
+	for i, s := range bs.Statements {
+		parts[i] = s.String()
+		if _, ok := s.(*ExpressionStatement); ok && i < len(bs.Statements)-1 {
+			parts[i] += ";"
+		}
+	}
+	return "{ " + strings.Join(parts, " ") + " }"
+}
+
 // BlockStatement はブロック文を表すノード
 type BlockStatement struct {
 	Token      Token // LBRACEトークン
@@ -226,9 +347,19 @@ func (bs *BlockStatement) String() string {
 
 // FunctionLiteral は関数リテラルを表すノード
 type FunctionLiteral struct {
-	Token      Token // FNトークン
-	Parameters []*Identifier
-	Body       *BlockStatement
+	Token          Token // FNトークン
+	Parameters     []*Identifier
+	ParameterTypes []TypeAnnotation // 各パラメータの型注釈（Parametersと同じ長さ、省略された要素はnil）
+	ReturnType     TypeAnnotation   // 戻り値の型注釈（省略時はnil）
+	Body           *BlockStatement
+}
+
+// ParameterType はi番目のパラメータの型注釈を返す（省略されている場合はnil）
+func (fl *FunctionLiteral) ParameterType(i int) TypeAnnotation {
+	if i < len(fl.ParameterTypes) {
+		return fl.ParameterTypes[i]
+	}
+	return nil
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -236,13 +367,20 @@ func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if typ := fl.ParameterType(i); typ != nil {
+			params = append(params, p.String()+": "+typ.String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 	return out.String()
 }
@@ -271,7 +409,8 @@ func (ce *CallExpression) String() string {
 
 // WhileStatement はwhile文を表すノード
 type WhileStatement struct {
-	Token     Token // WHILEトークン
+	Token     Token       // WHILEトークン
+	Label     *Identifier // ループのラベル（省略時はnil）
 	Condition Expression
 	Body      *BlockStatement
 }
@@ -280,6 +419,9 @@ func (ws *WhileStatement) statementNode()       {}
 func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
 func (ws *WhileStatement) String() string {
 	var out bytes.Buffer
+	if ws.Label != nil {
+		out.WriteString(ws.Label.Value + ": ")
+	}
 	out.WriteString("while ")
 	out.WriteString(ws.Condition.String())
 	out.WriteString(" ")
@@ -289,7 +431,8 @@ func (ws *WhileStatement) String() string {
 
 // ForStatement はfor文を表すノード
 type ForStatement struct {
-	Token       Token // FORトークン
+	Token       Token       // FORトークン
+	Label       *Identifier // ループのラベル（省略時はnil）
 	Initializer Statement
 	Condition   Expression
 	Update      Expression
@@ -300,6 +443,9 @@ func (fs *ForStatement) statementNode()       {}
 func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
 func (fs *ForStatement) String() string {
 	var out bytes.Buffer
+	if fs.Label != nil {
+		out.WriteString(fs.Label.Value + ": ")
+	}
 	out.WriteString("for ")
 	if fs.Initializer != nil {
 		out.WriteString(fs.Initializer.String())
@@ -319,18 +465,89 @@ func (fs *ForStatement) String() string {
 
 // BreakStatement はbreak文を表すノード
 type BreakStatement struct {
-	Token Token // BREAKトークン
+	Token Token       // BREAKトークン
+	Label *Identifier // 脱出するループのラベル（省略時は最も内側のループ）
 }
 
 func (bs *BreakStatement) statementNode()       {}
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
-func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+func (bs *BreakStatement) String() string {
+	if bs.Label != nil {
+		return bs.Token.Literal + " " + bs.Label.Value + ";"
+	}
+	return bs.Token.Literal + ";"
+}
 
 // ContinueStatement はcontinue文を表すノード
 type ContinueStatement struct {
-	Token Token // CONTINUEトークン
+	Token Token       // CONTINUEトークン
+	Label *Identifier // 次の反復に進むループのラベル（省略時は最も内側のループ）
 }
 
 func (cs *ContinueStatement) statementNode()       {}
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
-func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+func (cs *ContinueStatement) String() string {
+	if cs.Label != nil {
+		return cs.Token.Literal + " " + cs.Label.Value + ";"
+	}
+	return cs.Token.Literal + ";"
+}
+
+// TypeAnnotation は型注釈を表すノード
+// 構文は phase2 の型の文字列表現と同じ（int, [int], {string: int}, fn(int) -> bool）
+type TypeAnnotation interface {
+	Node
+	typeNode()
+}
+
+// NamedType は int, float, string, bool の基本型名を表すノード
+type NamedType struct {
+	Token Token // 型キーワードのトークン
+	Name  string
+}
+
+func (nt *NamedType) typeNode()            {}
+func (nt *NamedType) TokenLiteral() string { return nt.Token.Literal }
+func (nt *NamedType) String() string       { return nt.Name }
+
+// ArrayTypeAnnotation は配列型 [T] を表すノード
+type ArrayTypeAnnotation struct {
+	Token       Token // LBRACKETトークン
+	ElementType TypeAnnotation
+}
+
+func (at *ArrayTypeAnnotation) typeNode()            {}
+func (at *ArrayTypeAnnotation) TokenLiteral() string { return at.Token.Literal }
+func (at *ArrayTypeAnnotation) String() string {
+	return "[" + at.ElementType.String() + "]"
+}
+
+// MapTypeAnnotation はハッシュ型 {K: V} を表すノード
+type MapTypeAnnotation struct {
+	Token     Token // LBRACEトークン
+	KeyType   TypeAnnotation
+	ValueType TypeAnnotation
+}
+
+func (mt *MapTypeAnnotation) typeNode()            {}
+func (mt *MapTypeAnnotation) TokenLiteral() string { return mt.Token.Literal }
+func (mt *MapTypeAnnotation) String() string {
+	return "{" + mt.KeyType.String() + ": " + mt.ValueType.String() + "}"
+}
+
+// FunctionTypeAnnotation は関数型 fn(T1, T2) -> R を表すノード
+type FunctionTypeAnnotation struct {
+	Token      Token // FNトークン
+	Parameters []TypeAnnotation
+	ReturnType TypeAnnotation
+}
+
+func (ft *FunctionTypeAnnotation) typeNode()            {}
+func (ft *FunctionTypeAnnotation) TokenLiteral() string { return ft.Token.Literal }
+func (ft *FunctionTypeAnnotation) String() string {
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, p.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + ") -> " + ft.ReturnType.String()
+}
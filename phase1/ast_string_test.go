@@ -167,7 +167,7 @@ func TestASTStringMethods(t *testing.T) {
 					},
 				},
 			},
-			expected: "iftrue 1else 2",
+			expected: "if (true) { 1 } else { 2 }",
 		},
 		{
 			name: "IfExpression without alternative",
@@ -191,7 +191,7 @@ func TestASTStringMethods(t *testing.T) {
 				},
 				Alternative: nil,
 			},
-			expected: "iftrue 1",
+			expected: "if (true) { 1 }",
 		},
 		{
 			name: "FunctionLiteral with parameters",
@@ -0,0 +1,434 @@
+package phase1
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity は診断の重大度を表す
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+// String は重大度の表示名を返す
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return "unknown"
+	}
+}
+
+// 字句解析器・構文解析器が発行する診断のエラーコード
+const (
+	CodeIllegalCharacter   = "E0001" // 不正な文字
+	CodeUnterminatedString = "E0002" // 閉じられていない文字列リテラル
+	CodeUnexpectedToken    = "E0101" // 期待したトークンと異なる
+	CodeExpectedExpression = "E0102" // 式が必要な位置に式がない
+	CodeInvalidNumber      = "E0103" // 数値リテラルを解釈できない
+	CodeInvalidAssignment  = "E0104" // 代入先として不正な式
+	CodeExpectedType       = "E0105" // 型注釈が必要な位置に型がない
+	CodeLabelWithoutLoop   = "E0106" // ループ以外の文に付けられたラベル
+)
+
+// Span はソースコード上の範囲を表す（行・列は1始まり、Offsetはバイト単位）
+type Span struct {
+	Line   int
+	Column int
+	Offset int
+	Length int
+}
+
+// IsValid は位置情報を持つスパンかどうかを返す
+func (s Span) IsValid() bool {
+	return s.Line > 0
+}
+
+// End はスパン末尾の次のバイトオフセットを返す
+func (s Span) End() int {
+	return s.Offset + s.Length
+}
+
+// String は "行:列" 形式の位置を返す
+func (s Span) String() string {
+	return fmt.Sprintf("%d:%d", s.Line, s.Column)
+}
+
+// SpanFromToken はトークンが占める範囲のスパンを作成する
+func SpanFromToken(tok Token) Span {
+	length := len(tok.Literal)
+	if tok.Type == STRING {
+		// リテラルには引用符が含まれないため、その分を加える
+		length += 2
+	}
+	if length == 0 {
+		length = 1
+	}
+	return Span{Line: tok.Line, Column: tok.Column, Offset: tok.Position, Length: length}
+}
+
+// joinSpans は同じ行にある2つのスパンを覆うスパンを返す（行が異なる場合は先頭のスパン）
+func joinSpans(start, end Span) Span {
+	if !start.IsValid() {
+		return end
+	}
+	if !end.IsValid() || end.Line != start.Line || end.End() < start.Offset {
+		return start
+	}
+	start.Length = end.End() - start.Offset
+	return start
+}
+
+// NodeSpan はASTノードが占めるおおよその範囲を返す
+// 二項演算や呼び出しなどは同じ行に収まる範囲で式全体を覆う
+func NodeSpan(node Node) Span {
+	switch n := node.(type) {
+	case nil:
+		return Span{}
+	case *Program:
+		if len(n.Statements) > 0 {
+			return NodeSpan(n.Statements[0])
+		}
+		return Span{}
+	case *LetStatement:
+		return SpanFromToken(n.Token)
+	case *ReturnStatement:
+		return SpanFromToken(n.Token)
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			return NodeSpan(n.Expression)
+		}
+		return SpanFromToken(n.Token)
+	case *BlockStatement:
+		return SpanFromToken(n.Token)
+	case *WhileStatement:
+		return SpanFromToken(n.Token)
+	case *ForStatement:
+		return SpanFromToken(n.Token)
+	case *BreakStatement:
+		if n.Label != nil {
+			return joinSpans(SpanFromToken(n.Token), SpanFromToken(n.Label.Token))
+		}
+		return SpanFromToken(n.Token)
+	case *ContinueStatement:
+		if n.Label != nil {
+			return joinSpans(SpanFromToken(n.Token), SpanFromToken(n.Label.Token))
+		}
+		return SpanFromToken(n.Token)
+	case *Identifier:
+		return SpanFromToken(n.Token)
+	case *IntegerLiteral:
+		return SpanFromToken(n.Token)
+	case *FloatLiteral:
+		return SpanFromToken(n.Token)
+	case *StringLiteral:
+		return SpanFromToken(n.Token)
+	case *Boolean:
+		return SpanFromToken(n.Token)
+	case *PrefixExpression:
+		return joinSpans(SpanFromToken(n.Token), NodeSpan(n.Right))
+	case *InfixExpression:
+		if n.Left == nil {
+			return SpanFromToken(n.Token)
+		}
+		return joinSpans(NodeSpan(n.Left), NodeSpan(n.Right))
+	case *AssignExpression:
+		return joinSpans(NodeSpan(n.Target), NodeSpan(n.Value))
+	case *IndexExpression:
+		span := joinSpans(NodeSpan(n.Left), NodeSpan(n.Index))
+		if span.Line == NodeSpan(n.Index).Line {
+			span.Length++ // 閉じ括弧 ']'
+		}
+		return span
+	case *CallExpression:
+		end := SpanFromToken(n.Token)
+		if len(n.Arguments) > 0 {
+			end = NodeSpan(n.Arguments[len(n.Arguments)-1])
+		}
+		span := joinSpans(NodeSpan(n.Function), end)
+		if span.Line == end.Line {
+			span.Length++ // 閉じ括弧 ')'
+		}
+		return span
+	case *ArrayLiteral:
+		return SpanFromToken(n.Token)
+	case *HashLiteral:
+		return SpanFromToken(n.Token)
+	case *IfExpression:
+		return SpanFromToken(n.Token)
+	case *FunctionLiteral:
+		return SpanFromToken(n.Token)
+	case *NamedType:
+		return SpanFromToken(n.Token)
+	case *ArrayTypeAnnotation:
+		return SpanFromToken(n.Token)
+	case *MapTypeAnnotation:
+		return SpanFromToken(n.Token)
+	case *FunctionTypeAnnotation:
+		return SpanFromToken(n.Token)
+	default:
+		return Span{}
+	}
+}
+
+// Label は診断に添える副次的な位置と説明を表す
+type Label struct {
+	Span    Span
+	Message string
+}
+
+// Diagnostic は各フェーズが報告するエラー・警告を表す
+type Diagnostic struct {
+	Severity Severity
+	Code     string   // "E0101" のようなエラーコード（省略可）
+	Message  string   // 診断の本文
+	Span     Span     // 主要な位置
+	Label    string   // 主要な位置に添える短い説明（省略可）
+	Labels   []Label  // 副次的な位置と説明
+	Notes    []string // 補足説明
+}
+
+// NewDiagnostic は新しい診断を作成する
+func NewDiagnostic(severity Severity, code string, span Span, message string) *Diagnostic {
+	return &Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  message,
+		Span:     span,
+	}
+}
+
+// Errorf はエラー診断を作成する
+func Errorf(code string, span Span, format string, a ...any) *Diagnostic {
+	return NewDiagnostic(SeverityError, code, span, fmt.Sprintf(format, a...))
+}
+
+// Warningf は警告診断を作成する
+func Warningf(code string, span Span, format string, a ...any) *Diagnostic {
+	return NewDiagnostic(SeverityWarning, code, span, fmt.Sprintf(format, a...))
+}
+
+// WithLabel は主要な位置に説明を付ける
+func (d *Diagnostic) WithLabel(message string) *Diagnostic {
+	d.Label = message
+	return d
+}
+
+// WithSecondary は副次的な位置と説明を追加する
+func (d *Diagnostic) WithSecondary(span Span, message string) *Diagnostic {
+	d.Labels = append(d.Labels, Label{Span: span, Message: message})
+	return d
+}
+
+// WithNote は補足説明を追加する
+func (d *Diagnostic) WithNote(note string) *Diagnostic {
+	d.Notes = append(d.Notes, note)
+	return d
+}
+
+// header は "error[E0101]: message" 形式の見出しを返す
+func (d *Diagnostic) header() string {
+	if d.Code == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s[%s]: %s", d.Severity, d.Code, d.Message)
+}
+
+// Error は1行形式の診断を返す（error インターフェースの実装）
+func (d *Diagnostic) Error() string {
+	if !d.Span.IsValid() {
+		return d.header()
+	}
+	return fmt.Sprintf("%s: %s", d.Span, d.header())
+}
+
+// String は1行形式の診断を返す
+func (d *Diagnostic) String() string {
+	return d.Error()
+}
+
+// annotation はソース行に下線を引く1つの注記
+type annotation struct {
+	span    Span
+	marker  string
+	message string
+}
+
+// Render はソース行とキャレットによる下線付きの rustc 形式で診断を整形する
+//
+//	error[E0101]: expected next token to be ), got ; instead
+//	 --> main.dog:1:15
+//	  |
+//	1 | let x = (5 + 3;
+//	  |               ^ expected )
+func (d *Diagnostic) Render(filename, source string) string {
+	var out strings.Builder
+	out.WriteString(d.header())
+	out.WriteString("\n")
+
+	if filename == "" {
+		filename = "<input>"
+	}
+
+	annotations := []annotation{}
+	if d.Span.IsValid() {
+		annotations = append(annotations, annotation{span: d.Span, marker: "^", message: d.Label})
+	}
+	for _, label := range d.Labels {
+		if label.Span.IsValid() {
+			annotations = append(annotations, annotation{span: label.Span, marker: "-", message: label.Message})
+		}
+	}
+
+	maxLine := 0
+	for _, a := range annotations {
+		if a.span.Line > maxLine {
+			maxLine = a.span.Line
+		}
+	}
+	pad := strings.Repeat(" ", len(strconv.Itoa(maxLine)))
+
+	if len(annotations) > 0 {
+		fmt.Fprintf(&out, "%s--> %s:%d:%d\n", pad, filename, d.Span.Line, d.Span.Column)
+		fmt.Fprintf(&out, "%s |\n", pad)
+
+		sort.SliceStable(annotations, func(i, j int) bool {
+			if annotations[i].span.Line != annotations[j].span.Line {
+				return annotations[i].span.Line < annotations[j].span.Line
+			}
+			return annotations[i].span.Column < annotations[j].span.Column
+		})
+
+		lines := strings.Split(source, "\n")
+		prevLine := 0
+		for _, a := range annotations {
+			text := sourceLine(lines, a.span.Line)
+			if a.span.Line != prevLine {
+				if prevLine != 0 && a.span.Line > prevLine+1 {
+					out.WriteString("...\n")
+				}
+				fmt.Fprintf(&out, "%*d | %s\n", len(pad), a.span.Line, text)
+				prevLine = a.span.Line
+			}
+			fmt.Fprintf(&out, "%s | %s\n", pad, underline(text, a))
+		}
+	}
+
+	if len(d.Notes) > 0 {
+		if len(annotations) > 0 {
+			fmt.Fprintf(&out, "%s |\n", pad)
+		}
+		for _, note := range d.Notes {
+			fmt.Fprintf(&out, "%s = note: %s\n", pad, note)
+		}
+	}
+
+	return out.String()
+}
+
+// sourceLine は1始まりの行番号に対応するソース行を返す
+func sourceLine(lines []string, line int) string {
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// underline は注記の位置に合わせた下線行を作成する
+func underline(text string, a annotation) string {
+	start := a.span.Column - 1
+	if start < 0 {
+		start = 0
+	}
+	if start > len(text) {
+		start = len(text)
+	}
+	end := start + a.span.Length
+	if end > len(text) {
+		end = len(text)
+	}
+
+	// タブはそのまま残し、それ以外は表示幅ぶんの空白に置き換えて位置を揃える
+	var prefix strings.Builder
+	for _, r := range text[:start] {
+		if r == '\t' {
+			prefix.WriteRune('\t')
+		} else {
+			prefix.WriteString(strings.Repeat(" ", runeWidth(r)))
+		}
+	}
+
+	width := 0
+	for _, r := range text[start:end] {
+		width += runeWidth(r)
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	result := prefix.String() + strings.Repeat(a.marker, width)
+	if a.message != "" {
+		result += " " + a.message
+	}
+	return strings.TrimRight(result, " ")
+}
+
+// runeWidth は端末上での文字の表示幅を返す（全角文字は2）
+func runeWidth(r rune) int {
+	switch {
+	case r == utf8.RuneError:
+		return 1
+	case r >= 0x1100 && r <= 0x115F,
+		r >= 0x2E80 && r <= 0xA4CF,
+		r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF,
+		r >= 0xFE30 && r <= 0xFE4F,
+		r >= 0xFF00 && r <= 0xFF60,
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1FAFF:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// RenderDiagnostics は複数の診断をまとめて整形する
+func RenderDiagnostics(diagnostics []*Diagnostic, filename, source string) string {
+	var out strings.Builder
+	for i, d := range diagnostics {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(d.Render(filename, source))
+	}
+	return out.String()
+}
+
+// HasErrors は診断の中にエラーが含まれるかどうかを返す
+func HasErrors(diagnostics []*Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// SortDiagnostics は診断をソース上の位置順に並べ替える（同じ位置では元の順序を保つ）
+func SortDiagnostics(diagnostics []*Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Span.Offset < diagnostics[j].Span.Offset
+	})
+}
@@ -0,0 +1,197 @@
+package phase1
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLexerDiagnostics は字句解析器が不正な入力に対して診断を記録することをテストする
+func TestLexerDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		code     string
+		expected Span
+	}{
+		{"不正な文字", "let x = 1 @ 2;", CodeIllegalCharacter, Span{Line: 1, Column: 11, Offset: 10, Length: 1}},
+		{"単独の&", "a & b", CodeIllegalCharacter, Span{Line: 1, Column: 3, Offset: 2, Length: 1}},
+		{"閉じられていない文字列", "let s = 1;\nlet t = \"abc", CodeUnterminatedString, Span{Line: 2, Column: 9, Offset: 19, Length: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.input)
+			for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+			}
+
+			diagnostics := l.Diagnostics()
+			if len(diagnostics) != 1 {
+				t.Fatalf("expected 1 diagnostic, got %d: %v", len(diagnostics), diagnostics)
+			}
+			d := diagnostics[0]
+			if d.Code != tt.code {
+				t.Errorf("wrong code. expected=%s, got=%s", tt.code, d.Code)
+			}
+			if d.Span != tt.expected {
+				t.Errorf("wrong span. expected=%+v, got=%+v", tt.expected, d.Span)
+			}
+		})
+	}
+}
+
+// TestParserDiagnostics は構文エラーの診断が位置情報とエラーコードを持つことをテストする
+func TestParserDiagnostics(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		code    string
+		line    int
+		column  int
+		message string
+	}{
+		{"閉じ括弧の欠落", "let x = 1;\nlet y = (5 + 3;", CodeUnexpectedToken, 2, 15, "expected next token to be ), got ; instead"},
+		{"式の欠落", "let x = ;", CodeExpectedExpression, 1, 9, "no prefix parse function for ; found"},
+		{"不正な代入先", "1 + 2 = 3;", CodeInvalidAssignment, 1, 1, "invalid assignment target: (1 + 2)"},
+		{"不正な文字は字句解析の診断のみ", "let x = @;", CodeIllegalCharacter, 1, 9, "unexpected character '@'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(New(tt.input))
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) == 0 {
+				t.Fatalf("expected diagnostics, got none")
+			}
+			d := diagnostics[0]
+			if d.Code != tt.code {
+				t.Errorf("wrong code. expected=%s, got=%s", tt.code, d.Code)
+			}
+			if d.Span.Line != tt.line || d.Span.Column != tt.column {
+				t.Errorf("wrong position. expected=%d:%d, got=%s", tt.line, tt.column, d.Span)
+			}
+			if d.Message != tt.message {
+				t.Errorf("wrong message. expected=%q, got=%q", tt.message, d.Message)
+			}
+			for _, other := range diagnostics[1:] {
+				if other.Span.Offset == d.Span.Offset {
+					t.Errorf("duplicate diagnostic at the same position: %s", other)
+				}
+			}
+		})
+	}
+}
+
+// TestParserErrorsMatchDiagnostics はErrorsが構文解析の診断メッセージと一致することをテストする
+func TestParserErrorsMatchDiagnostics(t *testing.T) {
+	p := NewParser(New("let = 5; let y 3;"))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	diagnostics := p.Diagnostics()
+	if len(errors) == 0 || len(errors) != len(diagnostics) {
+		t.Fatalf("expected matching errors and diagnostics, got %v and %v", errors, diagnostics)
+	}
+	for i, d := range diagnostics {
+		if errors[i] != d.Message {
+			t.Errorf("errors[%d] = %q, want %q", i, errors[i], d.Message)
+		}
+	}
+}
+
+// TestNodeSpan はASTノードのスパンが式全体を覆うことをテストする
+func TestNodeSpan(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"foo;", "foo"},
+		{"1 + 2 * 3;", "1 + 2 * 3"},
+		{"-x;", "-x"},
+		{"add(1, 2);", "add(1, 2)"},
+		{"f();", "f()"},
+		{"arr[10];", "arr[10]"},
+		{"x += \"ab\";", "x += \"ab\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := NewParser(New(tt.input))
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			span := NodeSpan(program.Statements[0])
+			got := tt.input[span.Offset:span.End()]
+			if got != tt.expected {
+				t.Errorf("NodeSpan covers %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestDiagnosticRender はrustc形式の整形結果をテストする
+func TestDiagnosticRender(t *testing.T) {
+	source := "let a = 1;\nlet b = a + \"x\";\n"
+	d := Errorf("E0202", Span{Line: 2, Column: 13, Offset: 23, Length: 3}, "mismatched types").
+		WithLabel("expected int").
+		WithSecondary(Span{Line: 2, Column: 9, Offset: 19, Length: 1}, "int").
+		WithNote("strings cannot be added to integers")
+
+	expected := strings.Join([]string{
+		"error[E0202]: mismatched types",
+		" --> main.dog:2:13",
+		"  |",
+		"2 | let b = a + \"x\";",
+		"  |         - int",
+		"  |             ^^^ expected int",
+		"  |",
+		"  = note: strings cannot be added to integers",
+		"",
+	}, "\n")
+
+	if got := d.Render("main.dog", source); got != expected {
+		t.Errorf("Render() wrong.\nexpected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// TestDiagnosticRenderMultipleLines は複数行にまたがるラベルと行番号の桁揃えをテストする
+func TestDiagnosticRenderMultipleLines(t *testing.T) {
+	source := strings.Repeat("\n", 9) + "let x = 1;\nfoo;\n\n\nx = true;"
+	d := Errorf("", Span{Line: 14, Column: 5, Offset: 31, Length: 4}, "bad").
+		WithSecondary(Span{Line: 10, Column: 5, Offset: 13, Length: 1}, "declared here")
+
+	got := d.Render("", source)
+	for _, want := range []string{
+		"error: bad\n",
+		"  --> <input>:14:5\n",
+		"10 | let x = 1;\n",
+		"   |     - declared here\n",
+		"...\n",
+		"14 | x = true;\n",
+		"   |     ^^^^\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+// TestDiagnosticError は1行形式の表現をテストする
+func TestDiagnosticError(t *testing.T) {
+	d := Errorf(CodeUnexpectedToken, Span{Line: 3, Column: 7, Offset: 20, Length: 1}, "unexpected %s", ";")
+	if got := d.Error(); got != "3:7: error[E0101]: unexpected ;" {
+		t.Errorf("Error() = %q", got)
+	}
+
+	w := Warningf("", Span{}, "unused")
+	if got := w.Error(); got != "warning: unused" {
+		t.Errorf("Error() = %q", got)
+	}
+	if HasErrors([]*Diagnostic{w}) {
+		t.Errorf("HasErrors() should ignore warnings")
+	}
+	if !HasErrors([]*Diagnostic{w, d}) {
+		t.Errorf("HasErrors() should report errors")
+	}
+}
@@ -4,6 +4,14 @@ package phase1
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	frame *CallFrame // この環境を評価している関数呼び出し（トップレベルではnil）
+}
+
+// CallFrame は関数呼び出しのスタックフレームを表す
+type CallFrame struct {
+	Function string     // 呼び出された関数の式（例: "fib"）
+	Span     Span       // 呼び出し位置
+	Parent   *CallFrame // 呼び出し元のフレーム
 }
 
 // NewEnvironment は新しい環境を作成する
@@ -16,6 +24,7 @@ func NewEnvironment() *Environment {
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.frame = outer.frame
 	return env
 }
 
@@ -33,3 +42,16 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign は既存の変数束縛を最も内側のスコープから探して更新する
+// 束縛が見つからない場合はfalseを返す
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return val, true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return nil, false
+}
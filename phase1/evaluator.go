@@ -3,7 +3,17 @@ package phase1
 import "fmt"
 
 // Eval はASTノードを評価してオブジェクトを返す
+// 評価中に発生したエラーには、最初に検出したノードの位置と呼び出しスタックを記録する
 func Eval(node Node, env *Environment) Object {
+	result := evalNode(node, env)
+	if err, ok := result.(*Error); ok && !err.Span.IsValid() {
+		attachPosition(err, node, env)
+	}
+	return result
+}
+
+// evalNode はノードの種類に応じて評価を振り分ける
+func evalNode(node Node, env *Environment) Object {
 	switch node := node.(type) {
 
 	// Program
@@ -32,6 +42,24 @@ func Eval(node Node, env *Environment) Object {
 	case *BlockStatement:
 		return evalBlockStatement(node, env)
 
+	case *WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ForStatement:
+		return evalForStatement(node, env)
+
+	case *BreakStatement:
+		if node.Label != nil {
+			return &BreakSignal{Label: node.Label.Value}
+		}
+		return BREAK_OBJ_INSTANCE
+
+	case *ContinueStatement:
+		if node.Label != nil {
+			return &ContinueSignal{Label: node.Label.Value}
+		}
+		return CONTINUE_OBJ_INSTANCE
+
 	// Expressions
 	case *IntegerLiteral:
 		return &Integer{Value: node.Value}
@@ -53,6 +81,9 @@ func Eval(node Node, env *Environment) Object {
 		return evalPrefixExpression(node.Operator, right)
 
 	case *InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -63,6 +94,30 @@ func Eval(node Node, env *Environment) Object {
 		}
 		return evalInfixExpression(node.Operator, left, right)
 
+	case *AssignExpression:
+		return evalAssignExpression(node, env)
+
+	case *ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &Array{Elements: elements}
+
+	case *HashLiteral:
+		return evalHashLiteral(node, env)
+
+	case *IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+		return evalIndexExpression(left, index)
+
 	case *IfExpression:
 		return evalIfExpression(node, env)
 
@@ -83,7 +138,8 @@ func Eval(node Node, env *Environment) Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		frame := &CallFrame{Function: node.Function.String(), Span: NodeSpan(node), Parent: env.frame}
+		return applyFunction(function, args, frame)
 
 	default:
 		return newError("unknown node type: %T", node)
@@ -102,6 +158,10 @@ func evalProgram(stmts []Statement, env *Environment) Object {
 			return result.Value
 		case *Error:
 			return result
+		case *BreakSignal:
+			return attachPosition(newError("break statement outside of loop"), statement, env)
+		case *ContinueSignal:
+			return attachPosition(newError("continue statement outside of loop"), statement, env)
 		}
 	}
 
@@ -117,7 +177,7 @@ func evalBlockStatement(block *BlockStatement, env *Environment) Object {
 
 		if result != nil {
 			rt := result.Type()
-			if rt == RETURN_VALUE_OBJ || rt == ERROR_OBJ {
+			if rt == RETURN_VALUE_OBJ || rt == ERROR_OBJ || rt == BREAK_OBJ || rt == CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -126,6 +186,98 @@ func evalBlockStatement(block *BlockStatement, env *Environment) Object {
 	return result
 }
 
+// evalWhileStatement はwhile文を評価する
+func evalWhileStatement(ws *WhileStatement, env *Environment) Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			break
+		}
+
+		result, done := evalLoopBody(ws.Body, ws.Label, env)
+		if done {
+			return result
+		}
+	}
+
+	return NULL_OBJ_INSTANCE
+}
+
+// evalForStatement はfor文を評価する
+// 初期化文で定義された変数がループの外に漏れないよう、専用の環境を作成する
+func evalForStatement(fs *ForStatement, env *Environment) Object {
+	loopEnv := NewEnclosedEnvironment(env)
+
+	if fs.Initializer != nil {
+		init := Eval(fs.Initializer, loopEnv)
+		if isError(init) {
+			return init
+		}
+	}
+
+	for {
+		if fs.Condition != nil {
+			condition := Eval(fs.Condition, loopEnv)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				break
+			}
+		}
+
+		result, done := evalLoopBody(fs.Body, fs.Label, loopEnv)
+		if done {
+			return result
+		}
+
+		if fs.Update != nil {
+			update := Eval(fs.Update, loopEnv)
+			if isError(update) {
+				return update
+			}
+		}
+	}
+
+	return NULL_OBJ_INSTANCE
+}
+
+// evalLoopBody はループ本体を1回評価する
+// ループを終了すべき場合はdoneがtrueになり、resultがループ全体の評価結果となる
+// 別のループのラベルを指すbreak/continueは、外側のループへ伝播させるためresultとして返す
+func evalLoopBody(body *BlockStatement, label *Identifier, env *Environment) (result Object, done bool) {
+	evaluated := Eval(body, env)
+	if evaluated == nil {
+		return nil, false
+	}
+
+	switch signal := evaluated.(type) {
+	case *BreakSignal:
+		if !targetsLoop(signal.Label, label) {
+			return signal, true
+		}
+		return NULL_OBJ_INSTANCE, true
+	case *ContinueSignal:
+		if !targetsLoop(signal.Label, label) {
+			return signal, true
+		}
+		return nil, false
+	case *ReturnValue, *Error:
+		return evaluated, true
+	}
+
+	return nil, false
+}
+
+// targetsLoop はbreak/continueのラベルがループを指すかどうかを判定する
+// ラベルのないbreak/continueは最も内側のループを指す
+func targetsLoop(target string, label *Identifier) bool {
+	return target == "" || (label != nil && label.Value == target)
+}
+
 // evalPrefixExpression は前置演算子式を評価する
 func evalPrefixExpression(operator string, right Object) Object {
 	switch operator {
@@ -203,6 +355,30 @@ func evalInfixExpression(operator string, left, right Object) Object {
 	}
 }
 
+// evalLogicalExpression は論理演算子（&&、||）を短絡評価する
+// 左辺だけで結果が決まる場合、右辺は評価しない
+func evalLogicalExpression(node *InfixExpression, env *Environment) Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	leftTruthy := isTruthy(left)
+	if node.Operator == "&&" && !leftTruthy {
+		return FALSE_OBJ_INSTANCE
+	}
+	if node.Operator == "||" && leftTruthy {
+		return TRUE_OBJ_INSTANCE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+
+	return nativeBoolToPugBoolean(isTruthy(right))
+}
+
 // evalIntegerInfixExpression は整数同士の中置演算子を評価する
 func evalIntegerInfixExpression(operator string, left, right Object) Object {
 	leftVal := left.(*Integer).Value
@@ -294,6 +470,160 @@ func evalStringInfixExpression(operator string, left, right Object) Object {
 	}
 }
 
+// evalAssignExpression は代入式を評価する
+// 代入先は最も近い外側のスコープにある既存の束縛で、未宣言の変数への代入はエラーとなる
+func evalAssignExpression(node *AssignExpression, env *Environment) Object {
+	switch target := node.Target.(type) {
+	case *Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			return newError("assignment to undeclared variable: %s", target.Value)
+		}
+
+		val := evalAssignedValue(node, current, env)
+		if isError(val) {
+			return val
+		}
+
+		env.Assign(target.Value, val)
+		return val
+
+	case *IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		current := evalIndexExpression(left, index)
+		if isError(current) {
+			return current
+		}
+
+		val := evalAssignedValue(node, current, env)
+		if isError(val) {
+			return val
+		}
+
+		return evalIndexAssignment(left, index, val)
+
+	default:
+		return newError("invalid assignment target: %s", node.Target.String())
+	}
+}
+
+// evalAssignedValue は代入する値を評価する（複合代入の場合は現在の値との演算結果）
+func evalAssignedValue(node *AssignExpression, current Object, env *Environment) Object {
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if op := node.BinaryOperator(); op != "" {
+		return evalInfixExpression(op, current, val)
+	}
+
+	return val
+}
+
+// evalIndexExpression は添字アクセス式を評価する
+func evalIndexExpression(left, index Object) Object {
+	switch {
+	case left.Type() == ARRAY_OBJ && index.Type() == INTEGER_OBJ:
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == HASH_OBJ:
+		return evalHashIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// evalHashLiteral はハッシュリテラルを評価する
+func evalHashLiteral(node *HashLiteral, env *Environment) Object {
+	pairs := make(map[HashKey]HashPair)
+
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if isError(key) {
+			return key
+		}
+
+		hashKey, ok := key.(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(pair.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		pairs[hashKey.HashKey()] = HashPair{Key: key, Value: value}
+	}
+
+	return &Hash{Pairs: pairs}
+}
+
+// evalHashIndexExpression はハッシュのキー参照を評価する（キーが存在しない場合はnull）
+func evalHashIndexExpression(hash, index Object) Object {
+	hashObject := hash.(*Hash)
+
+	key, ok := index.(Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return NULL_OBJ_INSTANCE
+	}
+
+	return pair.Value
+}
+
+// evalArrayIndexExpression は配列の添字アクセスを評価する
+func evalArrayIndexExpression(array, index Object) Object {
+	arrayObject := array.(*Array)
+	idx := index.(*Integer).Value
+	length := int64(len(arrayObject.Elements))
+
+	if idx < 0 || idx >= length {
+		return newError("index out of range: %d (length %d)", idx, length)
+	}
+
+	return arrayObject.Elements[idx]
+}
+
+// evalIndexAssignment は添字アクセス式への代入を評価する（配列・ハッシュはその場で更新される）
+func evalIndexAssignment(left, index, val Object) Object {
+	switch {
+	case left.Type() == ARRAY_OBJ && index.Type() == INTEGER_OBJ:
+		arrayObject := left.(*Array)
+		idx := index.(*Integer).Value
+		length := int64(len(arrayObject.Elements))
+
+		if idx < 0 || idx >= length {
+			return newError("index out of range: %d (length %d)", idx, length)
+		}
+
+		arrayObject.Elements[idx] = val
+		return val
+	case left.Type() == HASH_OBJ:
+		key, ok := index.(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+
+		left.(*Hash).Pairs[key.HashKey()] = HashPair{Key: index, Value: val}
+		return val
+	default:
+		return newError("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
 // evalIfExpression はif式を評価する
 func evalIfExpression(ie *IfExpression, env *Environment) Object {
 	condition := Eval(ie.Condition, env)
@@ -303,6 +633,8 @@ func evalIfExpression(ie *IfExpression, env *Environment) Object {
 
 	if isTruthy(condition) {
 		return Eval(ie.Consequence, env)
+	} else if ie.ElseIf != nil {
+		return evalIfExpression(ie.ElseIf, env)
 	} else if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
 	} else {
@@ -339,7 +671,8 @@ func evalExpressions(exps []Expression, env *Environment) []Object {
 }
 
 // applyFunction は関数を適用する
-func applyFunction(fn Object, args []Object) Object {
+// frame は呼び出し位置を表すフレームで、関数本体の評価中の呼び出しスタックの先頭になる
+func applyFunction(fn Object, args []Object, frame *CallFrame) Object {
 	switch fn := fn.(type) {
 	case *Function:
 		// 引数の数をチェック
@@ -347,7 +680,15 @@ func applyFunction(fn Object, args []Object) Object {
 			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
 		}
 		extendedEnv := extendFunctionEnv(fn, args)
+		extendedEnv.frame = frame
 		evaluated := Eval(fn.Body, extendedEnv)
+		// break/continueは関数境界を越えて伝播させない
+		switch evaluated.(type) {
+		case *BreakSignal:
+			return newError("break statement outside of loop")
+		case *ContinueSignal:
+			return newError("continue statement outside of loop")
+		}
 		return unwrapReturnValue(evaluated)
 	case *Builtin:
 		return fn.Fn(args...)
@@ -402,6 +743,13 @@ func newError(format string, a ...any) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// attachPosition はエラーに発生位置と、その時点の呼び出しスタックを記録する
+func attachPosition(err *Error, node Node, env *Environment) *Error {
+	err.Span = NodeSpan(node)
+	err.Frame = env.frame
+	return err
+}
+
 // nativeBoolToPugBoolean はGoのboolをPugのBooleanオブジェクトに変換する
 func nativeBoolToPugBoolean(input bool) *BooleanObj {
 	if input {
@@ -1,6 +1,7 @@
 package phase1
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -134,6 +135,11 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", nil},
+		{"if (1 < 2) { 10 } else if (undefined) { 20 }", 10},
+		{"let x = 3; if (x == 1) { 10 } else if (x == 2) { 20 } else if (x == 3) { 30 } else { 40 }", 30},
 	}
 
 	for _, tt := range tests {
@@ -431,6 +437,479 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a = a + 1;", 6},
+		{"let a = 1; let b = 2; a = b = 7; a + b;", 14},
+		{"let a = 5; a += 3; a;", 8},
+		{"let a = 5; a -= 3; a;", 2},
+		{"let a = 5; a *= 3; a;", 15},
+		{"let a = 17; a /= 3; a;", 5},
+		{"let a = 17; a %= 3; a;", 2},
+		{"let a = 1; let f = fn() { a = a + 1; }; f(); f(); a;", 3},
+		{"let a = 1; let f = fn() { let a = 10; a = 20; }; f(); a;", 1},
+		{"let i = 0; while (i < 5) { i += 1; } i;", 5},
+		{"let sum = 0; for (let i = 0; i < 5; i = i + 1) { sum += i; } sum;", 10},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestAssignExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"x = 5;", "assignment to undeclared variable: x"},
+		{"let f = fn() { y = 1; }; f();", "assignment to undeclared variable: y"},
+		{"let a = 1; a += true;", "unknown operator: INTEGER + BOOLEAN"},
+		{"let a = 1; a /= 0;", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d",
+			len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"let a = [1, 2, 3]; a[1] = 20; a[1];", 20},
+		{"let a = [1, 2, 3]; a[2] += 5; a[2];", 8},
+		{"let a = [1, 2]; let b = a; b[0] = 9; a[0];", 9},
+		{"let grid = [[1, 2], [3, 4]]; grid[1][0] = 7; grid[1][0];", 7},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestArrayIndexErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"[1, 2, 3][3]", "index out of range: 3 (length 3)"},
+		{"[1, 2, 3][-1]", "index out of range: -1 (length 3)"},
+		{"[][0]", "index out of range: 0 (length 0)"},
+		{"let a = [1]; a[1] = 2;", "index out of range: 1 (length 1)"},
+		{`[1, 2]["a"]`, "index operator not supported: ARRAY[STRING]"},
+		{"5[0]", "index operator not supported: INTEGER[INTEGER]"},
+		{"[1, 2][0 + true]", "unknown operator: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := map[HashKey]int64{
+		(&String{Value: "one"}).HashKey():   1,
+		(&String{Value: "two"}).HashKey():   2,
+		(&String{Value: "three"}).HashKey(): 3,
+		(&Integer{Value: 4}).HashKey():      4,
+		TRUE_OBJ_INSTANCE.HashKey():         5,
+		FALSE_OBJ_INSTANCE.HashKey():        6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+		{`let h = {"a": 1}; h["a"] = 2; h["a"]`, 2},
+		{`let h = {"a": 1}; h["b"] = 3; h["b"] + h["a"]`, 4},
+		{`let h = {"a": 1}; h["a"] += 10; h["a"]`, 11},
+		{`let h = {"a": [1, 2]}; h["a"][1] = 7; h["a"][1]`, 7},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashKeyErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`{"name": "Pug"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{`{fn(x) { x }: 1}`, "unusable as hash key: FUNCTION"},
+		{`{[1]: 1}`, "unusable as hash key: ARRAY"},
+		{`{1.5: 1}`, "unusable as hash key: FLOAT"},
+		{`let h = {}; h[[1]] = 1;`, "unusable as hash key: ARRAY"},
+		{`let h = {}; h[2.5] = 1;`, "unusable as hash key: FLOAT"},
+		{`{1: 2 + true}`, "unknown operator: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestQuicksort(t *testing.T) {
+	input := `
+let quicksort = fn(arr) {
+    if (len(arr) <= 1) {
+        return arr;
+    }
+
+    let pivot = first(arr);
+    let less = [];
+    let greater = [];
+    let rest_arr = rest(arr);
+
+    let i = 0;
+    while (i < len(rest_arr)) {
+        let elem = rest_arr[i];
+        if (elem < pivot) {
+            less = push(less, elem);
+        } else {
+            greater = push(greater, elem);
+        }
+        i = i + 1;
+    }
+
+    let sorted = push(quicksort(less), pivot);
+    let right = quicksort(greater);
+    let j = 0;
+    while (j < len(right)) {
+        sorted = push(sorted, right[j]);
+        j = j + 1;
+    }
+    return sorted;
+};
+
+quicksort([64, 34, 25, 12, 22, 11, 90, 5, 77, 30]);`
+
+	evaluated := testEval(input)
+	if evaluated.Inspect() != "[5, 11, 12, 22, 25, 30, 34, 64, 77, 90]" {
+		t.Errorf("wrong sorted result. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 < 2 && 3 < 2", false},
+		{"1 > 2 || 2 > 3 || 3 > 2", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		// 右辺は評価されないため、エラーにならない
+		{"false && 1 / 0 == 0", false},
+		{"true || undefined_identifier", true},
+		{"let called = false; let f = fn() { called = true; true }; false && f(); called", false},
+		{"let called = false; let f = fn() { called = true; true }; false || f(); called", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+
+	errObj, ok := testEval("true && 1 / 0").(*Error)
+	if !ok || errObj.Message != "division by zero" {
+		t.Errorf("expected division by zero error, got=%v", errObj)
+	}
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let i = 0; while (i < 5) { let i = i + 1; } i", 5},
+		{"let i = 10; while (i < 5) { let i = i + 1; } i", 10},
+		{"while (false) { 1 }", nil},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } } i", 3},
+		{
+			`let i = 0; let odd = 0;
+			while (i < 10) {
+				let i = i + 1;
+				if (i % 2 == 0) { continue; }
+				let odd = odd + 1;
+			}
+			odd`,
+			5,
+		},
+		{"let f = fn() { while (true) { return 7; } }; f()", 7},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let f = fn() { for (let i = 0; i < 10;) { let i = i + 1; if (i == 4) { return i; } } }; f()", 4},
+		{"let f = fn() { for (;;) { return 1; } }; f()", 1},
+		{"for (let i = 0; false;) { 1 }", nil},
+		{"let i = 100; for (let i = 0; i < 3;) { let i = i + 1; } i", 100},
+		{
+			`let f = fn() {
+				for (let i = 0; i < 10;) {
+					let i = i + 1;
+					if (i < 6) { continue; }
+					break;
+				}
+				return 0;
+			};
+			f()`,
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestNestedLoopControl(t *testing.T) {
+	input := `
+let outer = 0;
+let total = 0;
+while (outer < 3) {
+  let outer = outer + 1;
+  let inner = 0;
+  while (true) {
+    let inner = inner + 1;
+    if (inner > 2) { break; }
+    let total = total + 1;
+  }
+}
+total`
+
+	testIntegerObject(t, testEval(input), 6)
+}
+
+func TestLabeledLoopControl(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			`let count = 0;
+			outer: while (true) {
+				while (true) {
+					let count = count + 1;
+					if (count == 3) { break outer; }
+				}
+			}
+			count`,
+			3,
+		},
+		{
+			`let total = 0;
+			outer: for (let i = 0; i < 3; i += 1) {
+				for (let j = 0; j < 3; j += 1) {
+					if (j == 1) { continue outer; }
+					total += 1;
+				}
+			}
+			total`,
+			3,
+		},
+		{
+			`let total = 0;
+			outer: for (let i = 0; i < 3; i += 1) {
+				inner: for (let j = 0; j < 3; j += 1) {
+					if (j == 1) { continue inner; }
+					if (i == 2) { break; }
+					total += 1;
+				}
+			}
+			total`,
+			4,
+		},
+		{
+			`let f = fn() {
+				outer: while (true) {
+					while (true) { return 7; }
+				}
+			};
+			f()`,
+			7,
+		},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLoopControlErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"break;", "break statement outside of loop"},
+		{"continue;", "continue statement outside of loop"},
+		{"if (true) { break; }", "break statement outside of loop"},
+		{"let f = fn() { break; }; while (true) { f(); }", "break statement outside of loop"},
+		{"let f = fn() { continue; }; f()", "continue statement outside of loop"},
+		{"outer: while (true) { let f = fn() { break outer; }; f(); }", "break statement outside of loop"},
+		{"while (1 + true) { 1 }", "unknown operator: INTEGER + BOOLEAN"},
+		{"for (let i = 0; i < 1; i + true) { 1 }", "unknown operator: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
 // Helper functions
 
 func testEval(input string) Object {
@@ -494,3 +973,127 @@ func testNullObject(t *testing.T, obj Object) bool {
 	}
 	return true
 }
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+		line    int
+		column  int
+	}{
+		{"let a = 1;\nlet b = a / 0;", "division by zero", 2, 9},
+		{"let a = 1;\n\n  a + true;", "unknown operator: INTEGER + BOOLEAN", 3, 3},
+		{"foo;", "identifier not found: foo", 1, 1},
+		{"let arr = [1, 2];\narr[5]", "index out of range: 5 (length 2)", 2, 1},
+		{"let f = fn(x) { x };\nf(1, 2);", "wrong number of arguments: want=1, got=2", 2, 1},
+		{"if (true) {\n  break;\n}", "break statement outside of loop", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			errObj, ok := testEval(tt.input).(*Error)
+			if !ok {
+				t.Fatalf("expected error for %q", tt.input)
+			}
+			if errObj.Message != tt.message {
+				t.Errorf("wrong message. expected=%q, got=%q", tt.message, errObj.Message)
+			}
+			if errObj.Span.Line != tt.line || errObj.Span.Column != tt.column {
+				t.Errorf("wrong position. expected=%d:%d, got=%s", tt.line, tt.column, errObj.Span)
+			}
+		})
+	}
+}
+
+func TestRuntimeErrorCallStack(t *testing.T) {
+	input := `let divide = fn(a, b) {
+  a / b
+};
+let calc = fn(x) {
+  divide(x, 0)
+};
+calc(10);`
+
+	errObj, ok := testEval(input).(*Error)
+	if !ok {
+		t.Fatalf("expected error")
+	}
+
+	stack := errObj.CallStack()
+	expected := []struct {
+		function string
+		line     int
+	}{
+		{"calc", 7},
+		{"divide", 5},
+	}
+	if len(stack) != len(expected) {
+		t.Fatalf("expected %d frames, got %d", len(expected), len(stack))
+	}
+	for i, frame := range stack {
+		if frame.Function != expected[i].function || frame.Span.Line != expected[i].line {
+			t.Errorf("frame %d = %s at line %d, want %s at line %d",
+				i, frame.Function, frame.Span.Line, expected[i].function, expected[i].line)
+		}
+	}
+
+	traceback := errObj.Traceback(input)
+	for _, want := range []string{
+		"Traceback (most recent call last):",
+		"  line 7, column 1, in <main>\n    calc(10);",
+		"  line 5, column 3, in calc\n    divide(x, 0)",
+		"  line 2, column 3, in divide\n    a / b",
+		"ERROR: division by zero",
+	} {
+		if !strings.Contains(traceback, want) {
+			t.Errorf("expected traceback to contain %q, got:\n%s", want, traceback)
+		}
+	}
+}
+
+func TestRuntimeErrorRecursiveCallStack(t *testing.T) {
+	input := `let countdown = fn(n) {
+  if (n == 0) { return missing; }
+  countdown(n - 1)
+};
+countdown(3);`
+
+	errObj, ok := testEval(input).(*Error)
+	if !ok {
+		t.Fatalf("expected error")
+	}
+	if errObj.Span.Line != 2 {
+		t.Errorf("expected error on line 2, got %s", errObj.Span)
+	}
+
+	// トップレベルからの呼び出し1つと再帰呼び出し3つ
+	stack := errObj.CallStack()
+	if len(stack) != 4 {
+		t.Fatalf("expected 4 frames, got %d", len(stack))
+	}
+	if stack[0].Span.Line != 5 {
+		t.Errorf("outermost frame should be the top-level call, got line %d", stack[0].Span.Line)
+	}
+	for _, frame := range stack[1:] {
+		if frame.Function != "countdown" || frame.Span.Line != 3 {
+			t.Errorf("unexpected recursive frame %s at line %d", frame.Function, frame.Span.Line)
+		}
+	}
+}
+
+func TestTypeAnnotationsAreIgnoredAtRuntime(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x: int = 5; x", 5},
+		{"let add = fn(a: int, b: int) -> int { a + b }; add(2, 3)", 5},
+		{"let apply: fn(fn(int) -> int, int) -> int = fn(f, x) { f(x) }; apply(fn(n: int) -> int { n * 2 }, 4)", 8},
+		{"let xs: [int] = [1, 2, 3]; xs[2]", 3},
+		{"let sum = 0; for (let i: int = 1; i <= 3; i += 1) { sum += i; } sum", 6},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
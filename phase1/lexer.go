@@ -8,6 +8,8 @@ type Lexer struct {
 	ch           byte   // 現在検査中の文字
 	line         int    // 現在の行番号（エラー報告用）
 	column       int    // 現在の列番号（エラー報告用）
+
+	diagnostics []*Diagnostic // 字句解析中に検出した診断
 }
 
 // New は新しいLexerを作成する
@@ -108,7 +110,8 @@ func (l *Lexer) readNumber() (string, TokenType) {
 }
 
 // readString は文字列リテラルを読み取る
-func (l *Lexer) readString() string {
+// 閉じ引用符がないまま入力が終わった場合は診断を記録する
+func (l *Lexer) readString(start Token) string {
 	result := ""
 
 	for {
@@ -141,6 +144,15 @@ func (l *Lexer) readString() string {
 		}
 	}
 
+	if l.ch == 0 {
+		span := SpanFromToken(start)
+		span.Length = 1
+		l.diagnostics = append(l.diagnostics,
+			Errorf(CodeUnterminatedString, span, "unterminated string literal").
+				WithLabel("string starts here").
+				WithNote("add a closing '\"' to end the string"))
+	}
+
 	return result
 }
 
@@ -197,27 +209,60 @@ func (l *Lexer) NextToken() Token {
 			tok.Literal = string(l.ch)
 		}
 	case '+':
-		tok.Type = PLUS
-		tok.Literal = string(l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = PLUS_ASSIGN
+			tok.Literal = string(ch) + string(l.ch)
+		} else {
+			tok.Type = PLUS
+			tok.Literal = string(l.ch)
+		}
 	case '-':
 		if l.peekChar() == '>' {
 			ch := l.ch
 			l.readChar()
 			tok.Type = ARROW
 			tok.Literal = string(ch) + string(l.ch)
+		} else if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = MINUS_ASSIGN
+			tok.Literal = string(ch) + string(l.ch)
 		} else {
 			tok.Type = MINUS
 			tok.Literal = string(l.ch)
 		}
 	case '*':
-		tok.Type = MULTIPLY
-		tok.Literal = string(l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = MULTIPLY_ASSIGN
+			tok.Literal = string(ch) + string(l.ch)
+		} else {
+			tok.Type = MULTIPLY
+			tok.Literal = string(l.ch)
+		}
 	case '/':
-		tok.Type = DIVIDE
-		tok.Literal = string(l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = DIVIDE_ASSIGN
+			tok.Literal = string(ch) + string(l.ch)
+		} else {
+			tok.Type = DIVIDE
+			tok.Literal = string(l.ch)
+		}
 	case '%':
-		tok.Type = MODULO
-		tok.Literal = string(l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok.Type = MODULO_ASSIGN
+			tok.Literal = string(ch) + string(l.ch)
+		} else {
+			tok.Type = MODULO
+			tok.Literal = string(l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -297,7 +342,7 @@ func (l *Lexer) NextToken() Token {
 		tok.Literal = string(l.ch)
 	case '"':
 		tok.Type = STRING
-		tok.Literal = l.readString()
+		tok.Literal = l.readString(tok)
 	case 0:
 		tok.Type = EOF
 		tok.Literal = ""
@@ -315,6 +360,27 @@ func (l *Lexer) NextToken() Token {
 		}
 	}
 
+	if tok.Type == ILLEGAL {
+		l.illegalCharacter(tok)
+	}
+
 	l.readChar()
 	return tok
 }
+
+// illegalCharacter は不正な文字の診断を記録する
+func (l *Lexer) illegalCharacter(tok Token) {
+	d := Errorf(CodeIllegalCharacter, SpanFromToken(tok), "unexpected character '%s'", tok.Literal)
+	switch tok.Literal {
+	case "&":
+		d.WithLabel("did you mean `&&`?")
+	case "|":
+		d.WithLabel("did you mean `||`?")
+	}
+	l.diagnostics = append(l.diagnostics, d)
+}
+
+// Diagnostics は字句解析中に検出した診断を返す
+func (l *Lexer) Diagnostics() []*Diagnostic {
+	return l.diagnostics
+}
@@ -186,6 +186,41 @@ let flag: bool = true;`
 	}
 }
 
+func TestCompoundAssignOperators(t *testing.T) {
+	input := `x += 1; x -= 2; x *= 3; x /= 4; x %= 5; x = 6; x / 7; x % 8`
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{IDENT, "x"}, {PLUS_ASSIGN, "+="}, {INT, "1"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {MINUS_ASSIGN, "-="}, {INT, "2"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {MULTIPLY_ASSIGN, "*="}, {INT, "3"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {DIVIDE_ASSIGN, "/="}, {INT, "4"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {MODULO_ASSIGN, "%="}, {INT, "5"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {ASSIGN, "="}, {INT, "6"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {DIVIDE, "/"}, {INT, "7"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {MODULO, "%"}, {INT, "8"},
+		{EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 func TestIdentifiers(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -17,6 +17,8 @@ const (
 	STRING_OBJ       = "STRING"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	BUILTIN_OBJ      = "BUILTIN"
@@ -106,14 +108,75 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// BreakSignal はbreak文によるループ脱出を伝播させる制御オブジェクト
+// Labelが空でない場合は、そのラベルを持つループに到達するまで外側へ伝播する
+type BreakSignal struct {
+	Label string
+}
+
+func (bs *BreakSignal) Type() ObjectType { return BREAK_OBJ }
+func (bs *BreakSignal) Inspect() string  { return "break" }
+
+// ContinueSignal はcontinue文による次の反復への移行を伝播させる制御オブジェクト
+// Labelが空でない場合は、そのラベルを持つループに到達するまで外側へ伝播する
+type ContinueSignal struct {
+	Label string
+}
+
+func (cs *ContinueSignal) Type() ObjectType { return CONTINUE_OBJ }
+func (cs *ContinueSignal) Inspect() string  { return "continue" }
+
 // Error はエラーオブジェクト
 type Error struct {
 	Message string
+	Span    Span       // エラーを検出したノードの位置
+	Frame   *CallFrame // エラー発生時の最も内側の呼び出しフレーム
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
+// CallStack はエラー発生時の呼び出しスタックを外側のフレームから順に返す
+func (e *Error) CallStack() []*CallFrame {
+	var frames []*CallFrame
+	for f := e.Frame; f != nil; f = f.Parent {
+		frames = append(frames, f)
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+// Traceback は呼び出しスタックをたどるトレースバックを返す（最も新しい呼び出しが最後）
+// source を渡した場合は各位置のソース行も表示する
+func (e *Error) Traceback(source string) string {
+	var out bytes.Buffer
+	lines := strings.Split(source, "\n")
+
+	writeLocation := func(span Span, scope string) {
+		fmt.Fprintf(&out, "  line %d, column %d, in %s\n", span.Line, span.Column, scope)
+		if source != "" {
+			if text := strings.TrimSpace(sourceLine(lines, span.Line)); text != "" {
+				fmt.Fprintf(&out, "    %s\n", text)
+			}
+		}
+	}
+
+	out.WriteString("Traceback (most recent call last):\n")
+	scope := "<main>"
+	for _, frame := range e.CallStack() {
+		writeLocation(frame.Span, scope)
+		scope = frame.Function
+	}
+	if e.Span.IsValid() {
+		writeLocation(e.Span, scope)
+	}
+	out.WriteString(e.Inspect())
+
+	return out.String()
+}
+
 // Function は関数オブジェクト
 type Function struct {
 	Parameters []*Identifier
@@ -193,7 +256,9 @@ func (h *Hash) Inspect() string {
 
 // よく使用されるオブジェクトのシングルトン
 var (
-	NULL_OBJ_INSTANCE  = &Null{}
-	TRUE_OBJ_INSTANCE  = &BooleanObj{Value: true}
-	FALSE_OBJ_INSTANCE = &BooleanObj{Value: false}
+	NULL_OBJ_INSTANCE     = &Null{}
+	TRUE_OBJ_INSTANCE     = &BooleanObj{Value: true}
+	FALSE_OBJ_INSTANCE    = &BooleanObj{Value: false}
+	BREAK_OBJ_INSTANCE    = &BreakSignal{}
+	CONTINUE_OBJ_INSTANCE = &ContinueSignal{}
 )
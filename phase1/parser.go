@@ -9,28 +9,41 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGNMENT  // = または +=
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // > または <
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X または !X
 	CALL        // myFunction(X)
+	INDEX       // array[index]
 )
 
 // 演算子の優先順位マップ
 var precedences = map[TokenType]int{
-	EQ:       EQUALS,
-	NOT_EQ:   EQUALS,
-	LT:       LESSGREATER,
-	GT:       LESSGREATER,
-	LTE:      LESSGREATER,
-	GTE:      LESSGREATER,
-	PLUS:     SUM,
-	MINUS:    SUM,
-	DIVIDE:   PRODUCT,
-	MULTIPLY: PRODUCT,
-	MODULO:   PRODUCT,
-	LPAREN:   CALL,
+	ASSIGN:          ASSIGNMENT,
+	PLUS_ASSIGN:     ASSIGNMENT,
+	MINUS_ASSIGN:    ASSIGNMENT,
+	MULTIPLY_ASSIGN: ASSIGNMENT,
+	DIVIDE_ASSIGN:   ASSIGNMENT,
+	MODULO_ASSIGN:   ASSIGNMENT,
+	OR:              LOGICAL_OR,
+	AND:             LOGICAL_AND,
+	EQ:              EQUALS,
+	NOT_EQ:          EQUALS,
+	LT:              LESSGREATER,
+	GT:              LESSGREATER,
+	LTE:             LESSGREATER,
+	GTE:             LESSGREATER,
+	PLUS:            SUM,
+	MINUS:           SUM,
+	DIVIDE:          PRODUCT,
+	MULTIPLY:        PRODUCT,
+	MODULO:          PRODUCT,
+	LPAREN:          CALL,
+	LBRACKET:        INDEX,
 }
 
 // 前置構文解析関数の型
@@ -46,7 +59,12 @@ type Parser struct {
 	curToken  Token
 	peekToken Token
 
-	errors []string
+	diagnostics []*Diagnostic
+
+	// panicking はエラーを検出してから文の境界で同期するまでの間trueになる
+	// この間に発生したエラーは最初のエラーの連鎖とみなして報告しない
+	panicking bool
+	errorPos  int // 最後に報告したエラーのトークン位置
 
 	prefixParseFns map[TokenType]prefixParseFn
 	infixParseFns  map[TokenType]infixParseFn
@@ -55,8 +73,7 @@ type Parser struct {
 // New は新しいParserを作成する
 func NewParser(l *Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l: l,
 	}
 
 	// 前置構文解析関数の登録
@@ -73,6 +90,8 @@ func NewParser(l *Lexer) *Parser {
 	p.registerPrefix(LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(IF, p.parseIfExpression)
 	p.registerPrefix(FN, p.parseFunctionLiteral)
+	p.registerPrefix(LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(LBRACE, p.parseHashLiteral)
 
 	// 中置構文解析関数の登録
 	p.infixParseFns = make(map[TokenType]infixParseFn)
@@ -87,7 +106,16 @@ func NewParser(l *Lexer) *Parser {
 	p.registerInfix(GT, p.parseInfixExpression)
 	p.registerInfix(LTE, p.parseInfixExpression)
 	p.registerInfix(GTE, p.parseInfixExpression)
+	p.registerInfix(AND, p.parseInfixExpression)
+	p.registerInfix(OR, p.parseInfixExpression)
 	p.registerInfix(LPAREN, p.parseCallExpression)
+	p.registerInfix(LBRACKET, p.parseIndexExpression)
+	p.registerInfix(ASSIGN, p.parseAssignExpression)
+	p.registerInfix(PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(MULTIPLY_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(DIVIDE_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(MODULO_ASSIGN, p.parseAssignExpression)
 
 	// 2つのトークンを読み込んでcurTokenとpeekTokenを設定
 	p.nextToken()
@@ -112,22 +140,114 @@ func (p *Parser) nextToken() {
 	p.peekToken = p.l.NextToken()
 }
 
-// Errors はパーサーのエラーを返す
+// Errors はパーサーのエラーメッセージを返す
+// 位置情報を含む診断が必要な場合は Diagnostics を使う
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, len(p.diagnostics))
+	for i, d := range p.diagnostics {
+		errors[i] = d.Message
+	}
+	return errors
+}
+
+// Diagnostics は字句解析と構文解析で検出した診断をソース上の位置順に返す
+// 字句解析器が報告済みの位置に対する構文エラーは重複となるため除く
+func (p *Parser) Diagnostics() []*Diagnostic {
+	lexed := p.l.Diagnostics()
+	reported := make(map[int]bool, len(lexed))
+	diagnostics := make([]*Diagnostic, 0, len(lexed)+len(p.diagnostics))
+	for _, d := range lexed {
+		reported[d.Span.Offset] = true
+		diagnostics = append(diagnostics, d)
+	}
+	for _, d := range p.diagnostics {
+		if !reported[d.Span.Offset] {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	SortDiagnostics(diagnostics)
+	return diagnostics
+}
+
+// addDiagnostic は構文解析中の診断を追加する
+// 同期前に発生した後続のエラーは連鎖的なものとして捨てる
+func (p *Parser) addDiagnostic(d *Diagnostic) {
+	if d.Severity == SeverityError {
+		if p.panicking {
+			return
+		}
+		p.panicking = true
+		p.errorPos = d.Span.Offset
+	}
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// synchronize はエラーの後、次の文の先頭までトークンを読み飛ばす（パニックモード回復）
+// セミコロンの次のトークン、文を始めるキーワード、囲んでいるブロックの } のいずれかで止まる
+// start は失敗した文の最初のトークンで、文の途中で開いたまま閉じていない括弧の中は読み飛ばす
+func (p *Parser) synchronize(start Token) {
+	p.panicking = false
+
+	// 現在のトークンがエラー箇所でなければ、正しく読めた最後のトークンなので読み進める
+	if p.curToken.Position != p.errorPos {
+		p.nextToken()
+	}
+
+	depth := p.openBraces(start)
+	for !p.curTokenIs(EOF) {
+		switch p.curToken.Type {
+		case LBRACE:
+			depth++
+		case RBRACE:
+			if depth == 0 {
+				return
+			}
+			depth--
+		case SEMICOLON:
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case LET, RETURN, WHILE, FOR, BREAK, CONTINUE:
+			if depth == 0 {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+// openBraces は文の最初のトークン start から現在のトークンの直前までに開いて閉じていない波括弧の数を返す
+// 読み終えたトークンは残っていないため、その範囲のソースを字句解析し直して数える
+func (p *Parser) openBraces(start Token) int {
+	if p.curTokenIs(EOF) || p.curToken.Position <= start.Position {
+		return 0
+	}
+	depth := 0
+	l := New(p.l.input[start.Position:p.curToken.Position])
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case LBRACE:
+			depth++
+		case RBRACE:
+			depth--
+		}
+	}
+	return max(depth, 0)
 }
 
 // peekError はpeekTokenの予期しない型に対するエラーを追加する
 func (p *Parser) peekError(t TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addDiagnostic(Errorf(CodeUnexpectedToken, SpanFromToken(p.peekToken),
+		"expected next token to be %s, got %s instead", t, p.peekToken.Type).
+		WithLabel(fmt.Sprintf("expected %s", t)))
 }
 
 // noPrefixParseFnError は前置構文解析関数が見つからない場合のエラーを追加する
 func (p *Parser) noPrefixParseFnError(t TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addDiagnostic(Errorf(CodeExpectedExpression, SpanFromToken(p.curToken),
+		"no prefix parse function for %s found", t).
+		WithLabel("expected an expression"))
 }
 
 // ParseProgram はプログラム全体を解析する
@@ -136,7 +256,17 @@ func (p *Parser) ParseProgram() *Program {
 	program.Statements = []Statement{}
 
 	for !p.curTokenIs(EOF) {
+		start := p.curToken
 		stmt := p.parseStatement()
+		if p.panicking {
+			// 解析に失敗した文は捨て、次の文の先頭から再開する
+			p.synchronize(start)
+			if p.curTokenIs(RBRACE) && p.curToken.Position == p.errorPos {
+				// 報告済みの余分な } はトップレベルでは閉じる対象がないため読み飛ばす
+				p.nextToken()
+			}
+			continue
+		}
 		program.Statements = append(program.Statements, stmt)
 		p.nextToken()
 	}
@@ -159,11 +289,48 @@ func (p *Parser) parseStatement() Statement {
 		return p.parseBreakStatement()
 	case CONTINUE:
 		return p.parseContinueStatement()
+	case IF:
+		return p.parseIfStatement()
+	case IDENT:
+		if p.peekTokenIs(COLON) {
+			return p.parseLabeledStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
+// parseLabeledStatement はラベル付きのループを解析する（outer: while (...) { ... }）
+func (p *Parser) parseLabeledStatement() Statement {
+	label := &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken()
+
+	switch p.peekToken.Type {
+	case WHILE:
+		p.nextToken()
+		stmt := p.parseWhileStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Label = label
+		return stmt
+	case FOR:
+		p.nextToken()
+		stmt := p.parseForStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Label = label
+		return stmt
+	default:
+		p.addDiagnostic(Errorf(CodeLabelWithoutLoop, SpanFromToken(label.Token),
+			"label `%s` is not followed by a loop", label.Value).
+			WithLabel("labels can only be applied to `while` and `for` loops"))
+		return nil
+	}
+}
+
 // parseLetStatement はlet文を解析する
 func (p *Parser) parseLetStatement() *LetStatement {
 	stmt := &LetStatement{Token: p.curToken}
@@ -174,6 +341,16 @@ func (p *Parser) parseLetStatement() *LetStatement {
 
 	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	// 型注釈（省略可能）: let x: int = 1;
+	if p.peekTokenIs(COLON) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Type = p.parseTypeAnnotation()
+		if stmt.Type == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(ASSIGN) {
 		return nil
 	}
@@ -251,8 +428,9 @@ func (p *Parser) parseIntegerLiteral() Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addDiagnostic(Errorf(CodeInvalidNumber, SpanFromToken(p.curToken),
+			"could not parse %q as integer", p.curToken.Literal).
+			WithNote("integer literals must fit in a signed 64-bit integer"))
 		return nil
 	}
 
@@ -266,8 +444,8 @@ func (p *Parser) parseFloatLiteral() Expression {
 
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addDiagnostic(Errorf(CodeInvalidNumber, SpanFromToken(p.curToken),
+			"could not parse %q as float", p.curToken.Literal))
 		return nil
 	}
 
@@ -314,6 +492,35 @@ func (p *Parser) parseInfixExpression(left Expression) Expression {
 	return expression
 }
 
+// parseAssignExpression は代入式を解析する（右結合）
+func (p *Parser) parseAssignExpression(target Expression) Expression {
+	expression := &AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	// エラーの後の回復中は、目的の式が不完全（子がnil）なことがあるため検査しない
+	if target == nil || p.panicking {
+		return nil
+	}
+	switch target.(type) {
+	case *Identifier, *IndexExpression:
+	default:
+		p.addDiagnostic(Errorf(CodeInvalidAssignment, NodeSpan(target),
+			"invalid assignment target: %s", target.String()).
+			WithLabel("cannot assign to this expression").
+			WithNote("only variables and index expressions can be assigned to"))
+		return nil
+	}
+
+	p.nextToken()
+	// 右結合にするため、代入より一段低い優先順位で右辺を解析する
+	expression.Value = p.parseExpression(ASSIGNMENT - 1)
+
+	return expression
+}
+
 // parseGroupedExpression は括弧で囲まれた式を解析する
 func (p *Parser) parseGroupedExpression() Expression {
 	p.nextToken()
@@ -351,6 +558,17 @@ func (p *Parser) parseIfExpression() Expression {
 	if p.peekTokenIs(ELSE) {
 		p.nextToken()
 
+		// else if は後続のif式として連鎖させる
+		if p.peekTokenIs(IF) {
+			p.nextToken()
+			elseIf, ok := p.parseIfExpression().(*IfExpression)
+			if !ok {
+				return nil
+			}
+			expression.ElseIf = elseIf
+			return expression
+		}
+
 		if !p.expectPeek(LBRACE) {
 			return nil
 		}
@@ -361,6 +579,20 @@ func (p *Parser) parseIfExpression() Expression {
 	return expression
 }
 
+// parseIfStatement は文の先頭に現れたif式を文として解析する
+// 式文と異なり、閉じ括弧の後に続く演算子を中置式として取り込まない
+func (p *Parser) parseIfStatement() *ExpressionStatement {
+	stmt := &ExpressionStatement{Token: p.curToken}
+
+	stmt.Expression = p.parseIfExpression()
+
+	if p.peekTokenIs(SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 // parseBlockStatement はブロック文を解析する
 func (p *Parser) parseBlockStatement() *BlockStatement {
 	block := &BlockStatement{Token: p.curToken}
@@ -369,14 +601,37 @@ func (p *Parser) parseBlockStatement() *BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(RBRACE) && !p.curTokenIs(EOF) {
+		start := p.curToken
 		stmt := p.parseStatement()
+		if p.panicking {
+			p.synchronize(start)
+			continue
+		}
 		block.Statements = append(block.Statements, stmt)
 		p.nextToken()
 	}
 
+	if p.curTokenIs(EOF) {
+		p.unclosedBlockError(block)
+	}
+
 	return block
 }
 
+// unclosedBlockError は閉じられないまま入力が終わったブロックのエラーを追加する
+// 入れ子のブロックが同時に閉じられていない場合は、1つの診断に開始位置をまとめる
+func (p *Parser) unclosedBlockError(block *BlockStatement) {
+	label := "unclosed block starts here"
+	if n := len(p.diagnostics); n > 0 && p.errorPos == p.curToken.Position {
+		p.diagnostics[n-1].WithSecondary(SpanFromToken(block.Token), label)
+		p.panicking = true
+		return
+	}
+	p.addDiagnostic(Errorf(CodeUnexpectedToken, SpanFromToken(p.curToken),
+		"expected next token to be }, got EOF instead").
+		WithSecondary(SpanFromToken(block.Token), label))
+}
+
 // parseFunctionLiteral は関数リテラルを解析する
 func (p *Parser) parseFunctionLiteral() Expression {
 	lit := &FunctionLiteral{Token: p.curToken}
@@ -385,7 +640,20 @@ func (p *Parser) parseFunctionLiteral() Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.ParameterTypes = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return nil
+	}
+
+	// 戻り値の型注釈（省略可能）: fn(a: int) -> int { ... }
+	if p.peekTokenIs(ARROW) {
+		p.nextToken()
+		p.nextToken()
+		lit.ReturnType = p.parseTypeAnnotation()
+		if lit.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(LBRACE) {
 		return nil
@@ -396,32 +664,123 @@ func (p *Parser) parseFunctionLiteral() Expression {
 	return lit
 }
 
-// parseFunctionParameters は関数のパラメータを解析する
-func (p *Parser) parseFunctionParameters() []*Identifier {
+// parseFunctionParameters は関数のパラメータとその型注釈を解析する
+// 型注釈が省略されたパラメータの型はnilになる
+func (p *Parser) parseFunctionParameters() ([]*Identifier, []TypeAnnotation) {
 	identifiers := []*Identifier{}
+	types := []TypeAnnotation{}
 
 	if p.peekTokenIs(RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, types
 	}
 
-	p.nextToken()
+	for {
+		if !p.expectPeek(IDENT) {
+			return nil, nil
+		}
+		ident := &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		identifiers = append(identifiers, ident)
 
-	ident := &Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+		var typ TypeAnnotation
+		if p.peekTokenIs(COLON) {
+			p.nextToken()
+			p.nextToken()
+			if typ = p.parseTypeAnnotation(); typ == nil {
+				return nil, nil
+			}
+		}
+		types = append(types, typ)
 
-	for p.peekTokenIs(COMMA) {
-		p.nextToken()
+		if !p.peekTokenIs(COMMA) {
+			break
+		}
 		p.nextToken()
-		ident := &Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
 	if !p.expectPeek(RPAREN) {
-		return nil
+		return nil, nil
 	}
 
-	return identifiers
+	return identifiers, types
+}
+
+// parseTypeAnnotation は現在のトークンから始まる型注釈を解析する
+// int, float, string, bool, [T], {K: V}, fn(T1, T2) -> R の形式に対応する
+func (p *Parser) parseTypeAnnotation() TypeAnnotation {
+	switch p.curToken.Type {
+	case INT_TYPE, FLOAT_TYPE, STRING_TYPE, BOOL_TYPE:
+		return &NamedType{Token: p.curToken, Name: p.curToken.Literal}
+
+	case LBRACKET:
+		typ := &ArrayTypeAnnotation{Token: p.curToken}
+		p.nextToken()
+		if typ.ElementType = p.parseTypeAnnotation(); typ.ElementType == nil {
+			return nil
+		}
+		if !p.expectPeek(RBRACKET) {
+			return nil
+		}
+		return typ
+
+	case LBRACE:
+		typ := &MapTypeAnnotation{Token: p.curToken}
+		p.nextToken()
+		if typ.KeyType = p.parseTypeAnnotation(); typ.KeyType == nil {
+			return nil
+		}
+		if !p.expectPeek(COLON) {
+			return nil
+		}
+		p.nextToken()
+		if typ.ValueType = p.parseTypeAnnotation(); typ.ValueType == nil {
+			return nil
+		}
+		if !p.expectPeek(RBRACE) {
+			return nil
+		}
+		return typ
+
+	case FN:
+		typ := &FunctionTypeAnnotation{Token: p.curToken, Parameters: []TypeAnnotation{}}
+		if !p.expectPeek(LPAREN) {
+			return nil
+		}
+		if p.peekTokenIs(RPAREN) {
+			p.nextToken()
+		} else {
+			for {
+				p.nextToken()
+				param := p.parseTypeAnnotation()
+				if param == nil {
+					return nil
+				}
+				typ.Parameters = append(typ.Parameters, param)
+				if !p.peekTokenIs(COMMA) {
+					break
+				}
+				p.nextToken()
+			}
+			if !p.expectPeek(RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(ARROW) {
+			return nil
+		}
+		p.nextToken()
+		if typ.ReturnType = p.parseTypeAnnotation(); typ.ReturnType == nil {
+			return nil
+		}
+		return typ
+
+	default:
+		p.addDiagnostic(Errorf(CodeExpectedType, SpanFromToken(p.curToken),
+			"expected type, got %s instead", p.curToken.Type).
+			WithLabel("expected a type").
+			WithNote("types are int, float, string, bool, [T], {K: V} and fn(T1, T2) -> R"))
+		return nil
+	}
 }
 
 // parseCallExpression は関数呼び出し式を解析する
@@ -431,6 +790,60 @@ func (p *Parser) parseCallExpression(fn Expression) Expression {
 	return exp
 }
 
+// parseArrayLiteral は配列リテラルを解析する
+func (p *Parser) parseArrayLiteral() Expression {
+	array := &ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(RBRACKET)
+	if array.Elements == nil {
+		return nil
+	}
+	return array
+}
+
+// parseHashLiteral はハッシュリテラルを解析する
+func (p *Parser) parseHashLiteral() Expression {
+	hash := &HashLiteral{Token: p.curToken}
+	hash.Pairs = []*HashLiteralPair{}
+
+	for !p.peekTokenIs(RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+
+		if !p.expectPeek(COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+
+		hash.Pairs = append(hash.Pairs, &HashLiteralPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(RBRACE) && !p.expectPeek(COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(RBRACE) {
+		return nil
+	}
+
+	return hash
+}
+
+// parseIndexExpression は添字アクセス式を解析する
+func (p *Parser) parseIndexExpression(left Expression) Expression {
+	exp := &IndexExpression{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(RBRACKET) {
+		return nil
+	}
+
+	return exp
+}
+
 // parseExpressionList は式のリストを解析する
 func (p *Parser) parseExpressionList(end TokenType) []Expression {
 	args := []Expression{}
@@ -531,25 +944,26 @@ func (p *Parser) parseForStatement() *ForStatement {
 	p.nextToken()
 	if !p.curTokenIs(SEMICOLON) {
 		stmt.Initializer = p.parseStatement()
-	}
-
-	if !p.expectPeek(SEMICOLON) {
-		return nil
+		// let文・式文は後続のセミコロンを既に読み進めている場合がある
+		if !p.curTokenIs(SEMICOLON) && !p.expectPeek(SEMICOLON) {
+			return nil
+		}
 	}
 
 	// 条件式の解析（省略可能）
-	p.nextToken()
-	if !p.curTokenIs(SEMICOLON) {
+	if p.peekTokenIs(SEMICOLON) {
+		p.nextToken()
+	} else {
+		p.nextToken()
 		stmt.Condition = p.parseExpression(LOWEST)
-	}
-
-	if !p.expectPeek(SEMICOLON) {
-		return nil
+		if !p.expectPeek(SEMICOLON) {
+			return nil
+		}
 	}
 
 	// 更新式の解析（省略可能）
-	p.nextToken()
-	if !p.curTokenIs(RPAREN) {
+	if !p.peekTokenIs(RPAREN) {
+		p.nextToken()
 		stmt.Update = p.parseExpression(LOWEST)
 	}
 
@@ -570,6 +984,11 @@ func (p *Parser) parseForStatement() *ForStatement {
 func (p *Parser) parseBreakStatement() *BreakStatement {
 	stmt := &BreakStatement{Token: p.curToken}
 
+	if p.peekTokenIs(IDENT) {
+		p.nextToken()
+		stmt.Label = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
 	if p.peekTokenIs(SEMICOLON) {
 		p.nextToken()
 	}
@@ -581,6 +1000,11 @@ func (p *Parser) parseBreakStatement() *BreakStatement {
 func (p *Parser) parseContinueStatement() *ContinueStatement {
 	stmt := &ContinueStatement{Token: p.curToken}
 
+	if p.peekTokenIs(IDENT) {
+		p.nextToken()
+		stmt.Label = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
 	if p.peekTokenIs(SEMICOLON) {
 		p.nextToken()
 	}
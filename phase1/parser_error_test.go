@@ -245,3 +245,181 @@ func TestLetStatementWithNilValue(t *testing.T) {
 		t.Errorf("LetStatement.String() with nil value = %q, want %q", result, expected)
 	}
 }
+
+// TestParserRecovery はエラー後に文の境界で同期し、独立したエラーを全て報告することをテストする
+func TestParserRecovery(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedErrors []string
+		expectedStmts  []string // 回復後に残る文の String()
+	}{
+		{
+			name:  "複数の独立したエラー",
+			input: "let a = ;\nlet b = 5;\nlet = 3;\nlet c = b;",
+			expectedErrors: []string{
+				"no prefix parse function for ; found",
+				"expected next token to be IDENT, got = instead",
+			},
+			expectedStmts: []string{"let b = 5;", "let c = b;"},
+		},
+		{
+			name:  "同じ文の連鎖エラーは報告しない",
+			input: "let x = (1 + ;\nlet y = 2;",
+			expectedErrors: []string{
+				"no prefix parse function for ; found",
+			},
+			expectedStmts: []string{"let y = 2;"},
+		},
+		{
+			name:  "セミコロンがなくてもキーワードで同期する",
+			input: "let = 1 let y = 2;",
+			expectedErrors: []string{
+				"expected next token to be IDENT, got = instead",
+			},
+			expectedStmts: []string{"let y = 2;"},
+		},
+		{
+			name:  "ブロック内のエラーはブロック内で回復する",
+			input: "let f = fn(x) { let y = ; x };\nf(1);",
+			expectedErrors: []string{
+				"no prefix parse function for ; found",
+			},
+			expectedStmts: []string{"let f = fn(x) x;", "f(1)"},
+		},
+		{
+			name:  "ネストしたブロックを読み飛ばして同期する",
+			input: "if (x { let a = 1; };\nlet b = 2;",
+			expectedErrors: []string{
+				"expected next token to be ), got { instead",
+			},
+			expectedStmts: []string{"let b = 2;"},
+		},
+		{
+			name:  "文の途中で開いた波括弧の中を読み飛ばす",
+			input: "let f = fn() { let m = {1: ; 2}; 3 };\nf();",
+			expectedErrors: []string{
+				"no prefix parse function for ; found",
+			},
+			expectedStmts: []string{"let f = fn() 3;", "f()"},
+		},
+		{
+			name:  "余分な閉じ括弧",
+			input: "let a = 1; }\nlet b = 2;",
+			expectedErrors: []string{
+				"no prefix parse function for } found",
+			},
+			expectedStmts: []string{"let a = 1;", "let b = 2;"},
+		},
+		{
+			name:  "閉じられていないブロック",
+			input: "let f = fn() {\n  if (true) {\n    1",
+			expectedErrors: []string{
+				"expected next token to be }, got EOF instead",
+			},
+			expectedStmts: []string{},
+		},
+		{
+			name:  "不正な文字の後も解析を続ける",
+			input: "let a = 1 @ 2;\nlet b = a + ;",
+			expectedErrors: []string{
+				"no prefix parse function for ILLEGAL found",
+				"no prefix parse function for ; found",
+			},
+			expectedStmts: []string{"let a = 1;"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(New(tt.input))
+			program := p.ParseProgram()
+
+			errors := p.Errors()
+			if len(errors) != len(tt.expectedErrors) {
+				t.Fatalf("expected %d errors, got %d: %v", len(tt.expectedErrors), len(errors), errors)
+			}
+			for i, expected := range tt.expectedErrors {
+				if errors[i] != expected {
+					t.Errorf("errors[%d] = %q, want %q", i, errors[i], expected)
+				}
+			}
+
+			if len(program.Statements) != len(tt.expectedStmts) {
+				t.Fatalf("expected %d statements, got %d: %q", len(tt.expectedStmts), len(program.Statements), program.String())
+			}
+			for i, stmt := range program.Statements {
+				if stmt == nil {
+					t.Fatalf("statement %d is nil", i)
+				}
+				if stmt.String() != tt.expectedStmts[i] {
+					t.Errorf("statement %d = %q, want %q", i, stmt.String(), tt.expectedStmts[i])
+				}
+			}
+		})
+	}
+}
+
+// TestParserRecoveryNoPanic はエラーを含む入力でもASTの文字列化でパニックしないことをテストする
+func TestParserRecoveryNoPanic(t *testing.T) {
+	inputs := []string{
+		"let = ;",
+		"fn(x, { }",
+		"[1, 2",
+		"{1: }",
+		"a[",
+		"if (",
+		"while (true) { break",
+		"for (let i = 0; i < ; i += 1) { }",
+		"x = = 1;",
+		"} } let a = 1;",
+		"return let;",
+		"(a +) = 1;",
+		"a + ) = 1;",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			p := NewParser(New(input))
+			program := p.ParseProgram()
+
+			if len(p.Errors()) == 0 {
+				t.Errorf("expected parser errors for %q", input)
+			}
+			for i, stmt := range program.Statements {
+				if stmt == nil {
+					t.Fatalf("statement %d is nil", i)
+				}
+			}
+			_ = program.String()
+		})
+	}
+}
+
+// TestParserRecoveryAssignTarget は途中で切れた式を代入の左辺にしてもパニックしないことをテストする
+func TestParserRecoveryAssignTarget(t *testing.T) {
+	expressions := []string{
+		"(a + b) * c",
+		"-a[i + 1]",
+		"f(x, g(y))",
+		"[1, 2][0]",
+		`{"k": v}["k"]`,
+		"fn(x) { x }(1)",
+		"if (a) { b } else if (c) { d } else { e }",
+		"a && !b || c",
+	}
+
+	for _, expr := range expressions {
+		for i := 1; i <= len(expr); i++ {
+			input := expr[:i] + " = 1;"
+			t.Run(input, func(t *testing.T) {
+				p := NewParser(New(input))
+				program := p.ParseProgram()
+				for _, d := range p.Diagnostics() {
+					_ = d.String()
+				}
+				_ = program.String()
+			})
+		}
+	}
+}
@@ -378,6 +378,54 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"x = y + 1",
+			"x = (y + 1)",
+		},
+		{
+			"x = y = z",
+			"x = y = z",
+		},
+		{
+			"x += a * b",
+			"x += (a * b)",
+		},
+		{
+			"x = a == b",
+			"x = (a == b)",
+		},
+		{
+			"a * [1, 2, 3, 4][b * c] * d",
+			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
+		},
+		{
+			"add(a * b[2], b[1], 2 * [1, 2][1])",
+			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
+		},
+		{
+			"a[0] = b[1] + 1",
+			"(a[0]) = ((b[1]) + 1)",
+		},
+		{
+			"a < b && b < c",
+			"((a < b) && (b < c))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b || !c",
+			"((a == b) || (!c))",
+		},
+		{
+			"x = a || b",
+			"x = (a || b)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -393,6 +441,202 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestAssignExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		target   string
+		operator string
+		value    interface{}
+	}{
+		{"x = 5;", "x", "=", 5},
+		{"x += 5;", "x", "+=", 5},
+		{"x -= y;", "x", "-=", "y"},
+		{"x *= 2;", "x", "*=", 2},
+		{"x /= 2;", "x", "/=", 2},
+		{"x %= 2;", "x", "%=", 2},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+
+		exp, ok := stmt.Expression.(*AssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not AssignExpression. got=%T", stmt.Expression)
+		}
+
+		if !testIdentifier(t, exp.Target, tt.target) {
+			return
+		}
+		if exp.Operator != tt.operator {
+			t.Errorf("exp.Operator is not %q. got=%q", tt.operator, exp.Operator)
+		}
+		if !testLiteralExpression(t, exp.Value, tt.value) {
+			return
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := New("1 = 2;")
+	p := NewParser(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "invalid assignment target: 1" {
+		t.Errorf("unexpected parser errors: %v", errors)
+	}
+}
+
+func TestParsingArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	l := New(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	array, ok := stmt.Expression.(*ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not ArrayLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(array.Elements) != 3 {
+		t.Fatalf("len(array.Elements) not 3. got=%d", len(array.Elements))
+	}
+
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testInfixExpression(t, array.Elements[2], 3, "+", 3)
+}
+
+func TestParsingEmptyArrayLiteral(t *testing.T) {
+	l := New("[]")
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ExpressionStatement)
+	array, ok := stmt.Expression.(*ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not ArrayLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(array.Elements) != 0 {
+		t.Errorf("len(array.Elements) not 0. got=%d", len(array.Elements))
+	}
+}
+
+func TestParsingIndexExpressions(t *testing.T) {
+	input := "myArray[1 + 1]"
+
+	l := New(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	indexExp, ok := stmt.Expression.(*IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *IndexExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, indexExp.Left, "myArray") {
+		return
+	}
+
+	if !testInfixExpression(t, indexExp.Index, 1, "+", 1) {
+		return
+	}
+}
+
+func TestParsingHashLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		numPairs int
+	}{
+		{`{"one": 1, "two": 2, "three": 3}`, `{"one": 1, "two": 2, "three": 3}`, 3},
+		{"{}", "{}", 0},
+		{`{"one": 0 + 1, 2: 10 - 8, true: 15 / 5}`, `{"one": (0 + 1), 2: (10 - 8), true: (15 / 5)}`, 3},
+		{`{"a": {"b": 1}}["a"]`, `({"a": {"b": 1}}["a"])`, -1},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong String(). expected=%q, got=%q", tt.expected, stmt.String())
+		}
+
+		if tt.numPairs < 0 {
+			continue
+		}
+
+		hash, ok := stmt.Expression.(*HashLiteral)
+		if !ok {
+			t.Fatalf("exp is not HashLiteral. got=%T", stmt.Expression)
+		}
+		if len(hash.Pairs) != tt.numPairs {
+			t.Errorf("hash.Pairs has wrong length. want=%d, got=%d", tt.numPairs, len(hash.Pairs))
+		}
+	}
+}
+
+func TestParsingHashLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`{"a" 1}`, "expected next token to be :, got INT instead"},
+		{`{"a": 1 "b": 2}`, "expected next token to be ,, got STRING instead"},
+		{`{"a": 1`, "expected next token to be ,, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		p := NewParser(l)
+		p.ParseProgram()
+
+		found := false
+		for _, err := range p.Errors() {
+			if err == tt.expectedError {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error %q not found in errors: %v", tt.expectedError, p.Errors())
+		}
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -538,6 +782,112 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < y) { x } else if (x > y) { y } else if (x == 0) { 0 } else { 1 }`
+
+	l := New(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not IfExpression. got=%T", stmt.Expression)
+	}
+
+	if exp.Alternative != nil {
+		t.Errorf("exp.Alternative should be nil when followed by else if. got=%+v", exp.Alternative)
+	}
+
+	second := exp.ElseIf
+	if second == nil {
+		t.Fatalf("exp.ElseIf is nil")
+	}
+	if !testInfixExpression(t, second.Condition, "x", ">", "y") {
+		return
+	}
+
+	third := second.ElseIf
+	if third == nil {
+		t.Fatalf("second.ElseIf is nil")
+	}
+	if !testInfixExpression(t, third.Condition, "x", "==", 0) {
+		return
+	}
+	if third.ElseIf != nil || third.Alternative == nil {
+		t.Fatalf("final else block was not attached to the last else if")
+	}
+
+	expected := "if (x < y) { x } else if (x > y) { y } else if (x == 0) { 0 } else { 1 }"
+	if exp.String() != expected {
+		t.Errorf("exp.String() wrong. expected=%q, got=%q", expected, exp.String())
+	}
+}
+
+// TestIfExpressionStringRoundTrip はif式の文字列を再び構文解析すると同じ構文木になることをテストする
+func TestIfExpressionStringRoundTrip(t *testing.T) {
+	inputs := []string{
+		"if (x < y) { x } else if (x > y) { y } else if (x == 0) { 0 } else { 1 }",
+		"if (ok) { let a = 1; a + 1 } else { }",
+		"if (!done) { puts(1); puts(2) }",
+		"if (xs[0]) { if (y) { 1 } else { 2 } } else if (-z) { return 3; }",
+		"let v = if (a && b) { [1, 2] } else { {1: 2} };",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			first := NewParser(New(input))
+			program := first.ParseProgram()
+			checkParserErrors(t, first)
+
+			second := NewParser(New(program.String()))
+			reparsed := second.ParseProgram()
+			checkParserErrors(t, second)
+
+			if len(reparsed.Statements) != len(program.Statements) {
+				t.Fatalf("reparsed %q into %d statements, want %d", program.String(), len(reparsed.Statements), len(program.Statements))
+			}
+			for i, stmt := range program.Statements {
+				if stmt.String() != reparsed.Statements[i].String() {
+					t.Errorf("statement %d changed after round trip:\n got  %s\n want %s", i, reparsed.Statements[i].String(), stmt.String())
+				}
+			}
+		})
+	}
+}
+
+func TestIfStatementDoesNotContinueAsInfix(t *testing.T) {
+	input := `if (x) { 1 } -1`
+
+	l := New(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d (%s)",
+			len(program.Statements), program.String())
+	}
+
+	if _, ok := program.Statements[0].(*ExpressionStatement).Expression.(*IfExpression); !ok {
+		t.Errorf("first statement is not an if expression. got=%s", program.Statements[0].String())
+	}
+	if program.Statements[1].String() != "(-1)" {
+		t.Errorf("second statement wrong. got=%s", program.Statements[1].String())
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -615,6 +965,94 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 1;", "let x: int = 1;"},
+		{"let s: string = \"a\";", "let s: string = \"a\";"},
+		{"let xs: [float] = [1.5];", "let xs: [float] = [1.5];"},
+		{"let m: {string: [int]} = {};", "let m: {string: [int]} = {};"},
+		{"let f: fn(int, bool) -> [int] = g;", "let f: fn(int, bool) -> [int] = g;"},
+		{"let h: fn() -> fn(int) -> int = g;", "let h: fn() -> fn(int) -> int = g;"},
+		{"fn(a: int, b: float) -> float { a + b }", "fn(a: int, b: float) -> float (a + b)"},
+		{"fn(a, b: bool) { a }", "fn(a, b: bool) a"},
+		{"fn() -> {string: int} { {} }", "fn() -> {string: int} {}"},
+		{"for (let i: int = 0; i < 3; i += 1) { i }", "for let i: int = 0;; (i < 3); i += 1 i"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := NewParser(New(tt.input))
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			if len(program.Statements) != 1 {
+				t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+			}
+			if got := program.Statements[0].String(); got != tt.expected {
+				t.Errorf("String() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFunctionParameterTypes(t *testing.T) {
+	p := NewParser(New("fn(a: int, b, c: [string]) -> bool { true }"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	fn := program.Statements[0].(*ExpressionStatement).Expression.(*FunctionLiteral)
+	if len(fn.ParameterTypes) != len(fn.Parameters) {
+		t.Fatalf("expected %d parameter types, got %d", len(fn.Parameters), len(fn.ParameterTypes))
+	}
+
+	expected := []string{"int", "", "[string]"}
+	for i, want := range expected {
+		typ := fn.ParameterType(i)
+		if want == "" {
+			if typ != nil {
+				t.Errorf("parameter %d: expected no annotation, got %s", i, typ)
+			}
+			continue
+		}
+		if typ == nil || typ.String() != want {
+			t.Errorf("parameter %d: expected %s, got %v", i, want, typ)
+		}
+	}
+	if fn.ReturnType == nil || fn.ReturnType.String() != "bool" {
+		t.Errorf("expected return type bool, got %v", fn.ReturnType)
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: = 1;", "expected type, got = instead"},
+		{"let x: foo = 1;", "expected type, got IDENT instead"},
+		{"let x: [int = 1;", "expected next token to be ], got = instead"},
+		{"let f: fn(int) = g;", "expected next token to be ->, got = instead"},
+		{"fn(a: ) { a }", "expected type, got ) instead"},
+		{"fn(a) -> { a }", "expected type, got IDENT instead"},
+		{"fn(a) -> int a", "expected next token to be {, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := NewParser(New(tt.input))
+			p.ParseProgram()
+
+			errors := p.Errors()
+			if len(errors) != 1 || errors[0] != tt.expected {
+				t.Errorf("expected only error %q, got %v", tt.expected, errors)
+			}
+		})
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -9,8 +9,21 @@ import (
 
 const PROMPT = "pug> "
 
+// TypeReporter はREPLに入力されたプログラムを型検査し、表示する型情報を返す
+// phase1は型検査器に依存できないため、呼び出し側から実装を渡す
+type TypeReporter interface {
+	// ReportTypes は入力ごとに呼び出され、表示する行（例: "f: fn(int) -> int"）を返す
+	ReportTypes(program *Program) []string
+}
+
 // Start はREPLを開始する
 func Start(in io.Reader, out io.Writer) {
+	StartWithTypes(in, out, nil)
+}
+
+// StartWithTypes はlet束縛した関数の推論された型を表示するREPLを開始する
+// reporterがnilの場合は型情報を表示しない
+func StartWithTypes(in io.Reader, out io.Writer, reporter TypeReporter) {
 	scanner := bufio.NewScanner(in)
 	env := NewEnvironment()
 	history := []string{}
@@ -50,20 +63,27 @@ func Start(in io.Reader, out io.Writer) {
 		p := NewParser(l)
 		program := p.ParseProgram()
 
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if diagnostics := p.Diagnostics(); len(diagnostics) != 0 {
+			printDiagnostics(out, line, diagnostics)
 			continue
 		}
 
 		evaluated := Eval(program, env)
 		if evaluated != nil {
 			// エラーの場合は特別な表示
-			if evaluated.Type() == ERROR_OBJ {
-				fmt.Fprintf(out, "❌ エラー: %s\n", evaluated.Inspect())
+			if err, ok := evaluated.(*Error); ok {
+				// 以前の入力で定義された関数の位置も含むため、ソース行は表示しない
+				fmt.Fprintf(out, "❌ エラー:\n%s\n", err.Traceback(""))
 			} else if evaluated != NULL_OBJ_INSTANCE {
 				fmt.Fprintf(out, "=> %s\n", evaluated.Inspect())
 			}
 		}
+
+		if reporter != nil {
+			for _, line := range reporter.ReportTypes(program) {
+				fmt.Fprintf(out, "📐 %s\n", line)
+			}
+		}
 	}
 }
 
@@ -126,9 +146,8 @@ func printHistory(out io.Writer, history []string) {
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+// printDiagnostics は診断をソース行とキャレット付きで表示する
+func printDiagnostics(out io.Writer, source string, diagnostics []*Diagnostic) {
 	fmt.Fprintln(out, "🚨 構文解析エラー:")
-	for _, msg := range errors {
-		fmt.Fprintf(out, "  %s\n", msg)
-	}
+	fmt.Fprint(out, RenderDiagnostics(diagnostics, "<repl>", source))
 }
@@ -2,6 +2,7 @@ package phase1
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -140,25 +141,6 @@ func TestREPLMultipleStatements(t *testing.T) {
 	}
 }
 
-func TestPrintParserErrors(t *testing.T) {
-	output := &bytes.Buffer{}
-	errors := []string{
-		"expected next token to be =, got INT instead",
-		"no prefix parse function for INT found",
-	}
-
-	printParserErrors(output, errors)
-
-	result := output.String()
-
-	// Check that errors are properly formatted
-	for _, err := range errors {
-		if !strings.Contains(result, err) {
-			t.Errorf("Expected error %q, got:\n%s", err, result)
-		}
-	}
-}
-
 // Enhanced REPL tests for new functionality
 
 func TestREPLSpecialCommands(t *testing.T) {
@@ -276,3 +258,30 @@ func TestREPLEnhancedPromptAndOutput(t *testing.T) {
 		t.Error("Expected enhanced output format '=> 42'")
 	}
 }
+
+// fakeTypeReporter は入力された文の数を報告するテスト用のTypeReporter
+type fakeTypeReporter struct {
+	calls int
+}
+
+func (r *fakeTypeReporter) ReportTypes(program *Program) []string {
+	r.calls++
+	return []string{fmt.Sprintf("input %d: %d statements", r.calls, len(program.Statements))}
+}
+
+func TestREPLTypeReporter(t *testing.T) {
+	in := strings.NewReader("let f = fn(x) { x }; f(1)\nlet x =\n:help\n:exit\n")
+	var out bytes.Buffer
+	reporter := &fakeTypeReporter{}
+
+	StartWithTypes(in, &out, reporter)
+
+	output := out.String()
+	if !strings.Contains(output, "📐 input 1: 2 statements") {
+		t.Errorf("Expected reported types in output, got:\n%s", output)
+	}
+	// 構文エラーの入力と特殊コマンドは型検査しない
+	if reporter.calls != 1 {
+		t.Errorf("Expected ReportTypes to be called once, got %d", reporter.calls)
+	}
+}
@@ -32,6 +32,13 @@ const (
 	DIVIDE   TokenType = "/" // 除算
 	MODULO   TokenType = "%" // 剰余
 
+	// 複合代入演算子
+	PLUS_ASSIGN     TokenType = "+=" // 加算代入
+	MINUS_ASSIGN    TokenType = "-=" // 減算代入
+	MULTIPLY_ASSIGN TokenType = "*=" // 乗算代入
+	DIVIDE_ASSIGN   TokenType = "/=" // 除算代入
+	MODULO_ASSIGN   TokenType = "%=" // 剰余代入
+
 	// 比較演算子
 	EQ     TokenType = "==" // 等価
 	NOT_EQ TokenType = "!=" // 非等価
@@ -0,0 +1,172 @@
+package phase2
+
+import (
+	"fmt"
+
+	"github.com/nyasuto/pug/phase1"
+)
+
+// 配列の値は、要素数（8バイト）に続けて要素を8バイトずつ並べたヒープのオブジェクトへのポインタで表す
+// 要素は整数・真偽値ならその値、文字列・配列・関数ならそのポインタを置く
+// push・restは元の配列を変えずに新しい配列を作る（インタプリタと同じく、添字への代入だけがその場で更新する）
+//
+// 空の配列に対する組み込み関数は、生成するコードがnullを値として持たないためインタプリタと異なる
+//   - rest([]) はインタプリタではnullだが、配列の型を保つため空の配列を返す
+//   - first([])・last([]) はインタプリタではnullだが、要素の型の値を返せないためエラーで終了する
+
+// isArrayExpression は式の静的な型が配列かどうかを返す
+func (cg *CodeGenerator) isArrayExpression(expr phase1.Expression) bool {
+	typ, ok := cg.types.TypeOf(expr)
+	if !ok {
+		return false
+	}
+	_, isArray := typ.(*ArrayType)
+	return isArray
+}
+
+// checkArrayOperand は配列を受け取る操作のオペランドが配列でない型を持つ場合にエラーを返す
+// 型が決まらない式は配列として扱う
+func (cg *CodeGenerator) checkArrayOperand(operation string, expr phase1.Expression) error {
+	typ, ok := cg.types.TypeOf(expr)
+	if !ok {
+		return nil
+	}
+	switch typ.(type) {
+	case *ArrayType, *TypeVariable:
+		return nil
+	}
+	return fmt.Errorf("%s: a value of type %s is not supported in compiled code", operation, NormalizeType(typ).String())
+}
+
+// generateArrayLiteral は配列リテラルの中間表現を生成する
+// 要素を順に評価してから配列を確保して詰める（確保中のGCからは、要素を置いたレジスタやスタックのスロットが参照される）
+func (cg *CodeGenerator) generateArrayLiteral(node *phase1.ArrayLiteral) (vreg, error) {
+	elements, err := cg.generateOperands(node.Elements...)
+	if err != nil {
+		return 0, err
+	}
+
+	array := cg.allocate(8*(len(elements)+1), objectArray)
+	cg.store(array, 0, 0, cg.constant(int64(len(elements))))
+	for i, element := range elements {
+		cg.store(array, 0, int64(8*(i+1)), element)
+	}
+	return array, nil
+}
+
+// generateIndexOperands は配列と添字を評価し、添字が範囲外ならエラーで終了する中間表現を生成する
+func (cg *CodeGenerator) generateIndexOperands(array, index phase1.Expression, rest ...phase1.Expression) ([]vreg, error) {
+	if err := cg.checkArrayOperand("index", array); err != nil {
+		return nil, err
+	}
+	operands, err := cg.generateOperands(append([]phase1.Expression{array, index}, rest...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	cg.boundsCheck(operands[0], operands[1], runtimeIndexError)
+	return operands, nil
+}
+
+// generateIndexExpression は添字アクセス式の中間表現を生成する
+func (cg *CodeGenerator) generateIndexExpression(node *phase1.IndexExpression) (vreg, error) {
+	operands, err := cg.generateIndexOperands(node.Left, node.Index)
+	if err != nil {
+		return 0, err
+	}
+	return cg.load(operands[0], operands[1], 8), nil
+}
+
+// generateIndexAssignment は添字アクセス式への代入の中間表現を生成する
+// 配列、添字、値の順に評価し、代入した値を式の結果とする
+func (cg *CodeGenerator) generateIndexAssignment(node *phase1.AssignExpression, target *phase1.IndexExpression) (vreg, error) {
+	if node.BinaryOperator() != "" {
+		return 0, fmt.Errorf("compound assignment to an index expression is not supported in compiled code: %s", node.String())
+	}
+
+	operands, err := cg.generateIndexOperands(target.Left, target.Index, node.Value)
+	if err != nil {
+		return 0, err
+	}
+	cg.store(operands[0], operands[1], 8, operands[2])
+	cg.comment("%s = ...", target.String())
+	return operands[2], nil
+}
+
+// generateArrayBuiltin は配列の組み込み関数（first・last・rest・push）の呼び出しの中間表現を生成する
+// restは空の配列に対して空の配列を返す
+// 空の配列に対するfirst・lastはインタプリタではnullになるが、それに当たる値がないためエラーで終了する
+func (cg *CodeGenerator) generateArrayBuiltin(name string, node *phase1.CallExpression) (vreg, error) {
+	want := 1
+	if name == "push" {
+		want = 2
+	}
+	if len(node.Arguments) != want {
+		return 0, fmt.Errorf("%s: wrong number of arguments: expected %d, got %d", name, want, len(node.Arguments))
+	}
+	if err := cg.checkArrayOperand(name, node.Arguments[0]); err != nil {
+		return 0, err
+	}
+
+	args, err := cg.generateOperands(node.Arguments...)
+	if err != nil {
+		return 0, err
+	}
+
+	switch name {
+	case "rest":
+		return cg.callRuntime(runtimeArrayRest, args...), nil
+	case "push":
+		return cg.callRuntime(runtimeArrayPush, args...), nil
+	}
+
+	// 添字0が範囲外なら空の配列
+	array := args[0]
+	cg.boundsCheck(array, cg.constant(0), runtimeEmptyError)
+	if name == "first" {
+		return cg.load(array, 0, 8), nil
+	}
+	return cg.load(array, cg.load(array, 0, 0), 0), nil
+}
+
+// emitArrayPush は配列の末尾に値を加えた新しい配列を作るルーチンを出力する
+// 要素のコピーには rep movsq を使う
+func (cg *CodeGenerator) emitArrayPush() {
+	cg.emitf("%s:", runtimeArrayPush)
+	cg.emit("    pushq %rdi")
+	cg.emit("    pushq %rsi")
+	cg.emit("    movq (%rdi), %rdi")
+	cg.emit("    leaq 16(,%rdi,8), %rdi")
+	cg.emitf("    movq $%d, %%rsi", objectArray)
+	cg.emitf("    call %s", runtimeAlloc)
+	cg.emit("    popq %rdx") // 加える値
+	cg.emit("    popq %rsi") // 元の配列
+	cg.emit("    movq (%rsi), %rcx")
+	cg.emit("    leaq 1(%rcx), %r8")
+	cg.emit("    movq %r8, (%rax)")
+	cg.emit("    movq %rdx, 8(%rax,%rcx,8)")
+	cg.emit("    leaq 8(%rax), %rdi")
+	cg.emit("    addq $8, %rsi")
+	cg.emit("    rep movsq")
+	cg.emit("    ret")
+}
+
+// emitArrayRest は配列の先頭の要素を除いた新しい配列を作るルーチンを出力する
+func (cg *CodeGenerator) emitArrayRest() {
+	cg.emitf("%s:", runtimeArrayRest)
+	cg.emit("    pushq %rdi")
+	cg.emit("    movq (%rdi), %rdi")
+	cg.emit("    subq $1, %rdi")
+	cg.emit("    adcq $0, %rdi") // 空の配列では0にする
+	cg.emit("    pushq %rdi")
+	cg.emit("    leaq 8(,%rdi,8), %rdi")
+	cg.emitf("    movq $%d, %%rsi", objectArray)
+	cg.emitf("    call %s", runtimeAlloc)
+	cg.emit("    popq %rcx") // 新しい要素数
+	cg.emit("    popq %rsi") // 元の配列
+	cg.emit("    movq %rcx, (%rax)")
+	cg.emit("    leaq 8(%rax), %rdi")
+	cg.emit("    addq $16, %rsi")
+	cg.emit("    rep movsq")
+	cg.emit("    ret")
+}
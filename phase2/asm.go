@@ -0,0 +1,324 @@
+package phase2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 割り当て結果に従って中間表現の命令をアセンブリに変換する
+// スピルした値はフレームのスロットに置き、命令がレジスタを必要とする場合はRAX・R10・R11を経由する
+
+// isMemory はオペランドがメモリを指すかどうかを返す
+func isMemory(operand string) bool {
+	return strings.Contains(operand, "(")
+}
+
+// emitFunction は割り当て結果に従って関数のプロローグと本体を出力する
+func (cg *CodeGenerator) emitFunction(fn *irFunction, alloc *allocation) error {
+	frame := newFrameLayout(alloc)
+	if frame.size > maxFrameSize {
+		return fmt.Errorf("%s needs a %d-byte stack frame, which exceeds the limit of %d bytes", fn.name, frame.size, maxFrameSize)
+	}
+
+	cg.emit("    pushq %rbp")      // フレームポインタを保存
+	cg.emit("    movq %rsp, %rbp") // 新しいフレームポインタを設定
+	if frame.size > 0 {
+		cg.emitf("    subq $%d, %%rsp", frame.size) // callee-savedレジスタの保存とスピル用の領域を確保
+	}
+	for i, r := range alloc.calleeSaved {
+		cg.emitf("    movq %s, %d(%%rbp)", r, -8*(i+1))
+	}
+
+	for _, in := range fn.instructions {
+		cg.emitInstruction(frame, in)
+	}
+	return nil
+}
+
+// emitEpilogue は使用したcallee-savedレジスタを復元して関数から戻る（戻り値はRAXに置いておく）
+func (cg *CodeGenerator) emitEpilogue(frame *frameLayout) {
+	for i, r := range frame.allocation.calleeSaved {
+		cg.emitf("    movq %d(%%rbp), %s", -8*(i+1), r)
+	}
+	cg.emit("    movq %rbp, %rsp") // スタックポインタを復元
+	cg.emit("    popq %rbp")       // フレームポインタを復元
+	cg.emit("    ret")             // 関数から戻る
+}
+
+// memoryOperand はベースのレジスタと添字のレジスタ（空なら使わない）から [base + index*8 + offset] のオペランドを作る
+func memoryOperand(offset int64, base, index string) string {
+	disp := ""
+	if offset != 0 {
+		disp = fmt.Sprint(offset)
+	}
+	if index != "" {
+		return fmt.Sprintf("%s(%s,%s,8)", disp, base, index)
+	}
+	return fmt.Sprintf("%s(%s)", disp, base)
+}
+
+// emitMove は値をコピーする（メモリ同士のコピーはRAXを経由する）
+func (cg *CodeGenerator) emitMove(dst, src string) {
+	if dst == src {
+		return
+	}
+	if isMemory(dst) && isMemory(src) {
+		cg.emitf("    movq %s, %%rax", src)
+		src = "%rax"
+	}
+	cg.emitf("    movq %s, %s", src, dst)
+}
+
+// inRegister はオペランドがレジスタならそのまま、メモリならscratchに読み込んで返す
+func (cg *CodeGenerator) inRegister(operand, scratch string) string {
+	if !isMemory(operand) {
+		return operand
+	}
+	cg.emitf("    movq %s, %s", operand, scratch)
+	return scratch
+}
+
+// parallelMove は同時に行うコピーの移動先と移動元
+type parallelMove struct {
+	dst, src string
+}
+
+// emitParallelMoves は移動先が互いに異なるコピーを、他のコピーの移動元を壊さない順に出力する
+// 移動先と移動元が循環する場合は、1つの移動元をR11に退避して循環を断ち切る
+func (cg *CodeGenerator) emitParallelMoves(moves []parallelMove) {
+	var pending []parallelMove
+	for _, m := range moves {
+		if m.dst != m.src {
+			pending = append(pending, m)
+		}
+	}
+	for len(pending) > 0 {
+		ready := -1
+		for i, m := range pending {
+			blocked := false
+			for j, other := range pending {
+				if i != j && other.src == m.dst {
+					blocked = true
+					break
+				}
+			}
+			if !blocked {
+				ready = i
+				break
+			}
+		}
+		if ready < 0 {
+			saved := pending[0].src
+			cg.emitf("    movq %s, %%r11", saved)
+			for i := range pending {
+				if pending[i].src == saved {
+					pending[i].src = "%r11"
+				}
+			}
+			continue
+		}
+		cg.emitMove(pending[ready].dst, pending[ready].src)
+		pending = append(pending[:ready], pending[ready+1:]...)
+	}
+}
+
+// emitInstruction は1つの命令をアセンブリに変換する
+func (cg *CodeGenerator) emitInstruction(frame *frameLayout, in *instruction) {
+	op := frame.operand
+	// result は結果を計算するレジスタ（割り当て先がメモリならRAX）を返す
+	result := func() string {
+		if dst := op(in.dst); !isMemory(dst) {
+			return dst
+		}
+		return "%rax"
+	}
+	// source は右辺のオペランド（仮想レジスタがなければ即値）を返す
+	source := func() string {
+		if in.b == 0 {
+			return fmt.Sprintf("$%d", in.imm)
+		}
+		return op(in.b)
+	}
+
+	switch in.op {
+	case opComment:
+		cg.emitf("    # %s", in.sym)
+
+	case opEntry:
+		var moves []parallelMove
+		for i, param := range in.args {
+			if param == 0 {
+				continue
+			}
+			if i < len(argumentRegisters) {
+				moves = append(moves, parallelMove{op(param), argumentRegisters[i]})
+				continue
+			}
+			// スタックで渡された引数は戻りアドレスと保存したRBPの上にある
+			moves = append(moves, parallelMove{op(param), fmt.Sprintf("%d(%%rbp)", 16+8*(i-len(argumentRegisters)))})
+		}
+		if in.dst != 0 {
+			moves = append(moves, parallelMove{op(in.dst), "%r10"})
+		}
+		cg.emitParallelMoves(moves)
+
+	case opConst:
+		if fitsImmediate(in.imm) {
+			cg.emitf("    movq $%d, %s", in.imm, op(in.dst))
+			return
+		}
+		dst := result()
+		cg.emitf("    movabsq $%d, %s", in.imm, dst)
+		cg.emitMove(op(in.dst), dst)
+
+	case opAddress:
+		dst := result()
+		cg.emitf("    leaq %s(%%rip), %s", in.sym, dst)
+		cg.emitMove(op(in.dst), dst)
+
+	case opMove:
+		cg.emitMove(op(in.dst), op(in.a))
+
+	case opBinary:
+		instruction := in.cond + "q"
+		dst := result()
+		if in.b != 0 && dst == op(in.b) && in.a != in.b {
+			// 結果のレジスタが右辺と同じ場合、交換できる演算は左辺を加え、引き算はRAXで計算する
+			if in.cond != "sub" {
+				cg.emitf("    %s %s, %s", instruction, op(in.a), dst)
+				return
+			}
+			dst = "%rax"
+		}
+		cg.emitMove(dst, op(in.a))
+		cg.emitf("    %s %s, %s", instruction, source(), dst)
+		cg.emitMove(op(in.dst), dst)
+
+	case opDivide:
+		// 0での除算はidivの例外（SIGFPE）にせず、インタプリタと同じエラーで終了する
+		cg.emitf("    cmpq $0, %s", op(in.b))
+		cg.emitf("    je %s", in.sym)
+		// 符号拡張したRDX:RAXを割り、商はRAX、余りはRDXに得る
+		cg.emitMove("%rax", op(in.a))
+		cg.emit("    cqto")
+		cg.emitf("    idivq %s", op(in.b))
+		if in.cond == "%" {
+			cg.emitMove(op(in.dst), "%rdx")
+		} else {
+			cg.emitMove(op(in.dst), "%rax")
+		}
+
+	case opNegate:
+		dst := result()
+		cg.emitMove(dst, op(in.a))
+		cg.emitf("    negq %s", dst)
+		cg.emitMove(op(in.dst), dst)
+
+	case opCompare:
+		cg.emitComparison(frame, in)
+		cg.emitf("    set%s %%al", in.cond)
+		cg.emit("    movzbl %al, %eax")
+		cg.emitMove(op(in.dst), "%rax")
+
+	case opLoad:
+		base := cg.inRegister(op(in.a), "%r11")
+		index := ""
+		if in.index != 0 {
+			index = cg.inRegister(op(in.index), "%r10")
+		}
+		dst := result()
+		cg.emitf("    movq %s, %s", memoryOperand(in.imm, base, index), dst)
+		cg.emitMove(op(in.dst), dst)
+
+	case opStore:
+		base := cg.inRegister(op(in.a), "%r11")
+		value := cg.inRegister(op(in.b), "%rax")
+		index := ""
+		if in.index != 0 {
+			index = cg.inRegister(op(in.index), "%r10")
+		}
+		cg.emitf("    movq %s, %s", value, memoryOperand(in.imm, base, index))
+
+	case opLabel:
+		cg.emitf("%s:", in.sym)
+
+	case opJump:
+		cg.emitf("    jmp %s", in.sym)
+
+	case opBranch:
+		cg.emitComparison(frame, in)
+		cg.emitf("    j%s %s", in.cond, in.sym)
+
+	case opBoundsCheck:
+		// 負の添字は符号なしの比較で要素数以上とみなす
+		base := cg.inRegister(op(in.a), "%r11")
+		index := cg.inRegister(op(in.b), "%r10")
+		cg.emitf("    cmpq (%s), %s", base, index)
+		cg.emitf("    jae %s", in.sym)
+
+	case opCall:
+		cg.emitCall(frame, in)
+
+	case opReturn:
+		cg.emitMove("%rax", op(in.a))
+		cg.emitEpilogue(frame)
+	}
+}
+
+// emitComparison は比較・分岐の命令の左辺と右辺を比べる
+func (cg *CodeGenerator) emitComparison(frame *frameLayout, in *instruction) {
+	left := frame.operand(in.a)
+	if in.b == 0 {
+		if in.imm == 0 && !isMemory(left) {
+			cg.emitf("    testq %s, %s", left, left)
+			return
+		}
+		cg.emitf("    cmpq $%d, %s", in.imm, left)
+		return
+	}
+	right := frame.operand(in.b)
+	if isMemory(left) && isMemory(right) {
+		left = cg.inRegister(left, "%rax")
+	}
+	cg.emitf("    cmpq %s, %s", right, left)
+}
+
+// emitCall はSystem V ABIに従って関数を呼び出す
+// 最初の6個の引数をレジスタ、残りをスタックで渡し、呼び出し時のRSPを16バイト境界に揃える
+// クロージャを呼び出す場合は、レコードをR10に置いてその先頭のコードのアドレスを間接的に呼び出す
+func (cg *CodeGenerator) emitCall(frame *frameLayout, in *instruction) {
+	op := frame.operand
+
+	pushed := 0
+	if stackArgs := len(in.args) - len(argumentRegisters); stackArgs > 0 {
+		if stackArgs%2 != 0 {
+			cg.emit("    subq $8, %rsp")
+			pushed++
+		}
+		for i := len(in.args) - 1; i >= len(argumentRegisters); i-- {
+			cg.emitf("    pushq %s", op(in.args[i]))
+			pushed++
+		}
+	}
+
+	var moves []parallelMove
+	for i := 0; i < len(in.args) && i < len(argumentRegisters); i++ {
+		moves = append(moves, parallelMove{argumentRegisters[i], op(in.args[i])})
+	}
+	if in.sym == "" {
+		moves = append(moves, parallelMove{"%r10", op(in.a)})
+	}
+	cg.emitParallelMoves(moves)
+
+	if in.sym == "" {
+		cg.emit("    call *(%r10)")
+	} else {
+		cg.emitf("    call %s", in.sym)
+	}
+	if pushed > 0 {
+		cg.emitf("    addq $%d, %%rsp", 8*pushed)
+	}
+	if in.dst != 0 {
+		cg.emitMove(op(in.dst), "%rax")
+	}
+}
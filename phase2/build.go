@@ -0,0 +1,83 @@
+package phase2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// BuildError はアセンブラ・リンカによる実行可能ファイルの作成の失敗を表す
+type BuildError struct {
+	Step    string   // 失敗した段階（"assemble" または "link"）
+	Command []string // 実行したコマンドライン
+	Output  string   // コマンドの標準出力と標準エラー出力
+	Hint    string   // 解決のための補足（省略可）
+	Err     error
+}
+
+func (e *BuildError) Error() string {
+	var out strings.Builder
+	tool := "assembler"
+	if e.Step == "link" {
+		tool = "linker"
+	}
+	fmt.Fprintf(&out, "%s failed: %s: %v", tool, strings.Join(e.Command, " "), e.Err)
+	if output := strings.TrimSpace(e.Output); output != "" {
+		out.WriteString("\n" + output)
+	}
+	if e.Hint != "" {
+		out.WriteString("\nhint: " + e.Hint)
+	}
+	return out.String()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// AssembleAndLink は生成されたアセンブリコードをアセンブルしてリンクし、実行可能ファイルを作成する
+// アセンブラとリンカにはシステムの as と ld を使い（LinuxではCライブラリなしで、macOSではlibSystemとリンクする）、中間ファイルは一時ディレクトリに置く
+func (cg *CodeGenerator) AssembleAndLink(asmCode, outputFile string) error {
+	if host := DefaultTarget(); cg.target != host {
+		return fmt.Errorf("cannot build an executable for target %s on this host (%s); use --target=%s or assemble the generated code on a %s machine",
+			cg.target.Name, host.Name, host.Name, cg.target.Name)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "pug-build-")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	asmFile := filepath.Join(tmpDir, "main.s")
+	objFile := filepath.Join(tmpDir, "main.o")
+	if err := os.WriteFile(asmFile, []byte(asmCode), 0600); err != nil {
+		return fmt.Errorf("failed to write assembly file: %w", err)
+	}
+
+	assemble, link := cg.target.BuildCommands(asmFile, objFile, outputFile)
+	if err := runBuildCommand("assemble", assemble); err != nil {
+		return err
+	}
+	return runBuildCommand("link", link)
+}
+
+// runBuildCommand はアセンブラ・リンカを実行し、失敗した場合はBuildErrorを返す
+func runBuildCommand(step string, command []string) error {
+	// #nosec G204 -- コマンドはターゲットの定義から組み立てている
+	output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	buildErr := &BuildError{Step: step, Command: command, Output: string(output), Err: err}
+	if errors.Is(err, exec.ErrNotFound) {
+		buildErr.Hint = fmt.Sprintf("`%s` was not found in PATH; install the system assembler and linker (binutils on Linux, the Xcode command line tools on macOS)", command[0])
+	} else if step == "assemble" {
+		buildErr.Hint = "the generated assembly was rejected; this is a bug in the code generator"
+	}
+	return buildErr
+}
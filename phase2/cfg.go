@@ -0,0 +1,474 @@
+package phase2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nyasuto/pug/phase1"
+)
+
+// EdgeKind は制御フローグラフの辺の種類
+type EdgeKind string
+
+// 辺の種類
+const (
+	EdgeNormal   EdgeKind = ""         // 次のブロックへの無条件の遷移
+	EdgeTrue     EdgeKind = "true"     // 条件が真の場合の分岐
+	EdgeFalse    EdgeKind = "false"    // 条件が偽の場合の分岐
+	EdgeBreak    EdgeKind = "break"    // break文によるループの脱出
+	EdgeContinue EdgeKind = "continue" // continue文による次の反復への遷移
+	EdgeReturn   EdgeKind = "return"   // return文による関数の脱出
+	EdgeLoop     EdgeKind = "loop"     // ループ本体の末尾から条件判定への戻り
+)
+
+// BasicBlock は基本ブロック（途中で分岐も合流もしない文の並び）
+// 分岐するブロックでは、最後のノードが分岐の条件式になる
+type BasicBlock struct {
+	ID           int
+	Label        string        // "entry"、"while.cond" のようなブロックの役割
+	Nodes        []phase1.Node // ブロック内の文と条件式（実行順）
+	Successors   []*Edge
+	Predecessors []*Edge
+}
+
+// Edge は基本ブロック間の制御の遷移
+type Edge struct {
+	From *BasicBlock
+	To   *BasicBlock
+	Kind EdgeKind
+}
+
+// CFG は関数またはトップレベルの制御フローグラフ
+type CFG struct {
+	Name     string                  // 関数名（トップレベルは "<main>"、無名関数は "fn@行:列"）
+	Function *phase1.FunctionLiteral // 対象の関数（トップレベルはnil）
+	Entry    *BasicBlock
+	Exit     *BasicBlock
+	Blocks   []*BasicBlock // Entryから始まりExitで終わる、IDの順に並んだブロック
+}
+
+// BuildCFGs はプログラムのトップレベルと、含まれる全ての関数リテラルの制御フローグラフを作成する
+// トップレベルのグラフが先頭で、関数は外側の関数から順に（同じ深さではソース上の出現順に）並ぶ
+func BuildCFGs(program *phase1.Program) []*CFG {
+	var functions []namedFunction
+	main := buildCFG("<main>", nil, program.Statements, &functions)
+	cfgs := []*CFG{main}
+
+	// 関数本体の中で見つかった関数リテラルは、リストの末尾に追加されて順に処理される
+	for i := 0; i < len(functions); i++ {
+		fn := functions[i]
+		cfgs = append(cfgs, buildCFG(fn.name, fn.literal, fn.literal.Body.Statements, &functions))
+	}
+	return cfgs
+}
+
+// BuildFunctionCFG は関数本体の制御フローグラフを作成する（内側の関数リテラルは含まない）
+func BuildFunctionCFG(name string, fn *phase1.FunctionLiteral) *CFG {
+	var functions []namedFunction
+	return buildCFG(name, fn, fn.Body.Statements, &functions)
+}
+
+// namedFunction はグラフを作成する関数リテラルとその名前
+type namedFunction struct {
+	name    string
+	literal *phase1.FunctionLiteral
+}
+
+// loopTargets はbreak文・continue文の遷移先
+type loopTargets struct {
+	name       string // ループのラベル（省略時は空）
+	breakTo    *BasicBlock
+	continueTo *BasicBlock
+}
+
+// cfgBuilder は文をたどって制御フローグラフを組み立てる
+type cfgBuilder struct {
+	cfg       *CFG
+	current   *BasicBlock // 現在文を追加しているブロック（到達できない位置ではnil）
+	loops     []loopTargets
+	functions *[]namedFunction
+}
+
+// buildCFG は文の並びから制御フローグラフを作成する
+func buildCFG(name string, fn *phase1.FunctionLiteral, statements []phase1.Statement, functions *[]namedFunction) *CFG {
+	b := &cfgBuilder{
+		cfg:       &CFG{Name: name, Function: fn},
+		functions: functions,
+	}
+	b.cfg.Entry = b.newBlock("entry")
+	b.cfg.Exit = &BasicBlock{Label: "exit"}
+	b.current = b.cfg.Entry
+
+	b.statements(statements)
+	if b.current != nil {
+		b.addEdge(b.current, b.cfg.Exit, EdgeNormal)
+	}
+
+	b.cfg.Blocks = append(b.cfg.Blocks, b.cfg.Exit)
+	for i, block := range b.cfg.Blocks {
+		block.ID = i
+	}
+	return b.cfg
+}
+
+// newBlock は新しいブロックを作成してグラフに追加する
+func (b *cfgBuilder) newBlock(label string) *BasicBlock {
+	block := &BasicBlock{Label: label}
+	b.cfg.Blocks = append(b.cfg.Blocks, block)
+	return block
+}
+
+// removeBlock はどこからも到達しないブロックをグラフから取り除く
+func (b *cfgBuilder) removeBlock(block *BasicBlock) {
+	for i, other := range b.cfg.Blocks {
+		if other == block {
+			b.cfg.Blocks = append(b.cfg.Blocks[:i], b.cfg.Blocks[i+1:]...)
+			return
+		}
+	}
+}
+
+// addEdge はブロック間に辺を追加する
+func (b *cfgBuilder) addEdge(from, to *BasicBlock, kind EdgeKind) {
+	edge := &Edge{From: from, To: to, Kind: kind}
+	from.Successors = append(from.Successors, edge)
+	to.Predecessors = append(to.Predecessors, edge)
+}
+
+// add は現在のブロックにノードを追加する
+// 到達できない位置の文は、先行ブロックを持たない "unreachable" ブロックに置く
+func (b *cfgBuilder) add(node phase1.Node) {
+	if b.current == nil {
+		b.current = b.newBlock("unreachable")
+	}
+	b.current.Nodes = append(b.current.Nodes, node)
+	b.collectFunctions(node)
+}
+
+// jump は現在のブロックから遷移先への辺を追加し、以降の位置を到達できないものとする
+func (b *cfgBuilder) jump(to *BasicBlock, kind EdgeKind) {
+	b.addEdge(b.current, to, kind)
+	b.current = nil
+}
+
+// statements は文の並びをグラフに追加する
+func (b *cfgBuilder) statements(statements []phase1.Statement) {
+	for _, stmt := range statements {
+		b.statement(stmt)
+	}
+}
+
+// statement は文をグラフに追加する
+func (b *cfgBuilder) statement(stmt phase1.Statement) {
+	switch node := stmt.(type) {
+	case *phase1.ExpressionStatement:
+		if ifExpr, ok := node.Expression.(*phase1.IfExpression); ok {
+			b.ifExpression(ifExpr)
+			return
+		}
+		b.add(node)
+	case *phase1.BlockStatement:
+		b.statements(node.Statements)
+	case *phase1.WhileStatement:
+		b.whileStatement(node)
+	case *phase1.ForStatement:
+		b.forStatement(node)
+	case *phase1.ReturnStatement:
+		b.add(node)
+		b.jump(b.cfg.Exit, EdgeReturn)
+	case *phase1.BreakStatement:
+		b.add(node)
+		target := b.findLoop(node.Label)
+		if target == nil {
+			// ループ外のbreakや未定義のラベルは制御フロー解析でエラーになるため、ここでは遷移先を持たない
+			b.current = nil
+			return
+		}
+		b.jump(target.breakTo, EdgeBreak)
+	case *phase1.ContinueStatement:
+		b.add(node)
+		target := b.findLoop(node.Label)
+		if target == nil {
+			b.current = nil
+			return
+		}
+		b.jump(target.continueTo, EdgeContinue)
+	default:
+		b.add(stmt)
+	}
+}
+
+// findLoop はbreak/continueの対象のループを内側から探す（ラベルがなければ最も内側のループ）
+func (b *cfgBuilder) findLoop(label *phase1.Identifier) *loopTargets {
+	for i := len(b.loops) - 1; i >= 0; i-- {
+		if label == nil || b.loops[i].name == label.Value {
+			return &b.loops[i]
+		}
+	}
+	return nil
+}
+
+// ifExpression は文として使われたif式（else if の連鎖を含む）をグラフに追加する
+// 条件式は現在のブロックの末尾に置き、真と偽の分岐を "if.end" ブロックで合流させる
+func (b *cfgBuilder) ifExpression(node *phase1.IfExpression) {
+	b.add(node.Condition)
+	cond := b.current
+
+	var ends []*BasicBlock
+
+	b.current = b.newBlock("if.then")
+	b.addEdge(cond, b.current, EdgeTrue)
+	b.statements(node.Consequence.Statements)
+	ends = append(ends, b.current)
+
+	switch {
+	case node.ElseIf != nil:
+		b.current = b.newBlock("if.else")
+		b.addEdge(cond, b.current, EdgeFalse)
+		b.ifExpression(node.ElseIf)
+		ends = append(ends, b.current)
+	case node.Alternative != nil:
+		b.current = b.newBlock("if.else")
+		b.addEdge(cond, b.current, EdgeFalse)
+		b.statements(node.Alternative.Statements)
+		ends = append(ends, b.current)
+	default:
+		ends = append(ends, nil)
+	}
+
+	// どの分岐も末尾に到達しない場合は合流ブロックを作らない
+	reachable := node.ElseIf == nil && node.Alternative == nil
+	for _, end := range ends {
+		if end != nil {
+			reachable = true
+		}
+	}
+	if !reachable {
+		b.current = nil
+		return
+	}
+
+	join := b.newBlock("if.end")
+	for _, end := range ends {
+		if end != nil {
+			b.addEdge(end, join, EdgeNormal)
+		}
+	}
+	if node.ElseIf == nil && node.Alternative == nil {
+		b.addEdge(cond, join, EdgeFalse)
+	}
+	b.current = join
+}
+
+// whileStatement はwhile文をグラフに追加する
+func (b *cfgBuilder) whileStatement(node *phase1.WhileStatement) {
+	header := b.newBlock("while.cond")
+	if b.current != nil {
+		b.addEdge(b.current, header, EdgeNormal)
+	}
+	b.current = header
+	b.add(node.Condition)
+
+	body := b.newBlock("while.body")
+	b.addEdge(header, body, EdgeTrue)
+	after := b.newBlock("while.end")
+	if !isInfiniteLoop(node.Condition) {
+		b.addEdge(header, after, EdgeFalse)
+	}
+
+	b.loop(body, node.Body, loopTargets{name: loopLabel(node.Label), breakTo: after, continueTo: header}, header, EdgeLoop)
+	b.leaveLoop(after)
+}
+
+// forStatement はfor文をグラフに追加する
+// 初期化文は直前のブロックに、更新式はcontinueの遷移先となる "for.update" ブロックに置く
+func (b *cfgBuilder) forStatement(node *phase1.ForStatement) {
+	if node.Initializer != nil {
+		b.add(node.Initializer)
+	}
+
+	header := b.newBlock("for.cond")
+	if b.current != nil {
+		b.addEdge(b.current, header, EdgeNormal)
+	}
+	b.current = header
+	if node.Condition != nil {
+		b.add(node.Condition)
+	}
+
+	body := b.newBlock("for.body")
+	after := b.newBlock("for.end")
+	if node.Condition != nil && !isInfiniteLoop(node.Condition) {
+		b.addEdge(header, body, EdgeTrue)
+		b.addEdge(header, after, EdgeFalse)
+	} else {
+		b.addEdge(header, body, EdgeNormal)
+	}
+
+	update, kind := header, EdgeLoop
+	if node.Update != nil {
+		update, kind = &BasicBlock{Label: "for.update"}, EdgeNormal
+	}
+
+	b.loop(body, node.Body, loopTargets{name: loopLabel(node.Label), breakTo: after, continueTo: update}, update, kind)
+
+	if update != header {
+		if len(update.Predecessors) > 0 {
+			// 更新式のブロックはループ本体の後に並べる
+			b.cfg.Blocks = append(b.cfg.Blocks, update)
+			b.current = update
+			b.add(node.Update)
+			b.addEdge(update, header, EdgeLoop)
+		}
+	}
+	b.leaveLoop(after)
+}
+
+// loop はループ本体をグラフに追加し、本体の末尾からnextへの辺を追加する
+func (b *cfgBuilder) loop(body *BasicBlock, block *phase1.BlockStatement, targets loopTargets, next *BasicBlock, kind EdgeKind) {
+	b.loops = append(b.loops, targets)
+	b.current = body
+	b.statements(block.Statements)
+	if b.current != nil {
+		b.addEdge(b.current, next, kind)
+	}
+	b.loops = b.loops[:len(b.loops)-1]
+}
+
+// leaveLoop はループの後の位置をループ脱出のブロックとする
+// 脱出ブロックはループ本体のブロックの後に並べ直し、breakのない無限ループのように
+// 脱出ブロックへ到達しない場合は取り除く
+func (b *cfgBuilder) leaveLoop(after *BasicBlock) {
+	b.removeBlock(after)
+	if len(after.Predecessors) == 0 {
+		b.current = nil
+		return
+	}
+	b.cfg.Blocks = append(b.cfg.Blocks, after)
+	b.current = after
+}
+
+// collectFunctions はノードに含まれる関数リテラルを、グラフを作成する関数として登録する
+func (b *cfgBuilder) collectFunctions(node phase1.Node) {
+	switch n := node.(type) {
+	case *phase1.LetStatement:
+		if fn, ok := n.Value.(*phase1.FunctionLiteral); ok {
+			*b.functions = append(*b.functions, namedFunction{name: n.Name.Value, literal: fn})
+			return
+		}
+		b.collectFunctions(n.Value)
+	case *phase1.ReturnStatement:
+		b.collectFunctions(n.ReturnValue)
+	case *phase1.ExpressionStatement:
+		b.collectFunctions(n.Expression)
+	case *phase1.FunctionLiteral:
+		span := phase1.NodeSpan(n)
+		*b.functions = append(*b.functions, namedFunction{name: fmt.Sprintf("fn@%d:%d", span.Line, span.Column), literal: n})
+	case *phase1.CallExpression:
+		b.collectFunctions(n.Function)
+		for _, arg := range n.Arguments {
+			b.collectFunctions(arg)
+		}
+	case *phase1.InfixExpression:
+		b.collectFunctions(n.Left)
+		b.collectFunctions(n.Right)
+	case *phase1.PrefixExpression:
+		b.collectFunctions(n.Right)
+	case *phase1.AssignExpression:
+		b.collectFunctions(n.Value)
+	case *phase1.ArrayLiteral:
+		for _, element := range n.Elements {
+			b.collectFunctions(element)
+		}
+	case *phase1.HashLiteral:
+		for _, pair := range n.Pairs {
+			b.collectFunctions(pair.Key)
+			b.collectFunctions(pair.Value)
+		}
+	case *phase1.IndexExpression:
+		b.collectFunctions(n.Left)
+		b.collectFunctions(n.Index)
+	case *phase1.IfExpression:
+		// 式の中のif式は分岐させずに1つのノードとして扱うため、分岐内の関数もここで集める
+		b.collectFunctions(n.Condition)
+		for _, stmt := range n.Consequence.Statements {
+			b.collectFunctions(stmt)
+		}
+		if n.ElseIf != nil {
+			b.collectFunctions(n.ElseIf)
+		}
+		if n.Alternative != nil {
+			for _, stmt := range n.Alternative.Statements {
+				b.collectFunctions(stmt)
+			}
+		}
+	}
+}
+
+// Dot はグラフをGraphvizのDOT形式で出力する
+func (g *CFG) Dot() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "digraph %s {\n", dotQuote(g.Name))
+	out.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	g.writeDot(&out, "  ", "")
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// DotGraphs は複数のグラフを、それぞれをクラスタとする1つのDOT形式のグラフとして出力する
+func DotGraphs(cfgs []*CFG) string {
+	var out strings.Builder
+	out.WriteString("digraph cfg {\n")
+	out.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	for i, g := range cfgs {
+		fmt.Fprintf(&out, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&out, "    label=%s;\n", dotQuote(g.Name))
+		g.writeDot(&out, "    ", fmt.Sprintf("f%d_", i))
+		out.WriteString("  }\n")
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// writeDot はノードと辺の定義を出力する（prefixはクラスタ間でノード名が衝突しないように付ける接頭辞）
+func (g *CFG) writeDot(out *strings.Builder, indent, prefix string) {
+	for _, block := range g.Blocks {
+		lines := []string{fmt.Sprintf("B%d (%s)", block.ID, block.Label)}
+		for _, node := range block.Nodes {
+			lines = append(lines, cfgNodeText(node))
+		}
+		fmt.Fprintf(out, "%s%sB%d [label=%s];\n", indent, prefix, block.ID, dotQuote(strings.Join(lines, "\n")+"\n"))
+	}
+	for _, block := range g.Blocks {
+		for _, edge := range block.Successors {
+			fmt.Fprintf(out, "%s%sB%d -> %sB%d", indent, prefix, edge.From.ID, prefix, edge.To.ID)
+			if edge.Kind != EdgeNormal {
+				fmt.Fprintf(out, " [label=%s]", dotQuote(string(edge.Kind)))
+			}
+			out.WriteString(";\n")
+		}
+	}
+}
+
+// cfgNodeText はブロック内のノードの表示用の文字列を返す
+// let束縛された関数リテラルは、本体を別のグラフで表すためシグネチャだけを表示する
+func cfgNodeText(node phase1.Node) string {
+	if let, ok := node.(*phase1.LetStatement); ok {
+		if fn, ok := let.Value.(*phase1.FunctionLiteral); ok {
+			params := make([]string, len(fn.Parameters))
+			for i, param := range fn.Parameters {
+				params[i] = param.String()
+			}
+			return fmt.Sprintf("let %s = fn(%s) { ... };", let.Name.Value, strings.Join(params, ", "))
+		}
+	}
+	return node.String()
+}
+
+// dotQuote はDOT形式の文字列リテラルを作る（改行は左揃えの改行 \l にする）
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "\n", "\\l")
+	return "\"" + s + "\""
+}
@@ -0,0 +1,215 @@
+package phase2
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nyasuto/pug/phase1"
+)
+
+// cfgSummary はグラフのブロックと辺を "B1(if.then)" や "B0->B1:true" の形式で並べる
+func cfgSummary(g *CFG) ([]string, []string) {
+	var blocks, edges []string
+	for _, block := range g.Blocks {
+		blocks = append(blocks, fmt.Sprintf("B%d(%s)", block.ID, block.Label))
+		for _, edge := range block.Successors {
+			e := fmt.Sprintf("B%d->B%d", edge.From.ID, edge.To.ID)
+			if edge.Kind != EdgeNormal {
+				e += ":" + string(edge.Kind)
+			}
+			edges = append(edges, e)
+		}
+	}
+	return blocks, edges
+}
+
+func parseCFGs(t *testing.T, input string) []*CFG {
+	t.Helper()
+	p := phase1.NewParser(phase1.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return BuildCFGs(program)
+}
+
+func TestBuildCFGs_TopLevel(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		blocks string
+		edges  string
+	}{
+		{
+			name:   "直線的なコード",
+			input:  "let x = 1; puts(x);",
+			blocks: "B0(entry) B1(exit)",
+			edges:  "B0->B1",
+		},
+		{
+			name:   "elseのないif",
+			input:  "let x = 1; if (x > 0) { puts(x); } puts(0);",
+			blocks: "B0(entry) B1(if.then) B2(if.end) B3(exit)",
+			edges:  "B0->B1:true B0->B2:false B1->B2 B2->B3",
+		},
+		{
+			name:   "if-else",
+			input:  "if (true) { 1; } else { 2; }",
+			blocks: "B0(entry) B1(if.then) B2(if.else) B3(if.end) B4(exit)",
+			edges:  "B0->B1:true B0->B2:false B1->B3 B2->B3 B3->B4",
+		},
+		{
+			name:   "else if",
+			input:  "if (a) { 1; } else if (b) { 2; } else { 3; }",
+			blocks: "B0(entry) B1(if.then) B2(if.else) B3(if.then) B4(if.else) B5(if.end) B6(if.end) B7(exit)",
+			edges:  "B0->B1:true B0->B2:false B1->B6 B2->B3:true B2->B4:false B3->B5 B4->B5 B5->B6 B6->B7",
+		},
+		{
+			name:   "while",
+			input:  "let i = 0; while (i < 3) { i = i + 1; } puts(i);",
+			blocks: "B0(entry) B1(while.cond) B2(while.body) B3(while.end) B4(exit)",
+			edges:  "B0->B1 B1->B2:true B1->B3:false B2->B1:loop B3->B4",
+		},
+		{
+			name:   "whileのbreakとcontinue",
+			input:  "while (x) { if (a) { break; } if (b) { continue; } puts(1); }",
+			blocks: "B0(entry) B1(while.cond) B2(while.body) B3(if.then) B4(if.end) B5(if.then) B6(if.end) B7(while.end) B8(exit)",
+			edges:  "B0->B1 B1->B2:true B1->B7:false B2->B3:true B2->B4:false B3->B7:break B4->B5:true B4->B6:false B5->B1:continue B6->B1:loop B7->B8",
+		},
+		{
+			name:   "for",
+			input:  "for (let i = 0; i < 3; i = i + 1) { if (i == 1) { continue; } puts(i); }",
+			blocks: "B0(entry) B1(for.cond) B2(for.body) B3(if.then) B4(if.end) B5(for.update) B6(for.end) B7(exit)",
+			edges:  "B0->B1 B1->B2:true B1->B6:false B2->B3:true B2->B4:false B3->B5:continue B4->B5 B5->B1:loop B6->B7",
+		},
+		{
+			name:   "breakのない無限ループ",
+			input:  "while (true) { puts(1); }",
+			blocks: "B0(entry) B1(while.cond) B2(while.body) B3(exit)",
+			edges:  "B0->B1 B1->B2:true B2->B1:loop",
+		},
+		{
+			name:   "条件のないfor",
+			input:  "for (;;) { break; }",
+			blocks: "B0(entry) B1(for.cond) B2(for.body) B3(for.end) B4(exit)",
+			edges:  "B0->B1 B1->B2 B2->B3:break B3->B4",
+		},
+		{
+			name:   "ラベル付きのbreakとcontinue",
+			input:  "outer: while (a) { while (b) { if (c) { break outer; } continue outer; } }",
+			blocks: "B0(entry) B1(while.cond) B2(while.body) B3(while.cond) B4(while.body) B5(if.then) B6(if.end) B7(while.end) B8(while.end) B9(exit)",
+			edges:  "B0->B1 B1->B2:true B1->B8:false B2->B3 B3->B4:true B3->B7:false B4->B5:true B4->B6:false B5->B8:break B6->B1:continue B7->B1:loop B8->B9",
+		},
+		{
+			name:   "入れ子のループのbreak",
+			input:  "while (a) { while (b) { break; } continue; }",
+			blocks: "B0(entry) B1(while.cond) B2(while.body) B3(while.cond) B4(while.body) B5(while.end) B6(while.end) B7(exit)",
+			edges:  "B0->B1 B1->B2:true B1->B6:false B2->B3 B3->B4:true B3->B5:false B4->B5:break B5->B1:continue B6->B7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgs := parseCFGs(t, tt.input)
+			if len(cfgs) != 1 {
+				t.Fatalf("expected 1 graph, got %d", len(cfgs))
+			}
+			blocks, edges := cfgSummary(cfgs[0])
+			if got := strings.Join(blocks, " "); got != tt.blocks {
+				t.Errorf("blocks wrong.\nexpected=%s\ngot=     %s", tt.blocks, got)
+			}
+			if got := strings.Join(edges, " "); got != tt.edges {
+				t.Errorf("edges wrong.\nexpected=%s\ngot=     %s", tt.edges, got)
+			}
+		})
+	}
+}
+
+func TestBuildCFGs_Functions(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		graphs []string // グラフの名前とブロック・辺の要約
+	}{
+		{
+			name: "returnはexitへ遷移する",
+			input: `let abs = fn(x) {
+  if (x < 0) { return -x; }
+  x
+};`,
+			graphs: []string{
+				"<main>: B0(entry) B1(exit) | B0->B1",
+				"abs: B0(entry) B1(if.then) B2(if.end) B3(exit) | B0->B1:true B0->B2:false B1->B3:return B2->B3",
+			},
+		},
+		{
+			name: "全ての分岐がreturnするifの後は合流しない",
+			input: `let sign = fn(x) {
+  if (x < 0) { return -1; } else { return 1; }
+  0
+};`,
+			graphs: []string{
+				"<main>: B0(entry) B1(exit) | B0->B1",
+				"sign: B0(entry) B1(if.then) B2(if.else) B3(unreachable) B4(exit) | B0->B1:true B0->B2:false B1->B4:return B2->B4:return B3->B4",
+			},
+		},
+		{
+			name:  "入れ子の関数と無名関数",
+			input: "let outer = fn() { let inner = fn(y) { y }; inner(1) }; map(fn(z) { z * 2 });",
+			graphs: []string{
+				"<main>: B0(entry) B1(exit) | B0->B1",
+				"outer: B0(entry) B1(exit) | B0->B1",
+				"fn@1:61: B0(entry) B1(exit) | B0->B1",
+				"inner: B0(entry) B1(exit) | B0->B1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgs := parseCFGs(t, tt.input)
+			var got []string
+			for _, g := range cfgs {
+				blocks, edges := cfgSummary(g)
+				got = append(got, fmt.Sprintf("%s: %s | %s", g.Name, strings.Join(blocks, " "), strings.Join(edges, " ")))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.graphs, "\n") {
+				t.Errorf("graphs wrong.\nexpected:\n%s\ngot:\n%s", strings.Join(tt.graphs, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestCFG_Dot(t *testing.T) {
+	cfgs := parseCFGs(t, `let f = fn(x) { if (x) { return "a"; } "b" };`)
+
+	expected := `digraph "f" {
+  node [shape=box, fontname="monospace"];
+  B0 [label="B0 (entry)\lx\l"];
+  B1 [label="B1 (if.then)\lreturn \"a\";\l"];
+  B2 [label="B2 (if.end)\l\"b\"\l"];
+  B3 [label="B3 (exit)\l"];
+  B0 -> B1 [label="true"];
+  B0 -> B2 [label="false"];
+  B1 -> B3 [label="return"];
+  B2 -> B3;
+}
+`
+	if got := cfgs[1].Dot(); got != expected {
+		t.Errorf("Dot() wrong.\nexpected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	combined := DotGraphs(cfgs)
+	for _, want := range []string{
+		"subgraph cluster_0 {",
+		`label="<main>";`,
+		`f0_B0 [label="B0 (entry)\llet f = fn(x) { ... };\l"];`,
+		"subgraph cluster_1 {",
+		`f1_B0 -> f1_B1 [label="true"];`,
+	} {
+		if !strings.Contains(combined, want) {
+			t.Errorf("DotGraphs() does not contain %q:\n%s", want, combined)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package phase2
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nyasuto/pug/phase1"
+)
+
+// 関数の値は、コードのアドレスに続けて捕捉した変数のセルへのポインタを並べたクロージャのレコードへのポインタで表す
+// 内側の関数に捕捉される変数はフレームではなくヒープ上のセル（8バイト）に置き、フレームにはセルのアドレスを保存する
+// 外側の関数とクロージャが同じセルを共有するため、インタプリタの環境と同じく捕捉した変数への代入が互いに見える
+// 関数を呼び出すときはR10にクロージャのレコードを渡す（System V ABIの静的チェーンのレジスタ）
+
+// closureInfo は関数（またはトップレベル）の変数の捕捉に関する解析結果
+type closureInfo struct {
+	captures []*Symbol        // 外側の関数から捕捉する変数（クロージャのレコードに並べる順）
+	boxed    []*Symbol        // 内側の関数に捕捉されるため、関数の入口でセルに置く自身の変数（定義順）
+	isBoxed  map[*Symbol]bool // セルに置く自身の変数（for文のスコープの変数を含む）の集合
+}
+
+// captureAnalysis はプログラム全体の変数の捕捉の解析結果
+type captureAnalysis struct {
+	program   *closureInfo
+	functions map[*phase1.FunctionLiteral]*closureInfo
+	loops     map[*phase1.ForStatement][]*Symbol // for文のスコープの変数のうち、for文の入口でセルに置くもの（定義順）
+	symbols   map[*phase1.Identifier]*Symbol     // 識別子（変数の参照・let文の名前・引数）が指す変数
+	direct    map[*phase1.FunctionLiteral]bool   // 名前で直接呼び出せる、何も捕捉しないlet束縛された関数
+}
+
+// newCaptureAnalysis は何も捕捉しない空の解析結果を作成する
+func newCaptureAnalysis() *captureAnalysis {
+	return &captureAnalysis{
+		program:   newClosureInfo(),
+		functions: map[*phase1.FunctionLiteral]*closureInfo{},
+		loops:     map[*phase1.ForStatement][]*Symbol{},
+		symbols:   map[*phase1.Identifier]*Symbol{},
+		direct:    map[*phase1.FunctionLiteral]bool{},
+	}
+}
+
+func newClosureInfo() *closureInfo {
+	return &closureInfo{isBoxed: map[*Symbol]bool{}}
+}
+
+// function は関数リテラルの解析結果を返す（nilはトップレベル）
+func (a *captureAnalysis) function(fn *phase1.FunctionLiteral) *closureInfo {
+	if fn == nil {
+		return a.program
+	}
+	if info, ok := a.functions[fn]; ok {
+		return info
+	}
+	return newClosureInfo()
+}
+
+// captureScope は解析中のスコープ
+// インタプリタと同じく関数の本体とfor文ごとにシンボルテーブルを作り、ifやwhileのブロックの変数は外側のテーブルに定義する
+type captureScope struct {
+	table    *SymbolTable
+	info     *closureInfo  // スコープを含む関数の解析結果
+	declared []*Symbol     // 定義順の変数
+	function *captureScope // スコープを含む関数のスコープ（関数のスコープでは自身）
+	outer    *captureScope
+}
+
+// captureAnalyzer はシンボルテーブルで識別子を解決し、関数が外側の関数から捕捉する変数（自由変数）を求める
+type captureAnalyzer struct {
+	result   *captureAnalysis
+	scopes   map[*SymbolTable]*captureScope
+	bindings map[*Symbol]*phase1.FunctionLiteral // let束縛された関数の変数（再定義された変数はnil）
+	excluded map[*phase1.FunctionLiteral]bool    // 前回までの解析で直接呼び出せないことが分かった関数
+	found    map[*phase1.FunctionLiteral]bool    // 今回の解析で直接呼び出せないことが分かった関数
+}
+
+// analyzeCaptures はプログラムの各関数が捕捉する変数と、セルに置く変数を求める
+// 何も捕捉しないlet束縛された関数は名前で直接呼び出せるため、その名前の参照は捕捉として扱わない
+// 関数が捕捉するかどうかは参照する関数が直接呼び出せるかに依存するため、変化がなくなるまで解析を繰り返す
+func analyzeCaptures(program *phase1.Program) *captureAnalysis {
+	excluded := map[*phase1.FunctionLiteral]bool{}
+	for {
+		a := &captureAnalyzer{
+			result:   newCaptureAnalysis(),
+			scopes:   map[*SymbolTable]*captureScope{},
+			bindings: map[*Symbol]*phase1.FunctionLiteral{},
+			excluded: excluded,
+			found:    map[*phase1.FunctionLiteral]bool{},
+		}
+		a.analyzeFunction(nil, nil, program.Statements, a.result.program)
+
+		for fn := range a.result.direct {
+			if len(a.result.functions[fn].captures) > 0 {
+				a.found[fn] = true
+			}
+		}
+		changed := false
+		for fn := range a.found {
+			delete(a.result.direct, fn)
+			if !excluded[fn] {
+				excluded[fn] = true
+				changed = true
+			}
+		}
+		if !changed {
+			return a.result
+		}
+	}
+}
+
+// analyzeFunction は関数の引数を定義してから、本体のスコープを解析する
+func (a *captureAnalyzer) analyzeFunction(outer *captureScope, parameters []*phase1.Identifier, statements []phase1.Statement, info *closureInfo) {
+	scope := &captureScope{info: info, outer: outer}
+	scope.function = scope
+	if outer == nil {
+		scope.table = NewSymbolTable()
+	} else {
+		scope.table = NewEnclosedSymbolTable(outer.table)
+	}
+	a.scopes[scope.table] = scope
+
+	for _, param := range parameters {
+		a.define(scope, param, nil)
+	}
+	a.analyzeScope(scope, statements)
+	info.boxed = a.boxedSymbols(scope)
+}
+
+// analyzeScope はスコープの変数を定義してから、識別子を解決する
+// for文は初期化文・条件式・本体・更新式を1つの新しいスコープとして解析する
+func (a *captureAnalyzer) analyzeScope(scope *captureScope, statements []phase1.Statement) {
+	forEachInScope(statements, func(let *phase1.LetStatement) {
+		fn, _ := let.Value.(*phase1.FunctionLiteral)
+		a.define(scope, let.Name, fn)
+	}, func(phase1.Expression) {}, func(*phase1.ForStatement) {})
+
+	forEachInScope(statements, func(*phase1.LetStatement) {}, func(expr phase1.Expression) {
+		switch node := expr.(type) {
+		case *phase1.Identifier:
+			a.reference(scope, node)
+		case *phase1.AssignExpression:
+			// 代入される関数の名前は、別の関数を指す可能性があるため直接呼び出せない
+			if target, ok := node.Target.(*phase1.Identifier); ok {
+				if symbol, _, ok := scope.table.ResolveScope(target.Value); ok && a.bindings[symbol] != nil {
+					a.found[a.bindings[symbol]] = true
+				}
+			}
+		case *phase1.FunctionLiteral:
+			fnInfo := newClosureInfo()
+			a.result.functions[node] = fnInfo
+			a.analyzeFunction(scope, node.Parameters, node.Body.Statements, fnInfo)
+		}
+	}, func(node *phase1.ForStatement) {
+		loop := &captureScope{table: NewEnclosedSymbolTable(scope.table), info: scope.info, function: scope.function, outer: scope}
+		a.scopes[loop.table] = loop
+
+		var statements []phase1.Statement
+		if node.Initializer != nil {
+			statements = append(statements, node.Initializer)
+		}
+		statements = append(statements, &phase1.ExpressionStatement{Expression: node.Condition})
+		statements = append(statements, node.Body.Statements...)
+		statements = append(statements, &phase1.ExpressionStatement{Expression: node.Update})
+		a.analyzeScope(loop, statements)
+		a.result.loops[node] = a.boxedSymbols(loop)
+	})
+}
+
+// boxedSymbols はスコープで定義した変数のうち、セルに置くものを定義順に返す
+func (a *captureAnalyzer) boxedSymbols(scope *captureScope) []*Symbol {
+	var boxed []*Symbol
+	for _, symbol := range scope.declared {
+		if scope.info.isBoxed[symbol] {
+			boxed = append(boxed, symbol)
+		}
+	}
+	return boxed
+}
+
+// define はスコープに変数を定義する
+// 同じスコープで再定義された名前は、どの定義を指すかが実行順で変わるため直接呼び出しの対象にしない
+func (a *captureAnalyzer) define(scope *captureScope, ident *phase1.Identifier, fn *phase1.FunctionLiteral) {
+	if symbol, exists := scope.table.store[ident.Value]; exists {
+		a.result.symbols[ident] = symbol
+		if previous := a.bindings[symbol]; previous != nil {
+			a.found[previous] = true
+		}
+		if fn != nil {
+			a.found[fn] = true
+		}
+		a.bindings[symbol] = nil
+		return
+	}
+	symbol := scope.table.Define(ident.Value, nil)
+	a.result.symbols[ident] = symbol
+	scope.declared = append(scope.declared, symbol)
+	if fn != nil {
+		a.bindings[symbol] = fn
+		if !a.excluded[fn] {
+			a.result.direct[fn] = true
+		}
+	}
+}
+
+// reference は識別子の参照を解決し、外側の関数の変数であれば捕捉として記録する
+// 変数を定義した関数ではセルに置き、参照した関数からその関数までの間の関数はクロージャのレコードで受け渡す
+func (a *captureAnalyzer) reference(scope *captureScope, ident *phase1.Identifier) {
+	symbol, table, ok := scope.table.ResolveScope(ident.Value)
+	if !ok {
+		return
+	}
+	a.result.symbols[ident] = symbol
+	owner := a.scopes[table]
+	if owner.function == scope.function {
+		return
+	}
+	if fn := a.bindings[symbol]; fn != nil && !a.excluded[fn] {
+		return
+	}
+
+	owner.info.isBoxed[symbol] = true
+	for s := scope.function; s != owner.function; s = s.outer.function {
+		if !slices.Contains(s.info.captures, symbol) {
+			s.info.captures = append(s.info.captures, symbol)
+		}
+	}
+}
+
+// symbolNames は変数の名前を順に返す
+func symbolNames(symbols []*Symbol) []string {
+	names := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		names[i] = symbol.Name
+	}
+	return names
+}
+
+// containsString は文字列のスライスが値を含むかどうかを返す
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachInFunction は関数本体（またはトップレベル）の文を評価順にたどり、let文と式（部分式を含む）を通知する
+// ブロックやif式の分岐の中の文もたどるが、関数リテラルは式として通知するだけで本体には入らない
+func forEachInFunction(statements []phase1.Statement, let func(*phase1.LetStatement), visit func(phase1.Expression)) {
+	forEachInScope(statements, let, visit, nil)
+}
+
+// forEachInScope は forEachInFunction と同じく文をたどるが、loopがnilでなければfor文には入らずにloopに通知する
+func forEachInScope(statements []phase1.Statement, let func(*phase1.LetStatement), visit func(phase1.Expression), loop func(*phase1.ForStatement)) {
+	var statement func(phase1.Statement)
+	expression := func(expr phase1.Expression) {
+		forEachSubexpression(expr, visit, statement)
+	}
+	block := func(body *phase1.BlockStatement) {
+		if body == nil {
+			return
+		}
+		for _, stmt := range body.Statements {
+			statement(stmt)
+		}
+	}
+	statement = func(stmt phase1.Statement) {
+		switch node := stmt.(type) {
+		case *phase1.LetStatement:
+			expression(node.Value)
+			let(node)
+		case *phase1.ReturnStatement:
+			expression(node.ReturnValue)
+		case *phase1.ExpressionStatement:
+			expression(node.Expression)
+		case *phase1.WhileStatement:
+			expression(node.Condition)
+			block(node.Body)
+		case *phase1.ForStatement:
+			if loop != nil {
+				loop(node)
+				return
+			}
+			if node.Initializer != nil {
+				statement(node.Initializer)
+			}
+			expression(node.Condition)
+			block(node.Body)
+			expression(node.Update)
+		case *phase1.BlockStatement:
+			block(node)
+		}
+	}
+	for _, stmt := range statements {
+		statement(stmt)
+	}
+}
+
+// staticClosure は何も捕捉しない関数のクロージャのレコードのラベルを返す
+// レコードはコードのアドレスだけを持ち、関数ごとに1つをデータセクションに置く
+func (cg *CodeGenerator) staticClosure(label string) string {
+	closure := ".Lclosure_" + label
+	if !containsString(cg.staticClosures, label) {
+		cg.staticClosures = append(cg.staticClosures, label)
+	}
+	return closure
+}
+
+// emitStaticClosures は何も捕捉しない関数のクロージャのレコードを出力する
+func (cg *CodeGenerator) emitStaticClosures() {
+	if len(cg.staticClosures) == 0 {
+		return
+	}
+	cg.emit("")
+	cg.emit(cg.target.DataSection)
+	for _, label := range cg.staticClosures {
+		cg.emit(".p2align 3")
+		cg.emitf(".Lclosure_%s:", label)
+		cg.emitf("    .quad %s", label)
+	}
+}
+
+// generateClosure は関数リテラルのクロージャのレコードを作り、そのアドレスを置いた仮想レジスタを返す
+// 捕捉する変数がなければ静的なレコードを使い、あればヒープに確保してセルのアドレスを並べる
+func (cg *CodeGenerator) generateClosure(label string, info *closureInfo) (vreg, error) {
+	if len(info.captures) == 0 {
+		return cg.address(cg.staticClosure(label)), nil
+	}
+
+	record := cg.allocate(8*(len(info.captures)+1), objectRecord)
+	cg.store(record, 0, 0, cg.address(label))
+	for i, symbol := range info.captures {
+		cell, ok := cg.cellAddress(symbol)
+		if !ok {
+			return 0, fmt.Errorf("undefined variable: %s", symbol.Name)
+		}
+		cg.store(record, 0, int64(8*(i+1)), cell)
+	}
+	cg.comment("closure %s(%s)", label, strings.Join(symbolNames(info.captures), ", "))
+	return record, nil
+}
+
+// allocateCells は関数（またはfor文）の入口で、内側の関数に捕捉される変数のセルを確保する
+// 引数はその値で初期化し（letで定義される変数は確保時の0のまま）、変数の仮想レジスタにはセルのアドレスを置く
+func (cg *CodeGenerator) allocateCells(symbols []*Symbol) {
+	for _, symbol := range symbols {
+		cell := cg.allocate(8, objectRecord)
+		if param, isParameter := cg.variables[symbol.Name]; isParameter && cg.scopeNames[symbol.Name] {
+			cg.store(cell, 0, 0, param)
+		}
+		cg.variables[symbol.Name] = cell
+		cg.cells[symbol] = cell
+		cg.comment("cell %s", symbol.Name)
+	}
+}
+
+// hasVariable は名前が現在の関数の変数または捕捉した変数かどうかを返す
+func (cg *CodeGenerator) hasVariable(name string) bool {
+	if _, ok := cg.variables[name]; ok {
+		return true
+	}
+	for symbol := range cg.captured {
+		if symbol.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cellAddress はセルに置かれた変数のセルのアドレスを置いた仮想レジスタを返す
+func (cg *CodeGenerator) cellAddress(symbol *Symbol) (vreg, bool) {
+	if index, ok := cg.captured[symbol]; ok {
+		return cg.load(cg.closure, 0, int64(8*(index+1))), true
+	}
+	cell, ok := cg.cells[symbol]
+	return cell, ok
+}
+
+// loadVariable は識別子が指す変数の値を置いた仮想レジスタを返す（変数が見つからない場合はfalseを返す）
+// セルに置かれていない変数は、変数の仮想レジスタそのものを返す
+func (cg *CodeGenerator) loadVariable(ident *phase1.Identifier) (vreg, bool) {
+	if cell, ok := cg.cellAddress(cg.closures.symbols[ident]); ok {
+		return cg.load(cell, 0, 0), true
+	}
+	value, ok := cg.variables[ident.Value]
+	return value, ok
+}
+
+// storeVariable は識別子が指す変数に値を書き込む（変数が見つからない場合はfalseを返す）
+func (cg *CodeGenerator) storeVariable(ident *phase1.Identifier, value vreg) bool {
+	if cell, ok := cg.cellAddress(cg.closures.symbols[ident]); ok {
+		cg.store(cell, 0, 0, value)
+		return true
+	}
+	variable, ok := cg.variables[ident.Value]
+	if ok {
+		cg.move(variable, value)
+	}
+	return ok
+}
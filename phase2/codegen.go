@@ -8,31 +8,98 @@ import (
 )
 
 // CodeGenerator はASTからx86_64アセンブリコードを生成する
+// 関数ごとに式を仮想レジスタの中間表現に変換し（ir.go）、レジスタを割り当ててから出力する
 type CodeGenerator struct {
 	output       strings.Builder
 	labelCounter int
-	stackOffset  int
-	variables    map[string]int // 変数名とスタックオフセットのマッピング
-	loopContext  *LoopContext   // 現在のループコンテキスト
+	code         *irFunction       // 中間表現に変換中の関数
+	variables    map[string]vreg   // 変数名と、値（セルに置く変数ではセルのアドレス）を置く仮想レジスタのマッピング
+	scopeNames   map[string]bool   // 現在のスコープ（関数の本体またはfor文）で定義した変数
+	named        map[vreg]bool     // 変数に使っている仮想レジスタ（代入で値が変わりうる）
+	loopContext  *LoopContext      // 現在のループコンテキスト
+	target       *Target           // 出力先のプラットフォーム
+	types        *TypeChecker      // 式の静的な型（putsの出力方法や文字列の演算の選択に使う）
+	stringPool   []string          // 文字列定数（出現順）
+	stringLabels map[string]string // 文字列定数の内容とラベルのマッピング
+	runtimeUsed  map[string]bool   // プログラムが使うランタイムのルーチン
+
+	functions      *functionScope                     // 現在のスコープで関数の定義として参照できる名前
+	functionLabels map[*phase1.FunctionLiteral]string // 関数リテラルとそのシンボル名
+	symbolNames    map[string]bool                    // 使用済みの関数のシンボル名
+	pending        []*pendingFunction                 // 本体をまだ生成していない関数
+
+	closures       *captureAnalysis // 各関数が捕捉する変数とセルに置く変数
+	cells          map[*Symbol]vreg // 現在の関数で確保したセルと、そのアドレスを置いた仮想レジスタ
+	captured       map[*Symbol]int  // 現在の関数が捕捉した変数とクロージャのレコードでの位置
+	closure        vreg             // 現在の関数が受け取ったクロージャのレコード
+	staticClosures []string         // 静的なクロージャのレコードを持つ関数のシンボル名
 }
 
-// NewCodeGenerator は新しいコード生成器を作成する
+// NewCodeGenerator は実行中のOSに対応するターゲット向けの新しいコード生成器を作成する
 func NewCodeGenerator() *CodeGenerator {
+	return NewCodeGeneratorForTarget(DefaultTarget())
+}
+
+// NewCodeGeneratorForTarget は指定したターゲット向けの新しいコード生成器を作成する
+func NewCodeGeneratorForTarget(target *Target) *CodeGenerator {
 	return &CodeGenerator{
 		labelCounter: 0,
-		stackOffset:  0,
-		variables:    make(map[string]int),
+		variables:    make(map[string]vreg),
+		scopeNames:   make(map[string]bool),
+		named:        make(map[vreg]bool),
+		target:       target,
+		stringLabels: make(map[string]string),
+		runtimeUsed:  make(map[string]bool),
+
+		functionLabels: make(map[*phase1.FunctionLiteral]string),
+		symbolNames:    make(map[string]bool),
+
+		closures: newCaptureAnalysis(),
+		cells:    make(map[*Symbol]vreg),
 	}
 }
 
+// Target はコード生成器の出力先のターゲットを返す
+func (cg *CodeGenerator) Target() *Target {
+	return cg.target
+}
+
 // Generate はプログラム全体のアセンブリコードを生成する
 func (cg *CodeGenerator) Generate(program *phase1.Program) (string, error) {
+	// 式の型を推論する（命令は推論した型で選ぶため、型エラーのあるプログラムはコンパイルしない）
+	cg.types = NewTypeChecker()
+	cg.types.CheckProgram(program)
+	for _, d := range cg.types.Diagnostics() {
+		if d.Severity == phase1.SeverityError {
+			return "", fmt.Errorf("cannot compile: program has type errors: %s", d)
+		}
+	}
+	cg.closures = analyzeCaptures(program)
+
 	// アセンブリのプリアンブル
 	cg.emitHeader()
 
-	// 各文を処理
-	for _, stmt := range program.Statements {
-		if err := cg.generateStatement(stmt); err != nil {
+	// 各文を処理（相互再帰のため、let束縛された関数の名前を先に宣言する）
+	err := cg.generateFrame(cg.target.Symbol("main"), func() error {
+		cg.allocateCells(cg.closures.function(nil).boxed)
+		cg.functions = cg.declareFunctions(nil, program.Statements)
+		for _, stmt := range program.Statements {
+			if _, err := cg.generateStatement(stmt); err != nil {
+				return err
+			}
+		}
+		cg.add(&instruction{op: opReturn, a: cg.constant(0)}) // 戻り値を0に設定
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// 関数の本体（生成中に見つかった関数リテラルも順に生成する）
+	for len(cg.pending) > 0 {
+		fn := cg.pending[0]
+		cg.pending = cg.pending[1:]
+		if err := cg.generateFunction(fn); err != nil {
 			return "", err
 		}
 	}
@@ -43,26 +110,30 @@ func (cg *CodeGenerator) Generate(program *phase1.Program) (string, error) {
 	return cg.output.String(), nil
 }
 
-// emitHeader はアセンブリファイルのヘッダーを出力する
+// emitHeader はアセンブリファイルのヘッダーを出力する（mainのプロローグはレジスタの割り当てが決まってから出力する）
 func (cg *CodeGenerator) emitHeader() {
+	main := cg.target.Symbol("main")
+
 	cg.emit("# pug compiler generated assembly")
-	cg.emit(".section __DATA,__data")
+	cg.emitf("# target: %s", cg.target.Description)
+	cg.emit(cg.target.DataSection)
 	cg.emit("")
-	cg.emit(".section __TEXT,__text,regular,pure_instructions")
-	cg.emit(".globl _main")
+	cg.emit(cg.target.TextSection)
+	cg.emitf(".globl %s", main)
 	cg.emit("")
-	cg.emit("_main:")
-	cg.emit("    pushq %rbp")      // フレームポインタを保存
-	cg.emit("    movq %rsp, %rbp") // 新しいフレームポインタを設定
-	cg.emit("    subq $256, %rsp") // ローカル変数用のスタック領域を確保
+	cg.emitf("%s:", main)
 }
 
-// emitFooter はアセンブリファイルのフッターを出力する
+// emitFooter はアセンブリファイルのフッター（ランタイム・文字列定数など）を出力する
 func (cg *CodeGenerator) emitFooter() {
-	cg.emit("    movq $0, %rax")   // 戻り値を0に設定
-	cg.emit("    movq %rbp, %rsp") // スタックポインタを復元
-	cg.emit("    popq %rbp")       // フレームポインタを復元
-	cg.emit("    ret")             // 関数から戻る
+	cg.emitRuntime()
+	cg.emitStaticClosures()
+	cg.emitStringPool()
+
+	for _, directive := range cg.target.Trailer {
+		cg.emit("")
+		cg.emit(directive)
+	}
 }
 
 // emit は1行のアセンブリコードを出力する
@@ -82,85 +153,113 @@ func (cg *CodeGenerator) generateLabel(prefix string) string {
 	return label
 }
 
-// generateStatement は文のアセンブリコードを生成する
-func (cg *CodeGenerator) generateStatement(stmt phase1.Statement) error {
+// valueOf は文の値の仮想レジスタを返す（値を持たない文は0とする）
+func (cg *CodeGenerator) valueOf(value vreg) vreg {
+	if value == 0 {
+		return cg.constant(0)
+	}
+	return value
+}
+
+// generateStatement は文の中間表現を生成し、文の値（ブロックの最後の文の値になる）の仮想レジスタを返す
+// 値を持たない文は0を返す
+func (cg *CodeGenerator) generateStatement(stmt phase1.Statement) (vreg, error) {
 	switch node := stmt.(type) {
 	case *phase1.LetStatement:
 		return cg.generateLetStatement(node)
 	case *phase1.ReturnStatement:
-		return cg.generateReturnStatement(node)
+		return 0, cg.generateReturnStatement(node)
 	case *phase1.ExpressionStatement:
-		return cg.generateExpressionStatement(node)
+		// 文として置いたif式は値を持たない分岐があってもよい
+		if ifExpr, ok := node.Expression.(*phase1.IfExpression); ok {
+			return cg.generateIf(ifExpr, false)
+		}
+		return cg.generateExpression(node.Expression)
 	case *phase1.WhileStatement:
-		return cg.generateWhileStatement(node)
+		return 0, cg.generateWhileStatement(node)
 	case *phase1.ForStatement:
-		return cg.generateForStatement(node)
+		return 0, cg.generateForStatement(node)
 	case *phase1.BreakStatement:
-		return cg.generateBreakStatement(node)
+		return 0, cg.generateBreakStatement(node)
 	case *phase1.ContinueStatement:
-		return cg.generateContinueStatement(node)
+		return 0, cg.generateContinueStatement(node)
 	case *phase1.BlockStatement:
 		return cg.generateBlockStatement(node)
 	default:
-		return fmt.Errorf("unsupported statement type: %T", stmt)
+		return 0, fmt.Errorf("unsupported statement type: %T", stmt)
 	}
 }
 
-// generateLetStatement はlet文のアセンブリコードを生成する
-func (cg *CodeGenerator) generateLetStatement(stmt *phase1.LetStatement) error {
-	// 値を計算してRAXに格納
-	if err := cg.generateExpression(stmt.Value); err != nil {
-		return err
+// generateLetStatement はlet文の中間表現を生成する
+func (cg *CodeGenerator) generateLetStatement(stmt *phase1.LetStatement) (vreg, error) {
+	// ブロックの中でlet束縛された関数も、自身の本体から名前で参照できるようにする
+	if fn, ok := stmt.Value.(*phase1.FunctionLiteral); ok {
+		label := cg.functionLabel(fn, stmt.Name.Value)
+		if cg.closures.direct[fn] {
+			cg.functions.names[stmt.Name.Value] = label
+		}
 	}
 
-	// 変数をスタックに保存
-	cg.stackOffset += 8
-	cg.variables[stmt.Name.Value] = cg.stackOffset
-	cg.emitf("    movq %%rax, -%d(%%rbp)", cg.stackOffset)
-	cg.emitf("    # let %s = ...", stmt.Name.Value)
+	// 値を計算する
+	value, err := cg.generateExpression(stmt.Value)
+	if err != nil {
+		return 0, err
+	}
 
-	return nil
+	// 内側の関数に捕捉される変数は、関数（またはfor文）の入口で確保したセルに保存する
+	if cell, ok := cg.cellAddress(cg.closures.symbols[stmt.Name]); ok {
+		cg.store(cell, 0, 0, value)
+		cg.comment("let %s = ...", stmt.Name.Value)
+		return value, nil
+	}
+
+	// 変数の仮想レジスタに保存
+	cg.move(cg.defineVariable(stmt.Name.Value), value)
+	cg.comment("let %s = ...", stmt.Name.Value)
+
+	return value, nil
 }
 
-// generateReturnStatement はreturn文のアセンブリコードを生成する
+// generateReturnStatement はreturn文の中間表現を生成する
 func (cg *CodeGenerator) generateReturnStatement(stmt *phase1.ReturnStatement) error {
+	var value vreg
 	if stmt.ReturnValue != nil {
-		// 戻り値を計算してRAXに格納
-		if err := cg.generateExpression(stmt.ReturnValue); err != nil {
+		// 戻り値を計算する
+		v, err := cg.generateExpression(stmt.ReturnValue)
+		if err != nil {
 			return err
 		}
+		value = v
 	} else {
 		// 戻り値がない場合は0を返す
-		cg.emit("    movq $0, %rax")
+		value = cg.constant(0)
 	}
 
 	// 関数から戻る
-	cg.emit("    movq %rbp, %rsp")
-	cg.emit("    popq %rbp")
-	cg.emit("    ret")
+	cg.add(&instruction{op: opReturn, a: value})
 
 	return nil
 }
 
-// generateExpressionStatement は式文のアセンブリコードを生成する
-func (cg *CodeGenerator) generateExpressionStatement(stmt *phase1.ExpressionStatement) error {
-	return cg.generateExpression(stmt.Expression)
-}
-
-// generateExpression は式のアセンブリコードを生成する
-// 結果はRAXレジスタに格納される
-func (cg *CodeGenerator) generateExpression(expr phase1.Expression) error {
+// generateExpression は式の中間表現を生成し、結果を置いた仮想レジスタを返す
+// 変数の参照は変数の仮想レジスタをそのまま返すため、後で代入される可能性がある場合は generateOperands でコピーする
+func (cg *CodeGenerator) generateExpression(expr phase1.Expression) (vreg, error) {
 	switch node := expr.(type) {
 	case *phase1.IntegerLiteral:
-		return cg.generateIntegerLiteral(node)
+		return cg.constant(node.Value), nil
 	case *phase1.Boolean:
-		return cg.generateBoolean(node)
+		if node.Value {
+			return cg.constant(1), nil // true = 1
+		}
+		return cg.constant(0), nil // false = 0
 	case *phase1.Identifier:
 		return cg.generateIdentifier(node)
 	case *phase1.InfixExpression:
 		return cg.generateInfixExpression(node)
 	case *phase1.PrefixExpression:
 		return cg.generatePrefixExpression(node)
+	case *phase1.AssignExpression:
+		return cg.generateAssignExpression(node)
 	case *phase1.CallExpression:
 		return cg.generateCallExpression(node)
 	case *phase1.IfExpression:
@@ -169,183 +268,266 @@ func (cg *CodeGenerator) generateExpression(expr phase1.Expression) error {
 		return cg.generateFunctionLiteral(node)
 	case *phase1.StringLiteral:
 		return cg.generateStringLiteral(node)
+	case *phase1.ArrayLiteral:
+		return cg.generateArrayLiteral(node)
+	case *phase1.IndexExpression:
+		return cg.generateIndexExpression(node)
 	default:
-		return fmt.Errorf("unsupported expression type: %T", expr)
+		return 0, fmt.Errorf("unsupported expression type: %T", expr)
 	}
 }
 
-// generateIntegerLiteral は整数リテラルのアセンブリコードを生成する
-func (cg *CodeGenerator) generateIntegerLiteral(node *phase1.IntegerLiteral) error {
-	cg.emitf("    movq $%d, %%rax", node.Value)
-	return nil
+// generateOperands は式を左から順に評価し、結果の仮想レジスタを返す
+// 変数の値が後の式の代入で変わる場合に備えて、その場合だけ変数の仮想レジスタをコピーしておく
+func (cg *CodeGenerator) generateOperands(exprs ...phase1.Expression) ([]vreg, error) {
+	values := make([]vreg, len(exprs))
+	for i, expr := range exprs {
+		value, err := cg.generateExpression(expr)
+		if err != nil {
+			return nil, err
+		}
+		if cg.named[value] && mayAssign(exprs[i+1:]) {
+			value = cg.copyOf(value)
+		}
+		values[i] = value
+	}
+	return values, nil
 }
 
-// generateBoolean はブール値リテラルのアセンブリコードを生成する
-func (cg *CodeGenerator) generateBoolean(node *phase1.Boolean) error {
-	if node.Value {
-		cg.emit("    movq $1, %rax") // true = 1
-	} else {
-		cg.emit("    movq $0, %rax") // false = 0
+// mayAssign は式の評価が変数に代入しうるかどうかを返す
+// 代入式と、文（let文を含む）を持つif式を含む場合に真とする（関数リテラルの本体は現在の関数の変数に代入しない）
+func mayAssign(exprs []phase1.Expression) bool {
+	found := false
+	for _, expr := range exprs {
+		forEachSubexpression(expr, func(e phase1.Expression) {
+			switch e.(type) {
+			case *phase1.AssignExpression, *phase1.IfExpression:
+				found = true
+			}
+		}, func(phase1.Statement) {})
 	}
-	return nil
+	return found
 }
 
-// generateStringLiteral は文字列リテラルのアセンブリコードを生成する
-func (cg *CodeGenerator) generateStringLiteral(node *phase1.StringLiteral) error {
-	// 文字列ラベルを生成
-	strLabel := cg.generateLabel("str")
-
-	// データセクションに文字列を追加（簡易実装）
-	// 実際の実装では、データセクションを管理する必要がある
-	cg.emitf("    leaq %s(%%rip), %%rax", strLabel)
-
-	// TODO: データセクションに文字列データを追加する処理
-	// 現在は簡易的にアドレスのみを返す
-	return nil
+// generateStringLiteral は文字列リテラルの中間表現を生成する
+// 文字列の内容は定数プールに置き、そのアドレスを結果とする
+func (cg *CodeGenerator) generateStringLiteral(node *phase1.StringLiteral) (vreg, error) {
+	return cg.address(cg.stringConstant(node.Value)), nil
 }
 
-// generateIdentifier は識別子（変数）のアセンブリコードを生成する
-func (cg *CodeGenerator) generateIdentifier(node *phase1.Identifier) error {
-	offset, exists := cg.variables[node.Value]
-	if !exists {
-		return fmt.Errorf("undefined variable: %s", node.Value)
+// generateIdentifier は識別子（変数）の中間表現を生成する
+// 現在の関数の変数でも捕捉した変数でもなければ、let束縛された関数の名前としてそのクロージャを得る
+func (cg *CodeGenerator) generateIdentifier(node *phase1.Identifier) (vreg, error) {
+	if value, ok := cg.loadVariable(node); ok {
+		return value, nil
 	}
-
-	cg.emitf("    movq -%d(%%rbp), %%rax", offset)
-	cg.emitf("    # load variable %s", node.Value)
-
-	return nil
+	if label, ok := cg.functions.lookup(node.Value); ok {
+		return cg.address(cg.staticClosure(label)), nil
+	}
+	return 0, fmt.Errorf("undefined variable: %s", node.Value)
 }
 
-// generateInfixExpression は中置式のアセンブリコードを生成する
-func (cg *CodeGenerator) generateInfixExpression(node *phase1.InfixExpression) error {
-	// 左辺を評価してRAXに格納
-	if err := cg.generateExpression(node.Left); err != nil {
-		return err
+// generateAssignExpression は代入式の中間表現を生成する
+// 代入した値が式の結果となる
+func (cg *CodeGenerator) generateAssignExpression(node *phase1.AssignExpression) (vreg, error) {
+	if index, ok := node.Target.(*phase1.IndexExpression); ok {
+		return cg.generateIndexAssignment(node, index)
+	}
+	ident, ok := node.Target.(*phase1.Identifier)
+	if !ok {
+		return 0, fmt.Errorf("unsupported assignment target: %T", node.Target)
 	}
 
-	// RAXをスタックに退避
-	cg.emit("    pushq %rax")
+	if !cg.hasVariable(ident.Value) {
+		return 0, fmt.Errorf("assignment to undeclared variable: %s", ident.Value)
+	}
 
-	// 右辺を評価してRAXに格納
-	if err := cg.generateExpression(node.Right); err != nil {
-		return err
+	var value vreg
+	var err error
+	if op := node.BinaryOperator(); op != "" {
+		// 複合代入は「x = x op value」として計算する
+		value, err = cg.generateInfixExpression(&phase1.InfixExpression{
+			Token:    node.Token,
+			Left:     node.Target,
+			Operator: op,
+			Right:    node.Value,
+		})
+	} else {
+		value, err = cg.generateExpression(node.Value)
 	}
+	if err != nil {
+		return 0, err
+	}
+
+	cg.storeVariable(ident, value)
+	cg.comment("%s %s ...", ident.Value, node.Operator)
+
+	return value, nil
+}
 
-	// 右辺の値をRBXに移動
-	cg.emit("    movq %rax, %rbx")
+// generateInfixExpression は中置式の中間表現を生成する
+func (cg *CodeGenerator) generateInfixExpression(node *phase1.InfixExpression) (vreg, error) {
+	// 論理演算子は右辺を評価する前に分岐する必要がある
+	if node.Operator == "&&" || node.Operator == "||" {
+		return cg.generateLogicalExpression(node)
+	}
 
-	// 左辺の値をスタックから復元
-	cg.emit("    popq %rax")
+	// 文字列の演算はランタイムのルーチンで行う
+	if err := cg.checkOperandTypes(node); err != nil {
+		return 0, err
+	}
+	leftString, rightString := cg.isStringExpression(node.Left), cg.isStringExpression(node.Right)
+	if leftString != rightString {
+		return 0, fmt.Errorf("unsupported operand types for %s: string and non-string", node.Operator)
+	}
+	if leftString {
+		return cg.generateStringOperation(node)
+	}
 
-	// 演算子に応じてコードを生成
+	// 演算子に応じて命令を選ぶ
+	in := &instruction{}
 	switch node.Operator {
 	case "+":
-		cg.emit("    addq %rbx, %rax")
+		in.op, in.cond = opBinary, "add"
 	case "-":
-		cg.emit("    subq %rbx, %rax")
+		in.op, in.cond = opBinary, "sub"
 	case "*":
-		cg.emit("    imulq %rbx, %rax")
-	case "/":
-		// 符号拡張してRDX:RAXに展開
-		cg.emit("    cqto")
-		// RBXで除算（商はRAX、余りはRDX）
-		cg.emit("    idivq %rbx")
-	case "%":
-		// 符号拡張してRDX:RAXに展開
-		cg.emit("    cqto")
-		// RBXで除算（商はRAX、余りはRDX）
-		cg.emit("    idivq %rbx")
-		// 余りをRAXに移動
-		cg.emit("    movq %rdx, %rax")
-	case "==":
-		return cg.generateComparison(node.Operator)
-	case "!=":
-		return cg.generateComparison(node.Operator)
-	case "<":
-		return cg.generateComparison(node.Operator)
-	case ">":
-		return cg.generateComparison(node.Operator)
-	case "<=":
-		return cg.generateComparison(node.Operator)
-	case ">=":
-		return cg.generateComparison(node.Operator)
+		in.op, in.cond = opBinary, "imul"
+	case "/", "%":
+		// 除算は右辺をレジスタに置く必要がある
+		in.op, in.cond, in.sym = opDivide, node.Operator, divisionErrors[node.Operator]
+		cg.useRuntime(in.sym)
+	case "==", "!=", "<", ">", "<=", ">=":
+		in.op, in.cond = opCompare, comparisonConditions[node.Operator]
 	default:
-		return fmt.Errorf("unsupported infix operator: %s", node.Operator)
+		return 0, fmt.Errorf("unsupported infix operator: %s", node.Operator)
 	}
 
-	return nil
+	if err := cg.generateBinaryOperands(in, node.Left, node.Right); err != nil {
+		return 0, err
+	}
+	in.dst = cg.newVreg()
+	cg.add(in)
+	return in.dst, nil
 }
 
-// generateComparison は比較演算のアセンブリコードを生成する
-func (cg *CodeGenerator) generateComparison(operator string) error {
-	// cmpq命令で比較
-	cg.emit("    cmpq %rbx, %rax")
+// generateBinaryOperands は二項演算・比較・分岐の左辺と右辺を評価して命令に設定する
+// 右辺が32ビットに収まる整数リテラルなら即値として命令に埋め込む（除算を除く）
+func (cg *CodeGenerator) generateBinaryOperands(in *instruction, left, right phase1.Expression) error {
+	if literal, ok := right.(*phase1.IntegerLiteral); ok && in.op != opDivide && fitsImmediate(literal.Value) {
+		value, err := cg.generateExpression(left)
+		if err != nil {
+			return err
+		}
+		in.a, in.imm = value, literal.Value
+		return nil
+	}
+	operands, err := cg.generateOperands(left, right)
+	if err != nil {
+		return err
+	}
+	in.a, in.b = operands[0], operands[1]
+	return nil
+}
 
-	trueLabel := cg.generateLabel("true")
-	endLabel := cg.generateLabel("end")
+// generateLogicalExpression は論理演算子（&&、||）の短絡評価の中間表現を生成する
+// 結果は0または1とする
+func (cg *CodeGenerator) generateLogicalExpression(node *phase1.InfixExpression) (vreg, error) {
+	shortLabel := cg.generateLabel("logic_short")
+	endLabel := cg.generateLabel("logic_end")
+	result := cg.newVreg()
+
+	// &&は左辺が偽なら偽、||は左辺が真なら真で確定する
+	shortJump, shortValue, fallValue := cg.branchIfZero, int64(0), int64(1)
+	if node.Operator == "||" {
+		shortJump, shortValue, fallValue = cg.branchIfNonZero, 1, 0
+	}
 
-	// 条件に応じてジャンプ
-	switch operator {
-	case "==":
-		cg.emitf("    je %s", trueLabel)
-	case "!=":
-		cg.emitf("    jne %s", trueLabel)
-	case "<":
-		cg.emitf("    jl %s", trueLabel)
-	case ">":
-		cg.emitf("    jg %s", trueLabel)
-	case "<=":
-		cg.emitf("    jle %s", trueLabel)
-	case ">=":
-		cg.emitf("    jge %s", trueLabel)
+	// 左辺を評価
+	left, err := cg.generateExpression(node.Left)
+	if err != nil {
+		return 0, err
 	}
+	shortJump(left, shortLabel)
 
-	// 偽の場合：0をRAXに設定
-	cg.emit("    movq $0, %rax")
-	cg.emitf("    jmp %s", endLabel)
+	// 右辺を評価
+	right, err := cg.generateExpression(node.Right)
+	if err != nil {
+		return 0, err
+	}
+	shortJump(right, shortLabel)
 
-	// 真の場合：1をRAXに設定
-	cg.emitf("%s:", trueLabel)
-	cg.emit("    movq $1, %rax")
+	// どちらでも確定しなかった場合
+	cg.add(&instruction{op: opConst, dst: result, imm: fallValue})
+	cg.jump(endLabel)
 
-	cg.emitf("%s:", endLabel)
+	// 短絡した場合
+	cg.label(shortLabel)
+	cg.add(&instruction{op: opConst, dst: result, imm: shortValue})
 
-	return nil
+	cg.label(endLabel)
+	return result, nil
 }
 
-// generatePrefixExpression は前置式のアセンブリコードを生成する
-func (cg *CodeGenerator) generatePrefixExpression(node *phase1.PrefixExpression) error {
+// generatePrefixExpression は前置式の中間表現を生成する
+func (cg *CodeGenerator) generatePrefixExpression(node *phase1.PrefixExpression) (vreg, error) {
 	// 右辺を評価
-	if err := cg.generateExpression(node.Right); err != nil {
-		return err
+	value, err := cg.generateExpression(node.Right)
+	if err != nil {
+		return 0, err
 	}
 
+	result := cg.newVreg()
 	switch node.Operator {
 	case "-":
-		cg.emit("    negq %rax")
+		cg.add(&instruction{op: opNegate, dst: result, a: value})
 	case "!":
-		// ブール値の反転
-		cg.emit("    testq %rax, %rax")
-		trueLabel := cg.generateLabel("true")
-		endLabel := cg.generateLabel("end")
-
-		cg.emitf("    jz %s", trueLabel) // 0の場合は真（!false = true）
-		cg.emit("    movq $0, %rax")     // 非0の場合は偽（!true = false）
-		cg.emitf("    jmp %s", endLabel)
+		// ブール値の反転（0なら真、それ以外なら偽）
+		cg.add(&instruction{op: opCompare, dst: result, a: value, cond: "e", imm: 0})
+	default:
+		return 0, fmt.Errorf("unsupported prefix operator: %s", node.Operator)
+	}
 
-		cg.emitf("%s:", trueLabel)
-		cg.emit("    movq $1, %rax")
+	return result, nil
+}
 
-		cg.emitf("%s:", endLabel)
-	default:
-		return fmt.Errorf("unsupported prefix operator: %s", node.Operator)
+// generateCondition は条件式が偽のときにラベルにジャンプする中間表現を生成する
+// 整数の比較は値を作らずに比較と分岐だけにし、否定は分岐の条件を反転する
+func (cg *CodeGenerator) generateCondition(condition phase1.Expression, falseLabel string) error {
+	switch node := condition.(type) {
+	case *phase1.InfixExpression:
+		cc, isComparison := comparisonConditions[node.Operator]
+		if isComparison && !cg.isStringExpression(node.Left) && !cg.isStringExpression(node.Right) {
+			if err := cg.checkOperandTypes(node); err != nil {
+				return err
+			}
+			in := &instruction{op: opBranch, cond: negatedConditions[cc], sym: falseLabel}
+			if err := cg.generateBinaryOperands(in, node.Left, node.Right); err != nil {
+				return err
+			}
+			cg.add(in)
+			return nil
+		}
+	case *phase1.PrefixExpression:
+		if node.Operator == "!" {
+			value, err := cg.generateExpression(node.Right)
+			if err != nil {
+				return err
+			}
+			cg.branchIfNonZero(value, falseLabel)
+			return nil
+		}
 	}
 
+	value, err := cg.generateExpression(condition)
+	if err != nil {
+		return err
+	}
+	cg.branchIfZero(value, falseLabel)
 	return nil
 }
 
-// generateWhileStatement はwhile文のアセンブリコードを生成する
+// generateWhileStatement はwhile文の中間表現を生成する
 func (cg *CodeGenerator) generateWhileStatement(stmt *phase1.WhileStatement) error {
 	startLabel := cg.generateLabel("while_start")
 	endLabel := cg.generateLabel("while_end")
@@ -353,29 +535,28 @@ func (cg *CodeGenerator) generateWhileStatement(stmt *phase1.WhileStatement) err
 	// ループコンテキストを設定
 	oldContext := cg.loopContext
 	cg.loopContext = NewLoopContext(endLabel, startLabel, oldContext)
+	if stmt.Label != nil {
+		cg.loopContext.Name = stmt.Label.Value
+	}
 
 	// ループ開始ラベル
-	cg.emitf("%s:", startLabel)
+	cg.label(startLabel)
 
-	// 条件式を評価
-	if err := cg.generateExpression(stmt.Condition); err != nil {
+	// 条件が偽の場合はループを抜ける
+	if err := cg.generateCondition(stmt.Condition, endLabel); err != nil {
 		return err
 	}
 
-	// 条件が偽の場合はループを抜ける
-	cg.emit("    testq %rax, %rax")
-	cg.emitf("    jz %s", endLabel)
-
 	// ループ本体を実行
-	if err := cg.generateBlockStatement(stmt.Body); err != nil {
+	if _, err := cg.generateBlockStatement(stmt.Body); err != nil {
 		return err
 	}
 
 	// ループ開始に戻る
-	cg.emitf("    jmp %s", startLabel)
+	cg.jump(startLabel)
 
 	// ループ終了ラベル
-	cg.emitf("%s:", endLabel)
+	cg.label(endLabel)
 
 	// ループコンテキストを復元
 	cg.loopContext = oldContext
@@ -383,7 +564,7 @@ func (cg *CodeGenerator) generateWhileStatement(stmt *phase1.WhileStatement) err
 	return nil
 }
 
-// generateForStatement はfor文のアセンブリコードを生成する
+// generateForStatement はfor文の中間表現を生成する
 func (cg *CodeGenerator) generateForStatement(stmt *phase1.ForStatement) error {
 	startLabel := cg.generateLabel("for_start")
 	continueLabel := cg.generateLabel("for_continue")
@@ -392,47 +573,52 @@ func (cg *CodeGenerator) generateForStatement(stmt *phase1.ForStatement) error {
 	// ループコンテキストを設定
 	oldContext := cg.loopContext
 	cg.loopContext = NewLoopContext(endLabel, continueLabel, oldContext)
+	if stmt.Label != nil {
+		cg.loopContext.Name = stmt.Label.Value
+	}
+
+	// for文で定義した変数はループの外からは見えない
+	scope := cg.enterScope()
+	defer cg.leaveScope(scope)
+	cg.allocateCells(cg.closures.loops[stmt])
 
 	// 初期化文を実行
 	if stmt.Initializer != nil {
-		if err := cg.generateStatement(stmt.Initializer); err != nil {
+		if _, err := cg.generateStatement(stmt.Initializer); err != nil {
 			return err
 		}
 	}
 
 	// ループ開始ラベル
-	cg.emitf("%s:", startLabel)
+	cg.label(startLabel)
 
-	// 条件式がある場合は評価
+	// 条件式がある場合は評価し、偽の場合はループを抜ける
 	if stmt.Condition != nil {
-		if err := cg.generateExpression(stmt.Condition); err != nil {
+		if err := cg.generateCondition(stmt.Condition, endLabel); err != nil {
 			return err
 		}
-		// 条件が偽の場合はループを抜ける
-		cg.emit("    testq %rax, %rax")
-		cg.emitf("    jz %s", endLabel)
 	}
 
 	// ループ本体を実行
-	if err := cg.generateBlockStatement(stmt.Body); err != nil {
+	if _, err := cg.generateBlockStatement(stmt.Body); err != nil {
 		return err
 	}
 
 	// continueラベル（continue文がここにジャンプ）
-	cg.emitf("%s:", continueLabel)
+	cg.label(continueLabel)
 
 	// 更新式を実行
 	if stmt.Update != nil {
-		if err := cg.generateExpression(stmt.Update); err != nil {
+		if _, err := cg.generateExpression(stmt.Update); err != nil {
 			return err
 		}
 	}
 
 	// ループ開始に戻る
-	cg.emitf("    jmp %s", startLabel)
+	cg.jump(startLabel)
 
 	// ループ終了ラベル
-	cg.emitf("%s:", endLabel)
+	cg.label(endLabel)
 
 	// ループコンテキストを復元
 	cg.loopContext = oldContext
@@ -440,141 +626,131 @@ func (cg *CodeGenerator) generateForStatement(stmt *phase1.ForStatement) error {
 	return nil
 }
 
-// generateBreakStatement はbreak文のアセンブリコードを生成する
-func (cg *CodeGenerator) generateBreakStatement(_ *phase1.BreakStatement) error {
+// generateBreakStatement はbreak文の中間表現を生成する
+func (cg *CodeGenerator) generateBreakStatement(stmt *phase1.BreakStatement) error {
 	if cg.loopContext == nil {
 		return fmt.Errorf("break statement outside of loop")
 	}
 
-	// 現在のループの終了ラベルにジャンプ
-	cg.emitf("    jmp %s", cg.loopContext.BreakLabel)
+	// 対象のループ（ラベルがなければ現在のループ）の終了ラベルにジャンプ
+	target, err := cg.findLoop(stmt.Label)
+	if err != nil {
+		return err
+	}
+	cg.jump(target.BreakLabel)
 	return nil
 }
 
-// generateContinueStatement はcontinue文のアセンブリコードを生成する
-func (cg *CodeGenerator) generateContinueStatement(_ *phase1.ContinueStatement) error {
+// generateContinueStatement はcontinue文の中間表現を生成する
+func (cg *CodeGenerator) generateContinueStatement(stmt *phase1.ContinueStatement) error {
 	if cg.loopContext == nil {
 		return fmt.Errorf("continue statement outside of loop")
 	}
 
-	// 現在のループの継続ラベルにジャンプ
-	cg.emitf("    jmp %s", cg.loopContext.ContinueLabel)
-	return nil
-}
-
-// generateBlockStatement はブロック文のアセンブリコードを生成する
-func (cg *CodeGenerator) generateBlockStatement(stmt *phase1.BlockStatement) error {
-	for _, s := range stmt.Statements {
-		if err := cg.generateStatement(s); err != nil {
-			return err
-		}
+	// 対象のループ（ラベルがなければ現在のループ）の継続ラベルにジャンプ
+	target, err := cg.findLoop(stmt.Label)
+	if err != nil {
+		return err
 	}
+	cg.jump(target.ContinueLabel)
 	return nil
 }
 
-// generateCallExpression は関数呼び出しのアセンブリコードを生成する
-func (cg *CodeGenerator) generateCallExpression(node *phase1.CallExpression) error {
-	// 引数を逆順でスタックにプッシュ（x86_64 calling convention）
-	for i := len(node.Arguments) - 1; i >= 0; i-- {
-		if err := cg.generateExpression(node.Arguments[i]); err != nil {
-			return err
-		}
-		cg.emit("    pushq %rax")
+// findLoop はbreak/continueのラベルが指すループコンテキストをループコンテキストの連鎖から探す
+func (cg *CodeGenerator) findLoop(label *phase1.Identifier) (*LoopContext, error) {
+	if label == nil {
+		return cg.loopContext, nil
 	}
+	target := cg.loopContext.Find(label.Value)
+	if target == nil {
+		return nil, fmt.Errorf("undefined label: %s", label.Value)
+	}
+	return target, nil
+}
 
-	// 関数の識別子を取得
-	if ident, ok := node.Function.(*phase1.Identifier); ok {
-		// 関数呼び出し
-		cg.emitf("    call %s", ident.Value)
-
-		// スタックポインタを調整（引数の分だけpop）
-		if len(node.Arguments) > 0 {
-			cg.emitf("    addq $%d, %%rsp", len(node.Arguments)*8)
+// generateBlockStatement はブロック文の中間表現を生成し、最後の文の値を返す
+func (cg *CodeGenerator) generateBlockStatement(stmt *phase1.BlockStatement) (vreg, error) {
+	var value vreg
+	for _, s := range stmt.Statements {
+		v, err := cg.generateStatement(s)
+		if err != nil {
+			return 0, err
 		}
-	} else {
-		return fmt.Errorf("unsupported function call type: %T", node.Function)
+		value = v
 	}
-
-	return nil
+	return value, nil
 }
 
-// generateIfExpression はif式のアセンブリコードを生成する
-func (cg *CodeGenerator) generateIfExpression(node *phase1.IfExpression) error {
-	// 条件式を評価
-	if err := cg.generateExpression(node.Condition); err != nil {
-		return err
-	}
+// generateIfExpression は値として使うif式の中間表現を生成する
+// インタプリタのnullに当たる値がないため、値を持たずに抜ける分岐（elseのない場合を含む）があればエラーとする
+func (cg *CodeGenerator) generateIfExpression(node *phase1.IfExpression) (vreg, error) {
+	return cg.generateIf(node, true)
+}
 
+// generateIf はif式の中間表現を生成し、結果の仮想レジスタを返す
+// 値を持たずに抜ける分岐がある場合、needValueが偽なら0（値を持たない）を返し、真ならエラーとする
+func (cg *CodeGenerator) generateIf(node *phase1.IfExpression, needValue bool) (vreg, error) {
 	// ラベルを生成
 	elseLabel := cg.generateLabel("else")
 	endLabel := cg.generateLabel("end")
+	result := cg.newVreg()
+	hasValue := true
+
+	// branch は分岐の値を結果に移す（return文などで抜ける分岐は値を持たなくてよい）
+	branch := func(value vreg, block *phase1.BlockStatement) error {
+		switch {
+		case value != 0:
+			cg.move(result, value)
+		case block != nil && !blockCompletesNormally(block):
+		case needValue:
+			return fmt.Errorf("if expression without a value on every branch is not supported in compiled code: %s", node.String())
+		default:
+			hasValue = false
+		}
+		return nil
+	}
 
 	// 条件をテストして分岐
-	cg.emit("    testq %rax, %rax")
-	cg.emitf("    je %s", elseLabel)
+	if err := cg.generateCondition(node.Condition, elseLabel); err != nil {
+		return 0, err
+	}
 
 	// then節を生成
-	if err := cg.generateBlockStatement(node.Consequence); err != nil {
-		return err
+	value, err := cg.generateBlockStatement(node.Consequence)
+	if err != nil {
+		return 0, err
 	}
-	cg.emitf("    jmp %s", endLabel)
-
-	// else節
-	cg.emitf("%s:", elseLabel)
-	if node.Alternative != nil {
-		if err := cg.generateBlockStatement(node.Alternative); err != nil {
-			return err
+	if err := branch(value, node.Consequence); err != nil {
+		return 0, err
+	}
+	cg.jump(endLabel)
+
+	// else節（else if はこの位置に後続のif式として展開する）
+	cg.label(elseLabel)
+	switch {
+	case node.ElseIf != nil:
+		value, err = cg.generateIf(node.ElseIf, needValue)
+		if err != nil {
+			return 0, err
 		}
-	} else {
-		// elseがない場合はfalse(0)を返す
-		cg.emit("    movq $0, %rax")
+		err = branch(value, nil)
+	case node.Alternative != nil:
+		value, err = cg.generateBlockStatement(node.Alternative)
+		if err != nil {
+			return 0, err
+		}
+		err = branch(value, node.Alternative)
+	default:
+		// elseがない場合、条件が偽なら値を持たない
+		err = branch(0, nil)
 	}
-
-	cg.emitf("%s:", endLabel)
-	return nil
-}
-
-// generateFunctionLiteral は関数リテラルのアセンブリコードを生成する
-func (cg *CodeGenerator) generateFunctionLiteral(node *phase1.FunctionLiteral) error {
-	// 関数名を生成
-	funcName := cg.generateLabel("func")
-
-	// 関数のアドレスをRAXに格納
-	cg.emitf("    leaq %s(%%rip), %%rax", funcName)
-
-	// 関数の終わりまでジャンプ
-	endLabel := cg.generateLabel("func_end")
-	cg.emitf("    jmp %s", endLabel)
-
-	// 関数定義の開始
-	cg.emitf("%s:", funcName)
-	cg.emit("    pushq %rbp")
-	cg.emit("    movq %rsp, %rbp")
-
-	// パラメータをローカル変数に設定
-	for i, param := range node.Parameters {
-		// スタックから引数を取得してローカル変数として保存
-		offset := (i+1)*8 + 16 // rbp + return address + parameters
-		cg.variables[param.Value] = offset
+	if err != nil {
+		return 0, err
 	}
 
-	// 関数本体を生成
-	if err := cg.generateBlockStatement(node.Body); err != nil {
-		return err
+	cg.label(endLabel)
+	if !hasValue {
+		return 0, nil
 	}
-
-	// 関数のエピローグ
-	cg.emit("    popq %rbp")
-	cg.emit("    ret")
-
-	// 関数定義の終わり
-	cg.emitf("%s:", endLabel)
-	return nil
-}
-
-// AssembleAndLink は生成されたアセンブリコードをアセンブルしてリンクする
-func (cg *CodeGenerator) AssembleAndLink(asmCode, outputFile string) error {
-	// 実装は後で追加予定
-	// アセンブラ（as）とリンカ（ld）を使用して実行可能ファイルを生成
-	return fmt.Errorf("assembleAndLink not implemented yet")
+	return result, nil
 }
@@ -1,6 +1,8 @@
 package phase2
 
 import (
+	"maps"
+
 	"github.com/nyasuto/pug/phase1"
 )
 
@@ -8,8 +10,9 @@ import (
 type Symbol struct {
 	Name  string
 	Type  Type
-	Index int    // スタックオフセットまたはインデックス
-	Scope string // "local", "global", "function"
+	Index int         // スタックオフセットまたはインデックス
+	Scope string      // "local", "global", "function"
+	Span  phase1.Span // 定義された位置（位置を持たないシンボルはゼロ値）
 }
 
 // SymbolTable はスコープ管理を行うシンボルテーブル
@@ -65,6 +68,16 @@ func (s *SymbolTable) Resolve(name string) (*Symbol, bool) {
 	return nil, false
 }
 
+// ResolveScope はシンボルを解決し、そのシンボルが定義されているシンボルテーブルも返す
+func (s *SymbolTable) ResolveScope(name string) (*Symbol, *SymbolTable, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if symbol, ok := scope.store[name]; ok {
+			return symbol, scope, true
+		}
+	}
+	return nil, nil, false
+}
+
 // getScopeString はスコープレベルに応じた文字列を返す
 func (s *SymbolTable) getScopeString() string {
 	switch s.scopeLevel {
@@ -92,10 +105,23 @@ func (s *SymbolTable) GetParent() *SymbolTable {
 	return s.parent
 }
 
+// 制御フロー解析器が発行する診断のコード（Wで始まるものは警告）
+const (
+	CodeBreakOutsideLoop    = "E0301" // ループ外のbreak
+	CodeContinueOutsideLoop = "E0302" // ループ外のcontinue
+	CodeUnreachableCode     = "W0303" // 到達できない文
+	CodeUseBeforeDefinition = "E0304" // 全ての経路で定義より前にある変数の参照
+	CodeMaybeUninitialized  = "W0305" // 一部の経路でだけ定義済みの変数の参照
+	CodeShadowedVariable    = "W0306" // 外側のスコープの変数を隠す定義
+	CodeUndefinedLabel      = "E0307" // 囲んでいるループにないラベル
+	CodeRedefinedVariable   = "W0308" // 同じスコープの変数を上書きする再定義
+)
+
 // LoopContext はループコンテキストを管理する構造体
 type LoopContext struct {
 	BreakLabel    string
 	ContinueLabel string
+	Name          string // ソース上のループのラベル（outer: while ... のouter、省略時は空）
 	Parent        *LoopContext
 }
 
@@ -108,18 +134,28 @@ func NewLoopContext(breakLabel, continueLabel string, parent *LoopContext) *Loop
 	}
 }
 
+// Find はbreak/continueの対象となるループコンテキストを親をたどって探す
+// nameが空の場合は最も内側のループを返し、見つからない場合はnilを返す
+func (lc *LoopContext) Find(name string) *LoopContext {
+	for ctx := lc; ctx != nil; ctx = ctx.Parent {
+		if name == "" || ctx.Name == name {
+			return ctx
+		}
+	}
+	return nil
+}
+
 // ControlFlowAnalyzer は制御フロー解析を行う
 type ControlFlowAnalyzer struct {
 	symbolTable *SymbolTable
 	loopContext *LoopContext
-	errors      []string
+	diagnostics []*phase1.Diagnostic
 }
 
 // NewControlFlowAnalyzer は新しい制御フロー解析器を作成する
 func NewControlFlowAnalyzer() *ControlFlowAnalyzer {
 	return &ControlFlowAnalyzer{
 		symbolTable: NewSymbolTable(),
-		errors:      []string{},
 	}
 }
 
@@ -159,12 +195,53 @@ func (cfa *ControlFlowAnalyzer) GetSymbolTable() *SymbolTable {
 
 // GetErrors はエラーリストを返す
 func (cfa *ControlFlowAnalyzer) GetErrors() []string {
-	return cfa.errors
+	errors := []string{}
+	for _, d := range cfa.diagnostics {
+		if d.Severity == phase1.SeverityError {
+			errors = append(errors, d.Message)
+		}
+	}
+	return errors
 }
 
-// AddError はエラーを追加する
+// AddError は位置情報を持たないエラーを追加する
 func (cfa *ControlFlowAnalyzer) AddError(message string) {
-	cfa.errors = append(cfa.errors, message)
+	cfa.AddDiagnostic(phase1.NewDiagnostic(phase1.SeverityError, "", phase1.Span{}, message))
+}
+
+// AddDiagnostic は診断を追加する
+func (cfa *ControlFlowAnalyzer) AddDiagnostic(d *phase1.Diagnostic) {
+	cfa.diagnostics = append(cfa.diagnostics, d)
+}
+
+// Diagnostics は制御フロー解析で検出した診断を返す
+func (cfa *ControlFlowAnalyzer) Diagnostics() []*phase1.Diagnostic {
+	return cfa.diagnostics
+}
+
+// ValidateProgram はプログラム全体の制御フローの妥当性を検証する
+func (cfa *ControlFlowAnalyzer) ValidateProgram(program *phase1.Program) {
+	cfa.validateStatements(program.Statements)
+	cfa.checkDefiniteAssignment(program)
+}
+
+// validateStatements は文の並びを検証し、return・break・continue文などの後にある到達できない文を警告する
+// 警告は到達できない最初の文に対してだけ行う
+func (cfa *ControlFlowAnalyzer) validateStatements(statements []phase1.Statement) {
+	var terminator phase1.Statement
+	reported := false
+	for _, stmt := range statements {
+		if terminator != nil && !reported {
+			cfa.AddDiagnostic(phase1.Warningf(CodeUnreachableCode, phase1.NodeSpan(stmt), "unreachable statement").
+				WithLabel("unreachable statement").
+				WithSecondary(phase1.NodeSpan(terminator), "any code following this is unreachable"))
+			reported = true
+		}
+		cfa.ValidateControlFlow(stmt)
+		if terminator == nil && !completesNormally(stmt) {
+			terminator = stmt
+		}
+	}
 }
 
 // ValidateControlFlow は制御フローの妥当性を検証する
@@ -172,17 +249,146 @@ func (cfa *ControlFlowAnalyzer) ValidateControlFlow(stmt phase1.Statement) {
 	switch node := stmt.(type) {
 	case *phase1.BreakStatement:
 		if cfa.loopContext == nil {
-			cfa.AddError("break statement outside of loop")
+			cfa.AddDiagnostic(phase1.Errorf(CodeBreakOutsideLoop, phase1.NodeSpan(node),
+				"break statement outside of loop").
+				WithLabel("cannot `break` outside of a loop"))
+			return
 		}
+		cfa.validateLabel(node.Label)
 	case *phase1.ContinueStatement:
 		if cfa.loopContext == nil {
-			cfa.AddError("continue statement outside of loop")
+			cfa.AddDiagnostic(phase1.Errorf(CodeContinueOutsideLoop, phase1.NodeSpan(node),
+				"continue statement outside of loop").
+				WithLabel("cannot `continue` outside of a loop"))
+			return
 		}
+		cfa.validateLabel(node.Label)
 	case *phase1.BlockStatement:
+		// インタプリタと同じく、ブロックは外側と同じスコープを使う（スコープを作るのは関数本体とfor文だけ）
+		cfa.validateStatements(node.Statements)
+	case *phase1.WhileStatement:
+		cfa.validateExpression(node.Condition)
+		cfa.validateLoopBody(node.Label, node.Body)
+	case *phase1.ForStatement:
 		cfa.EnterScope()
-		for _, s := range node.Statements {
-			cfa.ValidateControlFlow(s)
+		if node.Initializer != nil {
+			cfa.ValidateControlFlow(node.Initializer)
 		}
+		cfa.validateExpression(node.Condition)
+		cfa.validateExpression(node.Update)
+		cfa.validateLoopBody(node.Label, node.Body)
 		cfa.ExitScope()
+	case *phase1.LetStatement:
+		cfa.validateExpression(node.Value)
+		cfa.defineVariable(node.Name)
+	case *phase1.ReturnStatement:
+		cfa.validateExpression(node.ReturnValue)
+	case *phase1.ExpressionStatement:
+		cfa.validateExpression(node.Expression)
+	}
+}
+
+// defineVariable は変数を現在のスコープに定義し、外側のスコープの変数を隠す場合は警告する
+// 同じスコープでの再定義は新しい変数を作らず元の変数を上書きするため、再定義として警告する
+func (cfa *ControlFlowAnalyzer) defineVariable(name *phase1.Identifier) {
+	if previous, ok := cfa.symbolTable.store[name.Value]; ok {
+		cfa.AddDiagnostic(phase1.Warningf(CodeRedefinedVariable, phase1.NodeSpan(name),
+			"`%s` is already defined in this scope", name.Value).
+			WithLabel("this definition overwrites `"+name.Value+"`").
+			WithSecondary(previous.Span, "`"+name.Value+"` is first defined here"))
+		return
+	} else if outer, ok := cfa.symbolTable.Resolve(name.Value); ok {
+		cfa.AddDiagnostic(phase1.Warningf(CodeShadowedVariable, phase1.NodeSpan(name),
+			"`%s` shadows a variable in an outer scope", name.Value).
+			WithLabel("this definition shadows the outer `"+name.Value+"`").
+			WithSecondary(outer.Span, "`"+name.Value+"` is first defined here"))
+	}
+	cfa.symbolTable.Define(name.Value, nil).Span = phase1.NodeSpan(name)
+}
+
+// validateLoopBody はループ本体をループコンテキストの中で検証する
+func (cfa *ControlFlowAnalyzer) validateLoopBody(label *phase1.Identifier, body *phase1.BlockStatement) {
+	if body == nil {
+		return
+	}
+	cfa.EnterLoop("", "")
+	if label != nil {
+		cfa.loopContext.Name = label.Value
+	}
+	cfa.ValidateControlFlow(body)
+	cfa.ExitLoop()
+}
+
+// validateLabel はbreak/continueのラベルが囲んでいるループのいずれかに付いていることを検証する
+func (cfa *ControlFlowAnalyzer) validateLabel(label *phase1.Identifier) {
+	if label == nil || cfa.loopContext.Find(label.Value) != nil {
+		return
+	}
+	cfa.AddDiagnostic(phase1.Errorf(CodeUndefinedLabel, phase1.NodeSpan(label),
+		"undefined label `%s`", label.Value).
+		WithLabel("no enclosing loop is labeled `" + label.Value + "`"))
+}
+
+// validateExpression は式に含まれるブロック（if式の分岐や関数本体）を検証する
+func (cfa *ControlFlowAnalyzer) validateExpression(expr phase1.Expression) {
+	switch node := expr.(type) {
+	case *phase1.IfExpression:
+		cfa.validateExpression(node.Condition)
+		// 分岐はどれか1つしか実行されないため、別の分岐での定義は再定義とみなさない
+		before := maps.Clone(cfa.symbolTable.store)
+		if node.Consequence != nil {
+			cfa.ValidateControlFlow(node.Consequence)
+		}
+		defined := cfa.symbolTable.store
+		cfa.symbolTable.store = maps.Clone(before)
+		if node.ElseIf != nil {
+			cfa.validateExpression(node.ElseIf)
+		}
+		if node.Alternative != nil {
+			cfa.ValidateControlFlow(node.Alternative)
+		}
+		for name, symbol := range defined {
+			if _, ok := before[name]; !ok {
+				cfa.symbolTable.store[name] = symbol
+			}
+		}
+	case *phase1.FunctionLiteral:
+		// 関数本体は外側のループとは独立しているため、ループコンテキストを切り離す
+		// パラメータは関数本体と同じスコープに定義する（パラメータ自身は隠す警告の対象にしない）
+		outer := cfa.loopContext
+		cfa.loopContext = nil
+		cfa.EnterScope()
+		for _, param := range node.Parameters {
+			cfa.symbolTable.Define(param.Value, nil).Span = phase1.NodeSpan(param)
+		}
+		if node.Body != nil {
+			cfa.validateStatements(node.Body.Statements)
+		}
+		cfa.ExitScope()
+		cfa.loopContext = outer
+	case *phase1.CallExpression:
+		cfa.validateExpression(node.Function)
+		for _, arg := range node.Arguments {
+			cfa.validateExpression(arg)
+		}
+	case *phase1.InfixExpression:
+		cfa.validateExpression(node.Left)
+		cfa.validateExpression(node.Right)
+	case *phase1.PrefixExpression:
+		cfa.validateExpression(node.Right)
+	case *phase1.AssignExpression:
+		cfa.validateExpression(node.Value)
+	case *phase1.ArrayLiteral:
+		for _, element := range node.Elements {
+			cfa.validateExpression(element)
+		}
+	case *phase1.HashLiteral:
+		for _, pair := range node.Pairs {
+			cfa.validateExpression(pair.Key)
+			cfa.validateExpression(pair.Value)
+		}
+	case *phase1.IndexExpression:
+		cfa.validateExpression(node.Left)
+		cfa.validateExpression(node.Index)
 	}
 }
@@ -18,8 +18,8 @@ func TestControlStructures_Integration(t *testing.T) {
 			input: "while (true) { let x = 1; }",
 			expected: []string{
 				".Lwhile_start",
-				"testq %rax, %rax",
-				"jz .Lwhile_end",
+				"testq %rcx, %rcx",
+				"je .Lwhile_end",
 				"jmp .Lwhile_start",
 				".Lwhile_end",
 			},
@@ -204,7 +204,7 @@ func TestControlStructures_ComplexExample(t *testing.T) {
 
 	// Verify complex control flow patterns
 	expectedPatterns := []string{
-		"_main:",             // Function entry
+		"main:",              // Function entry
 		".Lfor_start",        // For loop
 		".Lfor_continue",     // Continue label
 		"jmp .Lfor_continue", // Continue statement
@@ -1,6 +1,11 @@
 package phase2
 
 import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/nyasuto/pug/phase1"
@@ -226,6 +231,19 @@ func TestSymbolTableNesting(t *testing.T) {
 			}
 		}
 	}
+
+	// 定義されているテーブルの解決
+	for name, expected := range map[string]*SymbolTable{"a": global, "c": local1, "f": local2} {
+		_, table, ok := local2.ResolveScope(name)
+		if !ok {
+			t.Errorf("name %s not resolvable", name)
+		} else if table != expected {
+			t.Errorf("name %s resolved at level %d, expected level %d", name, table.GetScopeLevel(), expected.GetScopeLevel())
+		}
+	}
+	if _, _, ok := local1.ResolveScope("e"); ok {
+		t.Errorf("expected not to resolve 'e' from the outer table")
+	}
 }
 
 func TestControlFlowAnalyzer(t *testing.T) {
@@ -358,16 +376,36 @@ func TestControlFlowAnalyzerUtilities(t *testing.T) {
 }
 
 func TestCodeGeneratorUtilities(t *testing.T) {
-	cg := NewCodeGenerator()
+	// 実行中のOSと異なるターゲットの実行可能ファイルは作れない
+	other := TargetDarwin
+	if DefaultTarget() == TargetDarwin {
+		other = TargetLinux
+	}
+	err := NewCodeGeneratorForTarget(other).AssembleAndLink("", "output")
+	if err == nil || !strings.Contains(err.Error(), "cannot build an executable for target "+other.Name) {
+		t.Errorf("expected cross-target error, got %v", err)
+	}
 
-	// Test AssembleAndLink placeholder
-	err := cg.AssembleAndLink("test", "output")
-	if err == nil {
-		t.Errorf("expected error from AssembleAndLink placeholder")
+	if _, err := exec.LookPath(DefaultTarget().Assembler[0]); err != nil {
+		t.Skipf("assembler not available: %v", err)
 	}
-	if err.Error() != "assembleAndLink not implemented yet" {
+
+	// アセンブラが受け付けないコードはassemble段階のBuildErrorになる
+	output := filepath.Join(t.TempDir(), "output")
+	err = NewCodeGenerator().AssembleAndLink("not an instruction\n", output)
+	var buildErr *BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("expected *BuildError, got %T (%v)", err, err)
+	}
+	if buildErr.Step != "assemble" {
+		t.Errorf("expected assemble step, got %q", buildErr.Step)
+	}
+	if !strings.HasPrefix(err.Error(), "assembler failed: ") {
 		t.Errorf("unexpected error message: %s", err.Error())
 	}
+	if _, statErr := os.Stat(output); statErr == nil {
+		t.Errorf("no executable should be produced when assembling fails")
+	}
 }
 
 // ControlParser は制御構造のパースをテストするためのヘルパー
@@ -450,3 +488,208 @@ func (cp *ControlParser) parseContinueStatement() *phase1.ContinueStatement {
 		Token: phase1.Token{Type: phase1.CONTINUE, Literal: "continue"},
 	}
 }
+
+func TestControlFlowAnalyzer_ValidateProgram(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string // 期待する診断の位置（行:列）
+	}{
+		{"while内のbreak", "while (true) { break; }", nil},
+		{"for内のcontinue", "for (let i = 0; i < 3; i += 1) { continue; }", nil},
+		{"if内のbreak", "while (true) { if (true) { break; } }", nil},
+		{"トップレベルのbreak", "let x = 1;\nbreak;", []string{"2:1"}},
+		{"ループ外のif内のcontinue", "if (true) { continue; }", []string{"1:13"}},
+		{"ループ内の関数本体のbreak", "while (true) { let f = fn() { break; }; }", []string{"1:31"}},
+		{"ラベル付きのbreak", "outer: while (true) { for (;;) { break outer; } }", nil},
+		{"ラベル付きのcontinue", "outer: for (;;) { while (true) { continue outer; } }", nil},
+		{"未定義のラベル", "outer: while (true) {\n  break inner;\n}", []string{"2:9"}},
+		{"ループを抜けた後のラベル", "outer: while (true) { break; }\nwhile (true) { continue outer; }", []string{"2:25"}},
+		{"配列リテラル内の関数本体のbreak", "let fs = [fn() { break; }];", []string{"1:18"}},
+		{"ハッシュリテラル内の関数本体のcontinue", "let h = {\"k\": fn() { continue; }};", []string{"1:22"}},
+		{"ハッシュのキー内の関数本体のbreak", "let h = {fn() { break; }: 1};", []string{"1:17"}},
+		{"添字式内の関数本体のcontinue", "let x = [1][fn() { continue; }()];", []string{"1:20"}},
+		{"関数本体から外側のループのラベル", "outer: while (true) { let f = fn() { while (true) { break outer; } }; }", []string{"1:59"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := phase1.NewParser(phase1.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			analyzer := NewControlFlowAnalyzer()
+			analyzer.ValidateProgram(program)
+
+			diagnostics := analyzer.Diagnostics()
+			if len(diagnostics) != len(tt.expected) {
+				t.Fatalf("expected %d diagnostics, got %d: %v", len(tt.expected), len(diagnostics), diagnostics)
+			}
+			for i, d := range diagnostics {
+				if d.Span.String() != tt.expected[i] {
+					t.Errorf("diagnostic %d at %s, want %s", i, d.Span, tt.expected[i])
+				}
+				if d.Code != CodeBreakOutsideLoop && d.Code != CodeContinueOutsideLoop && d.Code != CodeUndefinedLabel {
+					t.Errorf("unexpected code %s", d.Code)
+				}
+			}
+		})
+	}
+}
+
+// TestControlFlowAnalyzer_UnreachableCode はreturn・break・continueの後の到達できない文の警告をテストする
+func TestControlFlowAnalyzer_UnreachableCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string // 期待する警告の位置（行:列）
+	}{
+		{"return後の文", "fn() {\n  return 1;\n  let x = 2;\n  x\n}", []string{"3:3"}},
+		{"break後の文", "while (true) {\n  break;\n  puts(1);\n}", []string{"3:3"}},
+		{"continue後の文", "for (let i = 0; i < 3; i += 1) {\n  continue;\n  puts(i);\n}", []string{"3:3"}},
+		{"全ての分岐がreturnするif-else", "fn(a) {\n  if (a) { return 1; } else { return 2; }\n  3\n}", []string{"3:3"}},
+		{"else ifの連鎖", "fn(a) {\n  if (a == 1) { return 1; } else if (a == 2) { return 2; } else { return 3; }\n  4\n}", []string{"3:3"}},
+		{"elseのないif", "fn(a) {\n  if (a) { return 1; }\n  2\n}", nil},
+		{"breakのない無限ループ", "fn() {\n  while (true) { puts(1); }\n  2\n}", []string{"3:3"}},
+		{"breakのある無限ループ", "fn() {\n  while (true) { if (true) { break; } }\n  2\n}", nil},
+		{"内側のループのbreak", "fn() {\n  for (;;) { while (true) { break; } }\n  2\n}", []string{"3:3"}},
+		{"条件のあるループ", "fn(n) {\n  while (n > 0) { return n; }\n  0\n}", nil},
+		{"警告は最初の文だけ", "fn() {\n  return 1;\n  puts(1);\n  puts(2);\n}", []string{"3:3"}},
+		{"内側のループからのラベル付きのbreak", "fn() {\n  outer: while (true) { while (true) { break outer; } }\n  2\n}", nil},
+		{"外側のループを抜けない内側のbreak", "fn() {\n  outer: while (true) { inner: while (true) { break inner; } }\n  2\n}", []string{"3:3"}},
+		{"外側のループへのbreakの後の文", "outer: while (true) {\n  while (true) {\n    break outer;\n  }\n  puts(1);\n}", []string{"5:3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := phase1.NewParser(phase1.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			analyzer := NewControlFlowAnalyzer()
+			analyzer.ValidateProgram(program)
+
+			diagnostics := analyzer.Diagnostics()
+			if len(diagnostics) != len(tt.expected) {
+				t.Fatalf("expected %d diagnostics, got %d: %v", len(tt.expected), len(diagnostics), diagnostics)
+			}
+			for i, d := range diagnostics {
+				if d.Span.String() != tt.expected[i] {
+					t.Errorf("diagnostic %d at %s, want %s", i, d.Span, tt.expected[i])
+				}
+				if d.Code != CodeUnreachableCode || d.Severity != phase1.SeverityWarning {
+					t.Errorf("expected an unreachable code warning, got %s", d)
+				}
+			}
+			if len(analyzer.GetErrors()) != 0 {
+				t.Errorf("warnings should not be reported as errors: %v", analyzer.GetErrors())
+			}
+		})
+	}
+}
+
+// TestControlFlowAnalyzer_DefiniteAssignment は定義前の変数の参照の検出をテストする
+func TestControlFlowAnalyzer_DefiniteAssignment(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string // 期待する診断のコードと位置（コード@行:列）
+	}{
+		{"定義後の参照", "let x = 1;\nputs(x);", nil},
+		{"トップレベルの定義前の参照", "puts(x);\nlet x = 1;", []string{"E0304@1:6"}},
+		{"初期化式での自己参照", "let x = x + 1;", []string{"E0304@1:9"}},
+		{"ifの分岐での定義", "if (c) { let x = 1; }\nputs(x);", []string{"W0305@2:6"}},
+		{"if-elseの両方の分岐での定義", "if (c) { let x = 1; } else { let x = 2; }\nputs(x);", nil},
+		{"returnする分岐", "fn(c) {\n  if (c) { let x = 1; } else { return 0; }\n  x\n}", nil},
+		{"whileの本体での定義", "while (c) { let x = 1; }\nputs(x);", []string{"W0305@2:6"}},
+		{"次の反復での参照", "while (c) {\n  puts(x);\n  let x = 1;\n}", []string{"W0305@2:8"}},
+		{"関数本体の定義前の参照", "let f = fn() {\n  let y = x;\n  let x = 1;\n  x + y\n};", []string{"E0304@2:11"}},
+		{"パラメータ", "let f = fn(x) { let x = x + 1; x };", []string{"W0308@1:21"}},
+		{"関数本体からの後方の関数の参照", "let f = fn() { g() };\nlet g = fn() { 1 };\nf();", nil},
+		{"定義前の関数の呼び出し", "let a = g();\nlet g = fn() { 1 };", []string{"E0304@1:9"}},
+		{"forのスコープ", "for (let i = 0; i < 3; i += 1) { let t = i; }\nlet t = 0;\nputs(t);", nil},
+		{"外側の変数と同名の内側の変数", "let f = fn() {\n  puts(n);\n  let n = 1;\n};\nlet n = 0;", []string{"E0304@2:8"}},
+		{"未定義の識別子は型検査で報告", "puts(undefined_var);", nil},
+		{"参照ごとではなく変数ごとに報告", "puts(x);\nputs(x);\nlet x = 1;", []string{"E0304@1:6"}},
+		{"到達できない参照", "fn() {\n  return 1;\n  puts(x);\n  let x = 1;\n}", []string{"W0303@3:3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := phase1.NewParser(phase1.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			analyzer := NewControlFlowAnalyzer()
+			analyzer.ValidateProgram(program)
+
+			var got []string
+			for _, d := range analyzer.Diagnostics() {
+				got = append(got, d.Code+"@"+d.Span.String())
+			}
+			if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("expected diagnostics %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestControlFlowAnalyzer_Shadowing は外側のスコープの変数を隠す定義の警告をテストする
+func TestControlFlowAnalyzer_Shadowing(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expected  []string // 期待する警告のコードと位置（コード@行:列）
+		secondary []string // 最初の定義の位置（行:列）
+	}{
+		{"関数本体での定義", "let x = 1;\nlet f = fn() { let x = 2; x };", []string{"W0306@2:20"}, []string{"1:5"}},
+		{"forの初期化文", "let i = 0;\nfor (let i = 0; i < 3; i += 1) { }", []string{"W0306@2:10"}, []string{"1:5"}},
+		{"同じスコープでの再定義", "let x = 1;\nlet x = 2;\nlet x = 3;", []string{"W0308@2:5", "W0308@3:5"}, []string{"1:5", "1:5"}},
+		{"ブロックは外側と同じスコープ", "let x = 1;\nif (true) { let x = 2; }", []string{"W0308@2:17"}, []string{"1:5"}},
+		{"whileの本体", "let x = 1;\nwhile (x < 3) { let x = x + 1; }", []string{"W0308@2:21"}, []string{"1:5"}},
+		{"forの本体はforと同じスコープ", "for (let i = 0; i < 3; i += 1) { let i = 5; }", []string{"W0308@1:38"}, []string{"1:10"}},
+		{"パラメータと同名の変数", "let f = fn(x) { let x = 2; x };", []string{"W0308@1:21"}, []string{"1:12"}},
+		{"外側の変数と同名のパラメータ", "let x = 1;\nlet f = fn(x) { x };", nil, nil},
+		{"別の分岐での定義", "if (true) { let x = 1; } else if (false) { let x = 2; } else { let x = 3; }", nil, nil},
+		{"分岐の後の再定義", "if (true) { let x = 1; } else { let x = 2; }\nlet x = 3;", []string{"W0308@2:5"}, []string{"1:17"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := phase1.NewParser(phase1.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			analyzer := NewControlFlowAnalyzer()
+			analyzer.ValidateProgram(program)
+
+			var got, secondary []string
+			for _, d := range analyzer.Diagnostics() {
+				if d.Code != CodeShadowedVariable && d.Code != CodeRedefinedVariable {
+					continue
+				}
+				if d.Severity != phase1.SeverityWarning {
+					t.Errorf("expected a warning, got %s", d)
+				}
+				got = append(got, d.Code+"@"+d.Span.String())
+				for _, label := range d.Labels {
+					secondary = append(secondary, label.Span.String())
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("expected warnings at %v, got %v", tt.expected, got)
+			}
+			if strings.Join(secondary, " ") != strings.Join(tt.secondary, " ") {
+				t.Errorf("expected outer definitions at %v, got %v", tt.secondary, secondary)
+			}
+		})
+	}
+}
@@ -79,7 +79,7 @@ func TestCodeGeneratorErrorPaths(t *testing.T) {
 		Token: phase1.Token{Type: phase1.IDENT, Literal: "undefined"},
 		Value: "undefined",
 	}
-	err := cg.generateIdentifier(ident)
+	_, err := cg.generateIdentifier(ident)
 	if err == nil {
 		t.Errorf("Expected error for undefined variable")
 	}
@@ -0,0 +1,399 @@
+package phase2
+
+import (
+	"github.com/nyasuto/pug/phase1"
+)
+
+// localVariable は関数（またはトップレベル）の中でletによって定義される変数
+// 実行時の環境と同じく、ifやwhileのブロックはスコープを作らず、関数本体とfor文だけがスコープを作る
+type localVariable struct {
+	index      int
+	name       string
+	definition *phase1.LetStatement // 最初に現れる定義（パラメータの場合はnil）
+	parameter  bool
+}
+
+// localScope は実行時のスコープ（関数本体またはfor文）に属する変数
+type localScope struct {
+	parent    *localScope
+	variables map[string]*localVariable
+}
+
+// variableResolver は関数本体の識別子を、その関数の中で定義される変数に対応付ける
+// 外側の関数やトップレベル、組み込み関数の名前はどの変数にも対応付けない
+type variableResolver struct {
+	variables []*localVariable
+	scopes    map[phase1.Node]*localScope // スコープを作るノード（関数本体・for文）とそのスコープ
+	reads     map[*phase1.Identifier]*localVariable
+	defines   map[*phase1.LetStatement]*localVariable
+}
+
+// resolveVariables は関数のパラメータと本体の文から変数を集め、参照と定義を対応付ける
+func resolveVariables(parameters []*phase1.Identifier, statements []phase1.Statement) *variableResolver {
+	r := &variableResolver{
+		scopes:  map[phase1.Node]*localScope{},
+		reads:   map[*phase1.Identifier]*localVariable{},
+		defines: map[*phase1.LetStatement]*localVariable{},
+	}
+
+	// 1回目の走査で各スコープの変数を集め、2回目の走査で参照を解決する
+	// 定義より前の参照も同じスコープの変数として解決するため、走査を分けている
+	root := &localScope{variables: map[string]*localVariable{}}
+	for _, param := range parameters {
+		r.declare(root, param.Value, nil)
+	}
+	r.declareStatements(root, statements)
+	r.resolveStatements(root, statements)
+	return r
+}
+
+// declare はスコープに変数を追加する（同じスコープで再定義された場合は最初の変数を使う）
+func (r *variableResolver) declare(scope *localScope, name string, let *phase1.LetStatement) {
+	if _, ok := scope.variables[name]; ok {
+		return
+	}
+	v := &localVariable{index: len(r.variables), name: name, definition: let, parameter: let == nil}
+	r.variables = append(r.variables, v)
+	scope.variables[name] = v
+}
+
+// definedNames はスコープを作る文の並びで定義される変数の名前を返す（for文と関数本体の中で定義される変数は含めない）
+func definedNames(statements []phase1.Statement) []string {
+	r := &variableResolver{scopes: map[phase1.Node]*localScope{}}
+	root := &localScope{variables: map[string]*localVariable{}}
+	r.declareStatements(root, statements)
+	names := make([]string, 0, len(root.variables))
+	for name := range root.variables {
+		names = append(names, name)
+	}
+	return names
+}
+
+// declareStatements は文の並びで定義される変数をスコープに追加する
+func (r *variableResolver) declareStatements(scope *localScope, statements []phase1.Statement) {
+	for _, stmt := range statements {
+		r.declareStatement(scope, stmt)
+	}
+}
+
+func (r *variableResolver) declareStatement(scope *localScope, stmt phase1.Statement) {
+	switch node := stmt.(type) {
+	case *phase1.LetStatement:
+		r.declare(scope, node.Name.Value, node)
+		r.declareExpression(scope, node.Value)
+	case *phase1.ExpressionStatement:
+		r.declareExpression(scope, node.Expression)
+	case *phase1.BlockStatement:
+		r.declareStatements(scope, node.Statements)
+	case *phase1.WhileStatement:
+		r.declareStatements(scope, node.Body.Statements)
+	case *phase1.ForStatement:
+		inner := &localScope{parent: scope, variables: map[string]*localVariable{}}
+		r.scopes[node] = inner
+		if node.Initializer != nil {
+			r.declareStatement(inner, node.Initializer)
+		}
+		r.declareStatements(inner, node.Body.Statements)
+	}
+}
+
+// declareExpression は式の中のif式の分岐で定義される変数をスコープに追加する
+func (r *variableResolver) declareExpression(scope *localScope, expr phase1.Expression) {
+	ifExpr, ok := expr.(*phase1.IfExpression)
+	if !ok {
+		return
+	}
+	r.declareStatements(scope, ifExpr.Consequence.Statements)
+	if ifExpr.ElseIf != nil {
+		r.declareExpression(scope, ifExpr.ElseIf)
+	}
+	if ifExpr.Alternative != nil {
+		r.declareStatements(scope, ifExpr.Alternative.Statements)
+	}
+}
+
+// lookup はスコープを内側から順にたどって変数を探す
+func (s *localScope) lookup(name string) *localVariable {
+	for scope := s; scope != nil; scope = scope.parent {
+		if v, ok := scope.variables[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// resolveStatements は文の並びに含まれる参照と定義を変数に対応付ける
+func (r *variableResolver) resolveStatements(scope *localScope, statements []phase1.Statement) {
+	for _, stmt := range statements {
+		r.resolveStatement(scope, stmt)
+	}
+}
+
+func (r *variableResolver) resolveStatement(scope *localScope, stmt phase1.Statement) {
+	switch node := stmt.(type) {
+	case *phase1.LetStatement:
+		r.defines[node] = scope.lookup(node.Name.Value)
+		r.resolveExpression(scope, node.Value)
+	case *phase1.ReturnStatement:
+		r.resolveExpression(scope, node.ReturnValue)
+	case *phase1.ExpressionStatement:
+		r.resolveExpression(scope, node.Expression)
+	case *phase1.BlockStatement:
+		r.resolveStatements(scope, node.Statements)
+	case *phase1.WhileStatement:
+		r.resolveExpression(scope, node.Condition)
+		r.resolveStatements(scope, node.Body.Statements)
+	case *phase1.ForStatement:
+		inner := r.scopes[node]
+		if node.Initializer != nil {
+			r.resolveStatement(inner, node.Initializer)
+		}
+		r.resolveExpression(inner, node.Condition)
+		r.resolveExpression(inner, node.Update)
+		r.resolveStatements(inner, node.Body.Statements)
+	}
+}
+
+// resolveExpression は式の中の識別子の参照を変数に対応付ける
+// 関数リテラルの本体は呼び出されたときに実行されるため、その関数のグラフで別に解析する
+func (r *variableResolver) resolveExpression(scope *localScope, expr phase1.Expression) {
+	forEachSubexpression(expr, func(e phase1.Expression) {
+		if ident, ok := e.(*phase1.Identifier); ok {
+			if v := scope.lookup(ident.Value); v != nil {
+				r.reads[ident] = v
+			}
+		}
+	}, func(stmt phase1.Statement) {
+		r.resolveStatement(scope, stmt)
+	})
+}
+
+// forEachSubexpression は式とその部分式を評価順にたどる
+// if式の分岐の文はvisitStatementに渡し、関数リテラルの中には入らない
+func forEachSubexpression(expr phase1.Expression, visit func(phase1.Expression), visitStatement func(phase1.Statement)) {
+	if expr == nil {
+		return
+	}
+	switch node := expr.(type) {
+	case *phase1.PrefixExpression:
+		forEachSubexpression(node.Right, visit, visitStatement)
+	case *phase1.InfixExpression:
+		forEachSubexpression(node.Left, visit, visitStatement)
+		forEachSubexpression(node.Right, visit, visitStatement)
+	case *phase1.AssignExpression:
+		forEachSubexpression(node.Value, visit, visitStatement)
+		forEachSubexpression(node.Target, visit, visitStatement)
+	case *phase1.CallExpression:
+		forEachSubexpression(node.Function, visit, visitStatement)
+		for _, arg := range node.Arguments {
+			forEachSubexpression(arg, visit, visitStatement)
+		}
+	case *phase1.ArrayLiteral:
+		for _, element := range node.Elements {
+			forEachSubexpression(element, visit, visitStatement)
+		}
+	case *phase1.HashLiteral:
+		for _, pair := range node.Pairs {
+			forEachSubexpression(pair.Key, visit, visitStatement)
+			forEachSubexpression(pair.Value, visit, visitStatement)
+		}
+	case *phase1.IndexExpression:
+		forEachSubexpression(node.Left, visit, visitStatement)
+		forEachSubexpression(node.Index, visit, visitStatement)
+	case *phase1.IfExpression:
+		forEachSubexpression(node.Condition, visit, visitStatement)
+		for _, stmt := range node.Consequence.Statements {
+			visitStatement(stmt)
+		}
+		if node.ElseIf != nil {
+			forEachSubexpression(node.ElseIf, visit, visitStatement)
+		}
+		if node.Alternative != nil {
+			for _, stmt := range node.Alternative.Statements {
+				visitStatement(stmt)
+			}
+		}
+	}
+	visit(expr)
+}
+
+// variableSet は変数の集合（localVariable.indexで引く）
+type variableSet []bool
+
+func newVariableSet(n int, value bool) variableSet {
+	set := make(variableSet, n)
+	for i := range set {
+		set[i] = value
+	}
+	return set
+}
+
+func (s variableSet) equals(other variableSet) bool {
+	for i := range s {
+		if s[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// definiteAssignment はグラフの各ブロックの入口で、変数が定義済みかどうかを表す
+// must は全ての経路で定義済みの変数、may は少なくとも1つの経路で定義済みの変数
+type definiteAssignment struct {
+	must map[*BasicBlock]variableSet
+	may  map[*BasicBlock]variableSet
+}
+
+// analyzeDefiniteAssignment はグラフ上で変数の定義を前向きに伝播させ、各ブロックの入口の状態を求める
+// 到達できないブロックは全ての変数を定義済みとして扱い、到達できない文の警告と重複して報告しない
+func analyzeDefiniteAssignment(g *CFG, r *variableResolver) *definiteAssignment {
+	n := len(r.variables)
+	result := &definiteAssignment{must: map[*BasicBlock]variableSet{}, may: map[*BasicBlock]variableSet{}}
+
+	entry := newVariableSet(n, false)
+	for _, v := range r.variables {
+		entry[v.index] = v.parameter
+	}
+
+	out := map[*BasicBlock][2]variableSet{}
+	for _, block := range g.Blocks {
+		result.must[block] = newVariableSet(n, true)
+		result.may[block] = newVariableSet(n, block != g.Entry && len(block.Predecessors) == 0)
+	}
+	result.must[g.Entry] = entry
+	result.may[g.Entry] = append(variableSet{}, entry...)
+
+	for changed := true; changed; {
+		changed = false
+		for _, block := range g.Blocks {
+			if block != g.Entry && len(block.Predecessors) > 0 {
+				must := newVariableSet(n, true)
+				may := newVariableSet(n, false)
+				for _, edge := range block.Predecessors {
+					predOut, ok := out[edge.From]
+					if !ok {
+						// まだ出口の状態が求まっていない先行ブロックは合流に影響させない
+						continue
+					}
+					for i := 0; i < n; i++ {
+						must[i] = must[i] && predOut[0][i]
+						may[i] = may[i] || predOut[1][i]
+					}
+				}
+				if !must.equals(result.must[block]) || !may.equals(result.may[block]) {
+					changed = true
+				}
+				result.must[block], result.may[block] = must, may
+			}
+
+			must := append(variableSet{}, result.must[block]...)
+			may := append(variableSet{}, result.may[block]...)
+			for _, node := range block.Nodes {
+				forEachDefinition(node, r, func(v *localVariable) {
+					must[v.index], may[v.index] = true, true
+				})
+			}
+			if prev, ok := out[block]; !ok || !prev[0].equals(must) || !prev[1].equals(may) {
+				changed = true
+			}
+			out[block] = [2]variableSet{must, may}
+		}
+	}
+	return result
+}
+
+// forEachDefinition はノードで定義される変数を評価順に通知する
+func forEachDefinition(node phase1.Node, r *variableResolver, define func(*localVariable)) {
+	forEachUse(node, r, func(*phase1.Identifier, *localVariable) {}, define)
+}
+
+// forEachUse はノードに含まれる変数の参照と定義を評価順に通知する
+// let文では初期化式の参照が先に、変数の定義が後に通知される
+func forEachUse(node phase1.Node, r *variableResolver, read func(*phase1.Identifier, *localVariable), define func(*localVariable)) {
+	var statement func(phase1.Statement)
+	var expression func(phase1.Expression)
+
+	expression = func(expr phase1.Expression) {
+		forEachSubexpression(expr, func(e phase1.Expression) {
+			if ident, ok := e.(*phase1.Identifier); ok {
+				if v, ok := r.reads[ident]; ok {
+					read(ident, v)
+				}
+			}
+		}, statement)
+	}
+	statement = func(stmt phase1.Statement) {
+		switch s := stmt.(type) {
+		case *phase1.LetStatement:
+			expression(s.Value)
+			if v := r.defines[s]; v != nil {
+				define(v)
+			}
+		case *phase1.ReturnStatement:
+			expression(s.ReturnValue)
+		case *phase1.ExpressionStatement:
+			expression(s.Expression)
+		case *phase1.BlockStatement:
+			for _, inner := range s.Statements {
+				statement(inner)
+			}
+		}
+	}
+
+	switch n := node.(type) {
+	case phase1.Statement:
+		statement(n)
+	case phase1.Expression:
+		expression(n)
+	}
+}
+
+// checkDefiniteAssignment はトップレベルと各関数について、定義前の変数の参照を検出する
+// 全ての経路で定義前の参照はエラー、一部の経路でだけ定義済みの参照は警告として、変数ごとに最初の参照だけを報告する
+func (cfa *ControlFlowAnalyzer) checkDefiniteAssignment(program *phase1.Program) {
+	for _, g := range BuildCFGs(program) {
+		var resolver *variableResolver
+		if g.Function != nil {
+			resolver = resolveVariables(g.Function.Parameters, g.Function.Body.Statements)
+		} else {
+			resolver = resolveVariables(nil, program.Statements)
+		}
+		if len(resolver.variables) == 0 {
+			continue
+		}
+
+		state := analyzeDefiniteAssignment(g, resolver)
+		reported := map[*localVariable]bool{}
+		for _, block := range g.Blocks {
+			must := append(variableSet{}, state.must[block]...)
+			may := append(variableSet{}, state.may[block]...)
+			for _, node := range block.Nodes {
+				forEachUse(node, resolver, func(ident *phase1.Identifier, v *localVariable) {
+					if must[v.index] || reported[v] {
+						return
+					}
+					reported[v] = true
+					cfa.AddDiagnostic(useBeforeDefinition(ident, v, may[v.index]))
+				}, func(v *localVariable) {
+					must[v.index], may[v.index] = true, true
+				})
+			}
+		}
+	}
+}
+
+// useBeforeDefinition は定義前の変数の参照に対する診断を作成する
+// maybeDefinedが真の場合は、一部の経路でだけ定義済みであることを表す警告になる
+func useBeforeDefinition(ident *phase1.Identifier, v *localVariable, maybeDefined bool) *phase1.Diagnostic {
+	var d *phase1.Diagnostic
+	if maybeDefined {
+		d = phase1.Warningf(CodeMaybeUninitialized, phase1.NodeSpan(ident),
+			"`%s` may be used before it is defined", v.name).
+			WithLabel("`" + v.name + "` is not defined on every path to this point")
+	} else {
+		d = phase1.Errorf(CodeUseBeforeDefinition, phase1.NodeSpan(ident),
+			"use of `%s` before its definition", v.name).
+			WithLabel("`" + v.name + "` is used here before it is defined")
+	}
+	return d.WithSecondary(phase1.NodeSpan(v.definition.Name), "`"+v.name+"` is defined here")
+}
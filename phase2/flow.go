@@ -0,0 +1,234 @@
+package phase2
+
+import (
+	"github.com/nyasuto/pug/phase1"
+)
+
+// completesNormally は文の実行が次の文へ進む経路を持つかどうかを判定する
+// return・break・continue文、全ての分岐がそれらで終わるif-else、breakのない無限ループは次の文へ進まない
+func completesNormally(stmt phase1.Statement) bool {
+	switch node := stmt.(type) {
+	case *phase1.ReturnStatement, *phase1.BreakStatement, *phase1.ContinueStatement:
+		return false
+	case *phase1.ExpressionStatement:
+		if ifExpr, ok := node.Expression.(*phase1.IfExpression); ok {
+			return ifCompletesNormally(ifExpr)
+		}
+		return true
+	case *phase1.BlockStatement:
+		return blockCompletesNormally(node)
+	case *phase1.WhileStatement:
+		return !isInfiniteLoop(node.Condition) || containsBreak(node.Body, loopLabel(node.Label))
+	case *phase1.ForStatement:
+		return (node.Condition != nil && !isInfiniteLoop(node.Condition)) || containsBreak(node.Body, loopLabel(node.Label))
+	default:
+		return true
+	}
+}
+
+// loopLabel はループのラベル名を返す（ラベルがなければ空）
+func loopLabel(label *phase1.Identifier) string {
+	if label == nil {
+		return ""
+	}
+	return label.Value
+}
+
+// blockCompletesNormally はブロックの末尾まで実行が到達する経路を持つかどうかを判定する
+func blockCompletesNormally(block *phase1.BlockStatement) bool {
+	if block == nil {
+		return true
+	}
+	for _, stmt := range block.Statements {
+		if !completesNormally(stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+// ifCompletesNormally はif式のいずれかの分岐が次の文へ進むかどうかを判定する
+// elseのないif式は条件が偽の場合に次の文へ進む
+func ifCompletesNormally(node *phase1.IfExpression) bool {
+	if blockCompletesNormally(node.Consequence) {
+		return true
+	}
+	switch {
+	case node.ElseIf != nil:
+		return ifCompletesNormally(node.ElseIf)
+	case node.Alternative != nil:
+		return blockCompletesNormally(node.Alternative)
+	default:
+		return true
+	}
+}
+
+// isInfiniteLoop はループ条件が常に真（trueリテラル）かどうかを判定する
+func isInfiniteLoop(condition phase1.Expression) bool {
+	b, ok := condition.(*phase1.Boolean)
+	return ok && b.Value
+}
+
+// containsBreak はループ本体にそのループを抜けるbreak文が含まれるかどうかを判定する
+// labelはループのラベルで、内側のループの中ではそのラベルを指すbreak文だけを対象にする
+// 関数リテラルの中のbreak文は対象にしない
+func containsBreak(block *phase1.BlockStatement, label string) bool {
+	return breaksOut(block, label, true)
+}
+
+// breaksOut はブロックに、ラベルlabelのループを抜けるbreak文が含まれるかどうかを判定する
+// innermostが真の場合、ラベルのないbreak文もそのループを抜ける
+func breaksOut(block *phase1.BlockStatement, label string, innermost bool) bool {
+	if block == nil {
+		return false
+	}
+	for _, stmt := range block.Statements {
+		switch node := stmt.(type) {
+		case *phase1.BreakStatement:
+			if (node.Label == nil && innermost) || (node.Label != nil && node.Label.Value == label) {
+				return true
+			}
+		case *phase1.BlockStatement:
+			if breaksOut(node, label, innermost) {
+				return true
+			}
+		case *phase1.ExpressionStatement:
+			if ifExpr, ok := node.Expression.(*phase1.IfExpression); ok && ifBreaksOut(ifExpr, label, innermost) {
+				return true
+			}
+		case *phase1.WhileStatement:
+			// 同じラベルを付け直した内側のループでは、ラベル付きのbreak文も内側のループを抜ける
+			if label != "" && loopLabel(node.Label) != label && breaksOut(node.Body, label, false) {
+				return true
+			}
+		case *phase1.ForStatement:
+			if label != "" && loopLabel(node.Label) != label && breaksOut(node.Body, label, false) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ifBreaksOut はif式のいずれかの分岐に、ラベルlabelのループを抜けるbreak文が含まれるかどうかを判定する
+func ifBreaksOut(node *phase1.IfExpression, label string, innermost bool) bool {
+	if breaksOut(node.Consequence, label, innermost) || breaksOut(node.Alternative, label, innermost) {
+		return true
+	}
+	return node.ElseIf != nil && ifBreaksOut(node.ElseIf, label, innermost)
+}
+
+// returnPaths は関数本体から抜ける全ての地点
+type returnPaths struct {
+	// implicit は暗黙の戻り値となる関数本体の末尾の式（到達できない場合や式文でない場合はnil）
+	// 末尾のif式では、各分岐の値の型はif式の型として一つにまとめられる
+	implicit phase1.Expression
+	// returnsValue は値を返す経路（値を持つreturn文または暗黙の戻り値）があるかどうか
+	returnsValue bool
+	// valueless は値を返さずに関数を抜ける経路（値のないreturn文や本体の末尾への到達）の例
+	valueless []phase1.Node
+}
+
+// analyzeReturnPaths は関数本体の制御フローをたどり、関数から抜ける全ての地点を集める
+func analyzeReturnPaths(body *phase1.BlockStatement) *returnPaths {
+	paths := &returnPaths{}
+	if body == nil {
+		return paths
+	}
+	if paths.block(body, true) {
+		paths.valueless = append(paths.valueless, body)
+	}
+
+	if n := len(body.Statements); n > 0 {
+		last, ok := body.Statements[n-1].(*phase1.ExpressionStatement)
+		if ok && blockCompletesNormally(&phase1.BlockStatement{Statements: body.Statements[:n-1]}) {
+			paths.implicit = last.Expression
+		}
+	}
+	return paths
+}
+
+// block は文の並びをたどり、末尾まで実行が到達するかどうかを返す
+// tailが真の場合、最後の式文の値は関数の戻り値になる
+func (r *returnPaths) block(block *phase1.BlockStatement, tail bool) bool {
+	if block == nil {
+		return true
+	}
+	for i, stmt := range block.Statements {
+		last := i == len(block.Statements)-1
+		if !r.statement(stmt, tail && last) {
+			return false
+		}
+	}
+	return true
+}
+
+// statement は文をたどり、次の文へ実行が進むかどうかを返す
+// tailが真の場合、文は関数本体の末尾にあり、式文の値は関数の戻り値になる
+func (r *returnPaths) statement(stmt phase1.Statement, tail bool) bool {
+	switch node := stmt.(type) {
+	case *phase1.ReturnStatement:
+		if node.ReturnValue != nil {
+			r.returnsValue = true
+		} else {
+			r.valueless = append(r.valueless, node)
+		}
+		return false
+	case *phase1.BreakStatement, *phase1.ContinueStatement:
+		return false
+	case *phase1.ExpressionStatement:
+		if ifExpr, ok := node.Expression.(*phase1.IfExpression); ok {
+			return r.ifExpression(ifExpr, tail)
+		}
+		if tail {
+			r.returnsValue = true
+			return false
+		}
+		return true
+	case *phase1.BlockStatement:
+		return r.block(node, tail)
+	case *phase1.WhileStatement:
+		r.block(node.Body, false)
+		return completesNormally(node)
+	case *phase1.ForStatement:
+		r.block(node.Body, false)
+		return completesNormally(node)
+	default:
+		return true
+	}
+}
+
+// ifExpression はif式の各分岐をたどり、次の文へ実行が進むかどうかを返す
+// 関数本体の末尾にあるif式では、各分岐の末尾の式が戻り値になり、
+// 値を生成しない分岐（elseの省略を含む）は値を返さずに関数を抜ける
+func (r *returnPaths) ifExpression(node *phase1.IfExpression, tail bool) bool {
+	completes := r.branch(node.Consequence, tail)
+
+	switch {
+	case node.ElseIf != nil:
+		if r.ifExpression(node.ElseIf, tail) {
+			completes = true
+		}
+	case node.Alternative != nil:
+		if r.branch(node.Alternative, tail) {
+			completes = true
+		}
+	default:
+		if tail {
+			r.valueless = append(r.valueless, node)
+		}
+		completes = true
+	}
+
+	return completes && !tail
+}
+
+// branch はif式の分岐をたどる
+// 関数本体の末尾では、末尾まで到達する分岐は値を返さずに関数を抜ける
+func (r *returnPaths) branch(block *phase1.BlockStatement, tail bool) bool {
+	completes := r.block(block, tail)
+	if completes && tail {
+		r.valueless = append(r.valueless, block)
+	}
+	return completes
+}
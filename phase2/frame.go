@@ -0,0 +1,80 @@
+package phase2
+
+import (
+	"fmt"
+	"maps"
+)
+
+// maxFrameSize は1つの関数のスタックフレームに確保できる領域の上限（バイト）
+// これを超えるフレームはスタックを溢れさせるおそれがあるため、コードを生成せずにエラーにする
+const maxFrameSize = 1 << 20
+
+// frameLayout は関数のフレームの配置（RBPの下にcallee-savedレジスタの保存領域、その下にスピルのスロットを置く）
+type frameLayout struct {
+	allocation *allocation
+	size       int // RBPからRSPまでの大きさ（16バイト単位）
+}
+
+// newFrameLayout は割り当て結果からフレームの配置を決める
+func newFrameLayout(alloc *allocation) *frameLayout {
+	used := 8 * (len(alloc.calleeSaved) + alloc.spillSlots)
+	// 呼び出し時のRSPを16バイト境界に保つため、フレームは16バイト単位で確保する
+	return &frameLayout{allocation: alloc, size: (used + 15) &^ 15}
+}
+
+// operand は仮想レジスタの割り当て先をアセンブリのオペランドとして返す
+func (f *frameLayout) operand(r vreg) string {
+	loc := f.allocation.locations[r]
+	if loc.register != "" {
+		return loc.register
+	}
+	return fmt.Sprintf("%d(%%rbp)", -8*(len(f.allocation.calleeSaved)+loc.slot+1))
+}
+
+// frameScope はfor文が作るスコープに入る前の、ローカル変数の状態
+// インタプリタと同じく、関数の本体とfor文だけがスコープを作る（whileやifのブロックで定義した変数は外から見える）
+type frameScope struct {
+	variables map[string]vreg
+	names     map[string]bool
+}
+
+// generateFrame は関数の本体をbodyで中間表現に変換し、仮想レジスタを割り当ててからプロローグと本体を出力する
+// フレームの大きさは、割り当てで使うcallee-savedレジスタとスピルのスロットの数から決まる
+func (cg *CodeGenerator) generateFrame(name string, body func() error) error {
+	cg.code = &irFunction{name: name}
+	cg.variables = map[string]vreg{}
+	cg.scopeNames = map[string]bool{}
+	cg.named = map[vreg]bool{}
+	if err := body(); err != nil {
+		return err
+	}
+	cg.code.simplify()
+	return cg.emitFunction(cg.code, allocateRegisters(cg.code))
+}
+
+// defineVariable は現在のスコープに変数を定義し、その値を置く仮想レジスタを返す
+// 同じスコープで定義済みの変数は仮想レジスタを再利用する（インタプリタでも同じ環境の値を上書きする）
+func (cg *CodeGenerator) defineVariable(name string) vreg {
+	if r, ok := cg.variables[name]; ok && cg.scopeNames[name] {
+		return r
+	}
+	r := cg.newVreg()
+	cg.variables[name] = r
+	cg.scopeNames[name] = true
+	cg.named[r] = true
+	return r
+}
+
+// enterScope はfor文のスコープに入り、抜けるときに戻す状態を返す
+func (cg *CodeGenerator) enterScope() frameScope {
+	saved := frameScope{variables: cg.variables, names: cg.scopeNames}
+	cg.variables = maps.Clone(cg.variables)
+	cg.scopeNames = map[string]bool{}
+	return saved
+}
+
+// leaveScope はfor文のスコープを抜け、外側の変数の名前を元に戻す
+func (cg *CodeGenerator) leaveScope(saved frameScope) {
+	cg.variables = saved.variables
+	cg.scopeNames = saved.names
+}
@@ -0,0 +1,179 @@
+package phase2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nyasuto/pug/phase1"
+)
+
+// argumentRegisters はSystem V ABIで整数の引数を渡すレジスタ（7番目以降の引数はスタックで渡す）
+var argumentRegisters = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+
+// functionScope は名前で直接呼び出せるlet束縛された関数の名前とシンボル名の対応（内側の関数から外側の関数の名前も参照できる）
+type functionScope struct {
+	names map[string]string
+	outer *functionScope
+}
+
+// lookup は名前に束縛された関数のシンボル名を内側のスコープから順に探す
+func (s *functionScope) lookup(name string) (string, bool) {
+	for scope := s; scope != nil; scope = scope.outer {
+		if label, ok := scope.names[name]; ok {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// pendingFunction は本体をまだ生成していない関数
+type pendingFunction struct {
+	label     string
+	literal   *phase1.FunctionLiteral
+	functions *functionScope // 関数リテラルが現れた位置で参照できる関数の名前
+}
+
+// declareFunctions は文の並びでlet束縛された関数のうち、直接呼び出せる関数の名前を新しいスコープに宣言する
+// 後で定義される関数も名前で呼び出せるため、相互再帰が可能になる
+func (cg *CodeGenerator) declareFunctions(outer *functionScope, statements []phase1.Statement) *functionScope {
+	scope := &functionScope{names: map[string]string{}, outer: outer}
+	for _, stmt := range statements {
+		let, ok := stmt.(*phase1.LetStatement)
+		if !ok {
+			continue
+		}
+		if fn, ok := let.Value.(*phase1.FunctionLiteral); ok && cg.closures.direct[fn] {
+			scope.names[let.Name.Value] = cg.functionLabel(fn, let.Name.Value)
+		}
+	}
+	return scope
+}
+
+// functionLabel は関数リテラルのシンボル名を返す（初めての場合は名前から重複しないものを作る）
+func (cg *CodeGenerator) functionLabel(fn *phase1.FunctionLiteral, name string) string {
+	if label, ok := cg.functionLabels[fn]; ok {
+		return label
+	}
+	if name == "" {
+		name = fmt.Sprintf("%d", len(cg.functionLabels))
+	}
+	label := "pug_func_" + name
+	for i := 1; cg.symbolNames[label]; i++ {
+		label = fmt.Sprintf("pug_func_%s_%d", name, i)
+	}
+	cg.symbolNames[label] = true
+	cg.functionLabels[fn] = label
+	return label
+}
+
+// generateFunctionLiteral は関数リテラルの中間表現を生成する
+// 関数の本体は独立したシンボルとして後で生成し、ここではそのクロージャのアドレスを置いた仮想レジスタを返す
+func (cg *CodeGenerator) generateFunctionLiteral(node *phase1.FunctionLiteral) (vreg, error) {
+	label := cg.functionLabel(node, "")
+	cg.pending = append(cg.pending, &pendingFunction{
+		label:     label,
+		literal:   node,
+		functions: cg.functions,
+	})
+	return cg.generateClosure(label, cg.closures.function(node))
+}
+
+// generateFunction は関数の本体を、独自のフレームを持つ関数として生成する
+// 引数はレジスタとスタックから入口で変数の仮想レジスタに受け取る
+// 捕捉した変数を使う関数は、R10で受け取ったクロージャのレコードも仮想レジスタに受け取る
+func (cg *CodeGenerator) generateFunction(fn *pendingFunction) error {
+	info := cg.closures.function(fn.literal)
+	cg.cells = map[*Symbol]vreg{}
+	cg.captured = map[*Symbol]int{}
+	cg.closure = 0
+	cg.loopContext = nil
+	cg.functions = cg.declareFunctions(fn.functions, fn.literal.Body.Statements)
+
+	cg.emit("")
+	cg.emitf("# fn(%s)", parameterNames(fn.literal))
+	cg.emitf("%s:", fn.label)
+	return cg.generateFrame(fn.label, func() error {
+		entry := &instruction{op: opEntry}
+		if len(info.captures) > 0 {
+			cg.closure = cg.newVreg()
+			entry.dst = cg.closure
+			for i, symbol := range info.captures {
+				cg.captured[symbol] = i
+			}
+		}
+		for _, param := range fn.literal.Parameters {
+			entry.args = append(entry.args, cg.defineVariable(param.Value))
+		}
+		cg.add(entry)
+		cg.allocateCells(info.boxed)
+
+		// 本体の最後の文の値が戻り値となる
+		value, err := cg.generateBlockStatement(fn.literal.Body)
+		if err != nil {
+			return err
+		}
+		cg.add(&instruction{op: opReturn, a: cg.valueOf(value)})
+		return nil
+	})
+}
+
+// isBuiltin は名前が変数や関数の定義に隠されていない組み込み関数の名前になりうるかどうかを返す
+func (cg *CodeGenerator) isBuiltin(name string) bool {
+	if cg.hasVariable(name) {
+		return false
+	}
+	_, isFunction := cg.functions.lookup(name)
+	return !isFunction
+}
+
+// parameterNames は関数の引数名をカンマ区切りで返す
+func parameterNames(fn *phase1.FunctionLiteral) string {
+	names := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		names[i] = param.Value
+	}
+	return strings.Join(names, ", ")
+}
+
+// generateCallExpression は関数呼び出しの中間表現を生成する
+// 直接呼び出せるlet束縛された関数はシンボルを呼び出し、それ以外の関数の値はクロージャを間接的に呼び出す
+// 引数の受け渡しはSystem V ABIに従う（emitCall）
+func (cg *CodeGenerator) generateCallExpression(node *phase1.CallExpression) (vreg, error) {
+	callee := ""
+	if ident, ok := node.Function.(*phase1.Identifier); ok {
+		if cg.isBuiltin(ident.Value) {
+			switch ident.Value {
+			case "puts":
+				return cg.generatePutsCall(node)
+			case "len":
+				return cg.generateLenCall(node)
+			case "first", "last", "rest", "push":
+				return cg.generateArrayBuiltin(ident.Value, node)
+			}
+		}
+		if !cg.hasVariable(ident.Value) {
+			if label, ok := cg.functions.lookup(ident.Value); ok {
+				callee = label
+			} else {
+				// 定義が見つからない関数は外部のシンボルとしてリンク時に解決する
+				callee = cg.target.Symbol(ident.Value)
+			}
+		}
+	}
+
+	// 呼び出す関数の値、引数の順に左から評価する
+	if callee != "" {
+		args, err := cg.generateOperands(node.Arguments...)
+		if err != nil {
+			return 0, err
+		}
+		return cg.call(callee, args...), nil
+	}
+	values, err := cg.generateOperands(append([]phase1.Expression{node.Function}, node.Arguments...)...)
+	if err != nil {
+		return 0, err
+	}
+	result := cg.newVreg()
+	cg.add(&instruction{op: opCall, dst: result, a: values[0], args: values[1:]})
+	return result, nil
+}
@@ -0,0 +1,347 @@
+package phase2
+
+// ヒープのオブジェクトは、8バイトのヘッダに続けて本体を置いたブロックで、値としてのポインタは本体の先頭を指す
+// ヘッダの下位8ビットはオブジェクトの種類とマークビット、残りのビットはヘッダを含むブロックのバイト数を表す
+// 文字列と配列は本体の先頭8バイトに長さ（要素数）を持つ
+//
+// ガベージコレクションはマーク・スイープ方式で、オブジェクトを移動しない
+// 生成したコードは値の型情報を持たないため、ネイティブのスタックとレジスタの全ての語を保守的にポインタの候補とし、
+// ブロックの先頭を表すビットマップで本体の先頭を指す語だけをポインタとみなす
+// スタックは生成した関数のフレームポインタ（RBP）の連鎖をたどって、最も外側のフレームまでを走査する
+
+// ヒープのオブジェクトの種類（ヘッダの下位7ビット）
+const (
+	objectFree   = 0 // 空きブロック（本体の先頭に空きリストの次のブロックを持つ）
+	objectString = 1 // 文字列（本体はバイト列なので走査しない）
+	objectArray  = 2 // 配列（要素を走査する）
+	objectRecord = 3 // クロージャのレコードや変数のセル（全ての語を走査する）
+)
+
+const (
+	headerMark      = 0x80 // マーク済みのオブジェクトを表すヘッダのビット
+	headerTagMask   = 0x7f // ヘッダのオブジェクトの種類のビット
+	headerSizeShift = 8    // ヘッダのブロックのバイト数の位置
+)
+
+const (
+	heapSize          = 1 << 30       // 予約するヒープの大きさ（使ったページだけが物理メモリに割り当てられる）
+	heapBitmapSize    = heapSize / 64 // ブロックの先頭を表すビットマップ（8バイトごとに1ビット）
+	heapMarkStackSize = heapSize / 2  // マーク中のオブジェクトのスタック（ブロックは16バイト以上なので溢れない）
+	heapSmallLimit    = 256           // 大きさごとの空きリストで管理するブロックの上限（バイト）
+	gcMinThreshold    = 1 << 20       // 前回のGCから次のGCまでに確保できる最小のバイト数
+	heapMinBlock      = 16            // ブロックの最小のバイト数（ヘッダと空きリストのリンク）
+	heapReserveSize   = heapSize + heapBitmapSize + heapMarkStackSize
+)
+
+// allocate はオブジェクトを確保するランタイムを呼び出し、本体のアドレスを置いた仮想レジスタを返す
+func (cg *CodeGenerator) allocate(size, tag int) vreg {
+	return cg.callRuntime(runtimeAlloc, cg.constant(int64(size)), cg.constant(int64(tag)))
+}
+
+// emitHeapBitmap はブロックの先頭を表すビットマップの、アドレスがaddrのブロックのビットに対するビット命令を出力する
+// R10とR11を破壊する
+func (cg *CodeGenerator) emitHeapBitmap(instruction, addr string) {
+	cg.emitf("    movq %s, %%r10", addr)
+	cg.emit("    subq .Lrt_heap_start(%rip), %r10")
+	cg.emit("    shrq $3, %r10")
+	cg.emit("    movq .Lrt_heap_bitmap(%rip), %r11")
+	cg.emitf("    %s %%r10, (%%r11)", instruction)
+}
+
+// emitAlloc はヒープにオブジェクトを確保するルーチンを出力する
+// 初めて呼ばれたときにヒープを予約し、前回のGCから確保したバイト数が閾値を超えるとGCを行う
+// ブロックは大きさごとの空きリスト、大きなブロックの空きリスト（最初に見つかったものを分割）、未使用の領域の順に探し、
+// 見つからない場合はGCを行ってもう一度探す。それでも確保できなければメッセージを出力して終了コード1で終了する
+func (cg *CodeGenerator) emitAlloc() {
+	t := cg.target
+
+	cg.emitf("%s:", runtimeAlloc)
+	cg.emit("    addq $15, %rdi") // ヘッダを加えて8バイト単位に切り上げる
+	cg.emit("    andq $-8, %rdi")
+	cg.emitf("    cmpq $%d, %%rdi", heapMinBlock)
+	cg.emit("    jae .Lrt_alloc_sized")
+	cg.emitf("    movq $%d, %%rdi", heapMinBlock)
+	cg.emit(".Lrt_alloc_sized:")
+	cg.emit("    cmpq $0, .Lrt_heap_start(%rip)")
+	cg.emit("    jne .Lrt_alloc_ready")
+	cg.emit("    pushq %rdi")
+	cg.emit("    pushq %rsi")
+	cg.emit("    call .Lrt_heap_init")
+	cg.emit("    popq %rsi")
+	cg.emit("    popq %rdi")
+	cg.emit(".Lrt_alloc_ready:")
+	cg.emit("    pushq %rdi")
+	cg.emit("    pushq %rsi")
+	cg.emit("    movq .Lrt_heap_allocated(%rip), %rax")
+	cg.emit("    addq %rdi, %rax")
+	cg.emit("    cmpq .Lrt_heap_threshold(%rip), %rax")
+	cg.emit("    jbe .Lrt_alloc_try")
+	cg.emitf("    call %s", runtimeGC)
+	cg.emit("    movq 8(%rsp), %rdi")
+	cg.emit(".Lrt_alloc_try:")
+	cg.emit("    call .Lrt_alloc_block")
+	cg.emit("    testq %rax, %rax")
+	cg.emit("    jnz .Lrt_alloc_found")
+	cg.emitf("    call %s", runtimeGC)
+	cg.emit("    movq 8(%rsp), %rdi")
+	cg.emit("    call .Lrt_alloc_block")
+	cg.emit("    testq %rax, %rax")
+	cg.emit("    jz .Lrt_out_of_memory")
+	cg.emit(".Lrt_alloc_found:")
+	cg.emit("    popq %rsi")
+	cg.emit("    popq %rdi")
+	cg.emit("    addq %rdx, .Lrt_heap_allocated(%rip)")
+	cg.emit("    movq %rdx, %rcx")
+	cg.emitf("    shlq $%d, %%rdx", headerSizeShift)
+	cg.emit("    orq %rsi, %rdx")
+	cg.emit("    movq %rdx, (%rax)")
+	cg.emit("    leaq 8(%rax), %rdx")
+	cg.emit("    movq %rdx, %rdi") // 本体を0で埋める
+	cg.emit("    shrq $3, %rcx")
+	cg.emit("    decq %rcx")
+	cg.emit("    xorq %rax, %rax")
+	cg.emit("    rep stosq")
+	cg.emit("    movq %rdx, %rax")
+	cg.emit("    ret")
+
+	// (rdi=ブロックのバイト数) のブロックを探し、rax にアドレス（見つからなければ0）、rdx に実際のバイト数を返す
+	cg.emit(".Lrt_alloc_block:")
+	cg.emitf("    cmpq $%d, %%rdi", heapSmallLimit)
+	cg.emit("    ja .Lrt_alloc_large")
+	cg.emit("    leaq .Lrt_free_lists(%rip), %rcx")
+	cg.emit("    movq (%rcx,%rdi), %rax")
+	cg.emit("    testq %rax, %rax")
+	cg.emit("    jz .Lrt_alloc_large")
+	cg.emit("    movq 8(%rax), %rdx")
+	cg.emit("    movq %rdx, (%rcx,%rdi)")
+	cg.emit("    movq %rdi, %rdx")
+	cg.emit("    ret")
+	cg.emit(".Lrt_alloc_large:")
+	cg.emit("    leaq .Lrt_free_large(%rip), %rcx") // rcx は現在のブロックを指すリンクのアドレス
+	cg.emit(".Lrt_alloc_large_next:")
+	cg.emit("    movq (%rcx), %rax")
+	cg.emit("    testq %rax, %rax")
+	cg.emit("    jz .Lrt_alloc_bump")
+	cg.emit("    movq (%rax), %rdx")
+	cg.emitf("    shrq $%d, %%rdx", headerSizeShift)
+	cg.emit("    cmpq %rdi, %rdx")
+	cg.emit("    jae .Lrt_alloc_large_fit")
+	cg.emit("    leaq 8(%rax), %rcx")
+	cg.emit("    jmp .Lrt_alloc_large_next")
+	cg.emit(".Lrt_alloc_large_fit:")
+	cg.emit("    movq 8(%rax), %r8")
+	cg.emit("    movq %r8, (%rcx)")
+	cg.emit("    movq %rdx, %r8")
+	cg.emit("    subq %rdi, %r8")
+	cg.emitf("    cmpq $%d, %%r8", heapMinBlock)
+	cg.emit("    jb .Lrt_alloc_large_done") // 残りが小さすぎる場合はブロック全体を使う
+	cg.emit("    leaq (%rax,%rdi), %r9")
+	cg.emit("    movq %r8, %rdx")
+	cg.emitf("    shlq $%d, %%rdx", headerSizeShift)
+	cg.emit("    movq %rdx, (%r9)")
+	cg.emitHeapBitmap("btsq", "%r9")
+	cg.emit("    call .Lrt_free_block")
+	cg.emit("    movq %rdi, %rdx")
+	cg.emit(".Lrt_alloc_large_done:")
+	cg.emit("    ret")
+	cg.emit(".Lrt_alloc_bump:")
+	cg.emit("    movq .Lrt_heap_next(%rip), %rax")
+	cg.emit("    leaq (%rax,%rdi), %rdx")
+	cg.emit("    cmpq .Lrt_heap_end(%rip), %rdx")
+	cg.emit("    ja .Lrt_alloc_fail")
+	cg.emit("    movq %rdx, .Lrt_heap_next(%rip)")
+	cg.emitHeapBitmap("btsq", "%rax")
+	cg.emit("    movq %rdi, %rdx")
+	cg.emit("    ret")
+	cg.emit(".Lrt_alloc_fail:")
+	cg.emit("    xorq %rax, %rax")
+	cg.emit("    ret")
+
+	// (r9=ブロック, r8=バイト数) の空きブロックを空きリストに加える（R10とR11を破壊する）
+	cg.emit(".Lrt_free_block:")
+	cg.emitf("    cmpq $%d, %%r8", heapSmallLimit)
+	cg.emit("    ja .Lrt_free_block_large")
+	cg.emit("    leaq .Lrt_free_lists(%rip), %r10")
+	cg.emit("    movq (%r10,%r8), %r11")
+	cg.emit("    movq %r11, 8(%r9)")
+	cg.emit("    movq %r9, (%r10,%r8)")
+	cg.emit("    ret")
+	cg.emit(".Lrt_free_block_large:")
+	cg.emit("    movq .Lrt_free_large(%rip), %r11")
+	cg.emit("    movq %r11, 8(%r9)")
+	cg.emit("    movq %r9, .Lrt_free_large(%rip)")
+	cg.emit("    ret")
+
+	// ヒープ・ビットマップ・マークスタックの領域をまとめて予約する
+	cg.emit(".Lrt_heap_init:")
+	cg.emit("    xorq %rdi, %rdi")
+	cg.emitf("    movq $%d, %%rsi", heapReserveSize)
+	cg.emit("    movq $3, %rdx") // PROT_READ|PROT_WRITE
+	cg.emitf("    movq $%d, %%r10", t.MapAnonymous|t.MapNoReserve)
+	cg.emit("    movq $-1, %r8")
+	cg.emit("    xorq %r9, %r9")
+	cg.emitf("    movq $%d, %%rax", t.SyscallMmap)
+	cg.emit("    syscall")
+	cg.emit("    jc .Lrt_out_of_memory") // macOS: キャリーフラグでエラーを表す
+	cg.emit("    cmpq $-4096, %rax")     // Linux: -4095〜-1 はエラー番号
+	cg.emit("    ja .Lrt_out_of_memory")
+	cg.emit("    movq %rax, .Lrt_heap_start(%rip)")
+	cg.emit("    movq %rax, .Lrt_heap_next(%rip)")
+	cg.emitf("    addq $%d, %%rax", heapSize)
+	cg.emit("    movq %rax, .Lrt_heap_end(%rip)")
+	cg.emit("    movq %rax, .Lrt_heap_bitmap(%rip)")
+	cg.emitf("    addq $%d, %%rax", heapBitmapSize)
+	cg.emit("    movq %rax, .Lrt_mark_stack(%rip)")
+	cg.emitf("    movq $%d, .Lrt_heap_threshold(%%rip)", gcMinThreshold)
+	cg.emit("    ret")
+	cg.emit(".Lrt_out_of_memory:")
+	cg.emitFatalError(outOfMemoryMessage)
+	cg.emit("")
+
+	cg.emit(t.DataSection)
+	cg.emit(".p2align 3")
+	for _, variable := range []string{"heap_start", "heap_next", "heap_end", "heap_bitmap", "mark_stack", "heap_allocated", "heap_threshold", "free_large"} {
+		cg.emitf(".Lrt_%s:", variable)
+		cg.emit("    .quad 0")
+	}
+	cg.emit(".Lrt_free_lists:") // ブロックのバイト数をオフセットとする空きリストの先頭
+	cg.emitf("    .zero %d", heapSmallLimit+8)
+	cg.emit(t.TextSection)
+}
+
+// emitGC はマーク・スイープ方式のガベージコレクションを行うルーチンを出力する
+// 全てのレジスタをスタックに積んでから、スタックの全ての語と、到達したオブジェクトの本体を保守的に走査してマークする
+// スイープではマークされていないブロックを隣り合うものとまとめて空きリストに戻し、ヒープの末尾のものは未使用の領域に戻す
+// 次のGCの閾値は、生き残ったバイト数（最小でgcMinThreshold）とする
+func (cg *CodeGenerator) emitGC() {
+	saved := []string{"%rbx", "%rbp", "%r12", "%r13", "%r14", "%r15", "%rax", "%rcx", "%rdx", "%rsi", "%rdi", "%r8", "%r9", "%r10", "%r11"}
+
+	cg.emitf("%s:", runtimeGC)
+	for _, reg := range saved {
+		cg.emitf("    pushq %s", reg)
+	}
+
+	// RBPの連鎖をたどって最も外側のフレームを探す（_startやlibSystemの入口ではRBPの連鎖が0で終わる）
+	cg.emit("    movq %rbp, %r13")
+	cg.emit(".Lrt_gc_frame:")
+	cg.emit("    movq (%r13), %rax")
+	cg.emit("    cmpq %r13, %rax")
+	cg.emit("    jbe .Lrt_gc_roots")
+	cg.emit("    movq %rax, %r13")
+	cg.emit("    jmp .Lrt_gc_frame")
+
+	// スタック（r12〜r13）の語をマークする（r14はマークスタックの先頭）
+	cg.emit(".Lrt_gc_roots:")
+	cg.emit("    movq .Lrt_mark_stack(%rip), %r14")
+	cg.emit("    movq %rsp, %r12")
+	cg.emit(".Lrt_gc_root:")
+	cg.emit("    cmpq %r13, %r12")
+	cg.emit("    jae .Lrt_gc_drain")
+	cg.emit("    movq (%r12), %rdi")
+	cg.emit("    call .Lrt_mark")
+	cg.emit("    addq $8, %r12")
+	cg.emit("    jmp .Lrt_gc_root")
+
+	// マークしたオブジェクトの本体を走査する（文字列はポインタを含まない）
+	cg.emit(".Lrt_gc_drain:")
+	cg.emit("    cmpq .Lrt_mark_stack(%rip), %r14")
+	cg.emit("    je .Lrt_gc_sweep")
+	cg.emit("    subq $8, %r14")
+	cg.emit("    movq (%r14), %r12")
+	cg.emit("    movq (%r12), %r13")
+	cg.emit("    movq %r13, %rax")
+	cg.emitf("    andq $%d, %%rax", headerTagMask)
+	cg.emitf("    cmpq $%d, %%rax", objectString)
+	cg.emit("    je .Lrt_gc_drain")
+	cg.emitf("    shrq $%d, %%r13", headerSizeShift)
+	cg.emit("    addq %r12, %r13")
+	cg.emit("    addq $8, %r12")
+	cg.emit(".Lrt_gc_scan:")
+	cg.emit("    cmpq %r13, %r12")
+	cg.emit("    jae .Lrt_gc_drain")
+	cg.emit("    movq (%r12), %rdi")
+	cg.emit("    call .Lrt_mark")
+	cg.emit("    addq $8, %r12")
+	cg.emit("    jmp .Lrt_gc_scan")
+
+	// 空きリストを作り直しながら、ヒープのブロックを先頭から順にスイープする（rbxは生き残ったバイト数）
+	cg.emit(".Lrt_gc_sweep:")
+	cg.emit("    leaq .Lrt_free_lists(%rip), %rdi")
+	cg.emitf("    movq $%d, %%rcx", heapSmallLimit/8+1)
+	cg.emit("    xorq %rax, %rax")
+	cg.emit("    rep stosq")
+	cg.emit("    movq $0, .Lrt_free_large(%rip)")
+	cg.emit("    xorq %rbx, %rbx")
+	cg.emit("    movq .Lrt_heap_start(%rip), %r12")
+	cg.emit(".Lrt_gc_sweep_block:")
+	cg.emit("    cmpq .Lrt_heap_next(%rip), %r12")
+	cg.emit("    jae .Lrt_gc_done")
+	cg.emit("    movq (%r12), %rax")
+	cg.emit("    movq %rax, %r13")
+	cg.emitf("    shrq $%d, %%r13", headerSizeShift)
+	cg.emitf("    testq $%d, %%rax", headerMark)
+	cg.emit("    jz .Lrt_gc_sweep_free")
+	cg.emitf("    andq $%d, %%rax", ^headerMark)
+	cg.emit("    movq %rax, (%r12)")
+	cg.emit("    addq %r13, %rbx")
+	cg.emit("    addq %r13, %r12")
+	cg.emit("    jmp .Lrt_gc_sweep_block")
+	cg.emit(".Lrt_gc_sweep_free:") // 後に続くマークされていないブロックをまとめる
+	cg.emit("    leaq (%r12,%r13), %r9")
+	cg.emit("    cmpq .Lrt_heap_next(%rip), %r9")
+	cg.emit("    jae .Lrt_gc_sweep_tail")
+	cg.emit("    movq (%r9), %rax")
+	cg.emitf("    testq $%d, %%rax", headerMark)
+	cg.emit("    jnz .Lrt_gc_sweep_add")
+	cg.emitf("    shrq $%d, %%rax", headerSizeShift)
+	cg.emit("    addq %rax, %r13")
+	cg.emitHeapBitmap("btrq", "%r9")
+	cg.emit("    jmp .Lrt_gc_sweep_free")
+	cg.emit(".Lrt_gc_sweep_tail:")
+	cg.emitHeapBitmap("btrq", "%r12")
+	cg.emit("    movq %r12, .Lrt_heap_next(%rip)")
+	cg.emit("    jmp .Lrt_gc_done")
+	cg.emit(".Lrt_gc_sweep_add:")
+	cg.emit("    movq %r13, %rax")
+	cg.emitf("    shlq $%d, %%rax", headerSizeShift)
+	cg.emit("    movq %rax, (%r12)")
+	cg.emit("    movq %r12, %r9")
+	cg.emit("    movq %r13, %r8")
+	cg.emit("    call .Lrt_free_block")
+	cg.emit("    addq %r13, %r12")
+	cg.emit("    jmp .Lrt_gc_sweep_block")
+
+	cg.emit(".Lrt_gc_done:")
+	cg.emitf("    movq $%d, %%rax", gcMinThreshold)
+	cg.emit("    cmpq %rax, %rbx")
+	cg.emit("    cmova %rbx, %rax")
+	cg.emit("    movq %rax, .Lrt_heap_threshold(%rip)")
+	cg.emit("    movq $0, .Lrt_heap_allocated(%rip)")
+	for i := len(saved) - 1; i >= 0; i-- {
+		cg.emitf("    popq %s", saved[i])
+	}
+	cg.emit("    ret")
+
+	// (rdi=ポインタの候補) がヒープのオブジェクトの本体の先頭を指していれば、マークしてマークスタックに積む
+	cg.emit(".Lrt_mark:")
+	cg.emit("    testq $7, %rdi")
+	cg.emit("    jnz .Lrt_mark_done")
+	cg.emit("    leaq -8(%rdi), %rax")
+	cg.emit("    cmpq .Lrt_heap_start(%rip), %rax")
+	cg.emit("    jb .Lrt_mark_done")
+	cg.emit("    cmpq .Lrt_heap_next(%rip), %rax")
+	cg.emit("    jae .Lrt_mark_done")
+	cg.emitHeapBitmap("btq", "%rax")
+	cg.emit("    jnc .Lrt_mark_done")
+	cg.emit("    movq (%rax), %rdx")
+	cg.emitf("    testq $%d, %%rdx", headerMark)
+	cg.emit("    jnz .Lrt_mark_done")
+	cg.emitf("    testq $%d, %%rdx", headerTagMask)
+	cg.emit("    jz .Lrt_mark_done")
+	cg.emitf("    orq $%d, %%rdx", headerMark)
+	cg.emit("    movq %rdx, (%rax)")
+	cg.emit("    movq %rax, (%r14)")
+	cg.emit("    addq $8, %r14")
+	cg.emit(".Lrt_mark_done:")
+	cg.emit("    ret")
+}
@@ -0,0 +1,345 @@
+package phase2
+
+import "fmt"
+
+// TypeVariable は型推論で使用する型変数
+// 単一化によって具体的な型に束縛されるまでは、Classが許す範囲の任意の型を表す
+type TypeVariable struct {
+	ID    int
+	Name  string
+	Class TypeClass
+}
+
+// TypeClass は型変数を束縛できる型の範囲
+// 後の定数ほど範囲が狭く、2つの型変数を単一化すると狭い方の範囲になる
+type TypeClass int
+
+const (
+	AnyClass     TypeClass = iota // 任意の型
+	AddableClass                  // + の演算ができる型（int・float・string）
+	NumericClass                  // 数値型（int・float）
+)
+
+func (c TypeClass) String() string {
+	switch c {
+	case AddableClass:
+		return "int, float or string"
+	case NumericClass:
+		return "int or float"
+	}
+	return "any type"
+}
+
+// description は診断で期待する型としてこの範囲を示す句を返す
+func (c TypeClass) description() string {
+	switch c {
+	case AddableClass:
+		return "a numeric or string type"
+	case NumericClass:
+		return "a numeric type"
+	}
+	return "any type"
+}
+
+// admits は型がこの範囲に含まれるかどうかを返す（型が決まらない値はどの範囲にも含まれる）
+func (c TypeClass) admits(t Type) bool {
+	switch t.(type) {
+	case *IntType, *FloatType, *UnknownType:
+		return true
+	case *StringType:
+		return c != NumericClass
+	}
+	return c == AnyClass
+}
+
+func (t *TypeVariable) String() string {
+	return "?" + t.Name
+}
+
+// classBound は範囲を持つ型変数を、診断でその範囲として表示するための型
+type classBound struct {
+	*TypeVariable
+}
+
+func (b classBound) String() string {
+	return b.Class.description() + " for " + b.TypeVariable.String()
+}
+
+func (t *TypeVariable) Equals(other Type) bool {
+	otherVar, ok := other.(*TypeVariable)
+	if !ok {
+		return false
+	}
+	return t.ID == otherVar.ID
+}
+
+// typeVariableName は型変数の番号から表示用の名前（T, U, ..., Z, T1, U1, ...）を作る
+func typeVariableName(n int) string {
+	const letters = "TUVWXYZ"
+	name := string(letters[n%len(letters)])
+	if n >= len(letters) {
+		name += fmt.Sprintf("%d", n/len(letters))
+	}
+	return name
+}
+
+// TypeScheme は全称量化された型（多相型）
+// 識別子として参照されるたびに、量化された型変数を新しい型変数に置き換えて使用する
+type TypeScheme struct {
+	Variables []*TypeVariable
+	Type      Type
+}
+
+func (t *TypeScheme) String() string {
+	return t.Type.String()
+}
+
+func (t *TypeScheme) Equals(other Type) bool {
+	otherScheme, ok := other.(*TypeScheme)
+	if !ok {
+		return false
+	}
+	return len(t.Variables) == len(otherScheme.Variables) && t.Type.Equals(otherScheme.Type)
+}
+
+// UnifyError は単一化の失敗を表す
+type UnifyError struct {
+	Left   Type
+	Right  Type
+	Occurs bool // 型変数が自分自身を含む型に束縛されようとした（無限型）
+}
+
+func (e *UnifyError) Error() string {
+	if e.Occurs {
+		return fmt.Sprintf("infinite type: %s occurs in %s", e.Left.String(), e.Right.String())
+	}
+	return fmt.Sprintf("cannot unify %s with %s", e.Left.String(), e.Right.String())
+}
+
+// Substitution は型変数の番号から束縛された型への置換
+type Substitution map[int]Type
+
+// Apply は置換を型に再帰的に適用し、束縛済みの型変数を全て置き換えた型を返す
+func (s Substitution) Apply(t Type) Type {
+	switch typ := t.(type) {
+	case *TypeVariable:
+		if bound, ok := s[typ.ID]; ok {
+			return s.Apply(bound)
+		}
+		return typ
+	case *ArrayType:
+		return &ArrayType{ElementType: s.Apply(typ.ElementType)}
+	case *MapType:
+		return &MapType{KeyType: s.Apply(typ.KeyType), ValueType: s.Apply(typ.ValueType)}
+	case *FunctionType:
+		params := make([]Type, len(typ.Parameters))
+		for i, param := range typ.Parameters {
+			params[i] = s.Apply(param)
+		}
+		return &FunctionType{Parameters: params, ReturnType: s.Apply(typ.ReturnType)}
+	case *TypeScheme:
+		return &TypeScheme{Variables: typ.Variables, Type: s.Apply(typ.Type)}
+	default:
+		return t
+	}
+}
+
+// prune は型変数の束縛を先頭だけたどる
+func (s Substitution) prune(t Type) Type {
+	for {
+		v, ok := t.(*TypeVariable)
+		if !ok {
+			return t
+		}
+		bound, ok := s[v.ID]
+		if !ok {
+			return t
+		}
+		t = bound
+	}
+}
+
+// Unify は2つの型が等しくなるように型変数を束縛し、置換を拡張する
+// UnknownTypeはエラーなど型を決定できない値を表し、どの型とも単一化できる
+func (s Substitution) Unify(a, b Type) error {
+	a, b = s.prune(a), s.prune(b)
+
+	if va, ok := a.(*TypeVariable); ok {
+		return s.bind(va, b)
+	}
+	if vb, ok := b.(*TypeVariable); ok {
+		return s.bind(vb, a)
+	}
+	if _, ok := a.(*UnknownType); ok {
+		return nil
+	}
+	if _, ok := b.(*UnknownType); ok {
+		return nil
+	}
+
+	mismatch := &UnifyError{Left: s.Apply(a), Right: s.Apply(b)}
+	switch at := a.(type) {
+	case *ArrayType:
+		bt, ok := b.(*ArrayType)
+		if !ok {
+			return mismatch
+		}
+		return s.Unify(at.ElementType, bt.ElementType)
+	case *MapType:
+		bt, ok := b.(*MapType)
+		if !ok {
+			return mismatch
+		}
+		if err := s.Unify(at.KeyType, bt.KeyType); err != nil {
+			return err
+		}
+		return s.Unify(at.ValueType, bt.ValueType)
+	case *FunctionType:
+		bt, ok := b.(*FunctionType)
+		if !ok || len(at.Parameters) != len(bt.Parameters) {
+			return mismatch
+		}
+		for i := range at.Parameters {
+			if err := s.Unify(at.Parameters[i], bt.Parameters[i]); err != nil {
+				return err
+			}
+		}
+		return s.Unify(at.ReturnType, bt.ReturnType)
+	default:
+		if !a.Equals(b) {
+			return mismatch
+		}
+		return nil
+	}
+}
+
+// bind は型変数を型に束縛する
+// 型変数が束縛先の型の中に現れる場合は無限型になるため失敗する（出現検査）
+// 型変数同士の束縛では、束縛先の型変数が両方の範囲の狭い方を引き継ぐ
+func (s Substitution) bind(v *TypeVariable, t Type) error {
+	if tv, ok := t.(*TypeVariable); ok {
+		if tv.ID == v.ID {
+			return nil
+		}
+		tv.Class = max(tv.Class, v.Class)
+	} else if !v.Class.admits(t) {
+		return &UnifyError{Left: v, Right: s.Apply(t)}
+	}
+	if s.occurs(v, t) {
+		return &UnifyError{Left: v, Right: s.Apply(t), Occurs: true}
+	}
+	s[v.ID] = t
+	return nil
+}
+
+// occurs は型変数が型の中に現れるかどうかをチェックする
+func (s Substitution) occurs(v *TypeVariable, t Type) bool {
+	for _, free := range freeTypeVariables(s.Apply(t)) {
+		if free.ID == v.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// freeTypeVariables は型に現れる自由な型変数を出現順に重複なく返す
+func freeTypeVariables(t Type) []*TypeVariable {
+	var result []*TypeVariable
+	seen := map[int]bool{}
+	bound := map[int]bool{}
+
+	var walk func(Type)
+	walk = func(t Type) {
+		switch typ := t.(type) {
+		case *TypeVariable:
+			if !seen[typ.ID] && !bound[typ.ID] {
+				seen[typ.ID] = true
+				result = append(result, typ)
+			}
+		case *ArrayType:
+			walk(typ.ElementType)
+		case *MapType:
+			walk(typ.KeyType)
+			walk(typ.ValueType)
+		case *FunctionType:
+			for _, param := range typ.Parameters {
+				walk(param)
+			}
+			walk(typ.ReturnType)
+		case *TypeScheme:
+			for _, v := range typ.Variables {
+				bound[v.ID] = true
+			}
+			walk(typ.Type)
+		}
+	}
+	walk(t)
+
+	return result
+}
+
+// replaceTypeVariables は型変数を対応表に従って置き換えた型を返す
+func replaceTypeVariables(t Type, mapping map[int]Type) Type {
+	switch typ := t.(type) {
+	case *TypeVariable:
+		if replacement, ok := mapping[typ.ID]; ok {
+			return replacement
+		}
+		return typ
+	case *ArrayType:
+		return &ArrayType{ElementType: replaceTypeVariables(typ.ElementType, mapping)}
+	case *MapType:
+		return &MapType{
+			KeyType:   replaceTypeVariables(typ.KeyType, mapping),
+			ValueType: replaceTypeVariables(typ.ValueType, mapping),
+		}
+	case *FunctionType:
+		params := make([]Type, len(typ.Parameters))
+		for i, param := range typ.Parameters {
+			params[i] = replaceTypeVariables(param, mapping)
+		}
+		return &FunctionType{Parameters: params, ReturnType: replaceTypeVariables(typ.ReturnType, mapping)}
+	default:
+		return t
+	}
+}
+
+// NormalizeType は型変数を出現順にT, U, V, ...と名前を付け直した表示用の型を返す
+// 量化された型は本体の型を返す
+func NormalizeType(t Type) Type {
+	return normalizeTypes(t)[0]
+}
+
+// normalizeTypes は複数の型に共通する型変数が同じ名前になるように、まとめて表示用に正規化する
+func normalizeTypes(types ...Type) []Type {
+	bodies := make([]Type, len(types))
+	for i, t := range types {
+		if scheme, ok := t.(*TypeScheme); ok {
+			t = scheme.Type
+		}
+		bodies[i] = t
+	}
+
+	mapping := map[int]Type{}
+	for _, v := range freeTypeVariables(&FunctionType{Parameters: bodies, ReturnType: &UnknownType{}}) {
+		// 表示専用の型変数は推論中の型変数と衝突しないよう負の番号を使う
+		n := len(mapping)
+		mapping[v.ID] = &TypeVariable{ID: -(n + 1), Name: typeVariableName(n), Class: v.Class}
+	}
+
+	result := make([]Type, len(bodies))
+	for i, t := range bodies {
+		result[i] = replaceTypeVariables(t, mapping)
+	}
+	return result
+}
+
+// Signature は名前と推論された型の組
+type Signature struct {
+	Name string
+	Type Type
+}
+
+func (s Signature) String() string {
+	return s.Name + ": " + s.Type.String()
+}
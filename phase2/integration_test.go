@@ -1,66 +1,250 @@
 package phase2
 
 import (
-	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/nyasuto/pug/phase1"
 )
 
 // TestIntegration_EndToEnd はエンドツーエンドの統合テストを実行する
+// 生成した実行可能ファイルを実際に動かし、終了コードと標準出力を確認する
 func TestIntegration_EndToEnd(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test in short mode")
 	}
-
-	// CI環境では統合テストをスキップ
-	if os.Getenv("CI") != "" {
-		t.Skip("skipping assembly integration tests in CI environment (assembly generation tests provide sufficient coverage)")
-	}
+	requireToolchain(t)
 
 	tests := []struct {
 		name     string
 		input    string
-		expected string
+		exitCode int
+		stdout   string
+		stderr   string // 実行時エラーで終了する場合のメッセージ
 	}{
 		{
 			name:     "simple_return",
 			input:    "return 42;",
-			expected: "42",
+			exitCode: 42,
 		},
 		{
 			name:     "arithmetic",
 			input:    "return 5 + 3 * 2;",
-			expected: "11",
+			exitCode: 11,
 		},
 		{
 			name:     "variables",
 			input:    "let x = 10; let y = 20; return x + y;",
-			expected: "30",
+			exitCode: 30,
 		},
 		{
 			name:     "comparison",
 			input:    "return 5 == 5;",
-			expected: "1",
+			exitCode: 1,
 		},
 		{
 			name:     "complex_expression",
 			input:    "let a = 5; let b = 3; return (a + b) * 2 - 1;",
-			expected: "15",
+			exitCode: 15,
+		},
+		{
+			name:     "while_loop",
+			input:    "let i = 0; let sum = 0; while (i < 5) { i = i + 1; sum = sum + i; } return sum;",
+			exitCode: 15,
+		},
+		{
+			name:     "labeled_break",
+			input:    "let n = 0; outer: for (let i = 0; i < 5; i = i + 1) { for (let j = 0; j < 5; j = j + 1) { if (j == 3) { continue outer; } if (i == 3) { break outer; } n = n + 1; } } return n;",
+			exitCode: 9,
+		},
+		{
+			name:     "no_return",
+			input:    "let x = 1;",
+			exitCode: 0,
+		},
+		{
+			name:     "puts_integers",
+			input:    "puts(0); puts(42, -7); puts(9223372036854775807); puts(-9223372036854775807 - 1);",
+			exitCode: 0,
+			stdout:   "0\n42\n-7\n9223372036854775807\n-9223372036854775808\n",
+		},
+		{
+			name:     "puts_booleans",
+			input:    "let x = 3; puts(true, x > 5, !false && x == 3);",
+			exitCode: 0,
+			stdout:   "true\nfalse\ntrue\n",
+		},
+		{
+			name:     "puts_strings",
+			input:    `let greeting = "Hello, " + "pug!"; puts(greeting, "", "tab\tand \\ backslash"); return len(greeting);`,
+			exitCode: 11,
+			stdout:   "Hello, pug!\n\ntab\tand \\ backslash\n",
+		},
+		{
+			name:     "string_equality",
+			input:    `let a = "ab" + "c"; puts(a == "abc", a != "abc", a == "abd", "" == "", a == "ab");`,
+			exitCode: 0,
+			stdout:   "true\nfalse\nfalse\ntrue\nfalse\n",
+		},
+		{
+			name:     "polymorphic_string_operations",
+			input:    `let eq = fn(a, b) { a == b }; let add = fn(a, b) { a + b }; let same = fn(x, y) { if (eq(x, y)) { "same" } else { "different" } }; puts(eq("ab", "a" + "b"), add("pu", "g"), same("a", "b"), same("c", "c"));`,
+			exitCode: 0,
+			stdout:   "true\npug\ndifferent\nsame\n",
+		},
+		{
+			name:     "if_statement_with_different_branch_types",
+			input:    "let x = 0; if (x < 1) { x = 5; } else { puts(0); } puts(x); return 0;",
+			exitCode: 0,
+			stdout:   "5\n",
+		},
+		{
+			name:     "puts_result_is_null",
+			input:    "let r = puts(1); puts(r); return 0;",
+			exitCode: 0,
+			stdout:   "1\nnull\n",
+		},
+		{
+			name:     "string_building_in_loop",
+			input:    `let s = ""; for (let i = 0; i < 5; i += 1) { s += "ab"; } puts(s); return len(s);`,
+			exitCode: 10,
+			stdout:   "ababababab\n",
+		},
+		{
+			name:     "recursive_fib",
+			input:    "let fib = fn(n) { if (n < 2) { return n; } return fib(n - 1) + fib(n - 2); }; puts(fib(20)); return fib(10);",
+			exitCode: 55,
+			stdout:   "6765\n",
+		},
+		{
+			name:     "stack_arguments",
+			input:    "let sum8 = fn(a, b, c, d, e, f, g, h) { a + 2 * b + 3 * c + 4 * d + 5 * e + 6 * f + 7 * g + 8 * h }; puts(sum8(1, 2, 3, 4, 5, 6, 7, 8), 1 + sum8(8, 7, 6, 5, 4, 3, 2, 1));",
+			exitCode: 0,
+			stdout:   "204\n121\n",
+		},
+		{
+			name:     "mutual_recursion",
+			input:    "let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } }; let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }; puts(isEven(10), isOdd(7), isEven(3));",
+			exitCode: 0,
+			stdout:   "true\ntrue\nfalse\n",
+		},
+		{
+			name:     "function_values",
+			input:    "let twice = fn(f, x) { f(f(x)) }; let inc = fn(x) { x + 1 }; let square = inc; puts(twice(inc, 5), square(1), fn(x) { x * x }(7));",
+			exitCode: 0,
+			stdout:   "7\n2\n49\n",
+		},
+		{
+			name:     "closure_counters",
+			input:    "let makeCounter = fn(initial) { let count = initial; return fn() { count = count + 1; return count; }; }; let c1 = makeCounter(0); let c2 = makeCounter(100); puts(c1(), c1(), c2(), c1(), c2());",
+			exitCode: 0,
+			stdout:   "1\n2\n101\n3\n102\n",
+		},
+		{
+			name:     "closure_outside_for_scope",
+			input:    "let f = fn() { let i = 100; let g = fn() { i }; for (let i = 0; i < 3; i += 1) {} g() }; puts(f());",
+			exitCode: 0,
+			stdout:   "100\n",
+		},
+		{
+			name:     "closure_capturing_for_variable",
+			input:    "let f = fn() { let n = 7; let h = fn() { 0 }; for (let n = 0; n < 2; n += 1) { h = fn() { n }; } puts(h(), n); }; f();",
+			exitCode: 0,
+			stdout:   "2\n7\n",
+		},
+		{
+			name:     "closure_cells_per_for_execution",
+			input:    "let gs = []; let j = 0; while (j < 2) { for (let x = j; x < j + 1; x += 1) { let y = x * 10; gs = push(gs, fn() { y }); } j += 1; } puts(gs[0](), gs[1]());",
+			exitCode: 0,
+			stdout:   "0\n10\n",
+		},
+		{
+			name:     "closure_adders_and_compose",
+			input:    "let makeAdder = fn(x) { fn(y) { x + y } }; let makeMultiplier = fn(factor) { fn(n) { n * factor } }; let compose = fn(f, g) { fn(x) { f(g(x)) } }; let add5 = makeAdder(5); let double = makeMultiplier(2); puts(add5(3), makeAdder(10)(3), compose(double, add5)(3));",
+			exitCode: 0,
+			stdout:   "8\n13\n16\n",
+		},
+		{
+			name:     "closures_share_variables",
+			input:    "let total = 0; let add = fn(x) { total += x; }; let make = fn() { let n = 0; let inc = fn() { n += 1; }; let get = fn() { n }; inc(); inc(); get() }; add(3); add(4); puts(total); return make();",
+			exitCode: 2,
+			stdout:   "7\n",
+		},
+		{
+			name:     "nested_and_recursive_closures",
+			input:    "let outer = fn(a) { fn(b) { fn(c) { a * 100 + b * 10 + c } } }; let makeFact = fn(k) { let fact = fn(n) { if (n < 2) { k } else { n * fact(n - 1) } }; fact }; puts(outer(1)(2)(3), makeFact(2)(5));",
+			exitCode: 0,
+			stdout:   "123\n240\n",
+		},
+		{
+			name:     "array_operations",
+			input:    "let array_sum = fn(arr) { let sum = 0; let i = 0; while (i < len(arr)) { sum = sum + arr[i]; i = i + 1; } return sum; }; let numbers = [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]; let result = array_sum(numbers); puts(result); return result;",
+			exitCode: 55,
+			stdout:   "55\n",
+		},
+		{
+			name:     "array_builtins",
+			input:    `let a = push([1, 2], 3); let b = push(rest(a), 4); b[0] = 42; let words = ["pug", "dog"]; puts(len(a), first(a), last(a), len(b), b[0], a[0], last(words), len(rest([])));`,
+			exitCode: 0,
+			stdout:   "3\n1\n3\n3\n42\n1\ndog\n0\n",
+		},
+		{
+			name:     "first_of_empty_array",
+			input:    "let a = rest([1]); puts(len(a)); puts(last(a) == 0); return 0;",
+			exitCode: 1,
+			stdout:   "0\n",
+			stderr:   "pug: first or last of an empty array\n",
+		},
+		{
+			name:     "index_out_of_range",
+			input:    "let a = [1, 2, 3]; puts(a[2]); puts(a[3]); return 0;",
+			exitCode: 1,
+			stdout:   "3\n",
+			stderr:   "pug: index out of range\n",
+		},
+		{
+			name:     "division_by_zero",
+			input:    "let zero = fn() { 0 }; puts(7 / 2); puts(7 / zero()); return 0;",
+			exitCode: 1,
+			stdout:   "3\n",
+			stderr:   "pug: division by zero\n",
+		},
+		{
+			name:     "modulo_by_zero",
+			input:    "let zero = fn() { 0 }; puts(7 % 2); puts(7 % zero()); return 0;",
+			exitCode: 1,
+			stdout:   "1\n",
+			stderr:   "pug: modulo by zero\n",
+		},
+		{
+			name:     "garbage_collection",
+			input:    `let makeCounter = fn() { let count = 0; fn() { count += 1; count } }; let counter = makeCounter(); let names = ["keep", "me"]; let base = []; for (let i = 0; i < 1000; i += 1) { base = push(base, i); } let total = 0; for (let i = 0; i < 200000; i += 1) { let grown = push(base, i); total += grown[1000] - grown[999]; let s = "garbage" + "!"; if (i % 50000 == 0) { counter(); } } puts(total, counter(), len(base), base[999], names[0] + names[1]);`,
+			exitCode: 0,
+			stdout:   "19800100000\n5\n1000\n999\nkeepme\n",
+		},
+		{
+			name:     "many_locals_and_loop_scopes",
+			input:    "let keep = 7; let wide = fn(n) { " + letStatements(40) + "n + v1 + v39 }; let i = 100; let total = 0; for (let i = 0; i < 3; i += 1) { let x = wide(i); total += x; } puts(keep, i, total);",
+			exitCode: 0,
+			stdout:   "7\n100\n123\n",
+		},
+		{
+			name:     "return_from_loop",
+			input:    "let firstOver = fn(limit) { let i = 1; while (true) { if (i * i > limit) { return i; } i += 1; } }; return firstOver(50);",
+			exitCode: 8,
+		},
+		{
+			name:     "puts_in_loop",
+			input:    "for (let i = 1; i <= 3; i += 1) { puts(i * i); } return 4;",
+			exitCode: 4,
+			stdout:   "1\n4\n9\n",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// テンポラリディレクトリを作成
-			tmpDir, err := os.MkdirTemp("", "pug_test_"+tt.name)
-			if err != nil {
-				t.Fatalf("failed to create temp dir: %v", err)
-			}
-			defer os.RemoveAll(tmpDir)
-
 			// アセンブリコードを生成
 			program := parseProgram(t, tt.input)
 			cg := NewCodeGenerator()
@@ -69,38 +253,133 @@ func TestIntegration_EndToEnd(t *testing.T) {
 				t.Fatalf("code generation failed: %v", err)
 			}
 
-			// アセンブリファイルに書き込み
-			asmFile := filepath.Join(tmpDir, tt.name+".s")
-			err = os.WriteFile(asmFile, []byte(asmCode), 0644)
-			if err != nil {
-				t.Fatalf("failed to write assembly file: %v", err)
-			}
-
 			// アセンブルとリンクを実行
-			execFile := filepath.Join(tmpDir, tt.name)
-			err = assembleAndLink(asmFile, execFile)
-			if err != nil {
+			execFile := filepath.Join(t.TempDir(), tt.name)
+			if err := cg.AssembleAndLink(asmCode, execFile); err != nil {
 				t.Logf("Assembly code:\n%s", asmCode)
 				t.Fatalf("failed to assemble and link: %v", err)
 			}
 
 			// 実行可能ファイルを実行
-			output, exitCode, err := runExecutable(execFile)
+			stdout, stderr, exitCode, err := runExecutable(execFile)
 			if err != nil {
 				t.Fatalf("failed to run executable: %v", err)
 			}
 
-			// 戻り値を確認（終了コードとして返される）
-			expectedCode := tt.expected
-			actualCode := fmt.Sprintf("%d", exitCode)
+			// 戻り値は終了コードとして返される
+			if exitCode != tt.exitCode {
+				t.Errorf("expected exit code %d, but got %d. Output: %s", tt.exitCode, exitCode, stdout)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("expected stdout %q, but got %q", tt.stdout, stdout)
+			}
+			if stderr != tt.stderr {
+				t.Errorf("expected stderr %q, but got %q", tt.stderr, stderr)
+			}
+		})
+	}
+}
+
+// TestIntegration_InterpreterParity はインタプリタとコンパイルしたプログラムの実行結果の対応をテストする
+// 0での除算はどちらも同じエラーになり、空の配列に対するrest・first・lastは（arrays.goに記した通り）異なる
+func TestIntegration_InterpreterParity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	requireToolchain(t)
+
+	tests := []struct {
+		name        string
+		expression  string // インタプリタで評価する式
+		interpreted string // インタプリタの結果
+		program     string // 同じ式をコンパイルして実行するプログラム
+		exitCode    int
+		stdout      string
+		stderr      string
+	}{
+		{
+			name:        "division_by_zero",
+			expression:  "7 / 0",
+			interpreted: "ERROR: division by zero",
+			program:     "puts(7 / 0); return 0;",
+			exitCode:    1,
+			stderr:      "pug: division by zero\n",
+		},
+		{
+			name:        "modulo_by_zero",
+			expression:  "7 % 0",
+			interpreted: "ERROR: modulo by zero",
+			program:     "puts(7 % 0); return 0;",
+			exitCode:    1,
+			stderr:      "pug: modulo by zero\n",
+		},
+		{
+			// 相違点: 空の配列を返す
+			name:        "rest_of_empty_array",
+			expression:  "rest(rest([1]))",
+			interpreted: "null",
+			program:     "puts(len(rest(rest([1])))); return 0;",
+			stdout:      "0\n",
+		},
+		{
+			// 相違点: エラーで終了する
+			name:        "first_of_empty_array",
+			expression:  "first(rest([1]))",
+			interpreted: "null",
+			program:     "puts(first(rest([1]))); return 0;",
+			exitCode:    1,
+			stderr:      "pug: first or last of an empty array\n",
+		},
+		{
+			// 相違点: エラーで終了する
+			name:        "last_of_empty_array",
+			expression:  "last(rest([1]))",
+			interpreted: "null",
+			program:     "puts(last(rest([1]))); return 0;",
+			exitCode:    1,
+			stderr:      "pug: first or last of an empty array\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := phase1.Eval(parseProgram(t, tt.expression), phase1.NewEnvironment())
+			if result == nil || result.Inspect() != tt.interpreted {
+				t.Errorf("interpreter: expected %q, got %v", tt.interpreted, result)
+			}
 
-			if actualCode != expectedCode {
-				t.Errorf("expected exit code %s, but got %s. Output: %s", expectedCode, actualCode, output)
+			cg := NewCodeGenerator()
+			asmCode, err := cg.Generate(parseProgram(t, tt.program))
+			if err != nil {
+				t.Fatalf("code generation failed: %v", err)
+			}
+			execFile := filepath.Join(t.TempDir(), tt.name)
+			if err := cg.AssembleAndLink(asmCode, execFile); err != nil {
+				t.Fatalf("failed to assemble and link: %v", err)
+			}
+			stdout, stderr, exitCode, err := runExecutable(execFile)
+			if err != nil {
+				t.Fatalf("failed to run executable: %v", err)
+			}
+			if exitCode != tt.exitCode || stdout != tt.stdout || stderr != tt.stderr {
+				t.Errorf("compiled: expected exit code %d, stdout %q, stderr %q; got %d, %q, %q",
+					tt.exitCode, tt.stdout, tt.stderr, exitCode, stdout, stderr)
 			}
 		})
 	}
 }
 
+// requireToolchain はアセンブラとリンカが見つからない場合にテストをスキップする
+func requireToolchain(t *testing.T) {
+	t.Helper()
+	target := DefaultTarget()
+	for _, tool := range []string{target.Assembler[0], target.Linker[0]} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("skipping: %s is not available (%v)", tool, err)
+		}
+	}
+}
+
 // TestIntegration_AssemblyGeneration はアセンブリ生成の詳細をテストする
 func TestIntegration_AssemblyGeneration(t *testing.T) {
 	tests := []struct {
@@ -114,14 +393,14 @@ func TestIntegration_AssemblyGeneration(t *testing.T) {
 			input: "return 1;",
 			shouldContain: []string{
 				"# pug compiler generated assembly",
-				".section __DATA,__data",
-				".section __TEXT,__text,regular,pure_instructions",
-				".globl _main",
-				"_main:",
+				".data",
+				".text",
+				".globl main",
+				"main:",
 				"pushq %rbp",
 				"movq %rsp, %rbp",
-				"subq $256, %rsp",
-				"movq $1, %rax",
+				"movq $1, %rcx",
+				"movq %rcx, %rax",
 				"movq %rbp, %rsp",
 				"popq %rbp",
 				"ret",
@@ -135,26 +414,24 @@ func TestIntegration_AssemblyGeneration(t *testing.T) {
 			name:  "variable_management",
 			input: "let x = 42; let y = 24; return x + y;",
 			shouldContain: []string{
-				"movq $42, %rax",
-				"movq %rax, -8(%rbp)",
+				"movq $42, %rcx",
 				"# let x = ...",
-				"movq $24, %rax",
-				"movq %rax, -16(%rbp)",
+				"movq $24, %rsi",
 				"# let y = ...",
-				"movq -8(%rbp), %rax",
-				"# load variable x",
-				"movq -16(%rbp), %rax",
-				"# load variable y",
-				"addq %rbx, %rax",
+				"addq %rsi, %rcx",
+			},
+			shouldNotContain: []string{
+				"(%rbp), %rcx",
+				"pushq %rax",
 			},
 		},
 		{
 			name:  "arithmetic_operations",
 			input: "return 10 * 5 / 2;",
 			shouldContain: []string{
-				"imulq %rbx, %rax",
+				"imulq $5, %rcx",
 				"cqto",
-				"idivq %rbx",
+				"idivq %rsi",
 			},
 		},
 	}
@@ -162,7 +439,7 @@ func TestIntegration_AssemblyGeneration(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			program := parseProgram(t, tt.input)
-			cg := NewCodeGenerator()
+			cg := NewCodeGeneratorForTarget(TargetLinux)
 			asmCode, err := cg.Generate(program)
 			if err != nil {
 				t.Fatalf("code generation failed: %v", err)
@@ -223,49 +500,20 @@ func TestIntegration_Performance(t *testing.T) {
 	}
 }
 
-// assembleAndLink はアセンブリファイルをアセンブルしてリンクする
-func assembleAndLink(asmFile, outputFile string) error {
-	// Linux/GNU環境では統合テストをスキップ
-	// アセンブリ生成テストのみで十分な検証
-	if os.Getenv("CI") != "" {
-		return fmt.Errorf("assembly integration tests are skipped in CI environment (assembly generation tests cover the core functionality)")
-	}
-
-	// macOSでの実装
-	// まずアセンブルしてオブジェクトファイルを作成
-	objFile := strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".o"
-
-	// macOSでは as コマンドに -64 フラグは不要
-	asCmd := exec.Command("as", "-arch", "x86_64", "-o", objFile, asmFile)
-	asOutput, err := asCmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("assembly failed: %v\nOutput: %s", err, string(asOutput))
-	}
-
-	// リンクして実行可能ファイルを作成
-	ldCmd := exec.Command("ld", "-o", outputFile, "-lSystem", "-syslibroot", "/Library/Developer/CommandLineTools/SDKs/MacOSX.sdk", "-e", "_main", "-arch", "x86_64", objFile)
-	ldOutput, err := ldCmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("linking failed: %v\nOutput: %s", err, string(ldOutput))
-	}
-
-	return nil
-}
-
 // runExecutable は実行可能ファイルを実行して結果を取得する
-func runExecutable(execFile string) (output string, exitCode int, err error) {
+func runExecutable(execFile string) (output, errorOutput string, exitCode int, err error) {
 	cmd := exec.Command(execFile)
-	outputBytes, err := cmd.CombinedOutput()
+	outputBytes, err := cmd.Output()
 
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			exitCode = exitError.ExitCode()
-			return string(outputBytes), exitCode, nil
+			return string(outputBytes), string(exitError.Stderr), exitCode, nil
 		}
-		return "", -1, err
+		return "", "", -1, err
 	}
 
-	return string(outputBytes), 0, nil
+	return string(outputBytes), "", 0, nil
 }
 
 // BenchmarkIntegration_CodeGeneration はコード生成のベンチマークを実行する
@@ -302,7 +550,13 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 			name:        "undefined_variable",
 			input:       "return undefined_var;",
 			expectError: true,
-			errorMsg:    "undefined variable",
+			errorMsg:    "identifier not found: undefined_var",
+		},
+		{
+			name:        "type_error",
+			input:       `let i = 1; let xs = [i, "s" + "t"]; puts(len(xs));`,
+			expectError: true,
+			errorMsg:    "cannot compile: program has type errors",
 		},
 		{
 			name:        "valid_program",
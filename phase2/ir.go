@@ -0,0 +1,335 @@
+package phase2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 関数の本体は、数に制限のない仮想レジスタを使う中間表現の命令列に変換してから、
+// 線形走査法で仮想レジスタをx86-64のレジスタに割り当ててアセンブリを出力する（regalloc.go、asm.go）
+// 変数は仮想レジスタに置き、内側の関数に捕捉される変数だけがヒープのセルに置かれる
+
+// vreg は仮想レジスタ（0は仮想レジスタがないことを表す）
+type vreg int
+
+// opcode は中間表現の命令の種類
+type opcode int
+
+// 中間表現の命令
+// 二項演算・比較・分岐の右辺（b）がない場合は即値（imm）を使う
+const (
+	opComment     opcode = iota // アセンブリにコメント（sym）を残す
+	opEntry                     // 関数の入口で引数を args に、クロージャのレコードを dst に受け取る（使わない引数は0）
+	opConst                     // dst = imm
+	opAddress                   // dst = シンボル sym のアドレス
+	opMove                      // dst = a
+	opBinary                    // dst = a cond b（condは add・sub・imul）
+	opDivide                    // dst = a / b（condが "%" なら剰余、bが0ならエラーのルーチン sym に飛んで終了する）
+	opNegate                    // dst = -a
+	opCompare                   // dst = a cond b ? 1 : 0（condは条件コード）
+	opLoad                      // dst = [a + index*8 + imm]（indexがなければ [a + imm]）
+	opStore                     // [a + index*8 + imm] = b
+	opLabel                     // ラベル sym
+	opJump                      // sym にジャンプする
+	opBranch                    // a cond b が成り立てば sym にジャンプする
+	opBoundsCheck               // 符号なしで b >= [a] ならエラーのルーチン sym に飛んで終了する
+	opCall                      // dst = sym(args...)（symが空ならクロージャ a を間接的に呼び出す）
+	opReturn                    // a を戻り値として関数から戻る
+)
+
+// instruction は中間表現の1つの命令
+type instruction struct {
+	op    opcode
+	dst   vreg   // 書き込む仮想レジスタ
+	a, b  vreg   // 読み取る仮想レジスタ
+	index vreg   // ロード・ストアの添字（8倍してaに加える）
+	args  []vreg // 呼び出しの引数、または入口で受け取る引数
+	imm   int64  // 即値・オフセット
+	cond  string // 演算の種類または条件コード
+	sym   string // ラベル・シンボル名・コメント
+}
+
+// uses は命令が読み取る仮想レジスタを返す
+func (in *instruction) uses() []vreg {
+	var regs []vreg
+	for _, r := range []vreg{in.a, in.b, in.index} {
+		if r != 0 {
+			regs = append(regs, r)
+		}
+	}
+	if in.op == opCall {
+		regs = append(regs, in.args...)
+	}
+	return regs
+}
+
+// defs は命令が書き込む仮想レジスタを返す
+func (in *instruction) defs() []vreg {
+	var regs []vreg
+	if in.op == opEntry {
+		for _, r := range in.args {
+			if r != 0 {
+				regs = append(regs, r)
+			}
+		}
+	}
+	if in.dst != 0 {
+		regs = append(regs, in.dst)
+	}
+	return regs
+}
+
+// String は命令を読みやすい形式で返す（テストやデバッグ用）
+func (in *instruction) String() string {
+	operand := func(r vreg) string {
+		if r == 0 {
+			return fmt.Sprintf("$%d", in.imm)
+		}
+		return fmt.Sprintf("v%d", r)
+	}
+	address := func() string {
+		if in.index != 0 {
+			return fmt.Sprintf("[v%d + v%d*8 + %d]", in.a, in.index, in.imm)
+		}
+		return fmt.Sprintf("[v%d + %d]", in.a, in.imm)
+	}
+	list := func(regs []vreg) string {
+		names := make([]string, len(regs))
+		for i, r := range regs {
+			names[i] = fmt.Sprintf("v%d", r)
+		}
+		return strings.Join(names, ", ")
+	}
+
+	switch in.op {
+	case opComment:
+		return "# " + in.sym
+	case opEntry:
+		return fmt.Sprintf("entry(%s) closure=v%d", list(in.args), in.dst)
+	case opConst:
+		return fmt.Sprintf("v%d = $%d", in.dst, in.imm)
+	case opAddress:
+		return fmt.Sprintf("v%d = &%s", in.dst, in.sym)
+	case opMove:
+		return fmt.Sprintf("v%d = v%d", in.dst, in.a)
+	case opBinary, opDivide, opCompare:
+		return fmt.Sprintf("v%d = v%d %s %s", in.dst, in.a, in.cond, operand(in.b))
+	case opNegate:
+		return fmt.Sprintf("v%d = -v%d", in.dst, in.a)
+	case opLoad:
+		return fmt.Sprintf("v%d = %s", in.dst, address())
+	case opStore:
+		return fmt.Sprintf("%s = v%d", address(), in.b)
+	case opLabel:
+		return in.sym + ":"
+	case opJump:
+		return "jump " + in.sym
+	case opBranch:
+		return fmt.Sprintf("if v%d %s %s jump %s", in.a, in.cond, operand(in.b), in.sym)
+	case opBoundsCheck:
+		return fmt.Sprintf("check v%d < len(v%d) else %s", in.b, in.a, in.sym)
+	case opCall:
+		callee := in.sym
+		if callee == "" {
+			callee = fmt.Sprintf("*v%d", in.a)
+		}
+		if in.dst == 0 {
+			return fmt.Sprintf("call %s(%s)", callee, list(in.args))
+		}
+		return fmt.Sprintf("v%d = call %s(%s)", in.dst, callee, list(in.args))
+	case opReturn:
+		return fmt.Sprintf("return v%d", in.a)
+	}
+	return fmt.Sprintf("<opcode %d>", in.op)
+}
+
+// irFunction は中間表現に変換中または変換済みの関数
+type irFunction struct {
+	name         string
+	instructions []*instruction
+	vregs        int // 使用した仮想レジスタの数（仮想レジスタは1から数える）
+}
+
+// comparisonConditions は比較演算子とx86-64の条件コードの対応
+var comparisonConditions = map[string]string{
+	"==": "e",
+	"!=": "ne",
+	"<":  "l",
+	">":  "g",
+	"<=": "le",
+	">=": "ge",
+}
+
+// negatedConditions は条件コードと、その条件が成り立たないことを表す条件コードの対応
+var negatedConditions = map[string]string{
+	"e":  "ne",
+	"ne": "e",
+	"l":  "ge",
+	"ge": "l",
+	"g":  "le",
+	"le": "g",
+}
+
+// newVreg は新しい仮想レジスタを返す
+func (cg *CodeGenerator) newVreg() vreg {
+	cg.code.vregs++
+	return vreg(cg.code.vregs)
+}
+
+// add は命令を現在の関数の末尾に追加する
+func (cg *CodeGenerator) add(in *instruction) {
+	cg.code.instructions = append(cg.code.instructions, in)
+}
+
+// comment はアセンブリに残すコメントを追加する
+func (cg *CodeGenerator) comment(format string, args ...interface{}) {
+	cg.add(&instruction{op: opComment, sym: fmt.Sprintf(format, args...)})
+}
+
+// constant は即値を新しい仮想レジスタに置く
+func (cg *CodeGenerator) constant(value int64) vreg {
+	dst := cg.newVreg()
+	cg.add(&instruction{op: opConst, dst: dst, imm: value})
+	return dst
+}
+
+// address はシンボルのアドレスを新しい仮想レジスタに置く
+func (cg *CodeGenerator) address(symbol string) vreg {
+	dst := cg.newVreg()
+	cg.add(&instruction{op: opAddress, dst: dst, sym: symbol})
+	return dst
+}
+
+// move は仮想レジスタの値を別の仮想レジスタにコピーする
+func (cg *CodeGenerator) move(dst, src vreg) {
+	cg.add(&instruction{op: opMove, dst: dst, a: src})
+}
+
+// copyOf は仮想レジスタの値を新しい仮想レジスタにコピーする
+func (cg *CodeGenerator) copyOf(src vreg) vreg {
+	dst := cg.newVreg()
+	cg.move(dst, src)
+	return dst
+}
+
+// load はメモリの値を新しい仮想レジスタに読み込む（indexが0でなければ8倍してbaseに加える）
+func (cg *CodeGenerator) load(base, index vreg, offset int64) vreg {
+	dst := cg.newVreg()
+	cg.add(&instruction{op: opLoad, dst: dst, a: base, index: index, imm: offset})
+	return dst
+}
+
+// store は仮想レジスタの値をメモリに書き込む（indexが0でなければ8倍してbaseに加える）
+func (cg *CodeGenerator) store(base, index vreg, offset int64, value vreg) {
+	cg.add(&instruction{op: opStore, a: base, b: value, index: index, imm: offset})
+}
+
+// label はラベルを置く
+func (cg *CodeGenerator) label(name string) {
+	cg.add(&instruction{op: opLabel, sym: name})
+}
+
+// jump は無条件のジャンプを追加する
+func (cg *CodeGenerator) jump(label string) {
+	cg.add(&instruction{op: opJump, sym: label})
+}
+
+// branchIfZero は値が0（偽）ならラベルにジャンプする
+func (cg *CodeGenerator) branchIfZero(value vreg, label string) {
+	cg.add(&instruction{op: opBranch, a: value, cond: "e", imm: 0, sym: label})
+}
+
+// branchIfNonZero は値が0以外（真）ならラベルにジャンプする
+func (cg *CodeGenerator) branchIfNonZero(value vreg, label string) {
+	cg.add(&instruction{op: opBranch, a: value, cond: "ne", imm: 0, sym: label})
+}
+
+// boundsCheck は添字が符号なしで配列の要素数以上なら、エラーのルーチンに飛んで終了する命令を追加する
+func (cg *CodeGenerator) boundsCheck(array, index vreg, routine string) {
+	cg.useRuntime(routine)
+	cg.add(&instruction{op: opBoundsCheck, a: array, b: index, sym: routine})
+}
+
+// call は関数を呼び出し、戻り値を新しい仮想レジスタに置く
+func (cg *CodeGenerator) call(symbol string, args ...vreg) vreg {
+	dst := cg.newVreg()
+	cg.add(&instruction{op: opCall, dst: dst, sym: symbol, args: args})
+	return dst
+}
+
+// fitsImmediate は値が命令の32ビットの即値として符号拡張して使えるかどうかを返す
+func fitsImmediate(value int64) bool {
+	return value == int64(int32(value))
+}
+
+// isPure は命令が結果の仮想レジスタへの書き込み以外に効果を持たないかどうかを返す
+// 除算はゼロ除算で終了しうるため含めない
+func (in *instruction) isPure() bool {
+	switch in.op {
+	case opConst, opAddress, opMove, opBinary, opNegate, opCompare, opLoad:
+		return true
+	}
+	return false
+}
+
+// simplify はレジスタの割り当ての前に、到達しない命令、使われない値の計算、計算した値を変数に移すだけのコピーを取り除く
+func (fn *irFunction) simplify() {
+	reachable := fn.instructions[:0]
+	unreachable := false
+	for _, in := range fn.instructions {
+		if in.op == opLabel {
+			unreachable = false
+		}
+		if !unreachable {
+			reachable = append(reachable, in)
+		}
+		if in.op == opReturn || in.op == opJump {
+			unreachable = true
+		}
+	}
+	fn.instructions = reachable
+
+	for changed := true; changed; {
+		changed = false
+		uses := make([]int, fn.vregs+1)
+		defs := make([]int, fn.vregs+1)
+		for _, in := range fn.instructions {
+			for _, r := range in.uses() {
+				uses[r]++
+			}
+			for _, r := range in.defs() {
+				defs[r]++
+			}
+		}
+
+		code := fn.instructions[:0]
+		for i := 0; i < len(fn.instructions); i++ {
+			in := fn.instructions[i]
+			if in.op == opEntry {
+				for j, r := range in.args {
+					if r != 0 && uses[r] == 0 {
+						in.args[j] = 0
+					}
+				}
+				if uses[in.dst] == 0 {
+					in.dst = 0
+				}
+			}
+			if in.isPure() && uses[in.dst] == 0 {
+				changed = true
+				continue
+			}
+			// t = ...; v = t で、tがこのコピーでしか使われなければ v = ... とする
+			if i+1 < len(fn.instructions) && in.dst != 0 && in.op != opEntry {
+				next := fn.instructions[i+1]
+				if next.op == opMove && next.a == in.dst && uses[in.dst] == 1 && defs[in.dst] == 1 {
+					in.dst = next.dst
+					i++
+					changed = true
+				}
+			}
+			code = append(code, in)
+		}
+		fn.instructions = code
+	}
+}
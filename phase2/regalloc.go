@@ -0,0 +1,328 @@
+package phase2
+
+import (
+	"container/heap"
+	"math/bits"
+	"sort"
+)
+
+// 仮想レジスタは線形走査法（Poletto & Sarkar）でx86-64のレジスタに割り当てる
+// RAX・RDX（戻り値と除算）、R10・R11（クロージャのレコードと一時的な値）は割り当てず、命令の出力に使う
+
+// callerSavedRegisters は関数の呼び出しで値が壊れる、割り当てに使うレジスタ（呼び出しをまたがない値に使う）
+var callerSavedRegisters = []string{"%rcx", "%rsi", "%rdi", "%r8", "%r9"}
+
+// calleeSavedRegisters は呼び出された関数が保存する、割り当てに使うレジスタ（使う場合はフレームに保存する）
+var calleeSavedRegisters = []string{"%rbx", "%r12", "%r13", "%r14", "%r15"}
+
+// location は仮想レジスタの割り当て先（レジスタ名、またはスピルしたスロットの番号）
+type location struct {
+	register string
+	slot     int // registerが空のとき、スピルしたスロット（0から数える）
+}
+
+// allocation は関数の仮想レジスタの割り当て結果
+type allocation struct {
+	locations   []location // 仮想レジスタの番号で引く
+	spillSlots  int        // スピルに使うスロットの数
+	calleeSaved []string   // 使用した（入口で保存し、出口で復元する）callee-savedレジスタ
+}
+
+// interval は仮想レジスタが値を保持する必要のある区間
+// 命令iの読み取りは位置2i、書き込みは位置2i+1とし、読み取りが終わったレジスタを同じ命令の結果に再利用できるようにする
+type interval struct {
+	reg        vreg
+	start, end int
+	crossCall  bool // 区間の途中に関数の呼び出しがある
+}
+
+// spilledIntervals はスロットにスピルした区間を終わりの位置の順に取り出すヒープ
+type spilledIntervals []*interval
+
+func (h spilledIntervals) Len() int            { return len(h) }
+func (h spilledIntervals) Less(i, j int) bool  { return h[i].end < h[j].end }
+func (h spilledIntervals) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *spilledIntervals) Push(x interface{}) { *h = append(*h, x.(*interval)) }
+func (h *spilledIntervals) Pop() interface{} {
+	old := *h
+	iv := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return iv
+}
+
+// bitSet は仮想レジスタの集合
+type bitSet []uint64
+
+func newBitSet(n int) bitSet {
+	return make(bitSet, (n+63)/64)
+}
+
+func (s bitSet) add(r vreg)           { s[r/64] |= 1 << (uint(r) % 64) }
+func (s bitSet) remove(r vreg)        { s[r/64] &^= 1 << (uint(r) % 64) }
+func (s bitSet) contains(r vreg) bool { return s[r/64]&(1<<(uint(r)%64)) != 0 }
+
+// forEach は集合の要素を昇順にたどる
+func (s bitSet) forEach(visit func(vreg)) {
+	for i, word := range s {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			visit(vreg(i*64 + bit))
+			word &^= 1 << uint(bit)
+		}
+	}
+}
+
+// irBlock は中間表現の基本ブロック（命令の番号の範囲 [first, last]）
+type irBlock struct {
+	first, last int
+	successors  []int
+	liveIn      bitSet
+	liveOut     bitSet
+}
+
+// splitBlocks は命令列をラベルとジャンプの位置で基本ブロックに分ける
+func splitBlocks(code []*instruction) []*irBlock {
+	var blocks []*irBlock
+	labels := map[string]int{}
+	start := 0
+	for i, in := range code {
+		if in.op == opLabel && i > start {
+			blocks = append(blocks, &irBlock{first: start, last: i - 1})
+			start = i
+		}
+		if in.op == opLabel {
+			labels[in.sym] = len(blocks)
+		}
+		switch in.op {
+		case opJump, opBranch, opReturn:
+			blocks = append(blocks, &irBlock{first: start, last: i})
+			start = i + 1
+		}
+	}
+	if start < len(code) {
+		blocks = append(blocks, &irBlock{first: start, last: len(code) - 1})
+	}
+
+	for i, block := range blocks {
+		last := code[block.last]
+		switch last.op {
+		case opReturn:
+		case opJump:
+			block.successors = []int{labels[last.sym]}
+		case opBranch:
+			block.successors = []int{labels[last.sym]}
+			if i+1 < len(blocks) {
+				block.successors = append(block.successors, i+1)
+			}
+		default:
+			if i+1 < len(blocks) {
+				block.successors = []int{i + 1}
+			}
+		}
+	}
+	return blocks
+}
+
+// analyzeLiveness は各ブロックの入口と出口で生きている仮想レジスタを求める（後ろ向きのデータフロー解析）
+func analyzeLiveness(fn *irFunction, blocks []*irBlock) {
+	n := fn.vregs + 1
+	uses := make([]bitSet, len(blocks))
+	defs := make([]bitSet, len(blocks))
+	for i, block := range blocks {
+		uses[i], defs[i] = newBitSet(n), newBitSet(n)
+		for j := block.first; j <= block.last; j++ {
+			in := fn.instructions[j]
+			for _, r := range in.uses() {
+				if !defs[i].contains(r) {
+					uses[i].add(r)
+				}
+			}
+			for _, r := range in.defs() {
+				defs[i].add(r)
+			}
+		}
+		block.liveIn, block.liveOut = newBitSet(n), newBitSet(n)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(blocks) - 1; i >= 0; i-- {
+			block := blocks[i]
+			for _, s := range block.successors {
+				for w, word := range blocks[s].liveIn {
+					block.liveOut[w] |= word
+				}
+			}
+			for w := range block.liveIn {
+				word := uses[i][w] | (block.liveOut[w] &^ defs[i][w])
+				if word != block.liveIn[w] {
+					block.liveIn[w] = word
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// buildIntervals は各仮想レジスタが生きている位置をすべて含む区間を求める
+// 区間は途中の空白を持たない1つの範囲とし、ループの先頭で生きている値はループの末尾まで延びる
+func buildIntervals(fn *irFunction, blocks []*irBlock) []*interval {
+	intervals := make([]*interval, fn.vregs+1)
+	extend := func(r vreg, position int) {
+		if intervals[r] == nil {
+			intervals[r] = &interval{reg: r, start: position, end: position}
+			return
+		}
+		if position < intervals[r].start {
+			intervals[r].start = position
+		}
+		if position > intervals[r].end {
+			intervals[r].end = position
+		}
+	}
+
+	var calls []int
+	for _, block := range blocks {
+		block.liveIn.forEach(func(r vreg) { extend(r, 2*block.first) })
+		block.liveOut.forEach(func(r vreg) { extend(r, 2*block.last+1) })
+		for i := block.first; i <= block.last; i++ {
+			in := fn.instructions[i]
+			for _, r := range in.uses() {
+				extend(r, 2*i)
+			}
+			for _, r := range in.defs() {
+				extend(r, 2*i+1)
+			}
+			if in.op == opCall {
+				calls = append(calls, i)
+			}
+		}
+	}
+
+	var result []*interval
+	for _, iv := range intervals {
+		if iv == nil {
+			continue
+		}
+		// 呼び出しの引数（位置2i）と戻り値（位置2i+1）は呼び出しをまたがない
+		next := sort.SearchInts(calls, iv.start/2)
+		for ; next < len(calls); next++ {
+			if 2*calls[next] <= iv.start {
+				continue
+			}
+			iv.crossCall = 2*calls[next]+1 < iv.end
+			break
+		}
+		result = append(result, iv)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].start < result[j].start })
+	return result
+}
+
+// allocateRegisters は関数の仮想レジスタをレジスタまたはスピルのスロットに割り当てる
+func allocateRegisters(fn *irFunction) *allocation {
+	blocks := splitBlocks(fn.instructions)
+	analyzeLiveness(fn, blocks)
+	intervals := buildIntervals(fn, blocks)
+
+	result := &allocation{locations: make([]location, fn.vregs+1)}
+	owner := map[string]*interval{} // レジスタと、それを使っている区間
+	usedCalleeSaved := map[string]bool{}
+	var active []*interval         // レジスタに割り当てた区間（終わりの位置の順）
+	spilled := &spilledIntervals{} // スロットを使っている区間
+	var freeSlots []int            // 使い終わったスロット
+	var slotEnds []int             // 各スロットを最後に使った区間の終わりの位置
+
+	// spill は区間全体をスロットに置く
+	// レジスタから追い出す区間は現在の位置より前から始まるため、その始まりより前に空いたスロットだけを再利用する
+	spill := func(iv *interval) {
+		slot := -1
+		for i := len(freeSlots) - 1; i >= 0; i-- {
+			if slotEnds[freeSlots[i]] < iv.start {
+				slot = freeSlots[i]
+				freeSlots = append(freeSlots[:i], freeSlots[i+1:]...)
+				break
+			}
+		}
+		if slot < 0 {
+			slot = len(slotEnds)
+			slotEnds = append(slotEnds, 0)
+		}
+		slotEnds[slot] = iv.end
+		result.locations[iv.reg] = location{slot: slot}
+		heap.Push(spilled, iv)
+	}
+	assign := func(iv *interval, register string) {
+		owner[register] = iv
+		result.locations[iv.reg] = location{register: register}
+		for _, r := range calleeSavedRegisters {
+			if r == register {
+				usedCalleeSaved[r] = true
+			}
+		}
+		i := sort.Search(len(active), func(i int) bool { return active[i].end > iv.end })
+		active = append(active, nil)
+		copy(active[i+1:], active[i:])
+		active[i] = iv
+	}
+
+	for _, current := range intervals {
+		// 終わった区間のレジスタを解放する
+		expired := 0
+		for expired < len(active) && active[expired].end < current.start {
+			delete(owner, result.locations[active[expired].reg].register)
+			expired++
+		}
+		active = active[expired:]
+		for spilled.Len() > 0 && (*spilled)[0].end < current.start {
+			iv := heap.Pop(spilled).(*interval)
+			freeSlots = append(freeSlots, result.locations[iv.reg].slot)
+		}
+
+		// 呼び出しをまたぐ値はcallee-savedレジスタだけに置く
+		candidates := calleeSavedRegisters
+		if !current.crossCall {
+			candidates = append(append([]string{}, callerSavedRegisters...), calleeSavedRegisters...)
+		}
+		free := ""
+		for _, r := range candidates {
+			if owner[r] == nil {
+				free = r
+				break
+			}
+		}
+		if free != "" {
+			assign(current, free)
+			continue
+		}
+
+		// 空きがなければ、候補のレジスタを使う区間のうち最も遅く終わるものと比べて、遅く終わる方をスピルする
+		var victim *interval
+		for _, r := range candidates {
+			if iv := owner[r]; victim == nil || iv.end > victim.end {
+				victim = iv
+			}
+		}
+		if victim.end <= current.end {
+			spill(current)
+			continue
+		}
+		register := result.locations[victim.reg].register
+		for i, iv := range active {
+			if iv == victim {
+				active = append(active[:i], active[i+1:]...)
+				break
+			}
+		}
+		spill(victim)
+		assign(current, register)
+	}
+
+	result.spillSlots = len(slotEnds)
+	for _, r := range calleeSavedRegisters {
+		if usedCalleeSaved[r] {
+			result.calleeSaved = append(result.calleeSaved, r)
+		}
+	}
+	return result
+}
@@ -0,0 +1,185 @@
+package phase2
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// newTestFunction は中間表現を直接組み立てるためのコード生成器を返す
+func newTestFunction() *CodeGenerator {
+	cg := NewCodeGenerator()
+	cg.code = &irFunction{name: "test"}
+	return cg
+}
+
+// checkAllocation は同時に生きている仮想レジスタが同じ場所に割り当てられていないこと、
+// 呼び出しをまたぐ仮想レジスタがcaller-savedレジスタに置かれていないことを確かめる
+func checkAllocation(t *testing.T, fn *irFunction, alloc *allocation) {
+	t.Helper()
+	blocks := splitBlocks(fn.instructions)
+	analyzeLiveness(fn, blocks)
+	intervals := buildIntervals(fn, blocks)
+
+	for i, a := range intervals {
+		loc := alloc.locations[a.reg]
+		if a.crossCall {
+			for _, r := range callerSavedRegisters {
+				if loc.register == r {
+					t.Errorf("v%d lives across a call but is allocated to caller-saved %s", a.reg, r)
+				}
+			}
+		}
+		for _, b := range intervals[i+1:] {
+			if a.start <= b.end && b.start <= a.end && alloc.locations[b.reg] == loc {
+				t.Errorf("v%d [%d, %d] and v%d [%d, %d] share %+v", a.reg, a.start, a.end, b.reg, b.start, b.end, loc)
+			}
+		}
+	}
+}
+
+// TestAllocateRegisters は線形走査法による割り当てをテストする
+func TestAllocateRegisters(t *testing.T) {
+	t.Run("呼び出しをまたぐ値はcallee-savedレジスタに置く", func(t *testing.T) {
+		cg := newTestFunction()
+		kept := cg.constant(1)
+		temp := cg.constant(2)
+		result := cg.call("f", temp)
+		sum := cg.newVreg()
+		cg.add(&instruction{op: opBinary, dst: sum, a: kept, b: result, cond: "add"})
+		cg.add(&instruction{op: opReturn, a: sum})
+
+		alloc := allocateRegisters(cg.code)
+		checkAllocation(t, cg.code, alloc)
+		if got := alloc.locations[kept].register; got != "%rbx" {
+			t.Errorf("expected v%d in %%rbx, got %q", kept, got)
+		}
+		if got := alloc.locations[temp].register; got != "%rcx" {
+			t.Errorf("expected v%d in %%rcx, got %q", temp, got)
+		}
+		if len(alloc.calleeSaved) != 1 || alloc.calleeSaved[0] != "%rbx" || alloc.spillSlots != 0 {
+			t.Errorf("expected only %%rbx to be saved and no spill slots, got %v and %d slots", alloc.calleeSaved, alloc.spillSlots)
+		}
+	})
+
+	t.Run("レジスタが足りなければスピルする", func(t *testing.T) {
+		cg := newTestFunction()
+		n := len(callerSavedRegisters) + len(calleeSavedRegisters) + 2
+		var values []vreg
+		for i := 0; i < n; i++ {
+			values = append(values, cg.constant(int64(i)))
+		}
+		sum := values[0]
+		for _, v := range values[1:] {
+			next := cg.newVreg()
+			cg.add(&instruction{op: opBinary, dst: next, a: sum, b: v, cond: "add"})
+			sum = next
+		}
+		cg.add(&instruction{op: opReturn, a: sum})
+
+		alloc := allocateRegisters(cg.code)
+		checkAllocation(t, cg.code, alloc)
+		if alloc.spillSlots != 2 {
+			t.Errorf("expected 2 spill slots, got %d", alloc.spillSlots)
+		}
+		if len(alloc.calleeSaved) != len(calleeSavedRegisters) {
+			t.Errorf("expected all callee-saved registers to be used, got %v", alloc.calleeSaved)
+		}
+	})
+
+	t.Run("ループの先頭で生きている値はループの末尾まで割り当てを保つ", func(t *testing.T) {
+		cg := newTestFunction()
+		counter := cg.constant(0)
+		cg.label("loop")
+		temp := cg.constant(5)
+		cg.add(&instruction{op: opBinary, dst: counter, a: counter, b: temp, cond: "add"})
+		cg.add(&instruction{op: opBranch, a: counter, cond: "l", imm: 100, sym: "loop"})
+		cg.add(&instruction{op: opReturn, a: counter})
+
+		alloc := allocateRegisters(cg.code)
+		checkAllocation(t, cg.code, alloc)
+		if alloc.locations[counter] == alloc.locations[temp] {
+			t.Errorf("the loop counter and a value defined in the loop share %+v", alloc.locations[counter])
+		}
+	})
+}
+
+// TestAllocateRegisters_Random は無作為に組み立てた関数で、割り当てが衝突しないことを確かめる
+// 呼び出しと長く生きる値を混ぜ、レジスタから追い出される値がスロットを共有しないことも確かめる
+func TestAllocateRegisters_Random(t *testing.T) {
+	for seed := int64(1); seed <= 200; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		cg := newTestFunction()
+		var live []vreg
+		for i := 0; i < 60; i++ {
+			switch r := rng.Intn(12); {
+			case r < 4 || len(live) < 2:
+				live = append(live, cg.constant(int64(i)))
+			case r < 7:
+				dst := cg.newVreg()
+				cg.add(&instruction{op: opBinary, dst: dst, a: live[rng.Intn(len(live))], b: live[rng.Intn(len(live))], cond: "add"})
+				live = append(live, dst)
+			case r < 9:
+				args := []vreg{live[rng.Intn(len(live))], live[rng.Intn(len(live))]}
+				live = append(live, cg.call("f", args...))
+			default:
+				// 値を使い終わる（以後の命令から参照されない）
+				// 短く生きる値と長く生きる値が混ざり、スピルした値が途中で使い終わることもある
+				k := rng.Intn(len(live))
+				live = append(live[:k], live[k+1:]...)
+			}
+		}
+		sum := live[0]
+		for _, v := range live[1:] {
+			next := cg.newVreg()
+			cg.add(&instruction{op: opBinary, dst: next, a: sum, b: v, cond: "add"})
+			sum = next
+		}
+		cg.add(&instruction{op: opReturn, a: sum})
+
+		checkAllocation(t, cg.code, allocateRegisters(cg.code))
+		if t.Failed() {
+			t.Fatalf("seed %d:\n%s", seed, dumpInstructions(cg.code))
+		}
+	}
+}
+
+// dumpInstructions は中間表現を1行に1命令ずつ並べた文字列を返す
+func dumpInstructions(fn *irFunction) string {
+	var lines []string
+	for _, in := range fn.instructions {
+		lines = append(lines, in.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// TestSimplify は割り当ての前の中間表現の整理をテストする
+func TestSimplify(t *testing.T) {
+	cg := newTestFunction()
+	param, unused := cg.newVreg(), cg.newVreg()
+	cg.add(&instruction{op: opEntry, args: []vreg{param, unused}})
+	cg.constant(7) // 使われない値
+	variable := cg.constant(1)
+	temp := cg.newVreg()
+	cg.add(&instruction{op: opBinary, dst: temp, a: variable, b: param, cond: "add"})
+	cg.move(variable, temp)
+	cg.call("f", variable) // 戻り値を使わない呼び出しは残す
+	cg.add(&instruction{op: opReturn, a: variable})
+	cg.jump("unreachable")
+	cg.label("next")
+	cg.add(&instruction{op: opReturn, a: param})
+
+	cg.code.simplify()
+	expected := strings.Join([]string{
+		"entry(v1, v0) closure=v0",
+		"v4 = $1",
+		"v4 = v4 add v1",
+		"v6 = call f(v4)",
+		"return v4",
+		"next:",
+		"return v1",
+	}, "\n")
+	if got := dumpInstructions(cg.code); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
@@ -0,0 +1,332 @@
+package phase2
+
+import (
+	"fmt"
+
+	"github.com/nyasuto/pug/phase1"
+)
+
+// ランタイムのルーチン名
+// ランタイムは生成したプログラムと同じアセンブリファイルに出力され、libcを使わずにシステムコールだけで動作する
+const (
+	runtimeWrite      = "pug_write"       // (rdi=バッファ, rsi=長さ) を標準出力に書き込む
+	runtimePutsInt    = "pug_puts_int"    // (rdi=整数) を10進数で1行出力する
+	runtimePutsBool   = "pug_puts_bool"   // (rdi=真偽値) を true/false で1行出力する
+	runtimePutsString = "pug_puts_string" // (rdi=長さ付き文字列へのポインタ) を1行出力する
+
+	runtimeAlloc        = "pug_alloc"         // (rdi=バイト数, rsi=オブジェクトの種類) の0で埋めた領域をヒープに確保し、rax に返す
+	runtimeGC           = "pug_gc"            // ヒープのガベージコレクションを行う
+	runtimeStringConcat = "pug_string_concat" // (rdi, rsi) の文字列を連結した新しい文字列を rax に返す
+	runtimeStringEqual  = "pug_string_equal"  // (rdi, rsi) の文字列が等しければ1、異なれば0を rax に返す
+
+	runtimeArrayPush  = "pug_array_push"  // (rdi=配列, rsi=値) の末尾に値を加えた新しい配列を rax に返す
+	runtimeArrayRest  = "pug_array_rest"  // (rdi=配列) の先頭を除いた新しい配列を rax に返す
+	runtimeIndexError = "pug_index_error" // 範囲外の添字のエラーを出力して終了する（戻らない）
+	runtimeEmptyError = "pug_empty_error" // 空の配列のfirst・lastのエラーを出力して終了する（戻らない）
+
+	runtimeDivisionError = "pug_division_error" // 0での除算のエラーを出力して終了する（戻らない）
+	runtimeModuloError   = "pug_modulo_error"   // 0での剰余のエラーを出力して終了する（戻らない）
+)
+
+// divisionErrors は除算の演算子ごとの、除数が0の場合に飛ぶエラーのルーチン
+var divisionErrors = map[string]string{
+	"/": runtimeDivisionError,
+	"%": runtimeModuloError,
+}
+
+// 実行時エラーで終了する場合に標準エラー出力に書き込むメッセージ
+const (
+	outOfMemoryMessage   = "pug: out of memory\n"
+	indexErrorMessage    = "pug: index out of range\n"
+	emptyErrorMessage    = "pug: first or last of an empty array\n"
+	divisionErrorMessage = "pug: division by zero\n"
+	moduloErrorMessage   = "pug: modulo by zero\n"
+)
+
+// putsRoutine は引数の型に対応するputsのランタイムルーチンを返す
+// 生成するコードは値の型情報を持たないため、型検査で推論された静的な型で出力方法を決める
+func (cg *CodeGenerator) putsRoutine(arg phase1.Expression) (string, error) {
+	typ, ok := cg.types.TypeOf(arg)
+	if !ok {
+		return "", fmt.Errorf("puts: cannot determine the type of %s", arg.String())
+	}
+	switch typ.(type) {
+	case *IntType:
+		return runtimePutsInt, nil
+	case *BoolType:
+		return runtimePutsBool, nil
+	case *StringType, *NullType:
+		return runtimePutsString, nil
+	case *TypeVariable:
+		return "", fmt.Errorf("puts: cannot determine the type of %s", arg.String())
+	default:
+		return "", fmt.Errorf("puts: printing a value of type %s is not supported in compiled code", NormalizeType(typ).String())
+	}
+}
+
+// generatePutsCall は組み込み関数putsの呼び出しの中間表現を生成する
+// インタプリタと同じく引数を1つずつ1行に出力し、結果はnull（0）とする
+func (cg *CodeGenerator) generatePutsCall(node *phase1.CallExpression) (vreg, error) {
+	for _, arg := range node.Arguments {
+		routine, err := cg.putsRoutine(arg)
+		if err != nil {
+			return 0, err
+		}
+		value, err := cg.generateExpression(arg)
+		if err != nil {
+			return 0, err
+		}
+		// nullの値（0）はインタプリタと同じく "null" と出力する
+		if typ, _ := cg.types.TypeOf(arg); NULL_TYPE.Equals(typ) {
+			value = cg.address(cg.stringConstant("null"))
+		}
+		cg.useRuntime(routine)
+		cg.add(&instruction{op: opCall, sym: routine, args: []vreg{value}})
+	}
+	return cg.constant(0), nil
+}
+
+// runtimeDependencies はランタイムのルーチンと、そのルーチンが呼び出す他のルーチン
+var runtimeDependencies = map[string][]string{
+	runtimePutsInt:      {runtimeWrite},
+	runtimePutsBool:     {runtimePutsString},
+	runtimePutsString:   {runtimeWrite},
+	runtimeAlloc:        {runtimeGC},
+	runtimeStringConcat: {runtimeAlloc},
+	runtimeArrayPush:    {runtimeAlloc},
+	runtimeArrayRest:    {runtimeAlloc},
+}
+
+// runtimeRoutines はランタイムのルーチンと、その定義を出力するメソッド（出力する順）
+var runtimeRoutines = []struct {
+	name string
+	emit func(cg *CodeGenerator)
+}{
+	{runtimeWrite, (*CodeGenerator).emitWrite},
+	{runtimePutsInt, (*CodeGenerator).emitPutsInt},
+	{runtimePutsBool, (*CodeGenerator).emitPutsBool},
+	{runtimePutsString, (*CodeGenerator).emitPutsString},
+	{runtimeAlloc, (*CodeGenerator).emitAlloc},
+	{runtimeGC, (*CodeGenerator).emitGC},
+	{runtimeStringConcat, (*CodeGenerator).emitStringConcat},
+	{runtimeStringEqual, (*CodeGenerator).emitStringEqual},
+	{runtimeArrayPush, (*CodeGenerator).emitArrayPush},
+	{runtimeArrayRest, (*CodeGenerator).emitArrayRest},
+	{runtimeIndexError, (*CodeGenerator).emitIndexError},
+	{runtimeEmptyError, (*CodeGenerator).emitEmptyError},
+	{runtimeDivisionError, (*CodeGenerator).emitDivisionError},
+	{runtimeModuloError, (*CodeGenerator).emitModuloError},
+}
+
+// callRuntime はランタイムのルーチンを呼び出し、そのルーチンを出力の対象に加える
+// 戻り値を置いた仮想レジスタを返す
+func (cg *CodeGenerator) callRuntime(routine string, args ...vreg) vreg {
+	cg.useRuntime(routine)
+	return cg.call(routine, args...)
+}
+
+// useRuntime はランタイムのルーチンと、それが依存するルーチンを出力の対象に加える
+func (cg *CodeGenerator) useRuntime(routine string) {
+	if cg.runtimeUsed[routine] {
+		return
+	}
+	cg.runtimeUsed[routine] = true
+	for _, dependency := range runtimeDependencies[routine] {
+		cg.useRuntime(dependency)
+	}
+}
+
+// emitRuntime はプログラムが使うランタイムのルーチンだけを出力する
+// Freestandingなターゲットでは、mainを呼び出してその戻り値で終了するエントリポイントも出力する
+func (cg *CodeGenerator) emitRuntime() {
+	t := cg.target
+
+	if t.Freestanding {
+		cg.emit("")
+		cg.emit(".globl _start")
+		cg.emit("_start:")
+		cg.emit("    xorq %rbp, %rbp") // スタックトレースの終端
+		cg.emitf("    call %s", t.Symbol("main"))
+		cg.emit("    movq %rax, %rdi")
+		cg.emitf("    movq $%d, %%rax", t.SyscallExit)
+		cg.emit("    syscall")
+	}
+
+	for _, routine := range runtimeRoutines {
+		if cg.runtimeUsed[routine.name] {
+			cg.emit("")
+			routine.emit(cg)
+		}
+	}
+}
+
+// emitWrite は標準出力に書き込むルーチンを出力する
+// 部分的な書き込みを繰り返し、エラーの場合は残りを諦める
+// syscallはrcxとr11だけを破壊する。Linuxではフラグがsyscall前の値に戻るため、
+// macOSでエラーを表すキャリーフラグの検査はLinuxでは常に偽になる
+func (cg *CodeGenerator) emitWrite() {
+	t := cg.target
+
+	cg.emitf("%s:", runtimeWrite)
+	cg.emit("    movq %rsi, %rdx")
+	cg.emit("    movq %rdi, %rsi")
+	cg.emit(".Lrt_write_loop:")
+	cg.emit("    testq %rdx, %rdx")
+	cg.emit("    jle .Lrt_write_done")
+	cg.emit("    movq $1, %rdi") // 標準出力
+	cg.emitf("    movq $%d, %%rax", t.SyscallWrite)
+	cg.emit("    syscall")
+	cg.emit("    jc .Lrt_write_done")
+	cg.emit("    testq %rax, %rax")
+	cg.emit("    jle .Lrt_write_done")
+	cg.emit("    addq %rax, %rsi")
+	cg.emit("    subq %rax, %rdx")
+	cg.emit("    jmp .Lrt_write_loop")
+	cg.emit(".Lrt_write_done:")
+	cg.emit("    ret")
+}
+
+// emitPutsInt は整数を出力するルーチンを出力する
+// スタック上のバッファに末尾から数字を並べ、改行と合わせて1回で書き込む
+// 絶対値は符号なし除算で求めるため、最小値（-2^63）も正しく出力できる
+func (cg *CodeGenerator) emitPutsInt() {
+	cg.emitf("%s:", runtimePutsInt)
+	cg.emit("    pushq %rbp")
+	cg.emit("    movq %rsp, %rbp")
+	cg.emit("    subq $32, %rsp")
+	cg.emit("    leaq -1(%rbp), %rsi")
+	cg.emit("    movb $10, (%rsi)") // '\n'
+	cg.emit("    movq %rdi, %rax")
+	cg.emit("    movq $10, %rcx")
+	cg.emit("    testq %rax, %rax")
+	cg.emit("    jns .Lrt_int_digit")
+	cg.emit("    negq %rax")
+	cg.emit(".Lrt_int_digit:")
+	cg.emit("    xorq %rdx, %rdx")
+	cg.emit("    divq %rcx")
+	cg.emit("    addb $48, %dl") // '0'
+	cg.emit("    decq %rsi")
+	cg.emit("    movb %dl, (%rsi)")
+	cg.emit("    testq %rax, %rax")
+	cg.emit("    jnz .Lrt_int_digit")
+	cg.emit("    testq %rdi, %rdi")
+	cg.emit("    jns .Lrt_int_write")
+	cg.emit("    decq %rsi")
+	cg.emit("    movb $45, (%rsi)") // '-'
+	cg.emit(".Lrt_int_write:")
+	cg.emit("    movq %rsi, %rdi")
+	cg.emit("    movq %rbp, %rsi")
+	cg.emit("    subq %rdi, %rsi")
+	cg.emitf("    call %s", runtimeWrite)
+	cg.emit("    movq %rbp, %rsp")
+	cg.emit("    popq %rbp")
+	cg.emit("    ret")
+}
+
+// emitPutsBool は真偽値を出力するルーチンを出力する
+// 定数の文字列 true/false をpug_puts_stringで出力する
+func (cg *CodeGenerator) emitPutsBool() {
+	cg.emitf("%s:", runtimePutsBool)
+	cg.emitf("    leaq %s(%%rip), %%rax", cg.stringConstant("false"))
+	cg.emitf("    leaq %s(%%rip), %%rcx", cg.stringConstant("true"))
+	cg.emit("    testq %rdi, %rdi")
+	cg.emit("    cmovnz %rcx, %rax")
+	cg.emit("    movq %rax, %rdi")
+	cg.emitf("    jmp %s", runtimePutsString)
+}
+
+// emitPutsString は文字列を出力するルーチンを出力する
+func (cg *CodeGenerator) emitPutsString() {
+	cg.emitf("%s:", runtimePutsString)
+	cg.emit("    movq (%rdi), %rsi")
+	cg.emit("    leaq 8(%rdi), %rdi")
+	cg.emitf("    call %s", runtimeWrite)
+	cg.emit("    pushq $10") // '\n'
+	cg.emit("    movq %rsp, %rdi")
+	cg.emit("    movq $1, %rsi")
+	cg.emitf("    call %s", runtimeWrite)
+	cg.emit("    addq $8, %rsp")
+	cg.emit("    ret")
+}
+
+// emitStringConcat は2つの文字列を連結した文字列をヒープに作るルーチンを出力する
+// バイト列のコピーには rep movsb を使う（方向フラグはABIによりクリアされている）
+func (cg *CodeGenerator) emitStringConcat() {
+	cg.emitf("%s:", runtimeStringConcat)
+	cg.emit("    pushq %rdi")
+	cg.emit("    pushq %rsi")
+	cg.emit("    movq (%rdi), %rax")
+	cg.emit("    addq (%rsi), %rax")
+	cg.emit("    pushq %rax")
+	cg.emit("    leaq 8(%rax), %rdi")
+	cg.emitf("    movq $%d, %%rsi", objectString)
+	cg.emitf("    call %s", runtimeAlloc)
+	cg.emit("    popq %rcx")
+	cg.emit("    movq %rcx, (%rax)") // 連結後の長さ
+	cg.emit("    popq %rdx")         // 右辺
+	cg.emit("    popq %rsi")         // 左辺
+	cg.emit("    leaq 8(%rax), %rdi")
+	cg.emit("    movq (%rsi), %rcx")
+	cg.emit("    addq $8, %rsi")
+	cg.emit("    rep movsb")
+	cg.emit("    movq (%rdx), %rcx")
+	cg.emit("    leaq 8(%rdx), %rsi")
+	cg.emit("    rep movsb")
+	cg.emit("    ret")
+}
+
+// emitStringEqual は2つの文字列を比較するルーチンを出力する
+// 長さが等しい場合のみバイト列を比較する（長さ0では repe cmpsb はフラグを変えない）
+func (cg *CodeGenerator) emitStringEqual() {
+	cg.emitf("%s:", runtimeStringEqual)
+	cg.emit("    xorq %rax, %rax")
+	cg.emit("    movq (%rdi), %rcx")
+	cg.emit("    cmpq (%rsi), %rcx")
+	cg.emit("    jne .Lrt_equal_done")
+	cg.emit("    leaq 8(%rdi), %rdi") // leaq はフラグを変えない
+	cg.emit("    leaq 8(%rsi), %rsi")
+	cg.emit("    repe cmpsb")
+	cg.emit("    sete %al")
+	cg.emit(".Lrt_equal_done:")
+	cg.emit("    ret")
+}
+
+// emitFatalError はメッセージを標準エラー出力に書き込み、終了コード1で終了するコードを出力する
+// メッセージは文字列定数として置き、その長さと内容を書き込む
+func (cg *CodeGenerator) emitFatalError(message string) {
+	t := cg.target
+
+	cg.emit("    movq $2, %rdi") // 標準エラー出力
+	cg.emitf("    leaq %s(%%rip), %%rsi", cg.stringConstant(message))
+	cg.emit("    movq (%rsi), %rdx")
+	cg.emit("    addq $8, %rsi")
+	cg.emitf("    movq $%d, %%rax", t.SyscallWrite)
+	cg.emit("    syscall")
+	cg.emit("    movq $1, %rdi")
+	cg.emitf("    movq $%d, %%rax", t.SyscallExit)
+	cg.emit("    syscall")
+}
+
+// emitIndexError は範囲外の添字のエラーで終了するルーチンを出力する
+func (cg *CodeGenerator) emitIndexError() {
+	cg.emitf("%s:", runtimeIndexError)
+	cg.emitFatalError(indexErrorMessage)
+}
+
+// emitEmptyError は空の配列に対するfirst・lastのエラーで終了するルーチンを出力する
+func (cg *CodeGenerator) emitEmptyError() {
+	cg.emitf("%s:", runtimeEmptyError)
+	cg.emitFatalError(emptyErrorMessage)
+}
+
+// emitDivisionError は0での除算のエラーで終了するルーチンを出力する
+func (cg *CodeGenerator) emitDivisionError() {
+	cg.emitf("%s:", runtimeDivisionError)
+	cg.emitFatalError(divisionErrorMessage)
+}
+
+// emitModuloError は0での剰余のエラーで終了するルーチンを出力する
+func (cg *CodeGenerator) emitModuloError() {
+	cg.emitf("%s:", runtimeModuloError)
+	cg.emitFatalError(moduloErrorMessage)
+}
@@ -0,0 +1,156 @@
+package phase2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nyasuto/pug/phase1"
+)
+
+// 文字列の値は、長さ（8バイト）に続けてバイト列を置いた領域へのポインタで表す
+// 文字列リテラルは読み取り専用の定数プールに置き、連結の結果はランタイムのヒープに確保する
+
+// stringConstant は文字列定数のラベルを返す
+// 同じ内容の文字列は1つの定数を共有し、初めて使われた順にプールに追加する
+func (cg *CodeGenerator) stringConstant(value string) string {
+	if label, ok := cg.stringLabels[value]; ok {
+		return label
+	}
+	label := fmt.Sprintf(".Lstr%d", len(cg.stringPool))
+	cg.stringLabels[value] = label
+	cg.stringPool = append(cg.stringPool, value)
+	return label
+}
+
+// emitStringPool は文字列定数のプールを読み取り専用のセクションに出力する
+func (cg *CodeGenerator) emitStringPool() {
+	if len(cg.stringPool) == 0 {
+		return
+	}
+	cg.emit("")
+	cg.emit(cg.target.RodataSection)
+	for _, value := range cg.stringPool {
+		cg.emit(".p2align 3")
+		cg.emitf("%s:", cg.stringLabels[value])
+		cg.emitf("    .quad %d", len(value))
+		if value != "" {
+			cg.emitf("    .ascii %s", asmQuote(value))
+		}
+	}
+}
+
+// asmQuote は文字列をアセンブラの文字列リテラルとして引用する
+// 表示可能なASCII文字以外は8進数のエスケープにする
+func asmQuote(value string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			out.WriteByte(c)
+		default:
+			fmt.Fprintf(&out, "\\%03o", c)
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+// isStringExpression は式の静的な型が文字列かどうかを返す
+// 型変数の型（多相な関数の引数など）は、具体化で取る型が全て文字列なら文字列とする
+func (cg *CodeGenerator) isStringExpression(expr phase1.Expression) bool {
+	typ, ok := cg.types.TypeOf(expr)
+	if !ok {
+		return false
+	}
+	switch typ := typ.(type) {
+	case *StringType:
+		return true
+	case *TypeVariable:
+		instances := cg.types.instanceTypes(typ)
+		for _, instance := range instances {
+			if _, isString := instance.(*StringType); !isString {
+				return false
+			}
+		}
+		return len(instances) > 0
+	}
+	return false
+}
+
+// checkOperandTypes は文字列にも使える演算子（+、==、!=）のオペランドの型から命令を選べるかを確かめる
+// 型変数の型のオペランドが呼び出しによって文字列にもそれ以外にもなる場合は、1つの命令で扱えないためエラーとする
+func (cg *CodeGenerator) checkOperandTypes(node *phase1.InfixExpression) error {
+	switch node.Operator {
+	case "+", "==", "!=":
+	default:
+		return nil
+	}
+	for _, operand := range []phase1.Expression{node.Left, node.Right} {
+		typ, ok := cg.types.TypeOf(operand)
+		if !ok {
+			continue
+		}
+		variable, isVariable := typ.(*TypeVariable)
+		if !isVariable {
+			continue
+		}
+		strings := 0
+		instances := cg.types.instanceTypes(variable)
+		for _, instance := range instances {
+			if _, isString := instance.(*StringType); isString {
+				strings++
+			}
+		}
+		if strings > 0 && strings < len(instances) {
+			return fmt.Errorf("%s: cannot determine the type of %s (it is a string in some calls but not in others)", node.Operator, operand.String())
+		}
+	}
+	return nil
+}
+
+// generateStringOperation は文字列の二項演算の中間表現を生成する
+func (cg *CodeGenerator) generateStringOperation(node *phase1.InfixExpression) (vreg, error) {
+	if node.Operator != "+" && node.Operator != "==" && node.Operator != "!=" {
+		return 0, fmt.Errorf("unsupported string operator: %s", node.Operator)
+	}
+	operands, err := cg.generateOperands(node.Left, node.Right)
+	if err != nil {
+		return 0, err
+	}
+	switch node.Operator {
+	case "+":
+		return cg.callRuntime(runtimeStringConcat, operands...), nil
+	case "==":
+		return cg.callRuntime(runtimeStringEqual, operands...), nil
+	}
+	result := cg.newVreg()
+	equal := cg.callRuntime(runtimeStringEqual, operands...)
+	cg.add(&instruction{op: opBinary, dst: result, a: equal, cond: "xor", imm: 1})
+	return result, nil
+}
+
+// generateLenCall は組み込み関数lenの呼び出しの中間表現を生成する
+// 文字列の長さと配列の要素数は、どちらも先頭の8バイトから読み取る
+func (cg *CodeGenerator) generateLenCall(node *phase1.CallExpression) (vreg, error) {
+	if len(node.Arguments) != 1 {
+		return 0, fmt.Errorf("len: wrong number of arguments: expected 1, got %d", len(node.Arguments))
+	}
+	arg := node.Arguments[0]
+	if !cg.isStringExpression(arg) && !cg.isArrayExpression(arg) {
+		typ, _ := cg.types.TypeOf(arg)
+		if typ == nil {
+			return 0, fmt.Errorf("len: cannot determine the type of %s", arg.String())
+		}
+		return 0, fmt.Errorf("len: a value of type %s is not supported in compiled code", NormalizeType(typ).String())
+	}
+	value, err := cg.generateExpression(arg)
+	if err != nil {
+		return 0, err
+	}
+	return cg.load(value, 0, 0), nil
+}
@@ -0,0 +1,113 @@
+package phase2
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Target はアセンブリの出力先のプラットフォーム（オブジェクト形式・セクション名・シンボルの規約）
+type Target struct {
+	Name          string   // --target で指定する名前
+	Description   string   // 表示用の説明
+	SymbolPrefix  string   // Cのシンボル名に付ける接頭辞（Mach-Oでは "_"）
+	TextSection   string   // コードを置くセクションのディレクティブ
+	DataSection   string   // 書き込み可能なデータを置くセクションのディレクティブ
+	RodataSection string   // 読み取り専用のデータを置くセクションのディレクティブ
+	Trailer       []string // ファイル末尾に出力するディレクティブ
+	Assembler     []string // アセンブラのコマンドと引数（-o と入出力ファイルを除く）
+	Linker        []string // リンカのコマンドと引数（-o と入出力ファイルを除く）
+	Freestanding  bool     // libcなしでリンクし、ランタイムがエントリポイント（_start）を提供する
+	SyscallWrite  int      // write(2) のシステムコール番号
+	SyscallExit   int      // exit(2) のシステムコール番号
+	SyscallMmap   int      // mmap(2) のシステムコール番号
+	MapAnonymous  int      // 匿名のプライベートなマッピングを作る mmap のフラグ（MAP_PRIVATE|MAP_ANONYMOUS）
+	MapNoReserve  int      // 物理メモリやスワップを先に確保せずに大きな領域を予約する mmap のフラグ（ない場合は0）
+}
+
+// BuildCommands はアセンブリファイルからオブジェクトファイルを経て実行可能ファイルを作る
+// アセンブラとリンカのコマンドラインを返す
+func (t *Target) BuildCommands(asmFile, objFile, outputFile string) (assemble, link []string) {
+	assemble = append(append([]string{}, t.Assembler...), "-o", objFile, asmFile)
+	link = append(append([]string{}, t.Linker...), "-o", outputFile, objFile)
+	return assemble, link
+}
+
+// Symbol はCのシンボル名をターゲットのアセンブリ上のシンボル名に変換する
+func (t *Target) Symbol(name string) string {
+	return t.SymbolPrefix + name
+}
+
+// TargetLinux はLinux（System V ABI・ELF）向けのターゲット
+// スタックを実行不可にするため、GNU-stackノートを出力する
+// ランタイムはシステムコールだけを使うため、libcなしで静的にリンクする
+var TargetLinux = &Target{
+	Name:          "linux",
+	Description:   "Linux x86-64 (System V, ELF)",
+	TextSection:   ".text",
+	DataSection:   ".data",
+	RodataSection: ".section .rodata",
+	Trailer:       []string{`.section .note.GNU-stack,"",@progbits`},
+	Assembler:     []string{"as", "--64"},
+	Linker:        []string{"ld"},
+	Freestanding:  true,
+	SyscallWrite:  1,
+	SyscallExit:   60,
+	SyscallMmap:   9,
+	MapAnonymous:  0x22,
+	MapNoReserve:  0x4000,
+}
+
+// TargetDarwin はmacOS（Mach-O）向けのターゲット
+// macOSでは静的な実行可能ファイルを作れないため、libSystemとリンクしてmainをエントリポイントにする
+// システムコール番号にはBSDシステムコールのクラス（0x2000000）を加える
+var TargetDarwin = &Target{
+	Name:          "darwin",
+	Description:   "macOS x86-64 (Mach-O)",
+	SymbolPrefix:  "_",
+	TextSection:   ".section __TEXT,__text,regular,pure_instructions",
+	DataSection:   ".section __DATA,__data",
+	RodataSection: ".section __TEXT,__const",
+	Assembler:     []string{"as", "-arch", "x86_64"},
+	Linker: []string{"ld", "-lSystem", "-syslibroot", "/Library/Developer/CommandLineTools/SDKs/MacOSX.sdk",
+		"-e", "_main", "-arch", "x86_64"},
+	SyscallWrite: 0x2000004,
+	SyscallExit:  0x2000001,
+	SyscallMmap:  0x20000c5,
+	MapAnonymous: 0x1002,
+}
+
+// targets は --target で指定できる名前とターゲットの対応（macos は darwin の別名）
+var targets = map[string]*Target{
+	"linux":  TargetLinux,
+	"darwin": TargetDarwin,
+	"macos":  TargetDarwin,
+}
+
+// DefaultTarget は実行中のOSに対応するターゲットを返す（macOS以外ではLinux）
+func DefaultTarget() *Target {
+	if runtime.GOOS == "darwin" {
+		return TargetDarwin
+	}
+	return TargetLinux
+}
+
+// LookupTarget は名前からターゲットを探す
+func LookupTarget(name string) (*Target, error) {
+	target, ok := targets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown target: %s (available: %s)", name, strings.Join(TargetNames(), ", "))
+	}
+	return target, nil
+}
+
+// TargetNames は指定できるターゲットの名前を辞書順に返す
+func TargetNames() []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -63,6 +63,18 @@ func (t *BoolType) Equals(other Type) bool {
 	return ok
 }
 
+// NullType はnull型（putsの結果など、値を持たない式の型）
+type NullType struct{}
+
+func (t *NullType) String() string {
+	return "null"
+}
+
+func (t *NullType) Equals(other Type) bool {
+	_, ok := other.(*NullType)
+	return ok
+}
+
 // FunctionType は関数型
 type FunctionType struct {
 	Parameters []Type
@@ -113,6 +125,24 @@ func (t *ArrayType) Equals(other Type) bool {
 	return t.ElementType.Equals(otherArray.ElementType)
 }
 
+// MapType はハッシュ（連想配列）型
+type MapType struct {
+	KeyType   Type
+	ValueType Type
+}
+
+func (t *MapType) String() string {
+	return fmt.Sprintf("{%s: %s}", t.KeyType.String(), t.ValueType.String())
+}
+
+func (t *MapType) Equals(other Type) bool {
+	otherMap, ok := other.(*MapType)
+	if !ok {
+		return false
+	}
+	return t.KeyType.Equals(otherMap.KeyType) && t.ValueType.Equals(otherMap.ValueType)
+}
+
 // UnknownType は未知の型（型推論で使用）
 type UnknownType struct {
 	Name string
@@ -136,6 +166,7 @@ var (
 	FLOAT_TYPE  = &FloatType{}
 	STRING_TYPE = &StringType{}
 	BOOL_TYPE   = &BoolType{}
+	NULL_TYPE   = &NullType{}
 )
 
 // TypeEnvironment は型環境（変数名と型のマッピング）
@@ -174,56 +205,140 @@ func (e *TypeEnvironment) Set(name string, val Type) Type {
 	return val
 }
 
+// 型検査器が発行する診断のエラーコード
+const (
+	CodeUnsupportedNode     = "E0200" // 型検査器が扱えないノード
+	CodeUndefinedIdentifier = "E0201" // 未定義の識別子
+	CodeTypeMismatch        = "E0202" // 型の不一致
+	CodeInvalidOperand      = "E0203" // 演算子に対する不正なオペランド
+	CodeNotCallable         = "E0204" // 関数ではない値の呼び出し
+	CodeArgumentCount       = "E0205" // 引数の数の不一致
+	CodeNotIndexable        = "E0206" // 添字アクセスできない値
+	CodeUnhashableKey       = "E0207" // ハッシュのキーにできない型
+	CodeInfiniteType        = "E0208" // 出現検査に失敗した無限型
+	CodeMissingReturn       = "E0209" // 値を返さない経路を持つ関数
+)
+
 // TypeChecker は型検査器
+// 型変数と単一化によるHindley-Milner型推論を行い、let束縛された関数は多相型に一般化する
 type TypeChecker struct {
-	env    *TypeEnvironment
-	errors []string
+	env         *TypeEnvironment
+	diagnostics []*phase1.Diagnostic
+
+	// subst は推論中に単一化で得られた型変数の束縛
+	subst Substitution
+	// nextVar は次に作成する型変数の番号
+	nextVar int
+
+	// returnType は検査中の関数の戻り値の型（関数外ではnil）
+	returnType Type
+	// returnAnnotation は検査中の関数の戻り値の型注釈（注釈がない場合はnil）
+	returnAnnotation phase1.TypeAnnotation
+
+	// types は検査した式とその型（置換の適用前）
+	types map[phase1.Expression]Type
+
+	// declared は相互再帰のため事前に名前を束縛したlet文と、その名前の型変数
+	declared map[*phase1.LetStatement]*TypeVariable
+
+	// branches は現在のスコープの中で検査しているブロック（if・while・for文の本体）の深さ
+	// インタプリタと同じく関数本体とfor文だけがスコープを作り、ブロックは外側と同じスコープを使う
+	branches int
+
+	// instances は量化された型変数ごとの、多相型の具体化で置き換えた型変数
+	instances map[int][]*TypeVariable
 }
 
 // NewTypeChecker は新しい型検査器を作成する
 func NewTypeChecker() *TypeChecker {
-	env := NewTypeEnvironment()
+	tc := &TypeChecker{
+		env:       NewTypeEnvironment(),
+		subst:     Substitution{},
+		types:     map[phase1.Expression]Type{},
+		declared:  map[*phase1.LetStatement]*TypeVariable{},
+		instances: map[int][]*TypeVariable{},
+	}
 
-	// 組み込み関数の型を設定
-	env.Set("len", &FunctionType{
-		Parameters: []Type{&ArrayType{ElementType: &UnknownType{Name: "T"}}},
-		ReturnType: INT_TYPE,
-	})
-	env.Set("first", &FunctionType{
-		Parameters: []Type{&ArrayType{ElementType: &UnknownType{Name: "T"}}},
-		ReturnType: &UnknownType{Name: "T"},
-	})
-	env.Set("rest", &FunctionType{
-		Parameters: []Type{&ArrayType{ElementType: &UnknownType{Name: "T"}}},
-		ReturnType: &ArrayType{ElementType: &UnknownType{Name: "T"}},
-	})
-	env.Set("push", &FunctionType{
-		Parameters: []Type{
-			&ArrayType{ElementType: &UnknownType{Name: "T"}},
-			&UnknownType{Name: "T"},
-		},
-		ReturnType: &ArrayType{ElementType: &UnknownType{Name: "T"}},
-	})
-	env.Set("puts", &FunctionType{
+	// 組み込み関数の型を多相型として設定
+	tc.env.Set("len", tc.builtinScheme(func(t Type) *FunctionType {
+		return &FunctionType{Parameters: []Type{&ArrayType{ElementType: t}}, ReturnType: INT_TYPE}
+	}))
+	tc.env.Set("first", tc.builtinScheme(func(t Type) *FunctionType {
+		return &FunctionType{Parameters: []Type{&ArrayType{ElementType: t}}, ReturnType: t}
+	}))
+	tc.env.Set("last", tc.builtinScheme(func(t Type) *FunctionType {
+		return &FunctionType{Parameters: []Type{&ArrayType{ElementType: t}}, ReturnType: t}
+	}))
+	tc.env.Set("rest", tc.builtinScheme(func(t Type) *FunctionType {
+		return &FunctionType{Parameters: []Type{&ArrayType{ElementType: t}}, ReturnType: &ArrayType{ElementType: t}}
+	}))
+	tc.env.Set("push", tc.builtinScheme(func(t Type) *FunctionType {
+		return &FunctionType{Parameters: []Type{&ArrayType{ElementType: t}, t}, ReturnType: &ArrayType{ElementType: t}}
+	}))
+	tc.env.Set("puts", &FunctionType{
 		Parameters: []Type{}, // 可変長引数として扱う
-		ReturnType: STRING_TYPE,
+		ReturnType: NULL_TYPE,
 	})
 
-	return &TypeChecker{
-		env:    env,
-		errors: []string{},
-	}
+	return tc
+}
+
+// builtinScheme は要素型について量化された組み込み関数の型を作成する
+func (tc *TypeChecker) builtinScheme(build func(Type) *FunctionType) *TypeScheme {
+	t := tc.freshVariable()
+	return &TypeScheme{Variables: []*TypeVariable{t}, Type: build(t)}
 }
 
 // CheckProgram はプログラム全体の型検査を行う
+// 返す型は推論結果を適用し、型変数を表示用に正規化したもの
 func (tc *TypeChecker) CheckProgram(program *phase1.Program) (Type, []string) {
 	var lastType Type = &UnknownType{Name: "void"}
 
+	tc.declareVariables(program.Statements)
+	tc.declareFunctions(program.Statements)
 	for _, stmt := range program.Statements {
 		lastType = tc.CheckStatement(stmt)
 	}
 
-	return lastType, tc.errors
+	return NormalizeType(tc.resolve(lastType)), tc.GetErrors()
+}
+
+// LookupType は現在の環境で名前に束縛された型を、推論結果を適用した表示用の形で返す
+func (tc *TypeChecker) LookupType(name string) (Type, bool) {
+	typ, ok := tc.env.Get(name)
+	if !ok || typ == undefinedType {
+		return nil, false
+	}
+	return NormalizeType(tc.resolve(typ)), true
+}
+
+// TypeOf は検査した式の推論された型を返す（式が検査されていなければfalse）
+// CheckProgramの後に呼び出す
+func (tc *TypeChecker) TypeOf(expr phase1.Expression) (Type, bool) {
+	typ, ok := tc.types[expr]
+	if !ok {
+		return nil, false
+	}
+	return tc.resolve(typ), true
+}
+
+// FunctionSignatures はプログラムのトップレベルでlet束縛された関数の名前と推論された型を定義順に返す
+// CheckProgramの後に呼び出す
+func (tc *TypeChecker) FunctionSignatures(program *phase1.Program) []Signature {
+	var signatures []Signature
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*phase1.LetStatement)
+		if !ok {
+			continue
+		}
+		if _, ok := let.Value.(*phase1.FunctionLiteral); !ok {
+			continue
+		}
+		if typ, ok := tc.LookupType(let.Name.Value); ok {
+			signatures = append(signatures, Signature{Name: let.Name.Value, Type: typ})
+		}
+	}
+	return signatures
 }
 
 // CheckStatement は文の型検査を行う
@@ -234,15 +349,39 @@ func (tc *TypeChecker) CheckStatement(stmt phase1.Statement) Type {
 	case *phase1.ReturnStatement:
 		return tc.checkReturnStatement(node)
 	case *phase1.ExpressionStatement:
+		// 値を使わないif式の分岐は、互いに異なる型でもよい
+		if ifExpr, ok := node.Expression.(*phase1.IfExpression); ok {
+			tc.types[ifExpr] = tc.checkIfStatement(ifExpr)
+			return tc.types[ifExpr]
+		}
 		return tc.CheckExpression(node.Expression)
+	case *phase1.BlockStatement:
+		if typ := tc.checkBlock(node, false); typ != nil {
+			return typ
+		}
+		return &UnknownType{Name: "void"}
+	case *phase1.WhileStatement:
+		return tc.checkWhileStatement(node)
+	case *phase1.ForStatement:
+		return tc.checkForStatement(node)
+	case *phase1.BreakStatement, *phase1.ContinueStatement:
+		// ループの外での使用は制御フロー解析で検出する
+		return &UnknownType{Name: "void"}
 	default:
-		tc.addError(fmt.Sprintf("unknown statement type: %T", stmt))
+		tc.addError(stmt, CodeUnsupportedNode, fmt.Sprintf("unknown statement type: %T", stmt))
 		return &UnknownType{Name: "error"}
 	}
 }
 
 // CheckExpression は式の型検査を行う
 func (tc *TypeChecker) CheckExpression(expr phase1.Expression) Type {
+	typ := tc.checkExpression(expr)
+	tc.types[expr] = typ
+	return typ
+}
+
+// checkExpression は式の種類ごとの型検査を行う
+func (tc *TypeChecker) checkExpression(expr phase1.Expression) Type {
 	switch node := expr.(type) {
 	case *phase1.IntegerLiteral:
 		return INT_TYPE
@@ -258,6 +397,14 @@ func (tc *TypeChecker) CheckExpression(expr phase1.Expression) Type {
 		return tc.checkInfixExpression(node)
 	case *phase1.PrefixExpression:
 		return tc.checkPrefixExpression(node)
+	case *phase1.AssignExpression:
+		return tc.checkAssignExpression(node)
+	case *phase1.ArrayLiteral:
+		return tc.checkArrayLiteral(node)
+	case *phase1.HashLiteral:
+		return tc.checkHashLiteral(node)
+	case *phase1.IndexExpression:
+		return tc.checkIndexExpression(node)
 	case *phase1.IfExpression:
 		return tc.checkIfExpression(node)
 	case *phase1.FunctionLiteral:
@@ -265,191 +412,703 @@ func (tc *TypeChecker) CheckExpression(expr phase1.Expression) Type {
 	case *phase1.CallExpression:
 		return tc.checkCallExpression(node)
 	default:
-		tc.addError(fmt.Sprintf("unknown expression type: %T", expr))
+		tc.addError(expr, CodeUnsupportedNode, fmt.Sprintf("unknown expression type: %T", expr))
 		return &UnknownType{Name: "error"}
 	}
 }
 
 // checkLetStatement はlet文の型検査を行う
+// 型注釈がある場合は値の型が注釈と一致するかを検査し、変数の型は注釈の型とする
+// 値が関数リテラルの場合は、再帰呼び出しのため値の検査前に名前を束縛し、
+// 検査後に環境に現れない型変数について一般化して多相型とする
 func (tc *TypeChecker) checkLetStatement(stmt *phase1.LetStatement) Type {
+	_, isFunction := stmt.Value.(*phase1.FunctionLiteral)
+
+	// ブロックの中での同じスコープの変数の再定義は、ブロックを実行しない経路で元の値が残るため、同じ型でなければならない
+	previous, redefined := tc.env.store[stmt.Name.Value]
+	redefined = redefined && previous != undefinedType && tc.branches > 0
+
+	var self *TypeVariable
+	if isFunction {
+		self = tc.declareFunction(stmt)
+	}
+
 	valueType := tc.CheckExpression(stmt.Value)
-	tc.env.Set(stmt.Name.Value, valueType)
-	return valueType
+
+	if self != nil {
+		// 本体での再帰呼び出しから推論した型と、関数の型を単一化する
+		tc.expect(stmt.Value, self, valueType, func(used, defined Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(stmt.Value),
+				"function %s is used as %s but defined as %s", stmt.Name.Value, used.String(), defined.String()).
+				WithSecondary(phase1.NodeSpan(stmt.Name), "defined here")
+		})
+	}
+
+	if stmt.Type != nil {
+		declared := tc.resolveTypeAnnotation(stmt.Type)
+		tc.expect(stmt.Value, declared, valueType, func(expected, found Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(stmt.Value),
+				"cannot assign %s to variable %s of type %s",
+				found.String(), stmt.Name.Value, expected.String()).
+				WithLabel("expected "+expected.String()+", found "+found.String()).
+				WithSecondary(phase1.NodeSpan(stmt.Type), "expected due to this type annotation")
+		})
+		valueType = declared
+	}
+
+	if redefined {
+		tc.expect(stmt.Value, tc.instantiate(previous), valueType, func(expected, found Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(stmt.Value),
+				"cannot redefine variable %s of type %s as %s inside a block", stmt.Name.Value, expected.String(), found.String()).
+				WithLabel("expected " + expected.String() + ", found " + found.String()).
+				WithNote("the block shares the enclosing scope, so the previous value remains when the block does not run")
+		})
+	}
+
+	// 値制限: 再代入で型が変わらないよう、関数リテラル以外は一般化しない
+	if isFunction {
+		tc.env.Set(stmt.Name.Value, tc.generalize(valueType, stmt.Name.Value))
+	} else {
+		tc.env.Set(stmt.Name.Value, valueType)
+	}
+	return tc.resolve(valueType)
+}
+
+// declareFunctions は文の並びに含まれるlet束縛された関数リテラルの名前を、型変数として事前に束縛する
+// 後で定義される関数を本体から呼び出す相互再帰を検査できるようにする
+// 同じスコープで定義済みの名前は、再定義するまで元の値を指すため束縛しない
+func (tc *TypeChecker) declareFunctions(statements []phase1.Statement) {
+	for _, stmt := range statements {
+		let, ok := stmt.(*phase1.LetStatement)
+		if !ok {
+			continue
+		}
+		if _, ok := let.Value.(*phase1.FunctionLiteral); !ok {
+			continue
+		}
+		if typ, ok := tc.env.store[let.Name.Value]; ok && typ != undefinedType {
+			continue
+		}
+		v := tc.freshVariable()
+		tc.env.Set(let.Name.Value, v)
+		tc.declared[let] = v
+	}
+}
+
+// undefinedType は同じスコープで後から定義される変数の、定義されるまでの型
+// 定義より前の参照は制御フロー解析が報告するため、型検査では報告しない
+var undefinedType = &UnknownType{Name: "undefined"}
+
+// declareVariables はスコープを作る文の並び（プログラム・関数本体・for文）で定義される変数の名前を、未定義として事前に束縛する
+// インタプリタと同じく、ifやwhileのブロックで定義される変数も同じスコープに含める
+func (tc *TypeChecker) declareVariables(statements []phase1.Statement) {
+	for _, name := range definedNames(statements) {
+		if _, ok := tc.env.store[name]; !ok {
+			tc.env.Set(name, undefinedType)
+		}
+	}
+}
+
+// declareFunction はlet文で定義する関数の名前に束縛した型変数を返す
+// declareFunctionsで事前に束縛されていない場合は、ここで新しい型変数を束縛する
+func (tc *TypeChecker) declareFunction(stmt *phase1.LetStatement) *TypeVariable {
+	if v, ok := tc.declared[stmt]; ok {
+		delete(tc.declared, stmt)
+		return v
+	}
+	v := tc.freshVariable()
+	tc.env.Set(stmt.Name.Value, v)
+	return v
 }
 
 // checkReturnStatement はreturn文の型検査を行う
+// 関数の中では、値の型を関数の戻り値の型と単一化する
 func (tc *TypeChecker) checkReturnStatement(stmt *phase1.ReturnStatement) Type {
+	var valueType Type = &UnknownType{Name: "void"}
 	if stmt.ReturnValue != nil {
-		return tc.CheckExpression(stmt.ReturnValue)
+		valueType = tc.CheckExpression(stmt.ReturnValue)
 	}
-	return &UnknownType{Name: "void"}
+	if tc.returnType != nil {
+		tc.checkReturnValue(stmt.ReturnValue, valueType)
+	}
+	return tc.resolve(valueType)
+}
+
+// checkReturnValue は関数が返す値の型を関数の戻り値の型と単一化する
+func (tc *TypeChecker) checkReturnValue(value phase1.Node, valueType Type) {
+	tc.expect(value, tc.returnType, valueType, func(expected, found Type) *phase1.Diagnostic {
+		if tc.returnAnnotation == nil {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(value),
+				"mismatched return types: expected %s, found %s", expected.String(), found.String()).
+				WithLabel("expected " + expected.String() + ", found " + found.String()).
+				WithNote("every return of a function must produce the same type")
+		}
+		return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(value),
+			"cannot return %s from function declared to return %s",
+			found.String(), expected.String()).
+			WithLabel("expected "+expected.String()+", found "+found.String()).
+			WithSecondary(phase1.NodeSpan(tc.returnAnnotation), "expected due to this return type")
+	})
+}
+
+// resolveTypeAnnotation は型注釈のASTを型に変換する
+func (tc *TypeChecker) resolveTypeAnnotation(annotation phase1.TypeAnnotation) Type {
+	switch node := annotation.(type) {
+	case *phase1.NamedType:
+		switch node.Name {
+		case "int":
+			return INT_TYPE
+		case "float":
+			return FLOAT_TYPE
+		case "string":
+			return STRING_TYPE
+		case "bool":
+			return BOOL_TYPE
+		}
+	case *phase1.ArrayTypeAnnotation:
+		return &ArrayType{ElementType: tc.resolveTypeAnnotation(node.ElementType)}
+	case *phase1.MapTypeAnnotation:
+		keyType := tc.resolveTypeAnnotation(node.KeyType)
+		if !tc.isHashableType(keyType) {
+			tc.addError(node.KeyType, CodeUnhashableKey, fmt.Sprintf("unusable as hash key: %s", keyType.String()))
+		}
+		return &MapType{KeyType: keyType, ValueType: tc.resolveTypeAnnotation(node.ValueType)}
+	case *phase1.FunctionTypeAnnotation:
+		params := make([]Type, len(node.Parameters))
+		for i, param := range node.Parameters {
+			params[i] = tc.resolveTypeAnnotation(param)
+		}
+		return &FunctionType{Parameters: params, ReturnType: tc.resolveTypeAnnotation(node.ReturnType)}
+	}
+
+	tc.addError(annotation, CodeUnsupportedNode, fmt.Sprintf("unknown type annotation: %s", annotation.String()))
+	return &UnknownType{Name: "error"}
 }
 
 // checkIdentifier は識別子の型検査を行う
+// 多相型の識別子は参照ごとに新しい型変数で具体化する
 func (tc *TypeChecker) checkIdentifier(node *phase1.Identifier) Type {
 	typ, ok := tc.env.Get(node.Value)
 	if !ok {
-		tc.addError(fmt.Sprintf("identifier not found: %s", node.Value))
+		tc.addDiagnostic(phase1.Errorf(CodeUndefinedIdentifier, phase1.NodeSpan(node),
+			"identifier not found: %s", node.Value).WithLabel("not found in this scope"))
 		return &UnknownType{Name: "error"}
 	}
-	return typ
+	if typ == undefinedType {
+		return &UnknownType{Name: "error"}
+	}
+	return tc.instantiate(typ)
 }
 
 // checkInfixExpression は中置式の型検査を行う
 func (tc *TypeChecker) checkInfixExpression(node *phase1.InfixExpression) Type {
 	leftType := tc.CheckExpression(node.Left)
 	rightType := tc.CheckExpression(node.Right)
+	return tc.checkInfixOperator(node, leftType, rightType)
+}
+
+// checkInfixOperator は検査済みの左辺と右辺の型に対して中置演算子の型検査を行う
+func (tc *TypeChecker) checkInfixOperator(node *phase1.InfixExpression, leftType, rightType Type) Type {
+	leftType, rightType = tc.resolve(leftType), tc.resolve(rightType)
 
 	switch node.Operator {
 	case "+", "-", "*", "/", "%":
-		// 算術演算子
-		leftIsNumeric := tc.isNumericType(leftType) || tc.isUnknownType(leftType)
-		rightIsNumeric := tc.isNumericType(rightType) || tc.isUnknownType(rightType)
+		// 文字列同士の + は連結（一方が型変数の場合はもう一方と同じ型として推論する）
+		if node.Operator == "+" && tc.isConcatenation(leftType, rightType) {
+			if err := tc.subst.Unify(leftType, rightType); err != nil {
+				tc.unifyError(node, err, func() *phase1.Diagnostic {
+					return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(node),
+						"cannot concatenate %s with %s", leftType.String(), rightType.String())
+				})
+			}
+			return STRING_TYPE
+		}
+
+		// 配列同士の + はインタプリタでも扱えないため、数値の演算と区別して報告する
+		if node.Operator == "+" && (isArrayType(leftType) || isArrayType(rightType)) {
+			tc.addDiagnostic(phase1.Errorf(CodeInvalidOperand, phase1.NodeSpan(node),
+				"cannot concatenate arrays with +: %s + %s", leftType.String(), rightType.String()).
+				WithSecondary(phase1.SpanFromToken(node.Token), "operator").
+				WithNote("use push to build a new array"))
+			return &UnknownType{Name: "error"}
+		}
+
+		// 算術演算子（両辺とも未決定の + は文字列の連結にもなりうる）
+		class := NumericClass
+		if node.Operator == "+" {
+			class = AddableClass
+		}
+		leftType, rightType = tc.inferNumericOperands(leftType, rightType, class)
 
-		if !leftIsNumeric {
-			tc.addError(fmt.Sprintf("left operand of %s must be numeric, got %s", node.Operator, leftType.String()))
+		if !tc.isNumericOperand(leftType) {
+			tc.operandError(node, node.Left, leftType, fmt.Sprintf("left operand of %s must be numeric, got %s", node.Operator, leftType.String()))
 		}
-		if !rightIsNumeric {
-			tc.addError(fmt.Sprintf("right operand of %s must be numeric, got %s", node.Operator, rightType.String()))
+		if !tc.isNumericOperand(rightType) {
+			tc.operandError(node, node.Right, rightType, fmt.Sprintf("right operand of %s must be numeric, got %s", node.Operator, rightType.String()))
 		}
 
 		// 型昇格: int + float = float
 		if leftType.Equals(FLOAT_TYPE) || rightType.Equals(FLOAT_TYPE) {
 			return FLOAT_TYPE
 		}
+		if tc.isTypeVariable(leftType) {
+			return leftType
+		}
 		return INT_TYPE
 
 	case "==", "!=":
 		// 等価演算子：同じ型同士で比較
-		if !leftType.Equals(rightType) {
-			tc.addError(fmt.Sprintf("cannot compare %s with %s", leftType.String(), rightType.String()))
-		}
+		tc.expect(node, leftType, rightType, func(left, right Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(node),
+				"cannot compare %s with %s", left.String(), right.String()).
+				WithSecondary(phase1.NodeSpan(node.Left), left.String()).
+				WithSecondary(phase1.NodeSpan(node.Right), right.String())
+		})
+		return BOOL_TYPE
+
+	case "&&", "||":
+		// 論理演算子：両辺ともbool型
+		tc.expectOperand(node, node.Left, BOOL_TYPE, leftType, "left operand of %s must be bool, got %s")
+		tc.expectOperand(node, node.Right, BOOL_TYPE, rightType, "right operand of %s must be bool, got %s")
 		return BOOL_TYPE
 
 	case "<", ">", "<=", ">=":
 		// 比較演算子：数値型のみ
-		if !tc.isNumericType(leftType) {
-			tc.addError(fmt.Sprintf("left operand of %s must be numeric, got %s", node.Operator, leftType.String()))
+		leftType, rightType = tc.inferNumericOperands(leftType, rightType, NumericClass)
+		if !tc.isNumericOperand(leftType) {
+			tc.operandError(node, node.Left, leftType, fmt.Sprintf("left operand of %s must be numeric, got %s", node.Operator, leftType.String()))
 		}
-		if !tc.isNumericType(rightType) {
-			tc.addError(fmt.Sprintf("right operand of %s must be numeric, got %s", node.Operator, rightType.String()))
+		if !tc.isNumericOperand(rightType) {
+			tc.operandError(node, node.Right, rightType, fmt.Sprintf("right operand of %s must be numeric, got %s", node.Operator, rightType.String()))
 		}
 		return BOOL_TYPE
 
 	default:
-		tc.addError(fmt.Sprintf("unknown infix operator: %s", node.Operator))
+		tc.addError(node, CodeInvalidOperand, fmt.Sprintf("unknown infix operator: %s", node.Operator))
 		return &UnknownType{Name: "error"}
 	}
 }
 
+// isConcatenation は + が文字列の連結になるかどうかをチェックする
+// 一方が文字列で、もう一方が文字列または型変数の場合に連結とみなす
+func (tc *TypeChecker) isConcatenation(left, right Type) bool {
+	_, leftString := left.(*StringType)
+	_, rightString := right.(*StringType)
+	return (leftString && (rightString || tc.isTypeVariable(right))) ||
+		(rightString && tc.isTypeVariable(left))
+}
+
+// inferNumericOperands は数値演算のオペランドのうち型が未決定のものを推論する
+// 一方が数値型ならもう一方も同じ型とし、両方とも未決定なら同じ型変数にしてclassの範囲に制限する
+func (tc *TypeChecker) inferNumericOperands(left, right Type, class TypeClass) (Type, Type) {
+	switch {
+	case tc.isTypeVariable(left) && tc.isNumericType(right):
+		_ = tc.subst.Unify(left, right)
+	case tc.isTypeVariable(right) && tc.isNumericType(left):
+		_ = tc.subst.Unify(right, left)
+	case tc.isTypeVariable(left) && tc.isTypeVariable(right):
+		v := tc.constrainedVariable(class)
+		_ = tc.subst.Unify(left, v)
+		_ = tc.subst.Unify(right, v)
+	}
+	return tc.resolve(left), tc.resolve(right)
+}
+
+// isNumericOperand は数値演算のオペランドとして使える型（数値型、未知型、数値演算で範囲を制限した型変数）かどうかをチェックする
+func (tc *TypeChecker) isNumericOperand(t Type) bool {
+	if v, ok := tc.resolve(t).(*TypeVariable); ok {
+		return v.Class != AnyClass
+	}
+	return tc.isNumericType(t) || tc.isUnknownType(t)
+}
+
 // checkPrefixExpression は前置式の型検査を行う
 func (tc *TypeChecker) checkPrefixExpression(node *phase1.PrefixExpression) Type {
-	rightType := tc.CheckExpression(node.Right)
+	rightType := tc.resolve(tc.CheckExpression(node.Right))
 
 	switch node.Operator {
 	case "-":
-		if !tc.isNumericType(rightType) {
-			tc.addError(fmt.Sprintf("operand of unary - must be numeric, got %s", rightType.String()))
+		if tc.isTypeVariable(rightType) {
+			_ = tc.subst.Unify(rightType, tc.constrainedVariable(NumericClass))
+			rightType = tc.resolve(rightType)
+		}
+		if !tc.isNumericOperand(rightType) {
+			tc.operandError(node, node.Right, rightType, fmt.Sprintf("operand of unary - must be numeric, got %s", rightType.String()))
 		}
 		return rightType
 	case "!":
-		if !rightType.Equals(BOOL_TYPE) {
-			tc.addError(fmt.Sprintf("operand of ! must be bool, got %s", rightType.String()))
-		}
+		tc.expectOperand(node, node.Right, BOOL_TYPE, rightType, "operand of %s must be bool, got %s")
 		return BOOL_TYPE
 	default:
-		tc.addError(fmt.Sprintf("unknown prefix operator: %s", node.Operator))
+		tc.addError(node, CodeInvalidOperand, fmt.Sprintf("unknown prefix operator: %s", node.Operator))
 		return &UnknownType{Name: "error"}
 	}
 }
 
-// checkIfExpression はif式の型検査を行う
-func (tc *TypeChecker) checkIfExpression(node *phase1.IfExpression) Type {
-	conditionType := tc.CheckExpression(node.Condition)
-	if !conditionType.Equals(BOOL_TYPE) {
-		tc.addError(fmt.Sprintf("if condition must be bool, got %s", conditionType.String()))
+// checkAssignExpression は代入式の型検査を行う
+func (tc *TypeChecker) checkAssignExpression(node *phase1.AssignExpression) Type {
+	var targetType Type
+	var targetName string
+
+	switch target := node.Target.(type) {
+	case *phase1.Identifier:
+		typ, ok := tc.env.Get(target.Value)
+		if typ == undefinedType {
+			tc.CheckExpression(node.Value)
+			return &UnknownType{Name: "error"}
+		}
+		if !ok {
+			tc.addDiagnostic(phase1.Errorf(CodeUndefinedIdentifier, phase1.NodeSpan(target),
+				"assignment to undeclared variable: %s", target.Value).
+				WithLabel("not declared in this scope").
+				WithNote(fmt.Sprintf("declare it first with `let %s = ...;`", target.Value)))
+			tc.CheckExpression(node.Value)
+			return &UnknownType{Name: "error"}
+		}
+		targetType = tc.instantiate(typ)
+		targetName = "variable " + target.Value
+	case *phase1.IndexExpression:
+		targetType = tc.checkIndexExpression(target)
+		targetName = "element of " + target.Left.String()
+	default:
+		tc.addError(node.Target, phase1.CodeInvalidAssignment, fmt.Sprintf("invalid assignment target: %s", node.Target.String()))
+		return &UnknownType{Name: "error"}
+	}
+	tc.types[node.Target] = targetType
+
+	var valueType Type
+	if op := node.BinaryOperator(); op != "" {
+		// 複合代入は対応する二項演算として検査する（代入先は検査済みの型を使い、再び検査しない）
+		valueType = tc.checkInfixOperator(&phase1.InfixExpression{
+			Token:    node.Token,
+			Left:     node.Target,
+			Operator: op,
+			Right:    node.Value,
+		}, targetType, tc.CheckExpression(node.Value))
+	} else {
+		valueType = tc.CheckExpression(node.Value)
 	}
 
-	// if文のブロック内で新しいスコープを作成
-	consequenceEnv := NewEnclosedTypeEnvironment(tc.env)
-	oldEnv := tc.env
-	tc.env = consequenceEnv
+	tc.expect(node.Value, targetType, valueType, func(expected, found Type) *phase1.Diagnostic {
+		return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(node.Value),
+			"cannot assign %s to %s of type %s", found.String(), targetName, expected.String()).
+			WithLabel("expected "+expected.String()+", found "+found.String()).
+			WithSecondary(phase1.NodeSpan(node.Target), "has type "+expected.String())
+	})
 
-	var consequenceType Type
-	for _, stmt := range node.Consequence.Statements {
-		consequenceType = tc.CheckStatement(stmt)
+	if tc.isUnknownType(tc.resolve(targetType)) {
+		return tc.resolve(valueType)
 	}
+	return tc.resolve(targetType)
+}
 
-	tc.env = oldEnv
+// checkArrayLiteral は配列リテラルの型検査を行う
+// 要素は全て同じ型でなければならず、空配列の要素型は新しい型変数となる
+func (tc *TypeChecker) checkArrayLiteral(node *phase1.ArrayLiteral) Type {
+	var elementType Type = tc.freshVariable()
+
+	for i, element := range node.Elements {
+		typ := tc.CheckExpression(element)
+		tc.expect(element, elementType, typ, func(expected, found Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(element),
+				"array elements must have the same type: element %d is %s, expected %s",
+				i, found.String(), expected.String()).
+				WithSecondary(phase1.NodeSpan(node.Elements[0]), "first element is "+expected.String())
+		})
+	}
 
-	if node.Alternative != nil {
-		// else文のブロック内で新しいスコープを作成
-		alternativeEnv := NewEnclosedTypeEnvironment(tc.env)
-		tc.env = alternativeEnv
+	return &ArrayType{ElementType: tc.resolve(elementType)}
+}
+
+// checkHashLiteral はハッシュリテラルの型検査を行う
+// キー同士・値同士はそれぞれ同じ型でなければならず、キーはハッシュ可能な型に限られる
+func (tc *TypeChecker) checkHashLiteral(node *phase1.HashLiteral) Type {
+	var keyType Type = tc.freshVariable()
+	var valueType Type = tc.freshVariable()
+
+	for i, pair := range node.Pairs {
+		kt := tc.resolve(tc.CheckExpression(pair.Key))
+		vt := tc.CheckExpression(pair.Value)
 
-		var alternativeType Type
-		for _, stmt := range node.Alternative.Statements {
-			alternativeType = tc.CheckStatement(stmt)
+		if !tc.isHashableType(kt) {
+			tc.addError(pair.Key, CodeUnhashableKey, fmt.Sprintf("unusable as hash key: %s", kt.String()))
 		}
 
-		tc.env = oldEnv
+		tc.expect(pair.Key, keyType, kt, func(expected, found Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(pair.Key),
+				"hash keys must have the same type: key %d is %s, expected %s", i, found.String(), expected.String())
+		})
+		tc.expect(pair.Value, valueType, vt, func(expected, found Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(pair.Value),
+				"hash values must have the same type: value %d is %s, expected %s", i, found.String(), expected.String())
+		})
+	}
 
-		// if-else式の型は両方の分岐の型が一致している必要がある
-		if consequenceType != nil && alternativeType != nil && !consequenceType.Equals(alternativeType) {
-			tc.addError(fmt.Sprintf("if-else branches have different types: %s vs %s",
-				consequenceType.String(), alternativeType.String()))
+	return &MapType{KeyType: tc.resolve(keyType), ValueType: tc.resolve(valueType)}
+}
+
+// checkIndexExpression は添字アクセス式の型検査を行う
+// 型が未決定の値への添字アクセスは、添字がintなら配列、それ以外ならハッシュとして推論する
+func (tc *TypeChecker) checkIndexExpression(node *phase1.IndexExpression) Type {
+	leftType := tc.resolve(tc.CheckExpression(node.Left))
+	indexType := tc.resolve(tc.CheckExpression(node.Index))
+
+	if tc.isTypeVariable(leftType) {
+		element := tc.freshVariable()
+		if indexType.Equals(INT_TYPE) || tc.isTypeVariable(indexType) {
+			_ = tc.subst.Unify(indexType, INT_TYPE)
+			_ = tc.subst.Unify(leftType, &ArrayType{ElementType: element})
+		} else {
+			_ = tc.subst.Unify(leftType, &MapType{KeyType: indexType, ValueType: element})
 		}
+		return element
+	}
+
+	switch container := leftType.(type) {
+	case *UnknownType:
+		return container
+	case *ArrayType:
+		tc.expect(node.Index, INT_TYPE, indexType, func(_, found Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeInvalidOperand, phase1.NodeSpan(node.Index),
+				"array index must be int, got %s", found.String())
+		})
+		return tc.resolve(container.ElementType)
+	case *MapType:
+		tc.expect(node.Index, container.KeyType, indexType, func(expected, found Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(node.Index),
+				"hash key must be %s, got %s", expected.String(), found.String())
+		})
+		return tc.resolve(container.ValueType)
+	default:
+		tc.addDiagnostic(phase1.Errorf(CodeNotIndexable, phase1.NodeSpan(node),
+			"index operator not supported: %s", leftType.String()).
+			WithSecondary(phase1.NodeSpan(node.Left), "has type "+leftType.String()))
+		return &UnknownType{Name: "error"}
+	}
+}
+
+// checkIfExpression はif式の型検査を行う
+func (tc *TypeChecker) checkIfExpression(node *phase1.IfExpression) Type {
+	if typ := tc.checkIfBranches(node); typ != nil {
+		return typ
+	}
+	return &UnknownType{Name: "void"}
+}
 
-		if alternativeType != nil {
-			return alternativeType
+// checkValueStatement は値を使う位置（関数本体や値を使うif式の分岐の末尾）の文の型検査を行う
+// 式文のif式は値を使うif式として、各分岐の型の一致を検査する
+func (tc *TypeChecker) checkValueStatement(stmt phase1.Statement) Type {
+	switch node := stmt.(type) {
+	case *phase1.ExpressionStatement:
+		return tc.CheckExpression(node.Expression)
+	case *phase1.BlockStatement:
+		if typ := tc.checkBlock(node, true); typ != nil {
+			return typ
 		}
+		return &UnknownType{Name: "void"}
 	}
+	return tc.CheckStatement(stmt)
+}
+
+// checkIfStatement は値を使わないif式（文として置いたif式）の条件と各分岐を検査する
+// 分岐の型は単一化せず、if式自体は値を持たない
+func (tc *TypeChecker) checkIfStatement(node *phase1.IfExpression) Type {
+	tc.checkCondition("if", node.Condition)
+	tc.checkBlock(node.Consequence, false)
+	switch {
+	case node.ElseIf != nil:
+		tc.types[node.ElseIf] = tc.checkIfStatement(node.ElseIf)
+	case node.Alternative != nil:
+		tc.checkBlock(node.Alternative, false)
+	}
+	return &UnknownType{Name: "void"}
+}
+
+// checkIfBranches はif式の条件と各分岐を検査し、分岐の値の型を返す（値を持つ分岐がなければnil）
+// else if の連鎖を含め、値を持つ全ての分岐の型が一致している必要がある
+func (tc *TypeChecker) checkIfBranches(node *phase1.IfExpression) Type {
+	tc.checkCondition("if", node.Condition)
 
+	consequenceType := tc.checkBlock(node.Consequence, true)
+
+	var alternativeType Type
+	switch {
+	case node.ElseIf != nil:
+		alternativeType = tc.checkIfBranches(node.ElseIf)
+	case node.Alternative != nil:
+		alternativeType = tc.checkBlock(node.Alternative, true)
+	}
+
+	// if-else式の型は全ての分岐の型が一致している必要がある
+	if consequenceType != nil && alternativeType != nil {
+		tc.expect(node, consequenceType, alternativeType, func(consequence, alternative Type) *phase1.Diagnostic {
+			return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(node),
+				"if-else branches have different types: %s vs %s",
+				consequence.String(), alternative.String()).
+				WithNote("every branch of an if expression must produce the same type")
+		})
+	}
+
+	if alternativeType != nil {
+		return tc.resolve(alternativeType)
+	}
 	if consequenceType != nil {
-		return consequenceType
+		return tc.resolve(consequenceType)
 	}
+	return nil
+}
 
+// checkBlock はブロックを外側と同じスコープで検査し、最後の文の型を返す
+// valueが真ならブロックの値を使うため、最後の文を checkValueStatement で検査する
+// 文がない場合や、return文などで抜けて末尾に到達しない場合はnilを返す
+func (tc *TypeChecker) checkBlock(block *phase1.BlockStatement, value bool) Type {
+	tc.branches++
+	var blockType Type
+	tc.declareFunctions(block.Statements)
+	for i, stmt := range block.Statements {
+		if value && i == len(block.Statements)-1 {
+			blockType = tc.checkValueStatement(stmt)
+		} else {
+			blockType = tc.CheckStatement(stmt)
+		}
+	}
+	tc.branches--
+
+	if !blockCompletesNormally(block) {
+		return nil
+	}
+	return blockType
+}
+
+// checkCondition は条件式の型がboolであることを検査する
+func (tc *TypeChecker) checkCondition(kind string, condition phase1.Expression) {
+	conditionType := tc.CheckExpression(condition)
+	tc.expect(condition, BOOL_TYPE, conditionType, func(_, found Type) *phase1.Diagnostic {
+		return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(condition),
+			"%s condition must be bool, got %s", kind, found.String()).
+			WithLabel("expected bool, found " + found.String())
+	})
+}
+
+// checkWhileStatement はwhile文の型検査を行う
+func (tc *TypeChecker) checkWhileStatement(stmt *phase1.WhileStatement) Type {
+	tc.checkCondition("while", stmt.Condition)
+	tc.checkBlock(stmt.Body, false)
+	return &UnknownType{Name: "void"}
+}
+
+// checkForStatement はfor文の型検査を行う
+// 初期化文と本体で定義された変数はループの中だけで有効なスコープに束縛する
+func (tc *TypeChecker) checkForStatement(stmt *phase1.ForStatement) Type {
+	oldEnv, oldBranches := tc.env, tc.branches
+	tc.env, tc.branches = NewEnclosedTypeEnvironment(oldEnv), 0
+	tc.declareVariables(append([]phase1.Statement{stmt.Initializer}, stmt.Body.Statements...))
+
+	if stmt.Initializer != nil {
+		tc.CheckStatement(stmt.Initializer)
+	}
+	if stmt.Condition != nil {
+		tc.checkCondition("for", stmt.Condition)
+	}
+	tc.checkBlock(stmt.Body, false)
+	if stmt.Update != nil {
+		tc.CheckExpression(stmt.Update)
+	}
+
+	tc.env, tc.branches = oldEnv, oldBranches
 	return &UnknownType{Name: "void"}
 }
 
 // checkFunctionLiteral は関数リテラルの型検査を行う
+// 型注釈のあるパラメータ・戻り値はその型とし、注釈のないものは型変数として本体から推論する
 func (tc *TypeChecker) checkFunctionLiteral(node *phase1.FunctionLiteral) Type {
-	// パラメータの型を推論（現在は全てUnknownType）
 	params := make([]Type, len(node.Parameters))
 	for i := range node.Parameters {
-		params[i] = &UnknownType{Name: fmt.Sprintf("param%d", i)}
+		if annotation := node.ParameterType(i); annotation != nil {
+			params[i] = tc.resolveTypeAnnotation(annotation)
+		} else {
+			params[i] = tc.freshVariable()
+		}
+	}
+
+	var returnType Type
+	if node.ReturnType != nil {
+		returnType = tc.resolveTypeAnnotation(node.ReturnType)
+	} else {
+		returnType = tc.freshVariable()
 	}
 
 	// 関数本体用の新しい環境を作成
 	funcEnv := NewEnclosedTypeEnvironment(tc.env)
 	oldEnv := tc.env
+	oldReturnType, oldReturnAnnotation, oldBranches := tc.returnType, tc.returnAnnotation, tc.branches
 	tc.env = funcEnv
+	tc.returnType, tc.returnAnnotation, tc.branches = returnType, node.ReturnType, 0
 
 	// パラメータを環境に追加
 	for i, param := range node.Parameters {
 		tc.env.Set(param.Value, params[i])
 	}
 
-	// 関数本体の型検査
-	var returnType Type = &UnknownType{Name: "void"}
-	for _, stmt := range node.Body.Statements {
-		stmtType := tc.CheckStatement(stmt)
-		if _, ok := stmt.(*phase1.ReturnStatement); ok {
-			returnType = stmtType
+	// 関数本体の型検査（return文の値はcheckReturnStatementで戻り値の型と単一化される）
+	tc.declareVariables(node.Body.Statements)
+	tc.declareFunctions(node.Body.Statements)
+	for i, stmt := range node.Body.Statements {
+		if i == len(node.Body.Statements)-1 {
+			tc.checkValueStatement(stmt)
+		} else {
+			tc.CheckStatement(stmt)
 		}
 	}
 
+	// 本体の末尾に到達する式文の値は暗黙の戻り値になる
+	paths := analyzeReturnPaths(node.Body)
+	if paths.implicit != nil {
+		tc.checkReturnValue(paths.implicit, tc.types[paths.implicit])
+	}
+
+	if paths.returnsValue || node.ReturnType != nil {
+		if len(paths.valueless) > 0 {
+			tc.missingReturnError(node, paths.valueless)
+		}
+	} else {
+		// 値を返さない関数の戻り値はvoidとする
+		_ = tc.subst.Unify(returnType, &UnknownType{Name: "void"})
+	}
+
 	tc.env = oldEnv
+	tc.returnType, tc.returnAnnotation, tc.branches = oldReturnType, oldReturnAnnotation, oldBranches
 
-	return &FunctionType{
+	return tc.resolve(&FunctionType{
 		Parameters: params,
 		ReturnType: returnType,
+	})
+}
+
+// missingReturnError は値を返す関数に値を返さずに抜ける経路がある場合のエラーを報告する
+func (tc *TypeChecker) missingReturnError(node *phase1.FunctionLiteral, valueless []phase1.Node) {
+	d := phase1.Errorf(CodeMissingReturn, phase1.NodeSpan(node), "not all paths return a value").
+		WithLabel("this function returns a value on some paths")
+	for _, exit := range valueless {
+		switch exit.(type) {
+		case *phase1.ReturnStatement:
+			d.WithSecondary(phase1.NodeSpan(exit), "returns without a value")
+		case *phase1.IfExpression:
+			d.WithSecondary(phase1.NodeSpan(exit), "this `if` has no `else` branch")
+		default:
+			if exit == phase1.Node(node.Body) {
+				d.WithSecondary(phase1.NodeSpan(exit), "the end of the function body is reachable without a value")
+			} else {
+				d.WithSecondary(phase1.NodeSpan(exit), "this branch does not produce a value")
+			}
+		}
 	}
+	tc.addDiagnostic(d.WithNote("add a `return` or a final expression to every path"))
 }
 
 // checkCallExpression は関数呼び出し式の型検査を行う
 func (tc *TypeChecker) checkCallExpression(node *phase1.CallExpression) Type {
-	funcType := tc.CheckExpression(node.Function)
+	funcType := tc.resolve(tc.CheckExpression(node.Function))
 
 	// 組み込み関数の特別処理
 	if ident, ok := node.Function.(*phase1.Identifier); ok {
@@ -459,40 +1118,213 @@ func (tc *TypeChecker) checkCallExpression(node *phase1.CallExpression) Type {
 			for _, arg := range node.Arguments {
 				tc.CheckExpression(arg)
 			}
-			return STRING_TYPE
+			return NULL_TYPE
+		case "len":
+			// lenは配列に加えて文字列も受け取る（型が未決定の引数は配列として推論する）
+			if len(node.Arguments) == 1 {
+				return tc.checkLenArgument(node.Arguments[0])
+			}
 		}
 	}
 
-	function, ok := funcType.(*FunctionType)
-	if !ok {
-		tc.addError(fmt.Sprintf("not a function: %s", funcType.String()))
+	switch function := funcType.(type) {
+	case *TypeVariable:
+		// 型が未決定の値の呼び出しから関数型を推論する
+		params := make([]Type, len(node.Arguments))
+		for i, arg := range node.Arguments {
+			params[i] = tc.CheckExpression(arg)
+		}
+		result := tc.freshVariable()
+		tc.expect(node.Function, function, &FunctionType{Parameters: params, ReturnType: result}, nil)
+		return tc.resolve(result)
+	case *UnknownType:
+		for _, arg := range node.Arguments {
+			tc.CheckExpression(arg)
+		}
+		return function
+	case *FunctionType:
+		// 引数の数をチェック
+		if len(node.Arguments) != len(function.Parameters) {
+			tc.addDiagnostic(phase1.Errorf(CodeArgumentCount, phase1.NodeSpan(node),
+				"wrong number of arguments: expected %d, got %d",
+				len(function.Parameters), len(node.Arguments)).
+				WithSecondary(phase1.NodeSpan(node.Function), "function type is "+function.String()))
+			return tc.resolve(function.ReturnType)
+		}
+
+		// 各引数の型をパラメータの型と単一化する
+		for i, arg := range node.Arguments {
+			argType := tc.CheckExpression(arg)
+			tc.expect(arg, function.Parameters[i], argType, func(expected, found Type) *phase1.Diagnostic {
+				return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(arg),
+					"argument %d: expected %s, got %s", i, expected.String(), found.String()).
+					WithLabel("expected " + expected.String())
+			})
+		}
+
+		return tc.resolve(function.ReturnType)
+	default:
+		tc.addDiagnostic(phase1.Errorf(CodeNotCallable, phase1.NodeSpan(node.Function),
+			"not a function: %s", funcType.String()).
+			WithLabel("has type " + funcType.String()))
 		return &UnknownType{Name: "error"}
 	}
+}
 
-	// 引数の数をチェック
-	if len(node.Arguments) != len(function.Parameters) {
-		tc.addError(fmt.Sprintf("wrong number of arguments: expected %d, got %d",
-			len(function.Parameters), len(node.Arguments)))
-		return function.ReturnType
+// checkLenArgument は組み込み関数lenの引数を検査し、結果の型（int）を返す
+func (tc *TypeChecker) checkLenArgument(arg phase1.Expression) Type {
+	argType := tc.CheckExpression(arg)
+	if _, ok := tc.resolve(argType).(*StringType); ok {
+		return INT_TYPE
 	}
+	tc.expect(arg, &ArrayType{ElementType: tc.freshVariable()}, argType, func(expected, found Type) *phase1.Diagnostic {
+		return phase1.Errorf(CodeTypeMismatch, phase1.NodeSpan(arg),
+			"argument to `len` must be an array or a string, got %s", found.String()).
+			WithLabel("has type " + found.String())
+	})
+	return INT_TYPE
+}
 
-	// 各引数の型をチェック
-	for i, arg := range node.Arguments {
-		argType := tc.CheckExpression(arg)
-		expectedType := function.Parameters[i]
+// freshVariable は新しい型変数を作成する
+func (tc *TypeChecker) freshVariable() *TypeVariable {
+	v := &TypeVariable{ID: tc.nextVar, Name: typeVariableName(tc.nextVar)}
+	tc.nextVar++
+	return v
+}
 
-		// UnknownTypeは任意の型にマッチする
-		if _, ok := expectedType.(*UnknownType); ok {
-			continue
+// constrainedVariable はclassの範囲に制限した新しい型変数を作成する
+func (tc *TypeChecker) constrainedVariable(class TypeClass) *TypeVariable {
+	v := tc.freshVariable()
+	v.Class = class
+	return v
+}
+
+// resolve は現在の置換を型に適用する
+func (tc *TypeChecker) resolve(t Type) Type {
+	return tc.subst.Apply(t)
+}
+
+// instantiate は多相型の量化された型変数を新しい型変数に置き換える
+func (tc *TypeChecker) instantiate(t Type) Type {
+	scheme, ok := t.(*TypeScheme)
+	if !ok {
+		return t
+	}
+	mapping := map[int]Type{}
+	for _, v := range scheme.Variables {
+		instance := tc.constrainedVariable(v.Class)
+		tc.instances[v.ID] = append(tc.instances[v.ID], instance)
+		mapping[v.ID] = instance
+	}
+	return replaceTypeVariables(tc.resolve(scheme.Type), mapping)
+}
+
+// instanceTypes は量化された型変数が多相型の具体化を通じて取る具体的な型を返す
+// 具体化した型変数が別の多相型の量化された型変数になっている場合は、その具体化もたどる
+func (tc *TypeChecker) instanceTypes(v *TypeVariable) []Type {
+	var types []Type
+	visited := map[int]bool{}
+	var visit func(v *TypeVariable)
+	visit = func(v *TypeVariable) {
+		if visited[v.ID] {
+			return
+		}
+		visited[v.ID] = true
+		for _, instance := range tc.instances[v.ID] {
+			switch t := tc.resolve(instance).(type) {
+			case *TypeVariable:
+				visit(t)
+			default:
+				types = append(types, t)
+			}
 		}
+	}
+	visit(v)
+	return types
+}
+
+// generalize は環境に自由に現れない型変数について型を量化する
+// nameは一般化する値を束縛する名前で、再帰のため事前に束縛したその名前自身の型は環境から除く
+func (tc *TypeChecker) generalize(t Type, name string) Type {
+	t = tc.resolve(t)
+
+	inEnv := map[int]bool{}
+	for env := tc.env; env != nil; env = env.outer {
+		for key, typ := range env.store {
+			if env == tc.env && key == name {
+				continue
+			}
+			for _, v := range freeTypeVariables(tc.resolve(typ)) {
+				inEnv[v.ID] = true
+			}
+		}
+	}
+
+	var variables []*TypeVariable
+	for _, v := range freeTypeVariables(t) {
+		if !inEnv[v.ID] {
+			variables = append(variables, v)
+		}
+	}
+	if len(variables) == 0 {
+		return t
+	}
+	return &TypeScheme{Variables: variables, Type: t}
+}
 
-		if !argType.Equals(expectedType) {
-			tc.addError(fmt.Sprintf("argument %d: expected %s, got %s",
-				i, expectedType.String(), argType.String()))
+// expect は2つの型を単一化し、失敗した場合は診断を報告する
+// mismatchは置換を適用した2つの型から型の不一致の診断を作る（nilの場合は出現検査の失敗のみ報告する）
+// 期待する型が範囲を制限した型変数なら、その範囲を注記に加える
+func (tc *TypeChecker) expect(node phase1.Node, expected, found Type, mismatch func(expected, found Type) *phase1.Diagnostic) bool {
+	err := tc.subst.Unify(expected, found)
+	if err == nil {
+		return true
+	}
+	tc.unifyError(node, err, func() *phase1.Diagnostic {
+		if mismatch == nil {
+			return nil
 		}
+		// 範囲を持つ型変数は、型変数の名前だけでなくその範囲を期待する型として示す
+		types := normalizeTypes(tc.resolve(expected), tc.resolve(found))
+		var notes []string
+		for i, t := range types {
+			if v, ok := t.(*TypeVariable); ok && v.Class != AnyClass {
+				types[i] = classBound{v}
+				notes = append(notes, fmt.Sprintf("%s must be %s", v.String(), v.Class.String()))
+			}
+		}
+		d := mismatch(types[0], types[1])
+		for _, note := range notes {
+			if d != nil {
+				d.WithNote(note)
+			}
+		}
+		return d
+	})
+	return false
+}
+
+// unifyError は単一化の失敗を診断として報告する
+// 出現検査の失敗は無限型のエラーとし、それ以外はmismatchが作る診断を報告する
+func (tc *TypeChecker) unifyError(node phase1.Node, err error, mismatch func() *phase1.Diagnostic) {
+	if unifyErr, ok := err.(*UnifyError); ok && unifyErr.Occurs {
+		types := normalizeTypes(unifyErr.Left, unifyErr.Right)
+		tc.addDiagnostic(phase1.Errorf(CodeInfiniteType, phase1.NodeSpan(node),
+			"infinite type: %s occurs in %s", types[0].String(), types[1].String()).
+			WithLabel("cannot construct the infinite type " + types[0].String() + " = " + types[1].String()))
+		return
 	}
+	if d := mismatch(); d != nil {
+		tc.addDiagnostic(d)
+	}
+}
 
-	return function.ReturnType
+// expectOperand は演算子のオペランドを期待する型と単一化し、失敗した場合はオペランドのエラーを報告する
+func (tc *TypeChecker) expectOperand(node, operand phase1.Node, expected, operandType Type, format string) {
+	if err := tc.subst.Unify(expected, operandType); err != nil {
+		operandType = tc.resolve(operandType)
+		tc.operandError(node, operand, operandType, fmt.Sprintf(format, operatorToken(node).Literal, operandType.String()))
+	}
 }
 
 // isNumericType は数値型かどうかをチェックする
@@ -500,23 +1332,76 @@ func (tc *TypeChecker) isNumericType(t Type) bool {
 	return t.Equals(INT_TYPE) || t.Equals(FLOAT_TYPE)
 }
 
+// isHashableType はハッシュのキーとして使える型（int、string、bool）かどうかをチェックする
+// 型が未決定の場合はキーにできるものとみなす
+func (tc *TypeChecker) isHashableType(t Type) bool {
+	return t.Equals(INT_TYPE) || t.Equals(STRING_TYPE) || t.Equals(BOOL_TYPE) || tc.isUnknownType(t) || tc.isTypeVariable(t)
+}
+
 // isUnknownType は未知型かどうかをチェックする
 func (tc *TypeChecker) isUnknownType(t Type) bool {
 	_, ok := t.(*UnknownType)
 	return ok
 }
 
-// addError はエラーメッセージを追加する
-func (tc *TypeChecker) addError(message string) {
-	tc.errors = append(tc.errors, message)
+// isArrayType は配列型かどうかをチェックする
+func isArrayType(t Type) bool {
+	_, ok := t.(*ArrayType)
+	return ok
+}
+
+// isTypeVariable は未束縛の型変数かどうかをチェックする
+func (tc *TypeChecker) isTypeVariable(t Type) bool {
+	_, ok := tc.resolve(t).(*TypeVariable)
+	return ok
+}
+
+// addError はノードの位置を主要スパンとするエラー診断を追加する
+func (tc *TypeChecker) addError(node phase1.Node, code, message string) {
+	tc.addDiagnostic(phase1.NewDiagnostic(phase1.SeverityError, code, phase1.NodeSpan(node), message))
+}
+
+// operandError は演算子のオペランドの型が不正な場合のエラー診断を追加する
+func (tc *TypeChecker) operandError(node, operand phase1.Node, operandType Type, message string) {
+	tc.addDiagnostic(phase1.Errorf(CodeInvalidOperand, phase1.NodeSpan(operand), "%s", message).
+		WithLabel("has type "+operandType.String()).
+		WithSecondary(phase1.SpanFromToken(operatorToken(node)), "operator"))
+}
+
+// operatorToken は演算子ノードのトークンを返す
+func operatorToken(node phase1.Node) phase1.Token {
+	switch n := node.(type) {
+	case *phase1.InfixExpression:
+		return n.Token
+	case *phase1.PrefixExpression:
+		return n.Token
+	default:
+		return phase1.Token{}
+	}
+}
+
+// addDiagnostic は診断を追加する
+func (tc *TypeChecker) addDiagnostic(d *phase1.Diagnostic) {
+	tc.diagnostics = append(tc.diagnostics, d)
+}
+
+// Diagnostics は型検査で検出した診断のリストを取得する
+func (tc *TypeChecker) Diagnostics() []*phase1.Diagnostic {
+	return tc.diagnostics
 }
 
 // GetErrors はエラーメッセージのリストを取得する
 func (tc *TypeChecker) GetErrors() []string {
-	return tc.errors
+	errors := []string{}
+	for _, d := range tc.diagnostics {
+		if d.Severity == phase1.SeverityError {
+			errors = append(errors, d.Message)
+		}
+	}
+	return errors
 }
 
 // HasErrors はエラーがあるかどうかをチェックする
 func (tc *TypeChecker) HasErrors() bool {
-	return len(tc.errors) > 0
+	return phase1.HasErrors(tc.diagnostics)
 }
@@ -109,6 +109,337 @@ func TestTypeChecker_Variables(t *testing.T) {
 	}
 }
 
+// TestTypeChecker_AssignExpressions は代入式の型検査をテストする
+func TestTypeChecker_AssignExpressions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		hasError bool
+	}{
+		{
+			name:     "同じ型の代入",
+			input:    "let x = 1; x = 2",
+			expected: "int",
+			hasError: false,
+		},
+		{
+			name:     "複合代入",
+			input:    "let x = 1.5; x *= 2.0",
+			expected: "float",
+			hasError: false,
+		},
+		{
+			name:     "異なる型の代入（エラー）",
+			input:    "let x = 1; x = \"hello\"",
+			expected: "int",
+			hasError: true,
+		},
+		{
+			name:     "未宣言変数への代入（エラー）",
+			input:    "y = 1",
+			expected: "?error",
+			hasError: true,
+		},
+		{
+			name:     "文字列への複合代入（エラー）",
+			input:    "let s = \"a\"; s -= 1",
+			expected: "string",
+			hasError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError {
+				if len(errors) == 0 {
+					t.Errorf("expected errors, but got none")
+				}
+			} else {
+				if len(errors) > 0 {
+					t.Errorf("unexpected errors: %v", errors)
+				}
+			}
+
+			if resultType.String() != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, resultType.String())
+			}
+		})
+	}
+}
+
+// TestTypeChecker_CompoundAssignTarget は複合代入の代入先のエラーを1回だけ報告することをテストする
+func TestTypeChecker_CompoundAssignTarget(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs[0] += 1", "identifier not found: xs"},
+		{"let a = [1]; a[\"k\" - 1] += 1", "left operand of - must be numeric, got string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			_, errors := NewTypeChecker().CheckProgram(program)
+			if len(errors) != 1 || errors[0] != tt.expected {
+				t.Errorf("expected only error %q, got %v", tt.expected, errors)
+			}
+		})
+	}
+}
+
+// TestTypeChecker_Arrays は配列の型推論をテストする
+func TestTypeChecker_Arrays(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		hasError bool
+	}{
+		{
+			name:     "整数配列",
+			input:    "[1, 2, 3]",
+			expected: "[int]",
+			hasError: false,
+		},
+		{
+			name:     "空配列",
+			input:    "[]",
+			expected: "[?T]",
+			hasError: false,
+		},
+		{
+			name:     "入れ子の配列",
+			input:    "[[1], [2, 3]]",
+			expected: "[[int]]",
+			hasError: false,
+		},
+		{
+			name:     "異なる型の要素（エラー）",
+			input:    "[1, \"a\"]",
+			expected: "[int]",
+			hasError: true,
+		},
+		{
+			name:     "添字アクセス",
+			input:    "let a = [1.5, 2.5]; a[0]",
+			expected: "float",
+			hasError: false,
+		},
+		{
+			name:     "整数以外の添字（エラー）",
+			input:    "let a = [1, 2]; a[true]",
+			expected: "int",
+			hasError: true,
+		},
+		{
+			name:     "配列以外への添字アクセス（エラー）",
+			input:    "5[0]",
+			expected: "?error",
+			hasError: true,
+		},
+		{
+			name:     "要素への代入",
+			input:    "let a = [1, 2]; a[0] = 5",
+			expected: "int",
+			hasError: false,
+		},
+		{
+			name:     "要素への異なる型の代入（エラー）",
+			input:    "let a = [1, 2]; a[0] = \"x\"",
+			expected: "int",
+			hasError: true,
+		},
+		{
+			name:     "配列同士の+（エラー）",
+			input:    "[1] + [2, 3]",
+			expected: "?error",
+			hasError: true,
+		},
+		{
+			name:     "組み込み関数に配列を渡す",
+			input:    "len([1, 2, 3])",
+			expected: "int",
+			hasError: false,
+		},
+		{
+			name:     "文字列の連結",
+			input:    `let s = "pu" + "g"; s + "!"`,
+			expected: "string",
+			hasError: false,
+		},
+		{
+			name:     "文字列と配列の連結",
+			input:    `"a" + [1]`,
+			expected: "?error",
+			hasError: true,
+		},
+		{
+			name:     "組み込み関数lenに文字列を渡す",
+			input:    `len("hello")`,
+			expected: "int",
+			hasError: false,
+		},
+		{
+			name:     "組み込み関数lenに整数を渡す",
+			input:    "len(1)",
+			expected: "int",
+			hasError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError {
+				if len(errors) == 0 {
+					t.Errorf("expected errors, but got none")
+				}
+			} else {
+				if len(errors) > 0 {
+					t.Errorf("unexpected errors: %v", errors)
+				}
+			}
+
+			if resultType.String() != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, resultType.String())
+			}
+		})
+	}
+}
+
+// TestTypeChecker_Hashes はハッシュの型検査をテストする
+func TestTypeChecker_Hashes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		hasError bool
+	}{
+		{
+			name:     "文字列キーのハッシュ",
+			input:    "{\"a\": 1, \"b\": 2}",
+			expected: "{string: int}",
+			hasError: false,
+		},
+		{
+			name:     "空のハッシュ",
+			input:    "{}",
+			expected: "{?T: ?U}",
+			hasError: false,
+		},
+		{
+			name:     "キーの型が不一致（エラー）",
+			input:    "{\"a\": 1, 2: 2}",
+			expected: "{string: int}",
+			hasError: true,
+		},
+		{
+			name:     "値の型が不一致（エラー）",
+			input:    "{1: true, 2: \"x\"}",
+			expected: "{int: bool}",
+			hasError: true,
+		},
+		{
+			name:     "ハッシュ不可能なキー（エラー）",
+			input:    "{1.5: 1}",
+			expected: "{float: int}",
+			hasError: true,
+		},
+		{
+			name:     "キー参照",
+			input:    "let h = {\"a\": 1.5}; h[\"a\"]",
+			expected: "float",
+			hasError: false,
+		},
+		{
+			name:     "キーの型が異なる参照（エラー）",
+			input:    "let h = {\"a\": 1}; h[1]",
+			expected: "int",
+			hasError: true,
+		},
+		{
+			name:     "値の更新",
+			input:    "let h = {\"a\": 1}; h[\"b\"] = 2",
+			expected: "int",
+			hasError: false,
+		},
+		{
+			name:     "異なる型の値で更新（エラー）",
+			input:    "let h = {\"a\": 1}; h[\"b\"] = false",
+			expected: "int",
+			hasError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError {
+				if len(errors) == 0 {
+					t.Errorf("expected errors, but got none")
+				}
+			} else {
+				if len(errors) > 0 {
+					t.Errorf("unexpected errors: %v", errors)
+				}
+			}
+
+			if resultType.String() != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, resultType.String())
+			}
+		})
+	}
+}
+
+// TestTypeChecker_LogicalOperations は論理演算の型検査をテストする
+func TestTypeChecker_LogicalOperations(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		hasError bool
+	}{
+		{"論理積", "true && false", false},
+		{"論理和", "1 < 2 || 3 > 4", false},
+		{"左辺が整数（エラー）", "1 && true", true},
+		{"右辺が文字列（エラー）", "false || \"x\"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError && len(errors) == 0 {
+				t.Errorf("expected errors, but got none")
+			}
+			if !tt.hasError && len(errors) > 0 {
+				t.Errorf("unexpected errors: %v", errors)
+			}
+
+			if !resultType.Equals(BOOL_TYPE) {
+				t.Errorf("expected type bool, got %s", resultType.String())
+			}
+		})
+	}
+}
+
 // TestTypeChecker_ArithmeticOperations は算術演算の型検査をテストする
 func TestTypeChecker_ArithmeticOperations(t *testing.T) {
 	tests := []struct {
@@ -368,6 +699,57 @@ func TestTypeChecker_FunctionTypes(t *testing.T) {
 	}
 }
 
+// TestTypeChecker_TypeAnnotations は型注釈の検査をテストする
+func TestTypeChecker_TypeAnnotations(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		hasError bool
+	}{
+		{"注釈付きlet", "let x: int = 1; x", "int", false},
+		{"注釈と値の型不一致", "let x: int = \"a\";", "", true},
+		{"intはfloatに暗黙変換しない", "let x: float = 1;", "", true},
+		{"空配列は注釈の要素型になる", "let xs: [string] = []; xs", "[string]", false},
+		{"ハッシュ型の注釈", "let m: {string: int} = {\"a\": 1}; m", "{string: int}", false},
+		{"ハッシュ型の値の不一致", "let m: {string: int} = {\"a\": true};", "", true},
+		{"ハッシュのキーにできない型", "let m: {[int]: int} = {};", "", true},
+		{"注釈付きパラメータ", "fn(a: int, b: float) -> float { a + b }", "fn(int, float) -> float", false},
+		{"注釈のないパラメータは型変数", "fn(a, b: bool) -> bool { b }", "fn(?T, bool) -> bool", false},
+		{"パラメータの型で本体を検査する", "fn(a: string) { a - 1 }", "", true},
+		{"引数の型を検査する", "let f = fn(a: int) -> int { a }; f(\"x\")", "", true},
+		{"注釈付き関数の呼び出し結果", "let f = fn(a: int) -> bool { a > 0 }; f(1)", "bool", false},
+		{"return文の型不一致", "fn(a: int) -> string { return a; }", "", true},
+		{"暗黙の戻り値の型不一致", "fn(a: int) -> bool { a + 1 }", "", true},
+		{"if式の分岐のreturn", "fn(a: int) -> int { if (a > 0) { return a; } else { return 0; } }", "fn(int) -> int", false},
+		{"関数型の注釈", "let f: fn(int) -> int = fn(x: int) -> int { x * 2 }; f", "fn(int) -> int", false},
+		{"関数型の注釈との不一致", "let f: fn(int) -> int = fn(x: string) -> int { 1 };", "", true},
+		{"高階関数", "let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) }; apply(fn(n: int) -> int { n }, 1)", "int", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError {
+				if len(errors) == 0 {
+					t.Errorf("expected errors, but got none (type %s)", resultType)
+				}
+				return
+			}
+			if len(errors) > 0 {
+				t.Fatalf("unexpected errors: %v", errors)
+			}
+			if resultType.String() != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, resultType.String())
+			}
+		})
+	}
+}
+
 // TestTypeChecker_BuiltinFunctions は組み込み関数の型検査をテストする
 func TestTypeChecker_BuiltinFunctions(t *testing.T) {
 	tests := []struct {
@@ -379,12 +761,28 @@ func TestTypeChecker_BuiltinFunctions(t *testing.T) {
 		{
 			name:     "puts関数の呼び出し",
 			input:    "puts(\"hello\")",
-			expected: "string",
+			expected: "null",
 			hasError: false,
 		},
 		{
 			name:     "puts関数に複数の引数",
 			input:    "puts(\"hello\", 42, true)",
+			expected: "null",
+			hasError: false,
+		},
+		{
+			name:     "putsの結果を値として使う（エラー）",
+			input:    "len(puts(2))",
+			hasError: true,
+		},
+		{
+			name:     "putsの結果の文字列との連結（エラー）",
+			input:    `"a" + puts(1)`,
+			hasError: true,
+		},
+		{
+			name:     "last関数は要素の型を返す",
+			input:    `last(["a", "b"])`,
 			expected: "string",
 			hasError: false,
 		},
@@ -424,28 +822,69 @@ func TestTypeChecker_IfExpressions(t *testing.T) {
 	}{
 		{
 			name:     "条件がブール値のif式",
-			input:    "if (true) { 42 }",
+			input:    "let v = if (true) { 42 }; v",
 			expected: "int",
 			hasError: false,
 		},
 		{
 			name:     "条件が整数のif式（エラー）",
-			input:    "if (42) { 100 }",
+			input:    "let v = if (42) { 100 }; v",
 			expected: "int",
 			hasError: true,
 		},
 		{
 			name:     "if-else式（同じ型）",
-			input:    "if (true) { 42 } else { 24 }",
+			input:    "let v = if (true) { 42 } else { 24 }; v",
 			expected: "int",
 			hasError: false,
 		},
 		{
 			name:     "if-else式（異なる型・エラー）",
-			input:    "if (true) { 42 } else { \"hello\" }",
+			input:    "let v = if (true) { 42 } else { \"hello\" }; v",
+			expected: "string",
+			hasError: true,
+		},
+		{
+			name:     "else if連鎖（同じ型）",
+			input:    "let v = if (true) { 1 } else if (false) { 2 } else { 3 }; v",
+			expected: "int",
+			hasError: false,
+		},
+		{
+			name:     "else if連鎖（異なる型・エラー）",
+			input:    "let v = if (true) { 1 } else if (false) { \"two\" } else { 3 }; v",
 			expected: "string",
 			hasError: true,
 		},
+		{
+			name:     "else if連鎖の最後の分岐が異なる型（エラー）",
+			input:    "let v = if (true) { 1 } else if (false) { 2 } else { true }; v",
+			expected: "bool",
+			hasError: true,
+		},
+		{
+			name:     "文として置いたif式の分岐は異なる型でもよい",
+			input:    "let x = 0; if (x < 1) { x = 5; } else { puts(0); }",
+			expected: "?void",
+			hasError: false,
+		},
+		{
+			name:     "文として置いたelse if連鎖",
+			input:    "let x = 0; if (x < 1) { 1 } else if (x < 2) { \"two\" }",
+			expected: "?void",
+			hasError: false,
+		},
+		{
+			name:     "関数本体の末尾のif式は値を使う（エラー）",
+			input:    "fn(x) { if (x) { 1 } else { \"a\" } }",
+			hasError: true,
+		},
+		{
+			name:     "else ifの条件が整数（エラー）",
+			input:    "let v = if (true) { 1 } else if (1) { 2 }; v",
+			expected: "int",
+			hasError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -494,7 +933,7 @@ func TestTypeChecker_ComplexExpressions(t *testing.T) {
 		},
 		{
 			name:     "ネストしたif式",
-			input:    "if (true) { if (false) { 1 } else { 2 } } else { 3 }",
+			input:    "let v = if (true) { if (false) { 1 } else { 2 } } else { 3 }; v",
 			expected: "int",
 			hasError: false,
 		},
@@ -559,6 +998,34 @@ func TestTypeChecker_ErrorMessages(t *testing.T) {
 				"operand of ! must be bool",
 			},
 		},
+		{
+			name:  "加算できる型に限られた引数",
+			input: "let add = fn(a, b) { a + b }; add(true, false);",
+			expectedErrors: []string{
+				"argument 0: expected a numeric or string type for ?T, got bool",
+			},
+		},
+		{
+			name:  "数値型に限られた引数（減算）",
+			input: "let sub = fn(a, b) { a - b }; sub(\"a\", \"b\");",
+			expectedErrors: []string{
+				"argument 0: expected a numeric type for ?T, got string",
+			},
+		},
+		{
+			name:  "数値型に限られた引数（比較）",
+			input: "let lt = fn(a, b) { a < b }; lt(\"a\", \"b\");",
+			expectedErrors: []string{
+				"argument 0: expected a numeric type for ?T, got string",
+			},
+		},
+		{
+			name:  "数値型に限られた引数（符号反転）",
+			input: "let neg = fn(a) { -a }; neg(\"x\");",
+			expectedErrors: []string{
+				"argument 0: expected a numeric type for ?T, got string",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -589,6 +1056,387 @@ func TestTypeChecker_ErrorMessages(t *testing.T) {
 	}
 }
 
+// TestTypeChecker_Diagnostics は型エラーの診断がエラーコードと位置を持つことをテストする
+func TestTypeChecker_Diagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		code     string
+		covers   string
+		labelled string
+	}{
+		{
+			name:   "未定義変数",
+			input:  "let x = 1;\nx + y",
+			code:   CodeUndefinedIdentifier,
+			covers: "y",
+		},
+		{
+			name:     "算術演算のオペランド",
+			input:    "\"hello\" + 5",
+			code:     CodeInvalidOperand,
+			covers:   "\"hello\"",
+			labelled: "+",
+		},
+		{
+			name:     "配列同士の+",
+			input:    "let a = [1]; let b = [2]; a + b",
+			code:     CodeInvalidOperand,
+			covers:   "a + b",
+			labelled: "+",
+		},
+		{
+			name:   "比較演算の型不一致",
+			input:  "5 == \"hello\"",
+			code:   CodeTypeMismatch,
+			covers: "5 == \"hello\"",
+		},
+		{
+			name:     "代入の型不一致",
+			input:    "let x = 1; x = true;",
+			code:     CodeTypeMismatch,
+			covers:   "true",
+			labelled: "x",
+		},
+		{
+			name:   "引数の数の不一致",
+			input:  "let f = fn(a) { a }; f(1, 2)",
+			code:   CodeArgumentCount,
+			covers: "f(1, 2)",
+		},
+		{
+			name:   "ハッシュのキーにできない型",
+			input:  "{[1]: 2}",
+			code:   CodeUnhashableKey,
+			covers: "[",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+			tc.CheckProgram(program)
+
+			diagnostics := tc.Diagnostics()
+			if len(diagnostics) == 0 {
+				t.Fatalf("expected diagnostics, got none")
+			}
+			d := diagnostics[0]
+			if d.Code != tt.code {
+				t.Errorf("wrong code. expected=%s, got=%s (%s)", tt.code, d.Code, d.Message)
+			}
+			if got := tt.input[d.Span.Offset:d.Span.End()]; got != tt.covers {
+				t.Errorf("primary span covers %q, want %q", got, tt.covers)
+			}
+			if tt.labelled != "" {
+				if len(d.Labels) == 0 {
+					t.Fatalf("expected a secondary label")
+				}
+				span := d.Labels[0].Span
+				if got := tt.input[span.Offset:span.End()]; got != tt.labelled {
+					t.Errorf("secondary label covers %q, want %q", got, tt.labelled)
+				}
+			}
+			if len(tc.GetErrors()) != len(diagnostics) {
+				t.Errorf("GetErrors() and Diagnostics() disagree: %v vs %v", tc.GetErrors(), diagnostics)
+			}
+		})
+	}
+}
+
+// TestTypeChecker_Inference は型変数と単一化による型推論をテストする
+func TestTypeChecker_Inference(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		hasError bool
+	}{
+		{"算術演算からパラメータを推論", "fn(x) { x + 1 }", "fn(int) -> int", false},
+		{"浮動小数点との演算", "fn(x) { x * 1.5 }", "fn(float) -> float", false},
+		{"未決定同士の+は型を決めない", "fn(a, b) { a + b }", "fn(?T, ?T) -> ?T", false},
+		{"未決定の+に整数を渡す", "let add = fn(a, b) { a + b }; add(1, 2)", "int", false},
+		{"未決定の+に浮動小数点を渡す", "let add = fn(a, b) { a + b }; add(1.5, 2.5)", "float", false},
+		{"未決定の+に文字列を渡す", "let add = fn(a, b) { a + b }; add(\"a\", \"b\")", "string", false},
+		{"未決定の+に真偽値は渡せない", "let add = fn(a, b) { a + b }; add(true, false)", "", true},
+		{"未決定の-に文字列は渡せない", "let sub = fn(a, b) { a - b }; sub(\"a\", \"b\")", "", true},
+		{"未決定の単項-", "fn(x) { -x }", "fn(?T) -> ?T", false},
+		{"恒等関数", "fn(x) { x }", "fn(?T) -> ?T", false},
+		{"高階関数", "fn(f, x) { f(x) + 1 }", "fn(fn(?T) -> int, ?T) -> int", false},
+		{"添字アクセスから配列を推論", "fn(xs) { xs[0] + 1 }", "fn([int]) -> int", false},
+		{"添字アクセスからハッシュを推論", "fn(h) { h[\"key\"] }", "fn({string: ?T}) -> ?T", false},
+		{"論理演算から推論", "fn(a, b) { a && !b }", "fn(bool, bool) -> bool", false},
+		{"推論した型で引数を検査する", "fn(x) { x + 1 }(\"a\")", "", true},
+		{"呼び出し結果の型", "let inc = fn(x) { x + 1 }; inc(41)", "int", false},
+		{"let多相", "let id = fn(x) { x }; id(1); id(\"a\")", "string", false},
+		{"多相関数の具体化", "let id = fn(x) { x }; let f = id; f(true)", "bool", false},
+		{"組み込み関数の多相性", "first([1]) + len(rest([\"a\"]))", "int", false},
+		{"pushの要素型の不一致", "push([1], \"x\")", "", true},
+		{"pushの戻り値", "push([\"a\"], \"b\")", "[string]", false},
+		{"空配列の要素型を推論", "push([], 1)", "[int]", false},
+		{"関数以外の値は一般化しない", "let xs = []; let ys = push(xs, 1); push(xs, \"a\")", "", true},
+		{"ラムダのパラメータは単相", "fn(f) { f(1); f(\"a\") }", "", true},
+		{"戻り値の型の不一致", "fn(x) { if (x) { return 1; } return \"a\"; }", "", true},
+		{"無限型", "fn(x) { x(x) }", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError {
+				if len(errors) == 0 {
+					t.Errorf("expected errors, but got none (type %s)", resultType.String())
+				}
+				return
+			}
+			if len(errors) > 0 {
+				t.Fatalf("unexpected errors: %v", errors)
+			}
+			if resultType.String() != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, resultType.String())
+			}
+		})
+	}
+}
+
+// TestTypeChecker_Statements はループ・ブロック・break/continue文の型検査をテストする
+func TestTypeChecker_Statements(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		hasError bool
+	}{
+		{"while文", "let i = 0; while (i < 10) { i += 1; }", false},
+		{"while文の条件が整数（エラー）", "while (1) { break; }", true},
+		{"for文", "let sum = 0; for (let i = 0; i < 10; i += 1) { sum += i; }", false},
+		{"for文の条件が文字列（エラー）", "for (let i = 0; \"x\"; i += 1) { }", true},
+		{"条件のないfor文", "for (;;) { break; }", false},
+		{"for文の更新式の型エラー", "for (let i = 0; i < 3; i += \"a\") { }", true},
+		{"初期化文の変数はループの外で見えない（エラー）", "for (let i = 0; i < 3; i += 1) { } i", true},
+		{"while文の本体の変数は外側と同じスコープ", "while (true) { let x = 1; break; } x", false},
+		{"同じスコープで後から定義される変数の参照は制御フロー解析が報告する", "puts(x); let x = 1;", false},
+		{"ブロックでの再定義は同じ型", "let x = 1; if (true) { let x = 2; } x + 1", false},
+		{"ブロックでの異なる型の再定義（エラー）", "let x = 1; if (true) { let x = \"a\"; } x", true},
+		{"for文の本体での初期化文の変数の再定義", "for (let i = 0; i < 3; i += 1) { let i = \"a\"; }", true},
+		{"ブロックの関数は定義済みの同名の関数を隠さない", "let f = fn() { 1 }; if (true) { f() + 1; let f = fn() { 2 }; }", false},
+		{"ループ本体の型エラー", "while (true) { let x = 1 + true; }", true},
+		{"break文とcontinue文", "for (let i = 0; i < 10; i += 1) { if (i == 2) { continue; } if (i == 5) { break; } }", false},
+		{"ループの中のreturn", "fn(n) { while (true) { return n + 1; } }", false},
+		{"ループの中のreturnの型不一致", "fn(n) { while (true) { return 1; } return \"a\"; }", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			_, errors := tc.CheckProgram(program)
+
+			if tt.hasError && len(errors) == 0 {
+				t.Errorf("expected errors, but got none")
+			}
+			if !tt.hasError && len(errors) > 0 {
+				t.Errorf("unexpected errors: %v", errors)
+			}
+		})
+	}
+}
+
+// TestTypeChecker_RecursiveFunctions は自己再帰・相互再帰する関数の型推論をテストする
+func TestTypeChecker_RecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		hasError bool
+	}{
+		{
+			name:     "自己再帰",
+			input:    "let fib = fn(n) { if (n <= 1) { return n; } return fib(n - 1) + fib(n - 2); }; fib",
+			expected: "fn(int) -> int",
+		},
+		{
+			name:     "再帰関数の呼び出し",
+			input:    "let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5)",
+			expected: "int",
+		},
+		{
+			name: "相互再帰",
+			input: `let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+				isOdd`,
+			expected: "fn(int) -> bool",
+		},
+		{
+			name:     "ブロック内の再帰関数",
+			input:    "fn(x) { let loop = fn(n) { if (n > 0) { loop(n - 1) } else { x } }; loop(3) }",
+			expected: "fn(?T) -> ?T",
+		},
+		{
+			name:     "再帰呼び出しの引数の型不一致（エラー）",
+			input:    "let f = fn(n) { if (n > 0) { f(\"a\") } else { 0 } };",
+			hasError: true,
+		},
+		{
+			name:     "再帰関数は定義後に多相になる",
+			input:    "let len2 = fn(xs) { if (len(xs) == 0) { 0 } else { 1 + len2(rest(xs)) } }; len2([1]) + len2([\"a\"])",
+			expected: "int",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError {
+				if len(errors) == 0 {
+					t.Errorf("expected errors, but got none")
+				}
+				return
+			}
+			if len(errors) > 0 {
+				t.Fatalf("unexpected errors: %v", errors)
+			}
+			if resultType.String() != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, resultType.String())
+			}
+		})
+	}
+}
+
+// TestTypeChecker_ReturnPaths は関数から抜ける全ての経路の戻り値の検査をテストする
+func TestTypeChecker_ReturnPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		hasError bool
+	}{
+		{"if内のreturnと末尾のreturn", "fn(n) { if (n > 0) { return 1; } return 0; }", "fn(int) -> int", false},
+		{"if-elseの両方の分岐の値", "fn(n) { if (n > 0) { n } else { 0 } }", "fn(int) -> int", false},
+		{"returnと暗黙の戻り値", "fn(n) { if (n > 0) { return n; } n + 1 }", "fn(int) -> int", false},
+		{"分岐内のreturnと値", "fn(n) { if (n > 0) { return 1.5; } else { 2.5 } }", "fn(int) -> float", false},
+		{"while内のreturn", "fn(n) { while (n > 0) { return n; } 0 }", "fn(int) -> int", false},
+		{"値を返さない関数", "fn(n) { if (n > 0) { puts(n); } let x = 1; }", "fn(int) -> ?void", false},
+		{"else ifの全ての分岐が値を返す", "fn(n) { if (n > 0) { 1 } else if (n < 0) { -1 } else { 0 } }", "fn(int) -> int", false},
+		{"到達できない末尾の式は戻り値にならない", "fn() { return 1; \"unreachable\" }", "fn() -> int", false},
+		{"if内のreturnの型不一致", "fn(n) { if (n > 0) { return \"pos\"; } return 0; }", "", true},
+		{"while内のreturnの型不一致", "fn(n) { while (n > 0) { return true; } 0 }", "", true},
+		{"暗黙の戻り値の型不一致", "fn(n) { if (n > 0) { return 1; } \"zero\" }", "", true},
+		{"elseのないif（エラー）", "fn(n) { if (n > 0) { return 1; } }", "", true},
+		{"末尾のelseのないif式（エラー）", "fn(n) { if (n > 0) { 1 } }", "", true},
+		{"ループの後に到達する（エラー）", "fn(n) { while (n > 0) { return n; } }", "", true},
+		{"値を生成しない分岐（エラー）", "fn(n) { if (n > 0) { 1 } else { let x = 2; } }", "", true},
+		{"戻り値の型注釈があるのに値を返さない（エラー）", "fn() -> int { let x = 1; }", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parseProgramForTypes(t, tt.input)
+			tc := NewTypeChecker()
+
+			resultType, errors := tc.CheckProgram(program)
+
+			if tt.hasError {
+				if len(errors) == 0 {
+					t.Errorf("expected errors, but got none (type %s)", resultType.String())
+				}
+				return
+			}
+			if len(errors) > 0 {
+				t.Fatalf("unexpected errors: %v", errors)
+			}
+			if resultType.String() != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, resultType.String())
+			}
+		})
+	}
+}
+
+// TestTypeChecker_MissingReturn は値を返さない経路の診断が各経路を指すことをテストする
+func TestTypeChecker_MissingReturn(t *testing.T) {
+	input := "let sign = fn(n) {\n  if (n > 0) { return 1; } else if (n < 0) { return -1; }\n};"
+	program := parseProgramForTypes(t, input)
+	tc := NewTypeChecker()
+	tc.CheckProgram(program)
+
+	diagnostics := tc.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diagnostics)
+	}
+	d := diagnostics[0]
+	if d.Code != CodeMissingReturn || d.Message != "not all paths return a value" {
+		t.Errorf("unexpected diagnostic: %s", d)
+	}
+	if got := input[d.Span.Offset:d.Span.End()]; got != "fn" {
+		t.Errorf("primary span covers %q, want %q", got, "fn")
+	}
+	if len(d.Labels) != 1 || d.Labels[0].Message != "this `if` has no `else` branch" || d.Labels[0].Span.String() != "2:33" {
+		t.Errorf("unexpected labels: %+v", d.Labels)
+	}
+}
+
+// TestTypeChecker_InfiniteType は出現検査の失敗が無限型のエラーとして報告されることをテストする
+func TestTypeChecker_InfiniteType(t *testing.T) {
+	input := "let f = fn(x) { x(x) };"
+	program := parseProgramForTypes(t, input)
+	tc := NewTypeChecker()
+	tc.CheckProgram(program)
+
+	diagnostics := tc.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", diagnostics)
+	}
+	d := diagnostics[0]
+	if d.Code != CodeInfiniteType {
+		t.Errorf("wrong code. expected=%s, got=%s", CodeInfiniteType, d.Code)
+	}
+	if got := input[d.Span.Offset:d.Span.End()]; got != "x" {
+		t.Errorf("primary span covers %q, want %q", got, "x")
+	}
+	if !strings.HasPrefix(d.Message, "infinite type: ") {
+		t.Errorf("unexpected message: %q", d.Message)
+	}
+}
+
+// TestTypeChecker_FunctionSignatures はトップレベルの関数の推論された型の一覧をテストする
+func TestTypeChecker_FunctionSignatures(t *testing.T) {
+	input := `
+		let add = fn(a, b) { a + b };
+		let x = 1;
+		let twice = fn(f, v) { f(f(v)) };
+		let greet = fn(name: string) -> string { name };
+	`
+	program := parseProgramForTypes(t, input)
+	tc := NewTypeChecker()
+	if _, errors := tc.CheckProgram(program); len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	expected := []string{
+		"add: fn(?T, ?T) -> ?T",
+		"twice: fn(fn(?T) -> ?T, ?T) -> ?T",
+		"greet: fn(string) -> string",
+	}
+	signatures := tc.FunctionSignatures(program)
+	if len(signatures) != len(expected) {
+		t.Fatalf("expected %d signatures, got %v", len(expected), signatures)
+	}
+	for i, signature := range signatures {
+		if signature.String() != expected[i] {
+			t.Errorf("signatures[%d] = %q, want %q", i, signature.String(), expected[i])
+		}
+	}
+}
+
 // TestTypeChecker_TypeEnvironment は型環境のテストを行う
 func TestTypeChecker_TypeEnvironment(t *testing.T) {
 	env := NewTypeEnvironment()
@@ -663,6 +1511,16 @@ func TestType_Equals(t *testing.T) {
 			&ArrayType{ElementType: STRING_TYPE},
 			false,
 		},
+		{
+			&MapType{KeyType: STRING_TYPE, ValueType: INT_TYPE},
+			&MapType{KeyType: STRING_TYPE, ValueType: INT_TYPE},
+			true,
+		},
+		{
+			&MapType{KeyType: STRING_TYPE, ValueType: INT_TYPE},
+			&MapType{KeyType: INT_TYPE, ValueType: INT_TYPE},
+			false,
+		},
 	}
 
 	for i, tt := range tests {